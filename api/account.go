@@ -45,8 +45,10 @@ func GetAccountDetails(db *sql.DB) gin.HandlerFunc {
 // GetAllAccounts retrieves accounts address, balance, transaction count
 func GetAllAccounts(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		limit := c.DefaultQuery("limit", "20")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 20)
+		if !ok {
+			return
+		}
 
 		// Get the total count of accounts
 		totalCount, err := models.CountAccounts(db)
@@ -55,16 +57,38 @@ func GetAllAccounts(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		accounts, err := models.FetchAllAccounts(db, limit, offset)
+		accounts, nextCursor, err := models.FetchAllAccounts(db, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve accounts")
+			return
+		}
+
+		respondPage(c, totalCount, accounts, nextCursor)
+	}
+}
+
+// GetAccountBalanceChanges retrieves the balance changes of an account, optionally filtered by asset
+func GetAccountBalanceChanges(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := c.Param("address")
+		assetAddress := c.Query("asset_address")
+		page, ok := parsePage(c, 20)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountBalanceChanges(db, address, assetAddress)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count balance changes"})
+			return
+		}
+
+		changes, nextCursor, err := models.FetchBalanceChanges(db, address, assetAddress, page)
 		if err != nil {
-			log.Printf("Error fetching accounts: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve accounts"})
+			respondPageError(c, err, "Unable to retrieve balance changes")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   accounts,
-		})
+		respondPage(c, totalCount, changes, nextCursor)
 	}
 }
@@ -16,8 +16,10 @@ import (
 // GetAllActions retrieves all actions with pagination and total count
 func GetAllActions(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Get total count of actions
 		var totalCount int
@@ -28,18 +30,14 @@ func GetAllActions(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated actions
-		actions, err := models.FetchAllActions(db, limit, offset)
+		actions, nextCursor, err := models.FetchAllActions(db, page)
 		if err != nil {
-			log.Printf("Error fetching actions: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve actions"})
+			respondPageError(c, err, "Unable to retrieve actions")
 			return
 		}
 
 		// Return response with counter
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   actions,
-		})
+		respondPage(c, totalCount, actions, nextCursor)
 	}
 }
 
@@ -79,8 +77,10 @@ func GetActionsByTransactionHash(db *sql.DB) gin.HandlerFunc {
 func GetActionsByActionType(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		actionType := c.Param("action_type")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Get total count of actions for this type
 		var totalCount int
@@ -92,17 +92,13 @@ func GetActionsByActionType(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated actions for the type
-		actions, err := models.FetchActionsByType(db, actionType, limit, offset)
+		actions, nextCursor, err := models.FetchActionsByType(db, actionType, page)
 		if err != nil {
-			log.Printf("Error fetching actions by type: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve actions by action type"})
+			respondPageError(c, err, "Unable to retrieve actions by action type")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   actions,
-		})
+		respondPage(c, totalCount, actions, nextCursor)
 	}
 }
 
@@ -111,8 +107,10 @@ func GetActionsByActionName(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		actionName := c.Param("action_name")
 		actionName = strings.ToLower(actionName)
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Get total count of actions for this name
 		var totalCount int
@@ -124,17 +122,13 @@ func GetActionsByActionName(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated actions for the name
-		actions, err := models.FetchActionsByName(db, actionName, limit, offset)
+		actions, nextCursor, err := models.FetchActionsByName(db, actionName, page)
 		if err != nil {
-			log.Printf("Error fetching actions by name: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve actions by action name"})
+			respondPageError(c, err, "Unable to retrieve actions by action name")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   actions,
-		})
+		respondPage(c, totalCount, actions, nextCursor)
 	}
 }
 
@@ -142,8 +136,10 @@ func GetActionsByActionName(db *sql.DB) gin.HandlerFunc {
 func GetActionsByUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := c.Param("user")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Normalize the user identifier by removing "0x" prefix if present
 		user = strings.TrimPrefix(user, "0x")
@@ -174,16 +170,12 @@ func GetActionsByUser(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated actions for the user
-		actions, err := models.FetchActionsByUser(db, user, limit, offset)
+		actions, nextCursor, err := models.FetchActionsByUser(db, user, page)
 		if err != nil {
-			log.Printf("Error fetching actions: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve actions for user"})
+			respondPageError(c, err, "Unable to retrieve actions for user")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   actions,
-		})
+		respondPage(c, totalCount, actions, nextCursor)
 	}
 }
@@ -5,7 +5,6 @@ package api
 
 import (
 	"database/sql"
-	"log"
 	"net/http"
 	"strings"
 
@@ -21,8 +20,10 @@ func GetAllAssets(db *sql.DB) gin.HandlerFunc {
 		assetAddress := c.Query("asset_address")
 		name := c.Query("name")
 		symbol := c.Query("symbol")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Normalize user to search with and without "0x" prefix
 		if user != "" {
@@ -40,17 +41,13 @@ func GetAllAssets(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch filtered assets with pagination
-		assets, err := models.FetchFilteredAssets(db, assetType, user, assetAddress, name, symbol, limit, offset)
+		assets, nextCursor, err := models.FetchFilteredAssets(db, assetType, user, assetAddress, name, symbol, page)
 		if err != nil {
-			log.Printf("Error fetching assets: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve assets"})
+			respondPageError(c, err, "Unable to retrieve assets")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   assets,
-		})
+		respondPage(c, totalCount, assets, nextCursor)
 	}
 }
 
@@ -58,8 +55,10 @@ func GetAllAssets(db *sql.DB) gin.HandlerFunc {
 func GetAssetsByType(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		assetType := c.Param("type")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Get total count of assets by type
 		var totalCount int
@@ -70,18 +69,14 @@ func GetAssetsByType(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated assets by type
-		assets, err := models.FetchAssetsByType(db, assetType, limit, offset)
+		assets, nextCursor, err := models.FetchAssetsByType(db, assetType, page)
 		if err != nil {
-			log.Printf("Error fetching assets: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve assets by type"})
+			respondPageError(c, err, "Unable to retrieve assets by type")
 			return
 		}
 
 		// Return response with counter
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   assets,
-		})
+		respondPage(c, totalCount, assets, nextCursor)
 	}
 }
 
@@ -89,8 +84,10 @@ func GetAssetsByType(db *sql.DB) gin.HandlerFunc {
 func GetAssetsByUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := c.Param("user")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		user = strings.TrimPrefix(user, "0x")
 
@@ -103,18 +100,14 @@ func GetAssetsByUser(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated assets by user
-		assets, err := models.FetchAssetsByUser(db, user, limit, offset)
+		assets, nextCursor, err := models.FetchAssetsByUser(db, user, page)
 		if err != nil {
-			log.Printf("Error fetching assets: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve assets for user"})
+			respondPageError(c, err, "Unable to retrieve assets for user")
 			return
 		}
 
 		// Return response with counter
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   assets,
-		})
+		respondPage(c, totalCount, assets, nextCursor)
 	}
 }
 
@@ -134,3 +127,53 @@ func GetAssetByAddress(db *sql.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, asset)
 	}
 }
+
+// GetAssetHistory retrieves the recorded changes of an asset
+func GetAssetHistory(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		assetAddress := strings.TrimPrefix(c.Param("asset_address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountAssetHistory(db, assetAddress)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count asset history"})
+			return
+		}
+
+		history, nextCursor, err := models.FetchAssetHistory(db, assetAddress, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve asset history")
+			return
+		}
+
+		respondPage(c, totalCount, history, nextCursor)
+	}
+}
+
+// GetAssetSupply retrieves the supply changes of a fungible asset in chain order
+func GetAssetSupply(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		assetAddress := strings.TrimPrefix(c.Param("asset_address"), "0x")
+		page, ok := parsePage(c, 100)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountAssetSupplyChanges(db, assetAddress)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count asset supply changes"})
+			return
+		}
+
+		changes, nextCursor, err := models.FetchAssetSupplyChanges(db, assetAddress, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve asset supply changes")
+			return
+		}
+
+		respondPage(c, totalCount, changes, nextCursor)
+	}
+}
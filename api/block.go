@@ -19,8 +19,10 @@ func GetAllBlocks(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		blockHash := c.Query("block_hash")
 		blockHeight := c.Query("block_height")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Request a specific block
 		if blockHash != "" || blockHeight != "" {
@@ -43,18 +45,14 @@ func GetAllBlocks(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated blocks
-		blocks, err := models.FetchAllBlocks(db, limit, offset)
+		blocks, nextCursor, err := models.FetchAllBlocks(db, page)
 		if err != nil {
-			log.Printf("Error fetching blocks: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve blocks"})
+			respondPageError(c, err, "Unable to retrieve blocks")
 			return
 		}
 
 		// Return response with counter
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   blocks,
-		})
+		respondPage(c, totalCount, blocks, nextCursor)
 	}
 }
 
@@ -0,0 +1,86 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GetAllContracts retrieves all published programs and deployed contracts with optional filters
+func GetAllContracts(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		contractType := c.Query("type")
+		creator := strings.TrimPrefix(c.Query("creator"), "0x")
+		programID := strings.TrimPrefix(c.Query("program_id"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		if contractType != "" && !models.IsContractType(contractType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contract type"})
+			return
+		}
+
+		totalCount, err := models.CountFilteredContracts(db, contractType, creator, programID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count contracts"})
+			return
+		}
+
+		contracts, nextCursor, err := models.FetchFilteredContracts(db, contractType, creator, programID, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve contracts")
+			return
+		}
+
+		respondPage(c, totalCount, contracts, nextCursor)
+	}
+}
+
+// GetContractByAddress retrieves a deployed contract by its address or a published program by its ID
+func GetContractByAddress(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := strings.TrimPrefix(c.Param("address"), "0x")
+
+		contract, err := models.FetchContract(db, address)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Contract not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, contract)
+	}
+}
+
+// GetContractCalls retrieves the calls made to a contract, optionally to a given function
+func GetContractCalls(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := strings.TrimPrefix(c.Param("address"), "0x")
+		functionName := c.Query("function")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountContractCalls(db, address, functionName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count contract calls"})
+			return
+		}
+
+		calls, nextCursor, err := models.FetchContractCalls(db, address, functionName, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve contract calls")
+			return
+		}
+
+		respondPage(c, totalCount, calls, nextCursor)
+	}
+}
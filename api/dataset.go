@@ -0,0 +1,174 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GetAllDatasets retrieves all datasets with optional filters
+func GetAllDatasets(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		owner := strings.TrimPrefix(c.Query("owner"), "0x")
+		datasetAddress := strings.TrimPrefix(c.Query("dataset_address"), "0x")
+		name := c.Query("name")
+		category := c.Query("category")
+		community := c.Query("community")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		if community != "" && community != "true" && community != "false" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid community filter"})
+			return
+		}
+
+		// Get total count with filters
+		totalCount, err := models.CountFilteredDatasets(db, owner, datasetAddress, name, category, community)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count datasets"})
+			return
+		}
+
+		// Fetch filtered datasets with pagination
+		datasets, nextCursor, err := models.FetchFilteredDatasets(db, owner, datasetAddress, name, category, community, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve datasets")
+			return
+		}
+
+		respondPage(c, totalCount, datasets, nextCursor)
+	}
+}
+
+// GetDatasetByAddress retrieves a specific dataset by its address
+func GetDatasetByAddress(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		datasetAddress := strings.TrimPrefix(c.Param("dataset_address"), "0x")
+
+		dataset, err := models.FetchDatasetByAddress(db, datasetAddress)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dataset not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, dataset)
+	}
+}
+
+// GetDatasetsByOwner retrieves the datasets owned by an address
+func GetDatasetsByOwner(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		owner := strings.TrimPrefix(c.Param("address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountDatasetsByOwner(db, owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count datasets for owner"})
+			return
+		}
+
+		datasets, nextCursor, err := models.FetchDatasetsByOwner(db, owner, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve datasets for owner")
+			return
+		}
+
+		respondPage(c, totalCount, datasets, nextCursor)
+	}
+}
+
+// GetDatasetHistory retrieves the recorded revisions of a dataset
+func GetDatasetHistory(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		datasetAddress := strings.TrimPrefix(c.Param("dataset_address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountDatasetHistory(db, datasetAddress)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count dataset history"})
+			return
+		}
+
+		history, nextCursor, err := models.FetchDatasetHistory(db, datasetAddress, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve dataset history")
+			return
+		}
+
+		respondPage(c, totalCount, history, nextCursor)
+	}
+}
+
+// GetDatasetContributions retrieves the contributions to a dataset, optionally filtered by status
+func GetDatasetContributions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		datasetAddress := strings.TrimPrefix(c.Param("dataset_address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+		status := c.Query("status")
+		if status != "" && !models.IsContributionStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+
+		totalCount, err := models.CountDatasetContributions(db, datasetAddress, status)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count contributions"})
+			return
+		}
+
+		contributions, nextCursor, err := models.FetchDatasetContributions(db, datasetAddress, status, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve contributions")
+			return
+		}
+
+		respondPage(c, totalCount, contributions, nextCursor)
+	}
+}
+
+// GetAccountContributions retrieves the dataset contributions of an account, optionally filtered by status
+func GetAccountContributions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := c.Param("address")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+		status := c.Query("status")
+		if status != "" && !models.IsContributionStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+
+		totalCount, err := models.CountAccountContributions(db, address, status)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count contributions"})
+			return
+		}
+
+		contributions, nextCursor, err := models.FetchAccountContributions(db, address, status, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve contributions")
+			return
+		}
+
+		respondPage(c, totalCount, contributions, nextCursor)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GetValidatorDelegators retrieves the delegations to a validator, optionally filtered by status
+func GetValidatorDelegators(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nodeID := c.Param("node_id")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+		status := c.Query("status")
+		if status != "" && !models.IsDelegationStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+
+		totalCount, err := models.CountValidatorDelegations(db, nodeID, status)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count delegations"})
+			return
+		}
+
+		delegations, nextCursor, err := models.FetchValidatorDelegations(db, nodeID, status, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve delegations")
+			return
+		}
+
+		respondPage(c, totalCount, delegations, nextCursor)
+	}
+}
+
+// GetAccountDelegations retrieves the delegations of an account, optionally filtered by status
+func GetAccountDelegations(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := c.Param("address")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+		status := c.Query("status")
+		if status != "" && !models.IsDelegationStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+
+		totalCount, err := models.CountAccountDelegations(db, address, status)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count delegations"})
+			return
+		}
+
+		delegations, nextCursor, err := models.FetchAccountDelegations(db, address, status, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve delegations")
+			return
+		}
+
+		respondPage(c, totalCount, delegations, nextCursor)
+	}
+}
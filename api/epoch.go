@@ -0,0 +1,27 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GetNetworkEpochs retrieves the network epochs indexed by this subscriber
+func GetNetworkEpochs(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		epochs, err := models.FetchNetworkEpochs(db)
+		if err != nil {
+			log.Printf("Error fetching network epochs: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve network epochs"})
+			return
+		}
+
+		c.JSON(http.StatusOK, epochs)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GapScanner periodically scans the blocks table for missing heights
+type GapScanner struct {
+	db      *sql.DB
+	mu      sync.RWMutex
+	status  models.SyncStatus
+	scanned bool
+}
+
+// InitGapScanner initializes the gap scanner
+func InitGapScanner(db *sql.DB) *GapScanner {
+	return &GapScanner{
+		db:     db,
+		status: models.SyncStatus{Gaps: []models.BlockGap{}},
+	}
+}
+
+// Start scans for gaps immediately and then on every interval
+func (g *GapScanner) Start(interval time.Duration) {
+	g.Scan()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		g.Scan()
+	}
+}
+
+// Scan refreshes the cached sync status
+func (g *GapScanner) Scan() models.SyncStatus {
+	status, err := models.FetchSyncStatus(g.db)
+	if err != nil {
+		log.Printf("Error scanning for block gaps: %v", err)
+	} else if status.MissingBlocks > 0 {
+		log.Printf("Detected %d missing blocks in %d ranges", status.MissingBlocks, len(status.Gaps))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err != nil {
+		// Keep the last known gaps but report the failure
+		g.status.LastScanned = time.Now().UTC()
+		g.status.LastError = err.Error()
+	} else {
+		g.status = status
+	}
+	g.scanned = true
+	return g.status
+}
+
+// GetSyncStatus returns the result of the last scan, scanning first if none has run yet
+func (g *GapScanner) GetSyncStatus() models.SyncStatus {
+	g.mu.RLock()
+	status, scanned := g.status, g.scanned
+	g.mu.RUnlock()
+
+	if !scanned {
+		return g.Scan()
+	}
+	return status
+}
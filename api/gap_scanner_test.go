@@ -0,0 +1,76 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+func TestGapScannerScan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	// Heights 3 to 5, 8 and 10 to 12 are stored
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MIN(block_height), 0), COALESCE(MAX(block_height), 0) FROM blocks`)).
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(3, 12))
+	mock.ExpectQuery(regexp.QuoteMeta(`LEAD(block_height) OVER (ORDER BY block_height)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"start_height", "end_height"}).
+			AddRow(1, 2).
+			AddRow(6, 7).
+			AddRow(9, 9))
+
+	scanner := InitGapScanner(db)
+	status := scanner.GetSyncStatus()
+
+	want := []models.BlockGap{
+		{StartHeight: 1, EndHeight: 2, MissingBlocks: 2},
+		{StartHeight: 6, EndHeight: 7, MissingBlocks: 2},
+		{StartHeight: 9, EndHeight: 9, MissingBlocks: 1},
+	}
+	if !reflect.DeepEqual(status.Gaps, want) {
+		t.Fatalf("gaps = %v, want %v", status.Gaps, want)
+	}
+	if status.LowestHeight != 3 || status.HighestHeight != 12 || status.MissingBlocks != 5 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGapScannerKeepsGapsOnError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MIN(block_height), 0)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(3, 5))
+	mock.ExpectQuery(regexp.QuoteMeta(`LEAD(block_height)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"start_height", "end_height"}).AddRow(1, 2))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MIN(block_height), 0)`)).
+		WillReturnError(errors.New("connection refused"))
+
+	scanner := InitGapScanner(db)
+	scanner.Scan()
+	status := scanner.Scan()
+
+	if len(status.Gaps) != 1 || status.MissingBlocks != 2 {
+		t.Fatalf("expected the last known gap to be kept, got %+v", status)
+	}
+	if status.LastError != "connection refused" {
+		t.Fatalf("LastError = %q, want %q", status.LastError, "connection refused")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/graph"
+)
+
+// GraphQL runs a GraphQL query given as a JSON body of a POST request, or in the query,
+// operationName and variables parameters of a GET request. The errors of the query are
+// returned in the GraphQL response.
+func GraphQL(executor *graph.Executor) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request graph.Request
+		if c.Request.Method == http.MethodGet {
+			request.Query = c.Query("query")
+			request.OperationName = c.Query("operationName")
+			if variables := c.Query("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variables"})
+					return
+				}
+			}
+		} else if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if request.Query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing query"})
+			return
+		}
+
+		c.JSON(http.StatusOK, executor.Execute(c.Request.Context(), request))
+	}
+}
@@ -13,18 +13,33 @@ import (
 	"github.com/nuklai/nuklaivm-external-subscriber/models"
 )
 
+// SubscriberStatusProvider reports the state of the parser used to ingest blocks and of
+// the queue of blocks waiting to be ingested
+type SubscriberStatusProvider interface {
+	ParserStatus() models.ParserStatus
+	IngestQueueStatus() models.IngestQueueStatus
+}
+
+// maxIngestLagSeconds is how long a block can wait in the ingest queue before the
+// subscriber is reported as lagging
+const maxIngestLagSeconds = 30
+
 type HealthMonitor struct {
 	db            *sql.DB
 	mu            sync.RWMutex
 	currentStatus models.HealthStatus
 	grpcPort      string
+	gapScanner    *GapScanner
+	subscriber    SubscriberStatusProvider
 }
 
 // InitHealthMonitor initializes the health monitor
-func InitHealthMonitor(db *sql.DB, grpcPort string) *HealthMonitor {
+func InitHealthMonitor(db *sql.DB, grpcPort string, gapScanner *GapScanner, subscriber SubscriberStatusProvider) *HealthMonitor {
 	monitor := &HealthMonitor{
-		db:       db,
-		grpcPort: grpcPort,
+		db:         db,
+		grpcPort:   grpcPort,
+		gapScanner: gapScanner,
+		subscriber: subscriber,
 		currentStatus: models.HealthStatus{
 			State:           models.HealthStateGreen,
 			Details:         make(map[string]bool),
@@ -210,8 +225,16 @@ func (h *HealthMonitor) GetHealthStatus() models.HealthStatus {
 
 	blockchainStatus, blockchainStats := h.FetchBlockchainHealth()
 
+	syncStatus := h.gapScanner.GetSyncStatus()
+	parserStatus := h.subscriber.ParserStatus()
+	queueStatus := h.subscriber.IngestQueueStatus()
+	queueHealthy := queueStatus.LastError == "" && queueStatus.LagSeconds <= maxIngestLagSeconds
+
 	h.currentStatus.Details = map[string]bool{
 		"blockchain": blockchainStatus.IsReachable,
+		"sync":       syncStatus.MissingBlocks == 0,
+		"parser":     parserStatus.Loaded,
+		"ingest":     queueHealthy && queueStatus.DeadLettered == 0,
 	}
 
 	h.currentStatus.ServiceStatuse = map[string]*models.ServiceStatus{
@@ -219,6 +242,9 @@ func (h *HealthMonitor) GetHealthStatus() models.HealthStatus {
 	}
 
 	h.currentStatus.BlockchainStats = blockchainStats
+	h.currentStatus.SyncStatus = &syncStatus
+	h.currentStatus.ParserStatus = &parserStatus
+	h.currentStatus.IngestQueue = &queueStatus
 
 	if !blockchainStatus.IsReachable {
 		description := strings.Builder{}
@@ -232,6 +258,21 @@ func (h *HealthMonitor) GetHealthStatus() models.HealthStatus {
 	} else if blockchainStatus.ResponseTimeSeconds > 2.0 {
 		description := fmt.Sprintf("High Latency - Response Time: %.2fs", blockchainStatus.ResponseTimeSeconds)
 		h.UpdateHealthState(models.HealthStateYellow, description, []string{"blockchain"})
+	} else if !parserStatus.Loaded {
+		description := "Parser Not Loaded - waiting for the node to call Initialize"
+		h.UpdateHealthState(models.HealthStateYellow, description, []string{"parser"})
+	} else if !queueHealthy {
+		description := fmt.Sprintf("Ingest Lagging - %d blocks queued, oldest waiting %.0fs", queueStatus.Depth, queueStatus.LagSeconds)
+		if queueStatus.LastError != "" {
+			description += fmt.Sprintf("\n- Error: %s", queueStatus.LastError)
+		}
+		h.UpdateHealthState(models.HealthStateYellow, description, []string{"ingest"})
+	} else if queueStatus.DeadLettered > 0 {
+		description := fmt.Sprintf("Blocks Dead-Lettered - %d queued blocks could not be written and wait to be backfilled", queueStatus.DeadLettered)
+		h.UpdateHealthState(models.HealthStateYellow, description, []string{"ingest"})
+	} else if syncStatus.MissingBlocks > 0 {
+		description := fmt.Sprintf("Missing Blocks - %d blocks missing in %d ranges", syncStatus.MissingBlocks, len(syncStatus.Gaps))
+		h.UpdateHealthState(models.HealthStateYellow, description, []string{"sync"})
 	} else {
 		h.UpdateHealthState(models.HealthStateGreen, "", nil)
 	}
@@ -0,0 +1,192 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// revenueIntervalPattern matches the periods accepted by the revenue endpoints, e.g. "30 days"
+var revenueIntervalPattern = regexp.MustCompile(`^[1-9][0-9]{0,3} (hour|day|week|month|year)s?$`)
+
+// GetMarketplaceListings retrieves the datasets published to the marketplace with their statistics
+func GetMarketplaceListings(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		publisher := strings.TrimPrefix(c.Query("publisher"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountMarketplaceListings(db, publisher)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count marketplace listings"})
+			return
+		}
+
+		listings, nextCursor, err := models.FetchMarketplaceListings(db, publisher, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve marketplace listings")
+			return
+		}
+
+		respondPage(c, totalCount, listings, nextCursor)
+	}
+}
+
+// GetMarketplaceListing retrieves the marketplace listing of a dataset
+func GetMarketplaceListing(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		datasetAddress := strings.TrimPrefix(c.Param("dataset_address"), "0x")
+
+		listing, err := models.FetchMarketplaceListing(db, datasetAddress)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Marketplace listing not found"})
+			return
+		} else if err != nil {
+			log.Printf("Error fetching marketplace listing: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve marketplace listing"})
+			return
+		}
+
+		c.JSON(http.StatusOK, listing)
+	}
+}
+
+// GetMarketplaceSubscriptions retrieves the subscriptions to a dataset, optionally filtered by status
+func GetMarketplaceSubscriptions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		datasetAddress := strings.TrimPrefix(c.Param("dataset_address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+		status := c.Query("status")
+		if status != "" && !models.IsSubscriptionStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+
+		totalCount, err := models.CountDatasetSubscriptions(db, datasetAddress, status)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count subscriptions"})
+			return
+		}
+
+		subscriptions, nextCursor, err := models.FetchDatasetSubscriptions(db, datasetAddress, status, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve subscriptions")
+			return
+		}
+
+		respondPage(c, totalCount, subscriptions, nextCursor)
+	}
+}
+
+// GetMarketplacePayments retrieves the payments claimed from the marketplace listing of a dataset
+func GetMarketplacePayments(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		datasetAddress := strings.TrimPrefix(c.Param("dataset_address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountDatasetPayments(db, datasetAddress)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count payments"})
+			return
+		}
+
+		payments, nextCursor, err := models.FetchDatasetPayments(db, datasetAddress, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve payments")
+			return
+		}
+
+		respondPage(c, totalCount, payments, nextCursor)
+	}
+}
+
+// GetUnclaimedPayments retrieves the marketplace listings with payments left to claim,
+// optionally of a given publisher
+func GetUnclaimedPayments(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		publisher := strings.TrimPrefix(c.Query("publisher"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountUnclaimedListings(db, publisher)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count unclaimed payments"})
+			return
+		}
+
+		listings, nextCursor, err := models.FetchUnclaimedListings(db, publisher, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve unclaimed payments")
+			return
+		}
+
+		respondPage(c, totalCount, listings, nextCursor)
+	}
+}
+
+// GetDatasetRevenue retrieves the revenue of the marketplace listing of a dataset over a period
+func GetDatasetRevenue(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		datasetAddress := strings.TrimPrefix(c.Param("dataset_address"), "0x")
+		interval := c.DefaultQuery("interval", "30 days")
+		if !revenueIntervalPattern.MatchString(interval) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval"})
+			return
+		}
+
+		revenue, err := models.FetchDatasetRevenue(db, datasetAddress, interval)
+		if err != nil {
+			log.Printf("Error fetching dataset revenue: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve dataset revenue"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"interval": interval,
+			"items":    revenue,
+		})
+	}
+}
+
+// GetOwnerRevenue retrieves the revenue of the marketplace listings published by an address over a period
+func GetOwnerRevenue(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		owner := strings.TrimPrefix(c.Param("address"), "0x")
+		interval := c.DefaultQuery("interval", "30 days")
+		if !revenueIntervalPattern.MatchString(interval) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval"})
+			return
+		}
+
+		revenue, err := models.FetchOwnerRevenue(db, owner, interval)
+		if err != nil {
+			log.Printf("Error fetching owner revenue: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve owner revenue"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"interval": interval,
+			"items":    revenue,
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GetCollectionNFTs retrieves the tokens minted in an NFT collection
+func GetCollectionNFTs(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collectionAddress := strings.TrimPrefix(c.Param("asset_address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountCollectionNFTs(db, collectionAddress)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count NFTs"})
+			return
+		}
+
+		tokens, nextCursor, err := models.FetchCollectionNFTs(db, collectionAddress, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve NFTs")
+			return
+		}
+
+		respondPage(c, totalCount, tokens, nextCursor)
+	}
+}
+
+// GetNFT retrieves a single token of an NFT collection
+func GetNFT(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collectionAddress := strings.TrimPrefix(c.Param("asset_address"), "0x")
+
+		token, err := models.FetchNFT(db, collectionAddress, c.Param("unique_id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "NFT not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, token)
+	}
+}
+
+// GetNFTProvenance retrieves the mint, transfers and burn of a single token
+func GetNFTProvenance(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		collectionAddress := strings.TrimPrefix(c.Param("asset_address"), "0x")
+
+		provenance, err := models.FetchNFTProvenance(db, collectionAddress, c.Param("unique_id"))
+		if err != nil {
+			log.Printf("Error fetching NFT provenance: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve NFT provenance"})
+			return
+		}
+		if len(provenance) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "NFT not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, provenance)
+	}
+}
+
+// GetNFTsByOwner retrieves the tokens currently held by an address
+func GetNFTsByOwner(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		owner := strings.TrimPrefix(c.Param("address"), "0x")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountNFTsByOwner(db, owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count NFTs"})
+			return
+		}
+
+		tokens, nextCursor, err := models.FetchNFTsByOwner(db, owner, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve NFTs")
+			return
+		}
+
+		respondPage(c, totalCount, tokens, nextCursor)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// maxPageSize is the largest limit accepted by the list endpoints
+const maxPageSize = 100
+
+// parsePage reads the limit, offset and cursor query parameters of a list endpoint, using
+// defaultLimit when no limit is given. It responds with 400 and returns false when one of
+// them is invalid.
+func parsePage(c *gin.Context, defaultLimit int) (models.Page, bool) {
+	page := models.Page{Limit: defaultLimit}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit, must be between 1 and %d", maxPageSize)})
+			return page, false
+		}
+		page.Limit = limit
+	}
+
+	if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return page, false
+		}
+		page.Offset = offset
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		if page.Offset > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursor and offset cannot be combined"})
+			return page, false
+		}
+		cursor, err := models.DecodeCursor(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return page, false
+		}
+		page.Cursor = cursor
+	}
+
+	return page, true
+}
+
+// respondPage writes a page of a list with the total count and the cursor of the next page
+func respondPage(c *gin.Context, totalCount int, items interface{}, nextCursor string) {
+	var next interface{}
+	if nextCursor != "" {
+		next = nextCursor
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"counter":     totalCount,
+		"items":       items,
+		"next_cursor": next,
+	})
+}
+
+// respondPageError writes the response of a list that could not be fetched. A cursor
+// taken from another list is a bad request.
+func respondPageError(c *gin.Context, err error, message string) {
+	if errors.Is(err, models.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	log.Printf("%s: %v", message, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
@@ -0,0 +1,36 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GetChainReorgs retrieves the chain reorganizations detected during ingestion
+func GetChainReorgs(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountChainReorgs(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count chain reorgs"})
+			return
+		}
+
+		reorgs, nextCursor, err := models.FetchChainReorgs(db, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve chain reorgs")
+			return
+		}
+
+		respondPage(c, totalCount, reorgs, nextCursor)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/nuklai/nuklaivm-external-subscriber/events"
+)
+
+// streamWriteTimeout bounds the time to write a message to a WebSocket client
+const streamWriteTimeout = 10 * time.Second
+
+// The stream endpoints are public like the rest of the API
+var upgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// streamRequest is a subscription to the events of the indexed blocks
+type streamRequest struct {
+	filter events.Filter
+	// fromHeight is the first block replayed before the live events, or -1
+	fromHeight int64
+}
+
+// StreamEvents streams the events of the indexed blocks as Server-Sent Events. The last
+// event of each block has the block height as id, so a reconnecting EventSource resumes
+// after the last block it fully received.
+func StreamEvents(db *sql.DB, hub *events.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request, ok := parseStreamRequest(c, db)
+		if !ok {
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		send := func(batch []events.Event) error {
+			for i, event := range batch {
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				// Only the last event of a block carries its id, so a client disconnected in
+				// the middle of a block resumes at the start of that block
+				if i == len(batch)-1 {
+					if _, err := fmt.Fprintf(c.Writer, "id: %d\n", event.BlockHeight); err != nil {
+						return err
+					}
+				}
+				if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event.Topic, data); err != nil {
+					return err
+				}
+			}
+			c.Writer.Flush()
+			return nil
+		}
+		heartbeat := func() error {
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		}
+
+		resumeHeight, err := events.Stream(c.Request.Context(), db, hub, request.filter, request.fromHeight, send, heartbeat)
+		if errors.Is(err, events.ErrSubscriberLagged) {
+			data, _ := json.Marshal(lagMessage(resumeHeight))
+			fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", data)
+			c.Writer.Flush()
+		} else if err != nil && c.Request.Context().Err() == nil {
+			log.Printf("Error streaming events: %v", err)
+		}
+	}
+}
+
+// StreamEventsWebSocket streams the events of the indexed blocks over a WebSocket, one
+// JSON message per event
+func StreamEventsWebSocket(db *sql.DB, hub *events.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request, ok := parseStreamRequest(c, db)
+		if !ok {
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Printf("Error upgrading stream to WebSocket: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// The client only sends control messages, read them to notice when it goes away
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		send := func(batch []events.Event) error {
+			for _, event := range batch {
+				conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if err := conn.WriteJSON(event); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		heartbeat := func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+		}
+
+		resumeHeight, err := events.Stream(ctx, db, hub, request.filter, request.fromHeight, send, heartbeat)
+		if errors.Is(err, events.ErrSubscriberLagged) {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			conn.WriteJSON(lagMessage(resumeHeight))
+		} else if err != nil && ctx.Err() == nil {
+			log.Printf("Error streaming events: %v", err)
+		}
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(streamWriteTimeout))
+	}
+}
+
+// parseStreamRequest reads the topic, filters and resume height of a stream request. It
+// responds with 400 and returns false when one of them is invalid.
+func parseStreamRequest(c *gin.Context, db *sql.DB) (streamRequest, bool) {
+	request := streamRequest{
+		filter: events.Filter{
+			Topic:      c.Query("topic"),
+			Address:    c.Query("address"),
+			ActionName: c.Query("action_name"),
+		},
+		fromHeight: -1,
+	}
+	if !events.IsTopic(request.filter.Topic) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid topic, must be blocks, transactions, actions, assets or validators"})
+		return request, false
+	}
+
+	if value := c.Query("from_height"); value != "" {
+		height, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || height < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from_height"})
+			return request, false
+		}
+		request.fromHeight = height
+	} else if value := c.GetHeader("Last-Event-ID"); value != "" {
+		// An EventSource reconnects with the id of the last event it received
+		height, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || height < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return request, false
+		}
+		request.fromHeight = height + 1
+	}
+
+	if request.fromHeight >= 0 {
+		latest, err := events.LatestHeight(db)
+		if err != nil {
+			log.Printf("Error fetching latest block height: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to resume the stream"})
+			return request, false
+		}
+		if latest-request.fromHeight >= events.MaxResumeBlocks {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("from_height is more than %d blocks behind the latest block %d", events.MaxResumeBlocks, latest),
+			})
+			return request, false
+		}
+	}
+	return request, true
+}
+
+// lagMessage tells a dropped subscriber where to resume from
+func lagMessage(resumeHeight int64) gin.H {
+	return gin.H{
+		"error":              "Subscriber fell behind, reconnect with from_height to resume",
+		"resume_from_height": resumeHeight,
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/events"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+func expectLatestHeight(mock sqlmock.Sqlmock, height int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(block_height), -1) FROM blocks`)).
+		WillReturnRows(sqlmock.NewRows([]string{"height"}).AddRow(height))
+}
+
+func TestParseStreamRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+		// latest is the latest indexed height, or -1 when it is not queried
+		latest     int64
+		wantStatus int
+		wantHeight int64
+	}{
+		{name: "live", query: "topic=blocks", latest: -1, wantHeight: -1},
+		{name: "unknown topic", query: "topic=reorgs", latest: -1, wantStatus: http.StatusBadRequest},
+		{name: "from height", query: "topic=actions&from_height=5", latest: 20, wantHeight: 5},
+		{name: "invalid from height", query: "topic=blocks&from_height=-1", latest: -1, wantStatus: http.StatusBadRequest},
+		{name: "last event id", query: "topic=blocks", lastEventID: "7", latest: 20, wantHeight: 8},
+		{name: "from height over last event id", query: "topic=blocks&from_height=3", lastEventID: "7", latest: 20, wantHeight: 3},
+		{name: "invalid last event id", query: "topic=blocks", lastEventID: "block", latest: -1, wantStatus: http.StatusBadRequest},
+		{
+			name: "last resumable height", query: "topic=blocks",
+			lastEventID: "5", latest: events.MaxResumeBlocks + 5, wantHeight: 6,
+		},
+		{
+			name: "too far behind", query: "topic=blocks&from_height=5",
+			latest: events.MaxResumeBlocks + 5, wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error creating mock database: %v", err)
+			}
+			defer db.Close()
+			if tt.latest >= 0 {
+				expectLatestHeight(mock, tt.latest)
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/stream?"+tt.query, nil)
+			if tt.lastEventID != "" {
+				c.Request.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+
+			request, ok := parseStreamRequest(c, db)
+			if tt.wantStatus != 0 {
+				if ok || w.Code != tt.wantStatus {
+					t.Fatalf("parseStreamRequest() = %v with status %d, want status %d", ok, w.Code, tt.wantStatus)
+				}
+			} else if !ok || request.fromHeight != tt.wantHeight {
+				t.Fatalf("parseStreamRequest() = %+v, %v; want from height %d", request, ok, tt.wantHeight)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStreamEventsFramesBlocks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+	expectLatestHeight(mock, 10)
+
+	hub := events.NewHub()
+	r := gin.New()
+	r.GET("/stream", StreamEvents(db, hub))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?topic=transactions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	for !hub.HasSubscribers() {
+		time.Sleep(time.Millisecond)
+	}
+	block := models.Block{BlockHeight: 11, BlockHash: "block"}
+	transactions := []models.Transaction{{TxHash: "tx1"}, {TxHash: "tx2"}}
+	hub.Publish(events.BlockEvents(block, transactions, nil))
+
+	// Only the last event of the block carries its height as id
+	want := []string{
+		"event: transactions",
+		`data: {"topic":"transactions","block_height":11,"data":{"ID":0,"TxHash":"tx1",`,
+		"",
+		"id: 11",
+		"event: transactions",
+		`data: {"topic":"transactions","block_height":11,"data":{"ID":0,"TxHash":"tx2",`,
+		"",
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for i, prefix := range want {
+		if !scanner.Scan() {
+			t.Fatalf("stream ended before line %d: %v", i, scanner.Err())
+		}
+		if line := scanner.Text(); !strings.HasPrefix(line, prefix) || (prefix == "" && line != "") {
+			t.Fatalf("line %d = %q, want prefix %q", i, line, prefix)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
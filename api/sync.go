@@ -0,0 +1,25 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// GetSyncGaps retrieves the block height ranges missing from the database
+func GetSyncGaps(scanner *GapScanner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var status models.SyncStatus
+		if c.Query("refresh") == "true" {
+			status = scanner.Scan()
+		} else {
+			status = scanner.GetSyncStatus()
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
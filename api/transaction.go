@@ -21,8 +21,10 @@ func GetAllTransactions(db *sql.DB) gin.HandlerFunc {
 		actionType := c.Query("action_type")
 		actionName := strings.ToLower(c.Query("action_name"))
 		user := c.Query("user")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Normalize user to search with and without "0x" prefix
 		if user != "" {
@@ -37,17 +39,13 @@ func GetAllTransactions(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch filtered transactions with pagination
-		transactions, err := models.FetchFilteredTransactions(db, txHash, blockHash, actionType, actionName, user, limit, offset)
+		transactions, nextCursor, err := models.FetchFilteredTransactions(db, txHash, blockHash, actionType, actionName, user, page)
 		if err != nil {
-			log.Printf("Error fetching transactions: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve transactions"})
+			respondPageError(c, err, "Unable to retrieve transactions")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   transactions,
-		})
+		respondPage(c, totalCount, transactions, nextCursor)
 	}
 }
 
@@ -87,8 +85,10 @@ func GetTransactionsByBlock(db *sql.DB) gin.HandlerFunc {
 func GetTransactionsByUser(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := c.Param("user")
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
 
 		// Get total count of user's transactions
 		var totalCount int
@@ -114,17 +114,13 @@ func GetTransactionsByUser(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Fetch paginated transactions for the user
-		transactions, err := models.FetchTransactionsByUser(db, user, limit, offset)
+		transactions, nextCursor, err := models.FetchTransactionsByUser(db, user, page)
 		if err != nil {
-			log.Printf("Error fetching transactions: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve transactions for user"})
+			respondPageError(c, err, "Unable to retrieve transactions for user")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   transactions,
-		})
+		respondPage(c, totalCount, transactions, nextCursor)
 	}
 }
 
@@ -22,34 +22,37 @@ type ValidatorStake struct {
 	Timestamp         string `json:"timestamp"`
 }
 
-// GetAllValidatorStakes retrieves all validator stakes with pagination
+// GetAllValidatorStakes retrieves all validator stakes with pagination, optionally filtered by status
 func GetAllValidatorStakes(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		limit := c.DefaultQuery("limit", "10")
-		offset := c.DefaultQuery("offset", "0")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+		status := c.Query("status")
+		if status != "" && !models.IsValidatorStatus(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
 
 		// Get validators total count
-		totalCount, err := models.CountValidatorStakes(db)
+		totalCount, err := models.CountValidatorStakes(db, status)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count validator stakes"})
 			return
 		}
 
-		stakes, err := models.FetchAllValidatorStakes(db, limit, offset)
+		stakes, nextCursor, err := models.FetchAllValidatorStakes(db, status, page)
 		if err != nil {
-			log.Printf("Error fetching validator stakes: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve validator stakes"})
+			respondPageError(c, err, "Unable to retrieve validator stakes")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"counter": totalCount,
-			"items":   stakes,
-		})
+		respondPage(c, totalCount, stakes, nextCursor)
 	}
 }
 
-// GetValidatorStakeByNodeID retrieves a specific validator stake by node ID
+// GetValidatorStakeByNodeID retrieves the latest stake of a node with its lifetime rewards
 func GetValidatorStakeByNodeID(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		nodeID := c.Param("node_id")
@@ -64,3 +67,28 @@ func GetValidatorStakeByNodeID(db *sql.DB) gin.HandlerFunc {
 		c.JSON(http.StatusOK, stake)
 	}
 }
+
+// GetValidatorRewards retrieves the rewards paid out to a validator
+func GetValidatorRewards(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nodeID := c.Param("node_id")
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountValidatorRewards(db, nodeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count validator rewards"})
+			return
+		}
+
+		rewards, nextCursor, err := models.FetchValidatorRewards(db, nodeID, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve validator rewards")
+			return
+		}
+
+		respondPage(c, totalCount, rewards, nextCursor)
+	}
+}
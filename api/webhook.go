@@ -0,0 +1,299 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nuklai/nuklaivm-external-subscriber/consts"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+	"github.com/nuklai/nuklaivm-external-subscriber/webhooks"
+)
+
+// minSecretLength is the shortest secret accepted to sign the deliveries of a webhook
+const minSecretLength = 16
+
+// webhookRequest is the body of the requests creating and updating a webhook
+type webhookRequest struct {
+	URL          string      `json:"url"`
+	Secret       string      `json:"secret"`
+	Address      string      `json:"address"`
+	ActionType   *int        `json:"action_type"`
+	ActionName   string      `json:"action_name"`
+	AssetAddress string      `json:"asset_address"`
+	MinAmount    json.Number `json:"min_amount"`
+	Active       *bool       `json:"active"`
+}
+
+// RequireAdminToken only lets through the requests authorized with the admin token. The
+// endpoints are disabled when no token is configured.
+func RequireAdminToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Webhook management is disabled, set WEBHOOK_ADMIN_TOKEN to enable it"})
+			return
+		}
+		given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CreateWebhook registers a webhook. The secret signing its deliveries is generated when
+// none is given and is only returned in this response.
+func CreateWebhook(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhook, ok := parseWebhookRequest(c)
+		if !ok {
+			return
+		}
+		if webhook.Secret == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				log.Printf("Error generating webhook secret: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create webhook"})
+				return
+			}
+			webhook.Secret = hex.EncodeToString(secret)
+		}
+
+		created, err := models.CreateWebhook(db, webhook)
+		if err != nil {
+			log.Printf("Error creating webhook: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create webhook"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, created)
+	}
+}
+
+// GetWebhooks retrieves the registered webhooks
+func GetWebhooks(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountWebhooks(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count webhooks"})
+			return
+		}
+
+		webhookList, nextCursor, err := models.FetchWebhooks(db, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve webhooks")
+			return
+		}
+
+		respondPage(c, totalCount, webhookList, nextCursor)
+	}
+}
+
+// GetWebhook retrieves a single webhook
+func GetWebhook(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseWebhookID(c)
+		if !ok {
+			return
+		}
+
+		webhook, err := models.FetchWebhook(db, id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		} else if err != nil {
+			log.Printf("Error fetching webhook %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to retrieve webhook"})
+			return
+		}
+
+		c.JSON(http.StatusOK, webhook)
+	}
+}
+
+// UpdateWebhook replaces the URL, filters and state of a webhook. Its secret is kept
+// unless a new one is given.
+func UpdateWebhook(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseWebhookID(c)
+		if !ok {
+			return
+		}
+		webhook, ok := parseWebhookRequest(c)
+		if !ok {
+			return
+		}
+		webhook.ID = id
+
+		updated, err := models.UpdateWebhook(db, webhook)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		} else if err != nil {
+			log.Printf("Error updating webhook %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to update webhook"})
+			return
+		}
+
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func DeleteWebhook(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseWebhookID(c)
+		if !ok {
+			return
+		}
+
+		deleted, err := models.DeleteWebhook(db, id)
+		if err != nil {
+			log.Printf("Error deleting webhook %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to delete webhook"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// GetWebhookDeliveries retrieves the delivery log of a webhook
+func GetWebhookDeliveries(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := parseWebhookID(c)
+		if !ok {
+			return
+		}
+		status := c.Query("status")
+		switch status {
+		case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryFailed:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, must be pending, delivered or failed"})
+			return
+		}
+		page, ok := parsePage(c, 10)
+		if !ok {
+			return
+		}
+
+		totalCount, err := models.CountWebhookDeliveries(db, id, status)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to count webhook deliveries"})
+			return
+		}
+
+		deliveries, nextCursor, err := models.FetchWebhookDeliveries(db, id, status, page)
+		if err != nil {
+			respondPageError(c, err, "Unable to retrieve webhook deliveries")
+			return
+		}
+
+		respondPage(c, totalCount, deliveries, nextCursor)
+	}
+}
+
+// parseWebhookID reads the id path parameter. It responds with 400 and returns false
+// when it is invalid.
+func parseWebhookID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// parseWebhookRequest reads and validates the body of a webhook request. It responds with
+// 400 and returns false when it is invalid.
+func parseWebhookRequest(c *gin.Context) (models.Webhook, bool) {
+	var request webhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return models.Webhook{}, false
+	}
+
+	endpoint, err := url.Parse(request.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid url, must be an http or https URL"})
+		return models.Webhook{}, false
+	}
+	if request.Secret != "" && len(request.Secret) < minSecretLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret, must be at least 16 characters"})
+		return models.Webhook{}, false
+	}
+
+	webhook := models.Webhook{
+		URL:          request.URL,
+		Secret:       request.Secret,
+		Address:      webhooks.NormalizeAddress(request.Address),
+		AssetAddress: webhooks.NormalizeAddress(request.AssetAddress),
+		Active:       request.Active == nil || *request.Active,
+	}
+
+	// The action can be given by type or by name
+	actionType := request.ActionType
+	if actionType != nil {
+		if _, ok := consts.ActionNames[uint8(*actionType)]; !ok || *actionType < 0 || *actionType > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action_type"})
+			return models.Webhook{}, false
+		}
+	}
+	if request.ActionName != "" {
+		byName, ok := actionTypeByName(request.ActionName)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action_name"})
+			return models.Webhook{}, false
+		}
+		if actionType != nil && *actionType != byName {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action_type and action_name do not match"})
+			return models.Webhook{}, false
+		}
+		actionType = &byName
+	}
+	webhook.ActionType = actionType
+
+	if request.MinAmount != "" {
+		amount, ok := new(big.Int).SetString(request.MinAmount.String(), 10)
+		if !ok || amount.Sign() < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_amount, must be a non-negative integer"})
+			return models.Webhook{}, false
+		}
+		minAmount := amount.String()
+		webhook.MinAmount = &minAmount
+	}
+
+	return webhook, true
+}
+
+// actionTypeByName returns the type of the action with the given name, ignoring case
+func actionTypeByName(name string) (int, bool) {
+	for actionType, actionName := range consts.ActionNames {
+		if strings.EqualFold(actionName, name) {
+			return int(actionType), true
+		}
+	}
+	return 0, false
+}
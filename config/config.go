@@ -32,6 +32,35 @@ func GetDatabaseURL() string {
 	)
 }
 
+// GetIngestQueueDir retrieves the directory holding the queue of blocks waiting to be
+// written to the database
+func GetIngestQueueDir() string {
+	return GetEnv("INGEST_QUEUE_DIR", "./data/ingest_queue")
+}
+
+// GetAllowRegenesis reports whether a new genesis may archive the data of the indexed
+// network and start a new epoch
+func GetAllowRegenesis() bool {
+	return GetEnv("ALLOW_REGENESIS", "false") == "true"
+}
+
+// GetWebhookAdminToken retrieves the token authorizing the webhook management endpoints,
+// which are disabled when it is empty
+func GetWebhookAdminToken() string {
+	return GetEnv("WEBHOOK_ADMIN_TOKEN", "")
+}
+
+// GetQueryGRPCPort retrieves the port of the gRPC query service
+func GetQueryGRPCPort() string {
+	return GetEnv("QUERY_GRPC_PORT", "50052")
+}
+
+// GetQueryAPIToken retrieves the token authorizing the calls to the gRPC query service,
+// which is not started when it is empty
+func GetQueryAPIToken() string {
+	return GetEnv("QUERY_API_TOKEN", "")
+}
+
 // GetWhitelistIPs retrieves the list of whitelisted IPs from the environment variable
 // and resolves domain names to IPs.
 // GetWhitelistIPs retrieves the list of whitelisted IPs and CIDR ranges
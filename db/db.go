@@ -7,14 +7,81 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/nuklai/nuklaivm-external-subscriber/config"
 )
 
-// InitDB initializes the database connection and creates the schema if it doesn't exist
+// BlockTables lists the chain tables derived from the ingested blocks. They are
+// rebuilt from the raw block archive by a reindex.
+var BlockTables = []string{
+	"blocks",
+	"transactions",
+	"actions",
+	"assets",
+	"asset_history",
+	"asset_supply_changes",
+	"nft_tokens",
+	"nft_transfers",
+	"balances",
+	"balance_changes",
+	"validator_stake",
+	"validator_rewards",
+	"delegations",
+	"delegation_rewards",
+	"datasets",
+	"dataset_history",
+	"dataset_contributions",
+	"marketplace_listings",
+	"marketplace_subscriptions",
+	"marketplace_payments",
+	"contracts",
+	"contract_calls",
+	"action_volumes",
+}
+
+// ChainTables lists the tables holding data of a single network. They are archived
+// together when a new genesis starts a new network epoch.
+var ChainTables = append(append([]string{}, BlockTables...),
+	"raw_blocks",
+	"genesis_data",
+	"chain_reorgs",
+	"health_events",
+	"daily_health_summaries",
+)
+
+// InitDB initializes the database connection and migrates the schema to the latest version
 func InitDB(connStr string) (*sql.DB, error) {
+	db, err := Connect(connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	reset := config.GetEnv("DB_RESET", "false") == "true"
+	if reset {
+		// Drop all existing tables
+		log.Println("Resetting the database...")
+		_, err := db.Exec(`DROP TABLE IF EXISTS ` + strings.Join(ChainTables, ", ") + `, network_epochs, schema_migrations CASCADE`)
+		if err != nil {
+			return nil, fmt.Errorf("error resetting the database: %w", err)
+		}
+	}
+
+	// Bring the schema up to date, refusing a database migrated by a newer subscriber
+	if err := CheckSchemaVersion(db); err != nil {
+		return nil, err
+	}
+	if err := MigrateUp(db); err != nil {
+		return nil, fmt.Errorf("error migrating schema: %w", err)
+	}
+
+	return db, nil
+}
+
+// Connect opens the database connection pool without touching the schema
+func Connect(connStr string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to the database: %w", err)
@@ -32,185 +99,135 @@ func InitDB(connStr string) (*sql.DB, error) {
 
 	log.Println("Database connection established")
 
-	reset := config.GetEnv("DB_RESET", "false") == "true"
-	if reset {
-		// Drop all existing tables
-		log.Println("Resetting the database...")
-		_, err := db.Exec(`
-			DROP TABLE IF EXISTS blocks, transactions, actions, assets, genesis_data CASCADE;
-		`)
-		if err != nil {
-			return nil, fmt.Errorf("error resetting the database: %w", err)
+	return db, nil
+}
+
+// CopyTables creates empty copies of tables from the source schema in the target schema,
+// with the same columns, defaults, constraints and index names. Serial columns get their
+// own sequence, so the copies do not depend on anything in the source schema.
+func CopyTables(dbTx *sql.Tx, source, target string, tables []string) error {
+	for _, table := range tables {
+		if err := copyTable(dbTx, source, target, table); err != nil {
+			return fmt.Errorf("error creating table %s.%s: %w", target, table, err)
 		}
 	}
+	return nil
+}
 
-	// Ensure schema is created
-	if err := CreateSchema(db); err != nil {
-		return nil, fmt.Errorf("error creating schema: %w", err)
+func copyTable(dbTx *sql.Tx, source, target, table string) error {
+	sourceTable := pq.QuoteIdentifier(source) + "." + pq.QuoteIdentifier(table)
+	targetTable := pq.QuoteIdentifier(target) + "." + pq.QuoteIdentifier(table)
+	if _, err := dbTx.Exec(`CREATE TABLE ` + targetTable + ` (LIKE ` + sourceTable + ` INCLUDING ALL EXCLUDING INDEXES)`); err != nil {
+		return err
 	}
 
-	return db, nil
-}
+	// LIKE would name the copied indexes after their columns, while migrations refer to
+	// them by name, so the keys and indexes are created again with their names
+	statements, err := queryStrings(dbTx, `
+		SELECT 'ALTER TABLE ' || $2 || ' ADD CONSTRAINT ' || quote_ident(conname) || ' ' || pg_get_constraintdef(oid)
+		FROM pg_constraint
+		WHERE conrelid = $1::regclass AND contype IN ('p', 'u', 'x')
+		ORDER BY conname`, sourceTable, targetTable)
+	if err != nil {
+		return fmt.Errorf("error reading constraints: %w", err)
+	}
+	indexes, err := queryStrings(dbTx, `
+		SELECT 'CREATE ' || CASE WHEN x.indisunique THEN 'UNIQUE ' ELSE '' END || 'INDEX ' || quote_ident(i.relname) ||
+		       ' ON ' || $2 || substring(pg_get_indexdef(x.indexrelid) FROM ' USING .*')
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		WHERE x.indrelid = $1::regclass
+		AND NOT EXISTS (
+			SELECT 1 FROM pg_constraint c
+			WHERE c.conindid = x.indexrelid AND c.conrelid = x.indrelid AND c.contype IN ('p', 'u', 'x')
+		)
+		ORDER BY i.relname`, sourceTable, targetTable)
+	if err != nil {
+		return fmt.Errorf("error reading indexes: %w", err)
+	}
 
-// CreateSchema creates the database schema if it doesn't already exist
-func CreateSchema(db *sql.DB) error {
-	schema := `
-	-- Ensure the pg_trgm extension is enabled for GIN indexes
-	CREATE EXTENSION IF NOT EXISTS pg_trgm;
-
-	CREATE TABLE IF NOT EXISTS blocks (
-			block_height BIGINT PRIMARY KEY,
-			block_hash TEXT NOT NULL,
-			parent_block_hash TEXT,
-			state_root TEXT,
-			block_size INT NOT NULL,
-			tx_count INT NOT NULL,
-			total_fee NUMERIC NOT NULL,
-			avg_tx_size NUMERIC NOT NULL,
-			unique_participants INT NOT NULL,
-			timestamp TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS transactions (
-		id SERIAL PRIMARY KEY,
-		tx_hash TEXT UNIQUE NOT NULL,
-		block_hash TEXT,
-		sponsor TEXT,
-		actors TEXT[],
-		receivers TEXT[],
-		max_fee NUMERIC,
-		success BOOLEAN,
-		fee NUMERIC,
-		actions JSON,
-		timestamp TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS actions (
-		id SERIAL PRIMARY KEY,
-		tx_hash TEXT NOT NULL,
-		action_type SMALLINT NOT NULL,
-		action_name TEXT,
-		action_index INT NOT NULL,
-		input JSON,
-    output JSON,
-		timestamp TIMESTAMP NOT NULL,
-		UNIQUE (tx_hash, action_type, action_index)
-	);
-
-  CREATE TABLE IF NOT EXISTS assets (
-    id SERIAL PRIMARY KEY,
-    asset_address TEXT NOT NULL UNIQUE,
-    asset_type_id SMALLINT NOT NULL,
-    asset_type TEXT NOT NULL,
-    asset_creator TEXT NOT NULL,
-    tx_hash TEXT NOT NULL,
-    name TEXT,
-    symbol TEXT,
-    decimals INT,
-    metadata TEXT,
-    max_supply NUMERIC,
-    mint_admin TEXT,
-    pause_unpause_admin TEXT,
-    freeze_unfreeze_admin TEXT,
-    enable_disable_kyc_account_admin TEXT,
-    timestamp TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS validator_stake (
-    id SERIAL PRIMARY KEY,
-    node_id TEXT NOT NULL UNIQUE,
-    actor TEXT NOT NULL,
-    stake_start_block BIGINT NOT NULL,
-    stake_end_block BIGINT NOT NULL,
-    staked_amount BIGINT NOT NULL,
-    delegation_fee_rate BIGINT NOT NULL,
-    reward_address TEXT NOT NULL,
-    tx_hash TEXT NOT NULL,
-    timestamp TIMESTAMP NOT NULL,
-    UNIQUE (node_id, stake_start_block)
-	);
-
-	CREATE TABLE IF NOT EXISTS health_events (
-    id SERIAL PRIMARY KEY,
-    state VARCHAR(10) NOT NULL,
-    description TEXT NOT NULL,
-    service_names TEXT[],
-    start_time TIMESTAMP NOT NULL,
-    end_time TIMESTAMP,
-    duration INT,
-    timestamp TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS daily_health_summaries (
-    date DATE PRIMARY KEY,
-    state VARCHAR(10) NOT NULL,
-    incidents TEXT[],
-    last_updated TIMESTAMP NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS action_volumes (
-    action_type SMALLINT PRIMARY KEY,
-    action_name TEXT NOT NULL,
-    total_count BIGINT NOT NULL DEFAULT 0
-	);
-
-	CREATE TABLE IF NOT EXISTS genesis_data (
-		id SERIAL PRIMARY KEY,
-		data JSON
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_block_height ON blocks(block_height);
-	CREATE INDEX IF NOT EXISTS idx_block_hash ON blocks(block_hash);
-
-	CREATE INDEX IF NOT EXISTS idx_tx_hash ON transactions(tx_hash);
-	CREATE INDEX IF NOT EXISTS idx_transactions_block_hash ON transactions(block_hash);
-	CREATE INDEX IF NOT EXISTS idx_sponsor ON transactions(sponsor);
-	CREATE INDEX IF NOT EXISTS idx_transactions_timestamp ON transactions (timestamp);
-	CREATE INDEX IF NOT EXISTS idx_actors ON transactions USING GIN (actors);
-	CREATE INDEX IF NOT EXISTS idx_receivers ON transactions USING GIN (receivers);
-
-	CREATE INDEX IF NOT EXISTS idx_action_type ON actions(action_type);
-	CREATE INDEX IF NOT EXISTS idx_action_name_lower ON actions (LOWER(action_name));
-
-	CREATE INDEX IF NOT EXISTS idx_assets_creator ON assets(asset_creator);
-  	CREATE INDEX IF NOT EXISTS idx_assets_type ON assets(asset_type_id);
-	CREATE INDEX IF NOT EXISTS idx_asset_address ON assets(asset_address);
-
-	CREATE INDEX IF NOT EXISTS idx_validator_stake_node_id ON validator_stake(node_id);
-	CREATE INDEX IF NOT EXISTS idx_validator_stake_actor ON validator_stake(actor);
-	CREATE INDEX IF NOT EXISTS idx_validator_stake_timestamp ON validator_stake(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_health_events_state ON health_events(state);
-	CREATE INDEX IF NOT EXISTS idx_health_events_timestamp ON health_events(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_daily_health_summaries_date ON daily_health_summaries(date);
-	CREATE INDEX IF NOT EXISTS idx_daily_health_summaries_state ON daily_health_summaries(state);
-	CREATE INDEX IF NOT EXISTS idx_daily_health_summaries_last_updated ON daily_health_summaries(last_updated);
-	CREATE INDEX IF NOT EXISTS idx_action_volumes_name ON action_volumes(action_name);
-
-	`
-
-	_, err := db.Exec(schema)
+	// The copied defaults still draw from the sequences of the source tables
+	serials, err := queryStrings(dbTx, `
+		SELECT a.attname || ' ' || format_type(a.atttypid, a.atttypmod)
+		FROM pg_attribute a
+		JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND pg_get_expr(d.adbin, d.adrelid) LIKE 'nextval(%'
+		ORDER BY a.attnum`, targetTable)
 	if err != nil {
-		return fmt.Errorf("error executing schema creation: %w", err)
-	}
-
-	_, err = db.Exec(`
-    DO $$ 
-    BEGIN 
-        IF NOT EXISTS (
-            SELECT 1 
-            FROM information_schema.columns 
-            WHERE table_name = 'health_events' 
-            AND column_name = 'service_names'
-        ) THEN
-            ALTER TABLE health_events 
-            ADD COLUMN service_names TEXT[];
-        END IF;
-    END $$;
-    `)
+		return fmt.Errorf("error reading serial columns: %w", err)
+	}
+	for _, serial := range serials {
+		column, columnType, _ := strings.Cut(serial, " ")
+		sequence := pq.QuoteIdentifier(target) + "." + pq.QuoteIdentifier(table+"_"+column+"_seq")
+		statements = append(statements,
+			`CREATE SEQUENCE `+sequence+` AS `+columnType+` OWNED BY `+targetTable+`.`+pq.QuoteIdentifier(column),
+			`ALTER TABLE `+targetTable+` ALTER COLUMN `+pq.QuoteIdentifier(column)+` SET DEFAULT nextval(`+pq.QuoteLiteral(sequence)+`)`)
+	}
+
+	for _, statement := range append(statements, indexes...) {
+		if _, err := dbTx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryStrings returns the single string column of the rows of a query
+func queryStrings(dbTx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := dbTx.Query(query, args...)
 	if err != nil {
-		return fmt.Errorf("error service_names column: %w", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// ArchiveChainTables moves every chain table into the given schema and creates empty
+// copies in their place
+func ArchiveChainTables(dbTx *sql.Tx, schema string) error {
+	quotedSchema := pq.QuoteIdentifier(schema)
+	if _, err := dbTx.Exec(`CREATE SCHEMA ` + quotedSchema); err != nil {
+		return fmt.Errorf("error creating archive schema %s: %w", schema, err)
+	}
+
+	for _, table := range ChainTables {
+		if _, err := dbTx.Exec(`ALTER TABLE IF EXISTS public.` + table + ` SET SCHEMA ` + quotedSchema); err != nil {
+			return fmt.Errorf("error archiving table %s: %w", table, err)
+		}
+	}
+
+	return CopyTables(dbTx, schema, "public", ChainTables)
+}
+
+// SwapBlockTables replaces the block tables with the ones built in the staging schema.
+// The replaced tables are moved into the backup schema and the staging schema is dropped.
+func SwapBlockTables(dbTx *sql.Tx, staging, backup string) error {
+	quotedStaging := pq.QuoteIdentifier(staging)
+	quotedBackup := pq.QuoteIdentifier(backup)
+	if _, err := dbTx.Exec(`CREATE SCHEMA ` + quotedBackup); err != nil {
+		return fmt.Errorf("error creating backup schema %s: %w", backup, err)
+	}
+
+	for _, table := range BlockTables {
+		if _, err := dbTx.Exec(`ALTER TABLE public.` + table + ` SET SCHEMA ` + quotedBackup); err != nil {
+			return fmt.Errorf("error backing up table %s: %w", table, err)
+		}
+		if _, err := dbTx.Exec(`ALTER TABLE ` + quotedStaging + `.` + table + ` SET SCHEMA public`); err != nil {
+			return fmt.Errorf("error swapping in table %s: %w", table, err)
+		}
 	}
 
-	log.Println("Database schema created or already exists")
+	if _, err := dbTx.Exec(`DROP SCHEMA ` + quotedStaging + ` CASCADE`); err != nil {
+		return fmt.Errorf("error dropping staging schema %s: %w", staging, err)
+	}
 	return nil
 }
@@ -0,0 +1,59 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package db
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCopyTables(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer conn.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE "public"."assets" (LIKE "epoch_1"."assets" INCLUDING ALL EXCLUDING INDEXES)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM pg_constraint`)).
+		WithArgs(`"epoch_1"."assets"`, `"public"."assets"`).
+		WillReturnRows(sqlmock.NewRows([]string{"statement"}).
+			AddRow(`ALTER TABLE "public"."assets" ADD CONSTRAINT assets_pkey PRIMARY KEY (id)`))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM pg_index`)).
+		WithArgs(`"epoch_1"."assets"`, `"public"."assets"`).
+		WillReturnRows(sqlmock.NewRows([]string{"statement"}).
+			AddRow(`CREATE INDEX idx_assets_owner ON "public"."assets" USING btree (owner)`))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM pg_attribute`)).
+		WithArgs(`"public"."assets"`).
+		WillReturnRows(sqlmock.NewRows([]string{"column"}).AddRow("id integer"))
+
+	// The keys and the serial column are set up before the indexes are built
+	mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "public"."assets" ADD CONSTRAINT assets_pkey PRIMARY KEY (id)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE SEQUENCE "public"."assets_id_seq" AS integer OWNED BY "public"."assets"."id"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "public"."assets" ALTER COLUMN "id" SET DEFAULT nextval('"public"."assets_id_seq"')`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE INDEX idx_assets_owner ON "public"."assets" USING btree (owner)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	dbTx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyTables(dbTx, "epoch_1", "public", []string{"assets"}); err != nil {
+		t.Fatalf("CopyTables() error = %v", err)
+	}
+	if err := dbTx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
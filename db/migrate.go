@@ -0,0 +1,305 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// schemaMigrationLockID is the advisory lock key held while a migration is applied
+const schemaMigrationLockID = 7_200_232
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationFileName matches <version>_<name>.<up|down>.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change with the SQL to apply and revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration is applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// LoadMigrations returns the embedded migrations ordered by version. Every migration
+// must have both an up and a down file.
+func LoadMigrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files named %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// LatestSchemaVersion returns the version of the last embedded migration
+func LatestSchemaVersion() (int, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// SchemaVersion returns the highest migration version applied to the database, or 0
+func SchemaVersion(db *sql.DB) (int, error) {
+	if err := createMigrationsTable(db); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error fetching schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// MigrationStatuses lists every embedded migration with the time it was applied
+func MigrationStatuses(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies every migration that is not applied yet
+func MigrateUp(db *sql.DB) error {
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	return MigrateTo(db, latest)
+}
+
+// MigrateDown reverts the last applied migration
+func MigrateDown(db *sql.DB) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return fmt.Errorf("no migration is applied")
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	target := 0
+	for _, migration := range migrations {
+		if migration.Version < version {
+			target = migration.Version
+		}
+	}
+	return MigrateTo(db, target)
+}
+
+// MigrateTo applies or reverts migrations until the database is at the given version.
+// Each migration runs in its own transaction.
+func MigrateTo(db *sql.DB, version int) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	if version != 0 && !hasMigration(migrations, version) {
+		return fmt.Errorf("unknown schema version %d", version)
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > 0 && !hasMigration(migrations, current) {
+		return fmt.Errorf("database schema version %d is not known to this subscriber", current)
+	}
+
+	for _, migration := range migrations {
+		if migration.Version > current && migration.Version <= version {
+			if err := applyMigration(db, migration, true); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version <= current && migration.Version > version {
+			if err := applyMigration(db, migration, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckSchemaVersion refuses a database migrated by a newer subscriber
+func CheckSchemaVersion(db *sql.DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than version %d supported by this subscriber", current, latest)
+	}
+	return nil
+}
+
+// applyMigration runs the up or down SQL of a migration and records the result. It is a
+// no-op when another process already applied or reverted the migration.
+func applyMigration(db *sql.DB, migration Migration, up bool) error {
+	dbTx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	// Serialize with any other process migrating the database
+	if _, err := dbTx.Exec(`SELECT pg_advisory_xact_lock($1)`, schemaMigrationLockID); err != nil {
+		return fmt.Errorf("error acquiring schema migration lock: %w", err)
+	}
+	var applied bool
+	err = dbTx.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, migration.Version).Scan(&applied)
+	if err != nil {
+		return fmt.Errorf("error checking migration %d: %w", migration.Version, err)
+	}
+	if applied == up {
+		return nil
+	}
+
+	if up {
+		log.Printf("Applying migration %d_%s\n", migration.Version, migration.Name)
+		if _, err := dbTx.Exec(migration.Up); err != nil {
+			return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		_, err = dbTx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		log.Printf("Reverting migration %d_%s\n", migration.Version, migration.Name)
+		if _, err := dbTx.Exec(migration.Down); err != nil {
+			return fmt.Errorf("error reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		_, err = dbTx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("error recording migration %d: %w", migration.Version, err)
+	}
+	return dbTx.Commit()
+}
+
+// appliedMigrations returns the applied migration versions with the time they were applied
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	if err := createMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func createMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+func hasMigration(migrations []Migration, version int) bool {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package db
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+
+	for i, migration := range migrations {
+		if i > 0 && migration.Version <= migrations[i-1].Version {
+			t.Fatalf("migration %d is not ordered after %d", migration.Version, migrations[i-1].Version)
+		}
+		if strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			t.Fatalf("migration %d_%s has an empty up or down file", migration.Version, migration.Name)
+		}
+	}
+
+	// The migrations create every chain table, which are copied for a new network epoch
+	for _, table := range append(ChainTables, "network_epochs") {
+		if !strings.Contains(migrations[0].Up, "CREATE TABLE IF NOT EXISTS "+table+" (") {
+			t.Errorf("table %s is not created by the migrations", table)
+		}
+	}
+}
+
+func TestCheckSchemaVersionRefusesNewerSchema(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer conn.Close()
+
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT MAX(version) FROM schema_migrations`)).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(latest + 1))
+
+	if err := CheckSchemaVersion(conn); err == nil {
+		t.Fatal("CheckSchemaVersion() accepted a newer schema")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+DROP TABLE IF EXISTS network_epochs CASCADE;
+DROP TABLE IF EXISTS chain_reorgs CASCADE;
+DROP TABLE IF EXISTS genesis_data CASCADE;
+DROP TABLE IF EXISTS action_volumes CASCADE;
+DROP TABLE IF EXISTS daily_health_summaries CASCADE;
+DROP TABLE IF EXISTS health_events CASCADE;
+DROP TABLE IF EXISTS raw_blocks CASCADE;
+DROP TABLE IF EXISTS contract_calls CASCADE;
+DROP TABLE IF EXISTS contracts CASCADE;
+DROP TABLE IF EXISTS marketplace_payments CASCADE;
+DROP TABLE IF EXISTS marketplace_subscriptions CASCADE;
+DROP TABLE IF EXISTS marketplace_listings CASCADE;
+DROP TABLE IF EXISTS dataset_contributions CASCADE;
+DROP TABLE IF EXISTS dataset_history CASCADE;
+DROP TABLE IF EXISTS datasets CASCADE;
+DROP TABLE IF EXISTS delegation_rewards CASCADE;
+DROP TABLE IF EXISTS delegations CASCADE;
+DROP TABLE IF EXISTS validator_rewards CASCADE;
+DROP TABLE IF EXISTS validator_stake CASCADE;
+DROP TABLE IF EXISTS balance_changes CASCADE;
+DROP TABLE IF EXISTS balances CASCADE;
+DROP TABLE IF EXISTS nft_transfers CASCADE;
+DROP TABLE IF EXISTS nft_tokens CASCADE;
+DROP TABLE IF EXISTS asset_supply_changes CASCADE;
+DROP TABLE IF EXISTS asset_history CASCADE;
+DROP TABLE IF EXISTS assets CASCADE;
+DROP TABLE IF EXISTS actions CASCADE;
+DROP TABLE IF EXISTS transactions CASCADE;
+DROP TABLE IF EXISTS blocks CASCADE;
@@ -0,0 +1,461 @@
+-- Baseline schema. Every statement is idempotent, so databases created before
+-- migrations were versioned are adopted without changes.
+
+-- Ensure the pg_trgm extension is enabled for GIN indexes
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE IF NOT EXISTS blocks (
+  block_height BIGINT PRIMARY KEY,
+  block_hash TEXT NOT NULL,
+  parent_block_hash TEXT,
+  state_root TEXT,
+  block_size INT NOT NULL,
+  tx_count INT NOT NULL,
+  total_fee NUMERIC NOT NULL,
+  avg_tx_size NUMERIC NOT NULL,
+  unique_participants INT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+  id SERIAL PRIMARY KEY,
+  tx_hash TEXT UNIQUE NOT NULL,
+  block_hash TEXT,
+  sponsor TEXT,
+  actors TEXT[],
+  receivers TEXT[],
+  max_fee NUMERIC,
+  success BOOLEAN,
+  fee NUMERIC,
+  actions JSON,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS actions (
+  id SERIAL PRIMARY KEY,
+  tx_hash TEXT NOT NULL,
+  action_type SMALLINT NOT NULL,
+  action_name TEXT,
+  action_index INT NOT NULL,
+  input JSON,
+  output JSON,
+  timestamp TIMESTAMP NOT NULL,
+  UNIQUE (tx_hash, action_type, action_index)
+);
+
+CREATE TABLE IF NOT EXISTS assets (
+  id SERIAL PRIMARY KEY,
+  asset_address TEXT NOT NULL UNIQUE,
+  asset_type_id SMALLINT NOT NULL,
+  asset_type TEXT NOT NULL,
+  asset_creator TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  name TEXT,
+  symbol TEXT,
+  decimals INT,
+  metadata TEXT,
+  max_supply NUMERIC,
+  mint_admin TEXT,
+  pause_unpause_admin TEXT,
+  freeze_unfreeze_admin TEXT,
+  enable_disable_kyc_account_admin TEXT,
+  timestamp TIMESTAMP NOT NULL
+);
+
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS owner TEXT;
+UPDATE assets SET owner = asset_creator WHERE owner IS NULL;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS total_minted NUMERIC NOT NULL DEFAULT 0;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS total_burned NUMERIC NOT NULL DEFAULT 0;
+ALTER TABLE assets ADD COLUMN IF NOT EXISTS current_supply NUMERIC NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS asset_history (
+  id SERIAL PRIMARY KEY,
+  asset_address TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  action_name TEXT NOT NULL,
+  changed_fields JSONB NOT NULL,
+  previous_values JSONB NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS asset_supply_changes (
+  id SERIAL PRIMARY KEY,
+  asset_address TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  action_name TEXT NOT NULL,
+  minted NUMERIC NOT NULL,
+  burned NUMERIC NOT NULL,
+  total_minted NUMERIC NOT NULL,
+  total_burned NUMERIC NOT NULL,
+  current_supply NUMERIC NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS nft_tokens (
+  id SERIAL PRIMARY KEY,
+  collection_address TEXT NOT NULL,
+  unique_id BIGINT NOT NULL,
+  nft_address TEXT NOT NULL UNIQUE,
+  owner TEXT NOT NULL,
+  metadata TEXT,
+  mint_tx_hash TEXT NOT NULL,
+  mint_block_height BIGINT NOT NULL,
+  minted_at TIMESTAMP NOT NULL,
+  burned BOOLEAN NOT NULL DEFAULT false,
+  burn_tx_hash TEXT,
+  burned_at TIMESTAMP,
+  UNIQUE (collection_address, unique_id)
+);
+
+CREATE TABLE IF NOT EXISTS nft_transfers (
+  id SERIAL PRIMARY KEY,
+  collection_address TEXT NOT NULL,
+  unique_id BIGINT NOT NULL,
+  nft_address TEXT NOT NULL,
+  action_name TEXT NOT NULL,
+  from_address TEXT,
+  to_address TEXT,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS balances (
+  address TEXT NOT NULL,
+  asset_address TEXT NOT NULL,
+  balance NUMERIC NOT NULL,
+  PRIMARY KEY (address, asset_address)
+);
+
+CREATE TABLE IF NOT EXISTS balance_changes (
+  id SERIAL PRIMARY KEY,
+  address TEXT NOT NULL,
+  asset_address TEXT NOT NULL,
+  tx_hash TEXT,
+  block_height BIGINT NOT NULL,
+  action_name TEXT NOT NULL,
+  previous_balance NUMERIC,
+  new_balance NUMERIC NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS validator_stake (
+  id SERIAL PRIMARY KEY,
+  node_id TEXT NOT NULL,
+  actor TEXT NOT NULL,
+  stake_start_block BIGINT NOT NULL,
+  stake_end_block BIGINT NOT NULL,
+  staked_amount BIGINT NOT NULL,
+  delegation_fee_rate BIGINT NOT NULL,
+  reward_address TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  timestamp TIMESTAMP NOT NULL,
+  withdrawal_tx_hash TEXT,
+  withdrawal_block_height BIGINT,
+  withdrawn_at TIMESTAMP,
+  UNIQUE (node_id, stake_start_block)
+);
+
+-- A node can register again once its previous stake is withdrawn
+ALTER TABLE validator_stake DROP CONSTRAINT IF EXISTS validator_stake_node_id_key;
+ALTER TABLE validator_stake ADD COLUMN IF NOT EXISTS withdrawal_tx_hash TEXT;
+ALTER TABLE validator_stake ADD COLUMN IF NOT EXISTS withdrawal_block_height BIGINT;
+ALTER TABLE validator_stake ADD COLUMN IF NOT EXISTS withdrawn_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS validator_rewards (
+  id SERIAL PRIMARY KEY,
+  node_id TEXT NOT NULL,
+  stake_start_block BIGINT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  action_name TEXT NOT NULL,
+  reward_amount NUMERIC NOT NULL,
+  distributed_to TEXT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS delegations (
+  id SERIAL PRIMARY KEY,
+  delegator TEXT NOT NULL,
+  node_id TEXT NOT NULL,
+  staked_amount BIGINT NOT NULL,
+  stake_start_block BIGINT NOT NULL,
+  stake_end_block BIGINT NOT NULL,
+  status TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL,
+  undelegation_tx_hash TEXT,
+  undelegation_block_height BIGINT,
+  undelegated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS delegation_rewards (
+  id SERIAL PRIMARY KEY,
+  delegator TEXT NOT NULL,
+  node_id TEXT NOT NULL,
+  stake_start_block BIGINT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  action_name TEXT NOT NULL,
+  reward_amount NUMERIC NOT NULL,
+  distributed_to TEXT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS datasets (
+  id SERIAL PRIMARY KEY,
+  dataset_address TEXT NOT NULL UNIQUE,
+  asset_address TEXT NOT NULL,
+  parent_nft_address TEXT,
+  owner TEXT NOT NULL,
+  name TEXT NOT NULL,
+  description TEXT,
+  categories TEXT,
+  license_name TEXT,
+  license_symbol TEXT,
+  license_url TEXT,
+  metadata TEXT,
+  is_community_dataset BOOLEAN NOT NULL,
+  marketplace_asset_address TEXT,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS dataset_history (
+  id SERIAL PRIMARY KEY,
+  dataset_address TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  action_name TEXT NOT NULL,
+  changed_fields JSONB NOT NULL,
+  previous_values JSONB NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS dataset_contributions (
+  id SERIAL PRIMARY KEY,
+  contribution_id TEXT NOT NULL UNIQUE,
+  dataset_address TEXT NOT NULL,
+  contributor TEXT NOT NULL,
+  data_location TEXT NOT NULL,
+  data_identifier TEXT NOT NULL,
+  collateral_asset_address TEXT,
+  collateral_amount NUMERIC NOT NULL,
+  status TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL,
+  contribution_nft_address TEXT,
+  collateral_refunded NUMERIC,
+  completion_tx_hash TEXT,
+  completion_block_height BIGINT,
+  completed_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS marketplace_listings (
+  id SERIAL PRIMARY KEY,
+  dataset_address TEXT NOT NULL,
+  marketplace_asset_address TEXT NOT NULL UNIQUE,
+  payment_asset_address TEXT NOT NULL,
+  price_per_block NUMERIC NOT NULL,
+  publisher TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS marketplace_subscriptions (
+  id SERIAL PRIMARY KEY,
+  marketplace_asset_address TEXT NOT NULL,
+  subscriber TEXT NOT NULL,
+  subscription_nft_address TEXT NOT NULL,
+  payment_asset_address TEXT NOT NULL,
+  price_per_block NUMERIC NOT NULL,
+  total_cost NUMERIC NOT NULL,
+  num_blocks BIGINT NOT NULL,
+  issuance_block BIGINT NOT NULL,
+  expiration_block BIGINT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS marketplace_payments (
+  id SERIAL PRIMARY KEY,
+  marketplace_asset_address TEXT NOT NULL,
+  payment_asset_address TEXT NOT NULL,
+  amount NUMERIC NOT NULL,
+  distributed_to TEXT NOT NULL,
+  payment_claimed NUMERIC NOT NULL,
+  payment_remaining NUMERIC NOT NULL,
+  last_claimed_block BIGINT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contracts (
+  id SERIAL PRIMARY KEY,
+  contract_type TEXT NOT NULL,
+  contract_address TEXT UNIQUE,
+  program_id TEXT NOT NULL,
+  bytecode_hash TEXT,
+  size INT,
+  creator TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contract_calls (
+  id SERIAL PRIMARY KEY,
+  contract_address TEXT NOT NULL,
+  caller TEXT NOT NULL,
+  function_name TEXT NOT NULL,
+  value NUMERIC NOT NULL,
+  fuel_limit BIGINT NOT NULL,
+  fuel_consumed BIGINT NOT NULL,
+  result TEXT NOT NULL,
+  tx_hash TEXT NOT NULL,
+  block_height BIGINT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS raw_blocks (
+  block_height BIGINT PRIMARY KEY,
+  block_hash TEXT NOT NULL,
+  data BYTEA NOT NULL,
+  size INT NOT NULL,
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS health_events (
+  id SERIAL PRIMARY KEY,
+  state VARCHAR(10) NOT NULL,
+  description TEXT NOT NULL,
+  service_names TEXT[],
+  start_time TIMESTAMP NOT NULL,
+  end_time TIMESTAMP,
+  duration INT,
+  timestamp TIMESTAMP NOT NULL
+);
+
+ALTER TABLE health_events ADD COLUMN IF NOT EXISTS service_names TEXT[];
+
+CREATE TABLE IF NOT EXISTS daily_health_summaries (
+  date DATE PRIMARY KEY,
+  state VARCHAR(10) NOT NULL,
+  incidents TEXT[],
+  last_updated TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS action_volumes (
+  action_type SMALLINT PRIMARY KEY,
+  action_name TEXT NOT NULL,
+  total_count BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS genesis_data (
+  id SERIAL PRIMARY KEY,
+  data JSON
+);
+
+CREATE TABLE IF NOT EXISTS chain_reorgs (
+  id SERIAL PRIMARY KEY,
+  fork_height BIGINT NOT NULL,
+  old_tip_height BIGINT NOT NULL,
+  old_tip_hash TEXT NOT NULL,
+  new_block_height BIGINT NOT NULL,
+  new_block_hash TEXT NOT NULL,
+  orphaned_blocks INT NOT NULL,
+  orphaned_transactions INT NOT NULL,
+  orphaned_block_hashes TEXT[],
+  timestamp TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS network_epochs (
+  id SERIAL PRIMARY KEY,
+  genesis_hash TEXT NOT NULL,
+  archive_schema TEXT,
+  started_at TIMESTAMP NOT NULL,
+  archived_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_block_height ON blocks(block_height);
+CREATE INDEX IF NOT EXISTS idx_block_hash ON blocks(block_hash);
+
+CREATE INDEX IF NOT EXISTS idx_tx_hash ON transactions(tx_hash);
+CREATE INDEX IF NOT EXISTS idx_transactions_block_hash ON transactions(block_hash);
+CREATE INDEX IF NOT EXISTS idx_sponsor ON transactions(sponsor);
+CREATE INDEX IF NOT EXISTS idx_transactions_timestamp ON transactions (timestamp);
+CREATE INDEX IF NOT EXISTS idx_actors ON transactions USING GIN (actors);
+CREATE INDEX IF NOT EXISTS idx_receivers ON transactions USING GIN (receivers);
+
+CREATE INDEX IF NOT EXISTS idx_action_type ON actions(action_type);
+CREATE INDEX IF NOT EXISTS idx_action_name_lower ON actions (LOWER(action_name));
+
+CREATE INDEX IF NOT EXISTS idx_assets_creator ON assets(asset_creator);
+CREATE INDEX IF NOT EXISTS idx_assets_type ON assets(asset_type_id);
+CREATE INDEX IF NOT EXISTS idx_asset_address ON assets(asset_address);
+CREATE INDEX IF NOT EXISTS idx_asset_history_asset_address ON asset_history(asset_address);
+CREATE INDEX IF NOT EXISTS idx_asset_history_block_height ON asset_history(block_height);
+CREATE INDEX IF NOT EXISTS idx_asset_supply_changes_asset_address ON asset_supply_changes(asset_address, block_height);
+CREATE INDEX IF NOT EXISTS idx_asset_supply_changes_block_height ON asset_supply_changes(block_height);
+
+CREATE INDEX IF NOT EXISTS idx_nft_tokens_owner ON nft_tokens(owner);
+CREATE INDEX IF NOT EXISTS idx_nft_tokens_mint_block_height ON nft_tokens(mint_block_height);
+CREATE INDEX IF NOT EXISTS idx_nft_transfers_nft_address ON nft_transfers(nft_address);
+CREATE INDEX IF NOT EXISTS idx_nft_transfers_block_height ON nft_transfers(block_height);
+
+CREATE INDEX IF NOT EXISTS idx_balances_asset_balance ON balances(asset_address, balance DESC);
+CREATE INDEX IF NOT EXISTS idx_balance_changes_address ON balance_changes(address, block_height);
+CREATE INDEX IF NOT EXISTS idx_balance_changes_block_height ON balance_changes(block_height);
+
+CREATE INDEX IF NOT EXISTS idx_validator_stake_node_id ON validator_stake(node_id);
+CREATE INDEX IF NOT EXISTS idx_validator_stake_actor ON validator_stake(actor);
+CREATE INDEX IF NOT EXISTS idx_validator_stake_timestamp ON validator_stake(timestamp);
+CREATE INDEX IF NOT EXISTS idx_validator_stake_withdrawal_block_height ON validator_stake(withdrawal_block_height);
+CREATE INDEX IF NOT EXISTS idx_validator_rewards_node_id ON validator_rewards(node_id, stake_start_block);
+CREATE INDEX IF NOT EXISTS idx_validator_rewards_block_height ON validator_rewards(block_height);
+CREATE INDEX IF NOT EXISTS idx_delegations_node_id ON delegations(node_id, status);
+CREATE INDEX IF NOT EXISTS idx_delegations_delegator ON delegations(delegator);
+CREATE INDEX IF NOT EXISTS idx_delegations_block_height ON delegations(block_height);
+CREATE INDEX IF NOT EXISTS idx_delegations_undelegation_block_height ON delegations(undelegation_block_height);
+CREATE INDEX IF NOT EXISTS idx_delegation_rewards_delegation ON delegation_rewards(delegator, node_id, stake_start_block);
+CREATE INDEX IF NOT EXISTS idx_delegation_rewards_block_height ON delegation_rewards(block_height);
+CREATE INDEX IF NOT EXISTS idx_datasets_owner ON datasets(owner);
+CREATE INDEX IF NOT EXISTS idx_datasets_block_height ON datasets(block_height);
+CREATE INDEX IF NOT EXISTS idx_dataset_history_dataset_address ON dataset_history(dataset_address);
+CREATE INDEX IF NOT EXISTS idx_dataset_history_block_height ON dataset_history(block_height);
+CREATE INDEX IF NOT EXISTS idx_dataset_contributions_dataset_address ON dataset_contributions(dataset_address);
+CREATE INDEX IF NOT EXISTS idx_dataset_contributions_contributor ON dataset_contributions(contributor);
+CREATE INDEX IF NOT EXISTS idx_dataset_contributions_block_height ON dataset_contributions(block_height);
+CREATE INDEX IF NOT EXISTS idx_dataset_contributions_completion_block_height ON dataset_contributions(completion_block_height);
+CREATE INDEX IF NOT EXISTS idx_marketplace_listings_dataset_address ON marketplace_listings(dataset_address);
+CREATE INDEX IF NOT EXISTS idx_marketplace_listings_publisher ON marketplace_listings(publisher);
+CREATE INDEX IF NOT EXISTS idx_marketplace_listings_block_height ON marketplace_listings(block_height);
+CREATE INDEX IF NOT EXISTS idx_marketplace_subscriptions_asset ON marketplace_subscriptions(marketplace_asset_address, expiration_block);
+CREATE INDEX IF NOT EXISTS idx_marketplace_subscriptions_subscriber ON marketplace_subscriptions(subscriber);
+CREATE INDEX IF NOT EXISTS idx_marketplace_subscriptions_timestamp ON marketplace_subscriptions(timestamp);
+CREATE INDEX IF NOT EXISTS idx_marketplace_subscriptions_block_height ON marketplace_subscriptions(block_height);
+CREATE INDEX IF NOT EXISTS idx_marketplace_payments_asset ON marketplace_payments(marketplace_asset_address, block_height);
+CREATE INDEX IF NOT EXISTS idx_marketplace_payments_timestamp ON marketplace_payments(timestamp);
+CREATE INDEX IF NOT EXISTS idx_marketplace_payments_block_height ON marketplace_payments(block_height);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_contracts_program ON contracts(program_id) WHERE contract_type = 'program';
+CREATE INDEX IF NOT EXISTS idx_contracts_instance_program ON contracts(program_id) WHERE contract_type = 'instance';
+CREATE INDEX IF NOT EXISTS idx_contracts_creator ON contracts(creator);
+CREATE INDEX IF NOT EXISTS idx_contracts_block_height ON contracts(block_height);
+CREATE INDEX IF NOT EXISTS idx_contract_calls_contract ON contract_calls(contract_address, block_height);
+CREATE INDEX IF NOT EXISTS idx_contract_calls_caller ON contract_calls(caller);
+CREATE INDEX IF NOT EXISTS idx_contract_calls_block_height ON contract_calls(block_height);
+CREATE INDEX IF NOT EXISTS idx_health_events_state ON health_events(state);
+CREATE INDEX IF NOT EXISTS idx_health_events_timestamp ON health_events(timestamp);
+CREATE INDEX IF NOT EXISTS idx_daily_health_summaries_date ON daily_health_summaries(date);
+CREATE INDEX IF NOT EXISTS idx_daily_health_summaries_state ON daily_health_summaries(state);
+CREATE INDEX IF NOT EXISTS idx_daily_health_summaries_last_updated ON daily_health_summaries(last_updated);
+CREATE INDEX IF NOT EXISTS idx_action_volumes_name ON action_volumes(action_name);
+CREATE INDEX IF NOT EXISTS idx_chain_reorgs_timestamp ON chain_reorgs(timestamp);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_chain_reorgs_timestamp_id;
+DROP INDEX IF EXISTS idx_assets_timestamp_id;
+DROP INDEX IF EXISTS idx_actions_timestamp_id;
+DROP INDEX IF EXISTS idx_transactions_timestamp_id;
@@ -0,0 +1,5 @@
+-- The list endpoints page through these tables in (timestamp, id) order
+CREATE INDEX IF NOT EXISTS idx_transactions_timestamp_id ON transactions(timestamp, id);
+CREATE INDEX IF NOT EXISTS idx_actions_timestamp_id ON actions(timestamp, id);
+CREATE INDEX IF NOT EXISTS idx_assets_timestamp_id ON assets(timestamp, id);
+CREATE INDEX IF NOT EXISTS idx_chain_reorgs_timestamp_id ON chain_reorgs(timestamp, id);
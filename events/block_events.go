@@ -0,0 +1,190 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// assetActions are the actions published on the assets topic
+var assetActions = map[string]bool{
+	"CreateAsset":  true,
+	"UpdateAsset":  true,
+	"MintAssetFT":  true,
+	"MintAssetNFT": true,
+	"BurnAssetFT":  true,
+	"BurnAssetNFT": true,
+}
+
+// validatorActions are the actions published on the validators topic
+var validatorActions = map[string]bool{
+	"RegisterValidatorStake":      true,
+	"WithdrawValidatorStake":      true,
+	"ClaimValidatorStakeRewards":  true,
+	"DelegateUserStake":           true,
+	"UndelegateUserStake":         true,
+	"ClaimDelegationStakeRewards": true,
+}
+
+// LoadBlockEvents builds the events of an indexed block from the database. It returns
+// no events when no block is indexed at the height. The events of a block that replaced
+// blocks during a reorg start with the reorg event.
+func LoadBlockEvents(db *sql.DB, height int64) ([]Event, error) {
+	identifier := strconv.FormatInt(height, 10)
+	block, err := models.FetchBlock(db, identifier, "")
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := models.FetchTransactionsByBlock(db, identifier)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := models.FetchActionsByBlock(db, identifier)
+	if err != nil {
+		return nil, err
+	}
+	blockEvents := BlockEvents(block, transactions, actions)
+
+	reorg, err := models.FetchChainReorgByBlock(db, block.BlockHeight, block.BlockHash)
+	if err == sql.ErrNoRows {
+		return blockEvents, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]Event{{Topic: TopicReorgs, BlockHeight: block.BlockHeight, Data: reorg}}, blockEvents...), nil
+}
+
+// BlockEvents returns the events of a block in publication order: the block, then its
+// transactions, then its actions followed by their asset or validator event
+func BlockEvents(block models.Block, transactions []models.Transaction, actions []models.Action) []Event {
+	blockEvents := []Event{{Topic: TopicBlocks, BlockHeight: block.BlockHeight, Data: block}}
+
+	participants := make(map[string][]string, len(transactions))
+	for _, tx := range transactions {
+		addresses := append([]string{tx.Sponsor}, tx.Actors...)
+		addresses = append(addresses, tx.Receivers...)
+		participants[tx.TxHash] = addresses
+
+		blockEvents = append(blockEvents, Event{
+			Topic:       TopicTransactions,
+			BlockHeight: block.BlockHeight,
+			Data:        tx,
+			addresses:   addresses,
+		})
+	}
+
+	for _, action := range actions {
+		blockEvents = append(blockEvents, Event{
+			Topic:       TopicActions,
+			BlockHeight: block.BlockHeight,
+			Data:        action,
+			addresses:   participants[action.TxHash],
+			actionName:  action.ActionName,
+		})
+
+		switch {
+		case assetActions[action.ActionName]:
+			blockEvents = append(blockEvents, Event{
+				Topic:       TopicAssets,
+				BlockHeight: block.BlockHeight,
+				Data:        action,
+				addresses:   actionValues(action, "asset_address"),
+				actionName:  action.ActionName,
+			})
+		case validatorActions[action.ActionName]:
+			blockEvents = append(blockEvents, Event{
+				Topic:       TopicValidators,
+				BlockHeight: block.BlockHeight,
+				Data:        action,
+				addresses:   actionValues(action, "node_id"),
+				actionName:  action.ActionName,
+			})
+		}
+	}
+	return blockEvents
+}
+
+// actionValues returns the string values of a field in the input and output of an action
+func actionValues(action models.Action, field string) []string {
+	var values []string
+	for _, fields := range []map[string]interface{}{action.Input, action.Output} {
+		if value, ok := fields[field].(string); ok && value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// replayBatchSize is the number of block heights read per query while replaying
+const replayBatchSize = 100
+
+// LatestHeight returns the height of the highest indexed block, or -1 when none is indexed
+func LatestHeight(db *sql.DB) (int64, error) {
+	var height int64
+	err := db.QueryRow(`SELECT COALESCE(MAX(block_height), -1) FROM blocks`).Scan(&height)
+	return height, err
+}
+
+// Replay sends the events matching filter of every block indexed from fromHeight up to
+// toHeight, in height order. It stops early when ctx is done.
+func Replay(ctx context.Context, db *sql.DB, filter Filter, fromHeight, toHeight int64, send func([]Event) error) error {
+	for fromHeight <= toHeight {
+		heights, err := indexedHeights(db, fromHeight, toHeight)
+		if err != nil {
+			return err
+		}
+		if len(heights) == 0 {
+			return nil
+		}
+		for _, height := range heights {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			blockEvents, err := LoadBlockEvents(db, height)
+			if err != nil {
+				return fmt.Errorf("error loading events of block %d: %w", height, err)
+			}
+			if matched := FilterEvents(blockEvents, filter); len(matched) > 0 {
+				if err := send(matched); err != nil {
+					return err
+				}
+			}
+		}
+		fromHeight = heights[len(heights)-1] + 1
+	}
+	return nil
+}
+
+// indexedHeights returns the next indexed block heights of [fromHeight, toHeight]
+func indexedHeights(db *sql.DB, fromHeight, toHeight int64) ([]int64, error) {
+	rows, err := db.Query(`
+		SELECT block_height
+		FROM blocks
+		WHERE block_height BETWEEN $1 AND $2
+		ORDER BY block_height
+		LIMIT $3`, fromHeight, toHeight, replayBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching indexed heights: %w", err)
+	}
+	defer rows.Close()
+
+	var heights []int64
+	for rows.Next() {
+		var height int64
+		if err := rows.Scan(&height); err != nil {
+			return nil, err
+		}
+		heights = append(heights, height)
+	}
+	return heights, rows.Err()
+}
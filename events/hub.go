@@ -0,0 +1,173 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package events
+
+import (
+	"strings"
+	"sync"
+)
+
+// Event topics
+const (
+	TopicBlocks       = "blocks"
+	TopicTransactions = "transactions"
+	TopicActions      = "actions"
+	TopicAssets       = "assets"
+	TopicValidators   = "validators"
+	// TopicReorgs announces that the blocks from its height were replaced. It cannot be
+	// subscribed to, every subscription receives it before the replacement blocks.
+	TopicReorgs = "reorgs"
+)
+
+// subscriptionBuffer is the number of blocks a subscriber can fall behind before it is dropped
+const subscriptionBuffer = 64
+
+// Event is a change indexed from a block
+type Event struct {
+	Topic       string      `json:"topic"`
+	BlockHeight int64       `json:"block_height"`
+	Data        interface{} `json:"data"`
+
+	// addresses and actionName are matched against the filter of a subscription
+	addresses  []string
+	actionName string
+}
+
+// Filter selects the events of a subscription. Address matches the sponsor, actors and
+// receivers of a transaction and of the transaction of an action, the asset address of an
+// asset event and the node ID of a validator event. ActionName matches the action name of
+// an action, asset or validator event. Empty fields match every event.
+type Filter struct {
+	Topic      string
+	Address    string
+	ActionName string
+}
+
+// IsTopic reports whether topic is a known event topic
+func IsTopic(topic string) bool {
+	switch topic {
+	case TopicBlocks, TopicTransactions, TopicActions, TopicAssets, TopicValidators:
+		return true
+	}
+	return false
+}
+
+// Match reports whether the event is selected by the filter. Reorg events match every filter.
+func (f Filter) Match(event Event) bool {
+	if event.Topic == TopicReorgs {
+		return true
+	}
+	if f.Topic != event.Topic {
+		return false
+	}
+	if f.ActionName != "" && !strings.EqualFold(f.ActionName, event.actionName) {
+		return false
+	}
+	if f.Address == "" {
+		return true
+	}
+	address := normalizeAddress(f.Address)
+	for _, candidate := range event.addresses {
+		if normalizeAddress(candidate) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub fans the events of each indexed block out to the subscribers
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the events of each block matching its filter
+type Subscription struct {
+	filter Filter
+	events chan []Event
+	// lagged is set when the subscription was dropped for falling behind
+	lagged bool
+}
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscription for the events matching filter
+func (h *Hub) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{filter: filter, events: make(chan []Event, subscriptionBuffer)}
+
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a subscription and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// HasSubscribers reports whether any subscription is registered, so the events of a
+// block are only built when someone listens
+func (h *Hub) HasSubscribers() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers) > 0
+}
+
+// Publish sends the events of a block to the matching subscriptions. A subscription
+// whose buffer is full is dropped instead of blocking ingestion; its client is expected
+// to reconnect and resume from the last block it received.
+func (h *Hub) Publish(blockEvents []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		matched := FilterEvents(blockEvents, sub.filter)
+		if len(matched) == 0 {
+			continue
+		}
+		select {
+		case sub.events <- matched:
+		default:
+			sub.lagged = true
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Events returns the channel receiving the matching events of each block. It is closed
+// when the subscription is removed.
+func (s *Subscription) Events() <-chan []Event {
+	return s.events
+}
+
+// Lagged reports whether the subscription was dropped for falling behind. It is only
+// meaningful once the events channel is closed.
+func (s *Subscription) Lagged() bool {
+	return s.lagged
+}
+
+// FilterEvents returns the events matching filter
+func FilterEvents(blockEvents []Event, filter Filter) []Event {
+	var matched []Event
+	for _, event := range blockEvents {
+		if filter.Match(event) {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
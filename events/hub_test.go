@@ -0,0 +1,103 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package events
+
+import (
+	"testing"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+const (
+	sponsor      = "00c4cb545f748a28770042f893784ce85b107389004d6a0e0d6d7518eeae1292d9"
+	receiver     = "006835bfa9c67557da9fe6c7ad69089e17c6cad3e18284e037c78aa307e3c0c840"
+	assetAddress = "00cf77495ce1bdbf11e5e45463fad5a862cb6cc0a20e00e658c4ac3355dcdc64bb"
+	nodeID       = "NodeID-Nxy5Q8K9YkLasVKkdd4ftaHnVwdSPnKE5"
+)
+
+func testBlockEvents(height int64) []Event {
+	block := models.Block{BlockHeight: height, BlockHash: "block"}
+	transactions := []models.Transaction{
+		{TxHash: "tx1", Sponsor: sponsor, Receivers: []string{receiver}},
+		{TxHash: "tx2", Sponsor: sponsor},
+	}
+	actions := []models.Action{
+		{TxHash: "tx1", ActionName: "MintAssetFT", Input: map[string]interface{}{"asset_address": "0x" + assetAddress}},
+		{TxHash: "tx2", ActionName: "DelegateUserStake", Input: map[string]interface{}{"node_id": nodeID}},
+	}
+	return BlockEvents(block, transactions, actions)
+}
+
+func TestFilterEvents(t *testing.T) {
+	blockEvents := testBlockEvents(10)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{name: "blocks", filter: Filter{Topic: TopicBlocks}, want: 1},
+		{name: "transactions", filter: Filter{Topic: TopicTransactions}, want: 2},
+		{name: "transactions of receiver", filter: Filter{Topic: TopicTransactions, Address: "0x" + receiver}, want: 1},
+		{name: "actions by name", filter: Filter{Topic: TopicActions, ActionName: "mintassetft"}, want: 1},
+		{name: "actions of sponsor", filter: Filter{Topic: TopicActions, Address: sponsor}, want: 2},
+		{name: "asset events of asset", filter: Filter{Topic: TopicAssets, Address: assetAddress}, want: 1},
+		{name: "asset events of other asset", filter: Filter{Topic: TopicAssets, Address: receiver}, want: 0},
+		{name: "validator events of node", filter: Filter{Topic: TopicValidators, Address: nodeID}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterEvents(blockEvents, tt.filter); len(got) != tt.want {
+				t.Errorf("FilterEvents() returned %d events, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestHubPublishesMatchingEvents(t *testing.T) {
+	hub := NewHub()
+	blocks := hub.Subscribe(Filter{Topic: TopicBlocks})
+	assets := hub.Subscribe(Filter{Topic: TopicAssets, Address: receiver})
+	defer hub.Unsubscribe(blocks)
+	defer hub.Unsubscribe(assets)
+
+	hub.Publish(testBlockEvents(10))
+
+	select {
+	case batch := <-blocks.Events():
+		if len(batch) != 1 || batch[0].BlockHeight != 10 {
+			t.Fatalf("blocks subscription received %+v", batch)
+		}
+	default:
+		t.Fatal("blocks subscription received no events")
+	}
+	select {
+	case batch := <-assets.Events():
+		t.Fatalf("assets subscription received unmatched events %+v", batch)
+	default:
+	}
+}
+
+func TestHubDropsLaggingSubscriber(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(Filter{Topic: TopicBlocks})
+
+	for height := int64(0); height <= subscriptionBuffer; height++ {
+		hub.Publish(testBlockEvents(height))
+	}
+	if hub.HasSubscribers() {
+		t.Fatal("lagging subscriber is still registered")
+	}
+
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	if received != subscriptionBuffer || !sub.Lagged() {
+		t.Fatalf("received %d blocks, lagged = %v; want %d blocks and lagged", received, sub.Lagged(), subscriptionBuffer)
+	}
+
+	// Unsubscribing a dropped subscription is a no-op
+	hub.Unsubscribe(sub)
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package events
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	// MaxResumeBlocks is the largest number of blocks replayed to a resuming subscriber
+	MaxResumeBlocks = 10_000
+	// HeartbeatInterval is the interval of the keep-alive messages of a stream
+	HeartbeatInterval = 15 * time.Second
+)
+
+// ErrSubscriberLagged is returned when a subscriber is dropped for falling behind
+var ErrSubscriberLagged = errors.New("subscriber fell behind")
+
+// Stream delivers the events matching filter to send until ctx is done, replaying the
+// blocks indexed since fromHeight first, or only the live events when fromHeight is
+// negative. heartbeat, when set, is called every HeartbeatInterval to keep the connection
+// alive. After a reorg, the replacement blocks are delivered again from the fork height,
+// following the reorg event. It returns the height to resume from when the stream ends.
+func Stream(ctx context.Context, db *sql.DB, hub *Hub, filter Filter, fromHeight int64,
+	send func([]Event) error, heartbeat func() error,
+) (int64, error) {
+	// Subscribe before replaying so no block is missed between the replay and the live events
+	sub := hub.Subscribe(filter)
+	defer hub.Unsubscribe(sub)
+
+	// Blocks up to the latest one are replayed, or skipped by a live-only subscription
+	latest, err := LatestHeight(db)
+	if err != nil {
+		return fromHeight, err
+	}
+	if fromHeight >= 0 {
+		if err := Replay(ctx, db, filter, fromHeight, latest, send); err != nil {
+			return fromHeight, err
+		}
+	}
+	delivered := latest
+	resumeHeight := func() int64 { return delivered + 1 }
+
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return resumeHeight(), nil
+		case <-ticker.C:
+			if heartbeat == nil {
+				continue
+			}
+			if err := heartbeat(); err != nil {
+				return resumeHeight(), err
+			}
+		case batch, ok := <-sub.Events():
+			if !ok {
+				if sub.Lagged() {
+					return resumeHeight(), ErrSubscriberLagged
+				}
+				return resumeHeight(), nil
+			}
+			// A reorg replaced the blocks from its height, which are delivered again
+			height := batch[0].BlockHeight
+			if batch[0].Topic == TopicReorgs {
+				delivered = min(delivered, height-1)
+			}
+			// Skip the blocks indexed before the subscription started
+			if height <= delivered {
+				continue
+			}
+			if err := send(batch); err != nil {
+				return resumeHeight(), err
+			}
+			delivered = height
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package events
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+func expectLatestHeight(mock sqlmock.Sqlmock, height int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(block_height), -1) FROM blocks`)).
+		WillReturnRows(sqlmock.NewRows([]string{"height"}).AddRow(height))
+}
+
+// replacementBlockEvents returns the events of a block that replaced the blocks from its height
+func replacementBlockEvents(height int64) []Event {
+	reorg := Event{Topic: TopicReorgs, BlockHeight: height, Data: models.ChainReorg{ForkHeight: height - 1}}
+	return append([]Event{reorg}, testBlockEvents(height)...)
+}
+
+func TestStreamDeliversReplacementBlocks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+	expectLatestHeight(mock, 10)
+
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sent := make(chan []Event, 8)
+	send := func(batch []Event) error {
+		sent <- batch
+		return nil
+	}
+	type result struct {
+		resumeHeight int64
+		err          error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resumeHeight, err := Stream(ctx, db, hub, Filter{Topic: TopicBlocks}, -1, send, nil)
+		done <- result{resumeHeight, err}
+	}()
+	for !hub.HasSubscribers() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Block 10 was indexed before the subscription, block 11 is replaced after it was delivered
+	hub.Publish(testBlockEvents(10))
+	hub.Publish(testBlockEvents(11))
+	hub.Publish(testBlockEvents(12))
+	hub.Publish(replacementBlockEvents(11))
+	hub.Publish(testBlockEvents(12))
+
+	want := []struct {
+		topic  string
+		height int64
+		events int
+	}{
+		{topic: TopicBlocks, height: 11, events: 1},
+		{topic: TopicBlocks, height: 12, events: 1},
+		{topic: TopicReorgs, height: 11, events: 2},
+		{topic: TopicBlocks, height: 12, events: 1},
+	}
+	for i, w := range want {
+		select {
+		case batch := <-sent:
+			if len(batch) != w.events || batch[0].Topic != w.topic || batch[0].BlockHeight != w.height {
+				t.Fatalf("batch %d = %+v, want %d events starting with %s at height %d", i, batch, w.events, w.topic, w.height)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("batch %d was not delivered", i)
+		}
+	}
+
+	cancel()
+	res := <-done
+	if res.err != nil || res.resumeHeight != 13 {
+		t.Fatalf("Stream() = %d, %v; want 13, nil", res.resumeHeight, res.err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadBlockEventsStartsWithReorg(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM blocks WHERE block_height = $1::bigint`)).
+		WithArgs("11").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"block_height", "block_hash", "parent_block_hash", "state_root", "block_size", "tx_count",
+			"total_fee", "avg_tx_size", "unique_participants", "timestamp",
+		}).AddRow(11, "replacement", "parent", "root", 100, 0, 0, 0, 0, "2024-01-01T00:00:00Z"))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM transactions`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM actions`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE new_block_height = $1 AND new_block_hash = $2`)).
+		WithArgs(int64(11), "replacement").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "fork_height", "old_tip_height", "old_tip_hash", "new_block_height", "new_block_hash",
+			"orphaned_blocks", "orphaned_transactions", "orphaned_block_hashes", "timestamp",
+		}).AddRow(1, 10, 12, "orphaned", 11, "replacement", 2, 0, "{orphaned}", "2024-01-01T00:00:00Z"))
+
+	blockEvents, err := LoadBlockEvents(db, 11)
+	if err != nil {
+		t.Fatalf("LoadBlockEvents() error = %v", err)
+	}
+	if len(blockEvents) != 2 || blockEvents[0].Topic != TopicReorgs || blockEvents[1].Topic != TopicBlocks {
+		t.Fatalf("LoadBlockEvents() = %+v, want the reorg then the block", blockEvents)
+	}
+	if reorg := blockEvents[0].Data.(models.ChainReorg); reorg.ForkHeight != 10 || reorg.OrphanedBlocks != 2 {
+		t.Fatalf("reorg event data = %+v", reorg)
+	}
+
+	// Every subscription receives the reorg event
+	if matched := FilterEvents(blockEvents, Filter{Topic: TopicActions, Address: sponsor}); len(matched) != 1 {
+		t.Fatalf("actions subscription matched %+v, want only the reorg", matched)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// Request is a GraphQL request
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Executor runs GraphQL queries against the indexed tables
+type Executor struct {
+	db     *sql.DB
+	schema graphql.Schema
+}
+
+// NewExecutor creates an executor reading from db. The health field reports the status
+// returned by health.
+func NewExecutor(db *sql.DB, health func() models.HealthStatus) (*Executor, error) {
+	schema, err := newSchema(db, health)
+	if err != nil {
+		return nil, err
+	}
+	return &Executor{db: db, schema: schema}, nil
+}
+
+// Execute parses and validates a request, rejects it when it exceeds the depth or
+// complexity limits, and runs it with fresh loaders
+func (e *Executor) Execute(ctx context.Context, request Request) *graphql.Result {
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(request.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	validation := graphql.ValidateDocument(&e.schema, document, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+
+	if err := checkQueryLimits(&e.schema, document, request.OperationName, request.Variables); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        e.schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       context.WithValue(ctx, loadersKey{}, newLoaders(e.db)),
+	})
+}
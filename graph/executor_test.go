@@ -0,0 +1,135 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+var (
+	blockColumns       = []string{"block_height", "block_hash", "parent_block_hash", "state_root", "block_size", "tx_count", "total_fee", "avg_tx_size", "unique_participants", "timestamp"}
+	transactionColumns = []string{"id", "tx_hash", "block_hash", "block_height", "sponsor", "actors", "receivers", "max_fee", "success", "fee", "actions", "timestamp"}
+	actionColumns      = []string{"id", "tx_hash", "action_type", "action_name", "action_index", "input", "output", "timestamp"}
+)
+
+func newTestExecutor(t *testing.T) (*Executor, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	executor, err := NewExecutor(conn, func() models.HealthStatus {
+		return models.HealthStatus{State: models.HealthStateGreen}
+	})
+	if err != nil {
+		t.Fatalf("NewExecutor() error = %v", err)
+	}
+	return executor, mock
+}
+
+func TestExecuteBatchesNestedFields(t *testing.T) {
+	executor, mock := newTestExecutor(t)
+	// The loaders of a level run in the order their fields are resolved
+	mock.MatchExpectationsInOrder(false)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM blocks WHERE TRUE ORDER BY block_height DESC LIMIT $1`)).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(blockColumns).
+			AddRow(11, "b11", "b10", "root", 100, 1, 0.5, 100.0, 1, "2024-01-01T00:00:10Z").
+			AddRow(10, "b10", "b9", "root", 200, 2, 1.0, 100.0, 2, "2024-01-01T00:00:00Z"))
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE transactions.block_hash = ANY($1)`)).
+		WithArgs(pq.Array([]string{"b11", "b10"})).
+		WillReturnRows(sqlmock.NewRows(transactionColumns).
+			AddRow(1, "tx1", "b10", 10, "alice", "{alice}", "{bob}", 1.0, true, 100, []byte(`[]`), "2024-01-01T00:00:00Z").
+			AddRow(2, "tx2", "b10", 10, "bob", "{bob}", "{}", 1.0, true, 100, []byte(`[]`), "2024-01-01T00:00:00Z").
+			AddRow(3, "tx3", "b11", 11, "alice", "{alice}", "{}", 1.0, false, 100, []byte(`[]`), "2024-01-01T00:00:10Z"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM actions WHERE tx_hash = ANY($1)`)).
+		WithArgs(pq.Array([]string{"tx3", "tx1", "tx2"})).
+		WillReturnRows(sqlmock.NewRows(actionColumns).
+			AddRow(1, "tx1", 0, "Transfer", 0, []byte(`{"value":5}`), []byte(`{}`), "2024-01-01T00:00:00Z").
+			AddRow(2, "tx1", 0, "Transfer", 1, []byte(`{"value":6}`), []byte(`{}`), "2024-01-01T00:00:00Z"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM blocks WHERE block_hash = ANY($1)`)).
+		WithArgs(pq.Array([]string{"b11", "b10"})).
+		WillReturnRows(sqlmock.NewRows(blockColumns).
+			AddRow(11, "b11", "b10", "root", 100, 1, 0.5, 100.0, 1, "2024-01-01T00:00:10Z").
+			AddRow(10, "b10", "b9", "root", 200, 2, 1.0, 100.0, 2, "2024-01-01T00:00:00Z"))
+
+	result := executor.Execute(context.Background(), Request{Query: `{
+		blocks(limit: 2) {
+			items {
+				blockHeight
+				transactions {
+					txHash
+					fee
+					block { blockHeight }
+					actions { actionName input }
+				}
+			}
+			nextCursor
+		}
+	}`})
+	if result.HasErrors() {
+		t.Fatalf("Execute() errors = %v", result.Errors)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(result.Data)
+	want := `{"blocks":{"items":[` +
+		`{"blockHeight":11,"transactions":[{"actions":[],"block":{"blockHeight":11},"fee":"100","txHash":"tx3"}]},` +
+		`{"blockHeight":10,"transactions":[` +
+		`{"actions":[{"actionName":"Transfer","input":{"value":5}},{"actionName":"Transfer","input":{"value":6}}],"block":{"blockHeight":10},"fee":"100","txHash":"tx1"},` +
+		`{"actions":[],"block":{"blockHeight":10},"fee":"100","txHash":"tx2"}]}],` +
+		`"nextCursor":"` + models.EncodeCursor(10) + `"}}`
+	if string(data) != want {
+		t.Errorf("Execute() data = %s, want %s", data, want)
+	}
+}
+
+func TestExecuteMissingAccount(t *testing.T) {
+	executor, mock := newTestExecutor(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM balances`)).
+		WithArgs(pq.Array([]string{"00abc"}), naiAddress).
+		WillReturnRows(sqlmock.NewRows([]string{"address", "asset_address", "balance"}))
+
+	result := executor.Execute(context.Background(), Request{Query: `{ account(address: "0x00ABC") { address } }`})
+	if result.HasErrors() {
+		t.Fatalf("Execute() errors = %v", result.Errors)
+	}
+	data, _ := json.Marshal(result.Data)
+	if string(data) != `{"account":null}` {
+		t.Errorf("Execute() data = %s, want a null account", data)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecuteRejectsQueriesOverTheLimits(t *testing.T) {
+	executor, mock := newTestExecutor(t)
+
+	result := executor.Execute(context.Background(), Request{
+		Query:     `query ($limit: Int) { blocks(limit: $limit) { items { transactions { actions { transaction { actions { txHash } } } } } } }`,
+		Variables: map[string]interface{}{"limit": float64(100)},
+	})
+	if len(result.Errors) != 1 || result.Errors[0].Message != "query complexity 121201 exceeds the limit of 10000" {
+		t.Fatalf("Execute() errors = %v, want the complexity limit error", result.Errors)
+	}
+	// The query is rejected before it reaches the database
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
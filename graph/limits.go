@@ -0,0 +1,183 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+const (
+	// MaxQueryDepth is the deepest nesting of fields a query may select
+	MaxQueryDepth = 10
+	// MaxQueryComplexity is the highest estimated number of values a query may resolve
+	MaxQueryComplexity = 10000
+)
+
+// queryCost estimates the depth and complexity of an operation before it is executed.
+// Every field costs 1, and the fields selected under a list cost as many times as the
+// list is expected to have items: the limit of a page, or defaultPageSize for the lists
+// that are not paginated. The introspection fields are free.
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// checkQueryLimits returns an error when the selected operation of a validated document is
+// deeper or more complex than the limits
+func checkQueryLimits(schema *graphql.Schema, document *ast.Document, operationName string, variables map[string]interface{}) error {
+	cost := queryCost{fragments: make(map[string]*ast.FragmentDefinition), variables: variables}
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			cost.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+	// The executor reports a missing operation
+	if operation == nil {
+		return nil
+	}
+
+	root := schema.QueryType()
+	if operation.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+	if root == nil {
+		return nil
+	}
+
+	depth, complexity := cost.selectionSet(operation.SelectionSet, root, map[string]bool{})
+	if depth > MaxQueryDepth {
+		return fmt.Errorf("query depth %d exceeds the limit of %d", depth, MaxQueryDepth)
+	}
+	if complexity > MaxQueryComplexity {
+		return fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, MaxQueryComplexity)
+	}
+	return nil
+}
+
+// selectionSet returns the depth and complexity of the fields selected on an object. The
+// fragments being expanded are tracked so a cycle cannot recurse forever.
+func (c queryCost) selectionSet(set *ast.SelectionSet, parent *graphql.Object, expanding map[string]bool) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+
+	depth, complexity := 0, 0
+	add := func(d, cost int) {
+		depth = max(depth, d)
+		complexity += cost
+	}
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			add(c.field(selection, parent, expanding))
+		case *ast.InlineFragment:
+			add(c.selectionSet(selection.SelectionSet, parent, expanding))
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			fragment, ok := c.fragments[name]
+			if !ok || expanding[name] {
+				continue
+			}
+			expanding[name] = true
+			add(c.selectionSet(fragment.SelectionSet, parent, expanding))
+			delete(expanding, name)
+		}
+	}
+	return depth, complexity
+}
+
+// field returns the depth and complexity of a field and of the fields selected under it
+func (c queryCost) field(field *ast.Field, parent *graphql.Object, expanding map[string]bool) (int, int) {
+	name := field.Name.Value
+	if strings.HasPrefix(name, "__") {
+		return 0, 0
+	}
+	definition, ok := parent.Fields()[name]
+	if !ok {
+		return 1, 1
+	}
+
+	fieldType, isList := unwrapType(definition.Type)
+	object, ok := fieldType.(*graphql.Object)
+	if !ok {
+		return 1, 1
+	}
+
+	depth, complexity := c.selectionSet(field.SelectionSet, object, expanding)
+	multiplier := 1
+	if hasArgument(definition, "limit") {
+		// Out of range limits are rejected by the resolver, but must not lower the cost of
+		// the rest of the query
+		multiplier = min(max(c.intArgument(field, "limit", defaultPageSize), 1), maxPageSize)
+	} else if isList && !isPage(parent) {
+		// The items of a page are counted by the limit of the page
+		multiplier = defaultPageSize
+	}
+	return depth + 1, 1 + multiplier*complexity
+}
+
+// intArgument returns the value of an integer argument given inline or as a variable
+func (c queryCost) intArgument(field *ast.Field, name string, defaultValue int) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != name {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(value.Value); err == nil {
+				return n
+			}
+		case *ast.Variable:
+			switch n := c.variables[value.Name.Value].(type) {
+			case int:
+				return n
+			case float64:
+				return int(n)
+			case json.Number:
+				if i, err := n.Int64(); err == nil {
+					return int(i)
+				}
+			}
+		}
+	}
+	return defaultValue
+}
+
+// unwrapType returns the object or scalar a field resolves to, and whether it is a list
+func unwrapType(fieldType graphql.Output) (graphql.Output, bool) {
+	isList := false
+	for {
+		switch wrapped := fieldType.(type) {
+		case *graphql.NonNull:
+			fieldType = wrapped.OfType
+		case *graphql.List:
+			isList = true
+			fieldType = wrapped.OfType
+		default:
+			return fieldType, isList
+		}
+	}
+}
+
+// hasArgument reports whether a field declares an argument
+func hasArgument(definition *graphql.FieldDefinition, name string) bool {
+	for _, argument := range definition.Args {
+		if argument.Name() == name {
+			return true
+		}
+	}
+	return false
+}
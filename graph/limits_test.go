@@ -0,0 +1,76 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+func TestCheckQueryLimits(t *testing.T) {
+	schema, err := newSchema(nil, nil)
+	if err != nil {
+		t.Fatalf("newSchema() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		wantErr   string
+	}{
+		{
+			name:  "single block",
+			query: `{ block(height: 1) { blockHash transactions { txHash } } }`,
+		},
+		{
+			name:    "too deep",
+			query:   `{ block(height: 1) { transactions { block { transactions { block { transactions { block { transactions { block { transactions { txHash } } } } } } } } } } }`,
+			wantErr: "query depth 11 exceeds the limit of 10",
+		},
+		{
+			name:    "depth through fragments",
+			query:   `{ block(height: 1) { ...tx } } fragment tx on Block { transactions { block { transactions { block { transactions { block { transactions { block { transactions { txHash } } } } } } } } } }`,
+			wantErr: "query depth 11 exceeds the limit of 10",
+		},
+		{
+			// 1 + 100 * (1 + (1 + 10 * (1 + 10 * 1)))
+			name:    "limit multiplies the nested lists",
+			query:   `{ blocks(limit: 100) { items { transactions { actions { txHash } } } } }`,
+			wantErr: "query complexity 11201 exceeds the limit of 10000",
+		},
+		{
+			// The negative limit counts as 1: 1 + 1 * 112 + 1 + 100 * 112
+			name: "negative limit does not lower the complexity",
+			query: `{ a: blocks(limit: -100000) { items { transactions { actions { txHash } } } }` +
+				` b: blocks(limit: 100) { items { transactions { actions { txHash } } } } }`,
+			wantErr: "query complexity 11314 exceeds the limit of 10000",
+		},
+		{
+			name:      "limit given as a variable",
+			query:     `query ($limit: Int) { blocks(limit: $limit) { items { transactions { actions { txHash } } } } }`,
+			variables: map[string]interface{}{"limit": float64(50)},
+		},
+		{
+			name:  "introspection is free",
+			query: `{ __schema { types { name fields { name type { name ofType { name ofType { name ofType { name ofType { name } } } } } } } } }`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document, err := parser.Parse(parser.ParseParams{Source: tt.query})
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			err = checkQueryLimits(&schema, document, "", tt.variables)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkQueryLimits() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("checkQueryLimits() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
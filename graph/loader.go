@@ -0,0 +1,89 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import "sync"
+
+// loader batches the keys requested while a level of a query is resolved, so the values
+// of a whole list are read with a single query instead of one query per item. The values
+// are cached for the rest of the request.
+type loader[K comparable, V any] struct {
+	fetch func(keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	entries map[K]*loaderEntry[V]
+}
+
+// loaderEntry is the value of a key once its batch has been fetched
+type loaderEntry[V any] struct {
+	loaded bool
+	value  V
+	err    error
+}
+
+// newLoader creates a loader reading the values of a batch of keys with fetch. The keys
+// missing from the map it returns get the zero value.
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, entries: make(map[K]*loaderEntry[V])}
+}
+
+// load queues a key and returns a function returning its value. The first call to one of
+// the returned functions fetches every key queued so far.
+func (l *loader[K, V]) load(key K) func() (V, error) {
+	l.mu.Lock()
+	if _, ok := l.entries[key]; !ok {
+		l.entries[key] = &loaderEntry[V]{}
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		entry := l.entries[key]
+		if !entry.loaded {
+			l.dispatch()
+		}
+		return entry.value, entry.err
+	}
+}
+
+// dispatch fetches the pending keys. It must be called with the lock held.
+func (l *loader[K, V]) dispatch() {
+	keys := l.pending
+	l.pending = nil
+
+	values, err := l.fetch(keys)
+	for _, key := range keys {
+		entry := l.entries[key]
+		entry.loaded = true
+		entry.value = values[key]
+		entry.err = err
+	}
+}
+
+// indexBy maps the items read for a batch to their key
+func indexBy[K comparable, V any](items []V, key func(V) K) map[K]*V {
+	indexed := make(map[K]*V, len(items))
+	for i := range items {
+		indexed[key(items[i])] = &items[i]
+	}
+	return indexed
+}
+
+// groupBy groups the items read for a batch by key, keeping their order. Every key of the
+// batch gets a list, empty when no item has it.
+func groupBy[K comparable, V any](keys []K, items []V, key func(V) K) map[K][]V {
+	grouped := make(map[K][]V, len(keys))
+	for _, k := range keys {
+		grouped[k] = []V{}
+	}
+	for _, item := range items {
+		k := key(item)
+		grouped[k] = append(grouped[k], item)
+	}
+	return grouped
+}
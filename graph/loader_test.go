@@ -0,0 +1,69 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLoaderBatchesQueuedKeys(t *testing.T) {
+	var batches [][]string
+	l := newLoader(func(keys []string) (map[string]int, error) {
+		batches = append(batches, keys)
+		values := make(map[string]int, len(keys))
+		for _, key := range keys {
+			values[key] = len(key)
+		}
+		return values, nil
+	})
+
+	a, bb, again := l.load("a"), l.load("bb"), l.load("a")
+	if value, err := bb(); err != nil || value != 2 {
+		t.Fatalf("load(bb) = %d, %v, want 2", value, err)
+	}
+	if value, _ := a(); value != 1 {
+		t.Fatalf("load(a) = %d, want 1", value)
+	}
+	if value, _ := again(); value != 1 {
+		t.Fatalf("load(a) = %d, want 1", value)
+	}
+
+	// Loaded keys are cached and new keys form the next batch
+	cached, ccc := l.load("bb"), l.load("ccc")
+	if value, _ := cached(); value != 2 {
+		t.Fatalf("load(bb) = %d, want 2", value)
+	}
+	if value, _ := ccc(); value != 3 {
+		t.Fatalf("load(ccc) = %d, want 3", value)
+	}
+
+	want := [][]string{{"a", "bb"}, {"ccc"}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
+
+func TestLoaderReturnsTheBatchError(t *testing.T) {
+	fetchErr := errors.New("unable to retrieve blocks")
+	l := newLoader(func([]string) (map[string]int, error) { return nil, fetchErr })
+
+	a, b := l.load("a"), l.load("b")
+	if _, err := a(); err != fetchErr {
+		t.Errorf("load(a) error = %v, want %v", err, fetchErr)
+	}
+	if _, err := b(); err != fetchErr {
+		t.Errorf("load(b) error = %v, want %v", err, fetchErr)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	grouped := groupBy([]string{"tx1", "tx2"}, []string{"tx1/0", "tx1/1"}, func(action string) string { return action[:3] })
+
+	want := map[string][]string{"tx1": {"tx1/0", "tx1/1"}, "tx2": {}}
+	if !reflect.DeepEqual(grouped, want) {
+		t.Errorf("groupBy() = %v, want %v", grouped, want)
+	}
+}
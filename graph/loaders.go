@@ -0,0 +1,120 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// loaders are the loaders of a request, shared by all its resolvers
+type loaders struct {
+	blocks             *loader[string, *models.Block]
+	blockTransactions  *loader[string, []models.Transaction]
+	transactions       *loader[string, *models.Transaction]
+	transactionActions *loader[string, []models.Action]
+	assets             *loader[string, *models.Asset]
+	createdAssets      *loader[string, []models.Asset]
+	balances           *loader[string, []models.AccountBalance]
+	transactionCounts  *loader[string, int]
+	validatorStakes    *loader[string, []models.ValidatorStake]
+}
+
+// loadersKey is the context key of the loaders of a request
+type loadersKey struct{}
+
+// newLoaders creates the loaders of a request reading from db
+func newLoaders(db *sql.DB) *loaders {
+	return &loaders{
+		blocks: newLoader(func(hashes []string) (map[string]*models.Block, error) {
+			blocks, err := models.FetchBlocksByHashes(db, hashes)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve blocks")
+			}
+			return indexBy(blocks, func(block models.Block) string { return block.BlockHash }), nil
+		}),
+		blockTransactions: newLoader(func(hashes []string) (map[string][]models.Transaction, error) {
+			transactions, err := models.FetchTransactionsByBlockHashes(db, hashes)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve transactions")
+			}
+			return groupBy(hashes, transactions, func(tx models.Transaction) string { return tx.BlockHash }), nil
+		}),
+		transactions: newLoader(func(txHashes []string) (map[string]*models.Transaction, error) {
+			transactions, err := models.FetchTransactionsByHashes(db, txHashes)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve transactions")
+			}
+			return indexBy(transactions, func(tx models.Transaction) string { return tx.TxHash }), nil
+		}),
+		transactionActions: newLoader(func(txHashes []string) (map[string][]models.Action, error) {
+			actions, err := models.FetchActionsByTransactionHashes(db, txHashes)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve actions")
+			}
+			return groupBy(txHashes, actions, func(action models.Action) string { return action.TxHash }), nil
+		}),
+		assets: newLoader(func(addresses []string) (map[string]*models.Asset, error) {
+			assets, err := models.FetchAssetsByAddresses(db, addresses)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve assets")
+			}
+			return indexBy(assets, func(asset models.Asset) string { return asset.AssetAddress }), nil
+		}),
+		createdAssets: newLoader(func(creators []string) (map[string][]models.Asset, error) {
+			assets, err := models.FetchAssetsByCreators(db, creators)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve assets")
+			}
+			return groupBy(creators, assets, func(asset models.Asset) string { return asset.AssetCreator }), nil
+		}),
+		balances: newLoader(func(addresses []string) (map[string][]models.AccountBalance, error) {
+			balances, err := models.FetchBalancesByAddresses(db, addresses)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve balances")
+			}
+			grouped := make(map[string][]models.AccountBalance, len(addresses))
+			for _, address := range addresses {
+				grouped[address] = []models.AccountBalance{}
+			}
+			for _, balance := range balances {
+				grouped[balance.Address] = append(grouped[balance.Address], balance.AccountBalance)
+			}
+			return grouped, nil
+		}),
+		transactionCounts: newLoader(func(addresses []string) (map[string]int, error) {
+			counts, err := models.FetchTransactionCounts(db, addresses)
+			if err != nil {
+				return nil, loadError(err, "unable to count transactions")
+			}
+			return counts, nil
+		}),
+		validatorStakes: newLoader(func(actors []string) (map[string][]models.ValidatorStake, error) {
+			stakes, err := models.FetchValidatorStakesByActors(db, actors)
+			if err != nil {
+				return nil, loadError(err, "unable to retrieve validator stakes")
+			}
+			return groupBy(actors, stakes, func(stake models.ValidatorStake) string { return stake.Actor }), nil
+		}),
+	}
+}
+
+// loadersFrom returns the loaders of the request a resolver runs for
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// loadError logs the error of a query and returns the message shown to the client, so the
+// queries are not leaked in the response
+func loadError(err error, message string) error {
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return errors.New("invalid cursor")
+	}
+	log.Printf("GraphQL: %s: %v", message, err)
+	return errors.New(message)
+}
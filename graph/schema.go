@@ -0,0 +1,643 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package graph
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/nuklai/nuklaivm/storage"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+const (
+	// defaultPageSize is the number of items of a page when no limit is given
+	defaultPageSize = 10
+	// maxPageSize is the largest limit of a page
+	maxPageSize = 100
+)
+
+// naiAddress is the asset address of the native NAI token
+var naiAddress = storage.NAIAddress.String()
+
+// account is the source of the Account type. Its fields are read with the loaders.
+type account struct {
+	Address string
+}
+
+// page is the source of the page types
+type page struct {
+	Items      interface{}
+	NextCursor interface{}
+}
+
+// bigIntType serializes the amounts, which do not fit the 32-bit Int of GraphQL
+var bigIntType = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "BigInt",
+	Description: "An integer of arbitrary size, serialized as a decimal string.",
+	Serialize: func(value interface{}) interface{} {
+		switch value := value.(type) {
+		case json.Number:
+			return value.String()
+		case *json.Number:
+			if value == nil {
+				return nil
+			}
+			return value.String()
+		default:
+			return fmt.Sprint(value)
+		}
+	},
+})
+
+// jsonType serializes the values without a fixed shape, like the input and output of an action
+var jsonType = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "An arbitrary JSON value.",
+	Serialize:   func(value interface{}) interface{} { return value },
+})
+
+// newSchema builds the schema. The root fields read the models directly and the nested
+// fields go through the loaders of the request, so a list is resolved with one query per
+// level instead of one query per item.
+func newSchema(db *sql.DB, health func() models.HealthStatus) (graphql.Schema, error) {
+	var blockType, transactionType, actionType, assetType, accountType, validatorStakeType *graphql.Object
+
+	blockType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Block",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"blockHeight":        &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"blockHash":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"parentBlockHash":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"stateRoot":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"blockSize":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"txCount":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"totalFee":           &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"avgTxSize":          &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"uniqueParticipants": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"timestamp":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"transactions": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(transactionType))),
+					Description: "The transactions of the block, in the order they were executed.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						block := p.Source.(models.Block)
+						return many(loadersFrom(p.Context).blockTransactions.load(block.BlockHash)), nil
+					},
+				},
+			}
+		}),
+	})
+
+	transactionType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Transaction",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"txHash":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"blockHash":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"blockHeight": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"sponsor":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"actors":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+				"receivers":   &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+				"maxFee":      &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+				"success":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+				"fee":         &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"timestamp":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"block": &graphql.Field{
+					Type: blockType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						tx := p.Source.(models.Transaction)
+						return one(loadersFrom(p.Context).blocks.load(tx.BlockHash)), nil
+					},
+				},
+				"actions": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(actionType))),
+					Description: "The actions of the transaction, in the order they were executed.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						tx := p.Source.(models.Transaction)
+						return many(loadersFrom(p.Context).transactionActions.load(tx.TxHash)), nil
+					},
+				},
+				"sponsorAccount": &graphql.Field{
+					Type: graphql.NewNonNull(accountType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return account{Address: p.Source.(models.Transaction).Sponsor}, nil
+					},
+				},
+			}
+		}),
+	})
+
+	actionType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Action",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"txHash":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"actionType":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"actionName":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"actionIndex": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"input":       &graphql.Field{Type: jsonType},
+				"output":      &graphql.Field{Type: jsonType},
+				"timestamp":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"transaction": &graphql.Field{
+					Type: transactionType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						action := p.Source.(models.Action)
+						return one(loadersFrom(p.Context).transactions.load(action.TxHash)), nil
+					},
+				},
+			}
+		}),
+	})
+
+	assetType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Asset",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"assetAddress":                 &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"assetTypeId":                  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"assetType":                    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"assetCreator":                 &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"txHash":                       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"name":                         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"symbol":                       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"decimals":                     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"metadata":                     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"maxSupply":                    &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"owner":                        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"mintAdmin":                    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"pauseUnpauseAdmin":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"freezeUnfreezeAdmin":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"enableDisableKycAccountAdmin": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"totalMinted":                  &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"totalBurned":                  &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"currentSupply":                &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"timestamp":                    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"creator": &graphql.Field{
+					Type: graphql.NewNonNull(accountType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return account{Address: p.Source.(models.Asset).AssetCreator}, nil
+					},
+				},
+			}
+		}),
+	})
+
+	accountBalanceType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AccountBalance",
+		Fields: graphql.Fields{
+			"assetAddress": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"balance":      &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+			"asset": &graphql.Field{
+				Type: assetType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					balance := p.Source.(models.AccountBalance)
+					return one(loadersFrom(p.Context).assets.load(balance.AssetAddress)), nil
+				},
+			},
+		},
+	})
+
+	accountType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Account",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"address": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"balance": &graphql.Field{
+					Type:        graphql.NewNonNull(bigIntType),
+					Description: "The NAI balance of the account.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						load := loadersFrom(p.Context).balances.load(p.Source.(account).Address)
+						return func() (interface{}, error) {
+							balances, err := load()
+							if err != nil {
+								return nil, err
+							}
+							for _, balance := range balances {
+								if balance.AssetAddress == naiAddress {
+									return balance.Balance, nil
+								}
+							}
+							return json.Number("0"), nil
+						}, nil
+					},
+				},
+				"balances": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(accountBalanceType))),
+					Description: "The balance of the account in every asset it holds, NAI first.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return many(loadersFrom(p.Context).balances.load(p.Source.(account).Address)), nil
+					},
+				},
+				"transactionCount": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "The number of transactions the account sponsored or took part in.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						load := loadersFrom(p.Context).transactionCounts.load(p.Source.(account).Address)
+						return func() (interface{}, error) { return load() }, nil
+					},
+				},
+				"createdAssets": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(assetType))),
+					Description: "The assets created by the account, newest first.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return many(loadersFrom(p.Context).createdAssets.load(p.Source.(account).Address)), nil
+					},
+				},
+				"validatorStakes": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(validatorStakeType))),
+					Description: "The validator stakes registered by the account, newest first.",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return many(loadersFrom(p.Context).validatorStakes.load(p.Source.(account).Address)), nil
+					},
+				},
+			}
+		}),
+	})
+
+	validatorStakeType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "ValidatorStake",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"nodeId":                &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"actor":                 &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"stakeStartBlock":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"stakeEndBlock":         &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"stakedAmount":          &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"delegationFeeRate":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+				"rewardAddress":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"status":                &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"rewardsClaimed":        &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"totalDelegated":        &graphql.Field{Type: graphql.NewNonNull(bigIntType)},
+				"withdrawalTxHash":      &graphql.Field{Type: graphql.String},
+				"withdrawalBlockHeight": &graphql.Field{Type: graphql.Int},
+				"withdrawnAt":           &graphql.Field{Type: graphql.String},
+				"txHash":                &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"timestamp":             &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+				"account": &graphql.Field{
+					Type: graphql.NewNonNull(accountType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return account{Address: p.Source.(models.ValidatorStake).Actor}, nil
+					},
+				},
+			}
+		}),
+	})
+
+	healthType := newHealthType()
+
+	pageArgs := graphql.FieldConfigArgument{
+		"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+		"cursor": &graphql.ArgumentConfig{Type: graphql.String, Description: "The nextCursor of the previous page."},
+	}
+	withPageArgs := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		for name, arg := range pageArgs {
+			args[name] = arg
+		}
+		return args
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"block": &graphql.Field{
+				Type:        blockType,
+				Description: "A block by height or hash.",
+				Args: graphql.FieldConfigArgument{
+					"height": &graphql.ArgumentConfig{Type: graphql.Int},
+					"hash":   &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if hash, ok := p.Args["hash"].(string); ok {
+						return one(loadersFrom(p.Context).blocks.load(hash)), nil
+					}
+					height, ok := p.Args["height"].(int)
+					if !ok {
+						return nil, errors.New("either height or hash must be given")
+					}
+					block, err := models.FetchBlock(db, strconv.Itoa(height), "")
+					if err == sql.ErrNoRows {
+						return nil, nil
+					} else if err != nil {
+						return nil, loadError(err, "unable to retrieve block")
+					}
+					return block, nil
+				},
+			},
+			"blocks": &graphql.Field{
+				Type:        newPageType(blockType),
+				Description: "The blocks, highest first.",
+				Args:        withPageArgs(graphql.FieldConfigArgument{}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return resolvePage(p, "unable to retrieve blocks", func(page models.Page) (interface{}, string, error) {
+						return nonNil(models.FetchAllBlocks(db, page))
+					})
+				},
+			},
+			"transaction": &graphql.Field{
+				Type: transactionType,
+				Args: graphql.FieldConfigArgument{
+					"txHash": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return one(loadersFrom(p.Context).transactions.load(p.Args["txHash"].(string))), nil
+				},
+			},
+			"transactions": &graphql.Field{
+				Type:        newPageType(transactionType),
+				Description: "The transactions, newest first, optionally of a user or with an action of a name.",
+				Args: withPageArgs(graphql.FieldConfigArgument{
+					"user":       &graphql.ArgumentConfig{Type: graphql.String},
+					"actionName": &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user, _ := p.Args["user"].(string)
+					actionName, _ := p.Args["actionName"].(string)
+					return resolvePage(p, "unable to retrieve transactions", func(page models.Page) (interface{}, string, error) {
+						return nonNil(models.FetchFilteredTransactions(db, "", "", "", actionName, user, page))
+					})
+				},
+			},
+			"actions": &graphql.Field{
+				Type:        newPageType(actionType),
+				Description: "The actions, newest first, optionally of a user or of a name.",
+				Args: withPageArgs(graphql.FieldConfigArgument{
+					"user":       &graphql.ArgumentConfig{Type: graphql.String},
+					"actionName": &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					user, _ := p.Args["user"].(string)
+					actionName, _ := p.Args["actionName"].(string)
+					if user != "" && actionName != "" {
+						return nil, errors.New("user and actionName cannot be combined")
+					}
+					return resolvePage(p, "unable to retrieve actions", func(page models.Page) (interface{}, string, error) {
+						switch {
+						case user != "":
+							return nonNil(models.FetchActionsByUser(db, user, page))
+						case actionName != "":
+							return nonNil(models.FetchActionsByName(db, actionName, page))
+						default:
+							return nonNil(models.FetchAllActions(db, page))
+						}
+					})
+				},
+			},
+			"asset": &graphql.Field{
+				Type: assetType,
+				Args: graphql.FieldConfigArgument{
+					"address": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return one(loadersFrom(p.Context).assets.load(normalizeAddress(p.Args["address"].(string)))), nil
+				},
+			},
+			"assets": &graphql.Field{
+				Type:        newPageType(assetType),
+				Description: "The assets, most recently created first, optionally of a type or creator.",
+				Args: withPageArgs(graphql.FieldConfigArgument{
+					"type":    &graphql.ArgumentConfig{Type: graphql.Int},
+					"creator": &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var assetTypeID string
+					if typeID, ok := p.Args["type"].(int); ok {
+						assetTypeID = strconv.Itoa(typeID)
+					}
+					creator, _ := p.Args["creator"].(string)
+					return resolvePage(p, "unable to retrieve assets", func(page models.Page) (interface{}, string, error) {
+						return nonNil(models.FetchFilteredAssets(db, assetTypeID, normalizeAddress(creator), "", "", "", page))
+					})
+				},
+			},
+			"account": &graphql.Field{
+				Type:        accountType,
+				Description: "An account by address, null when it holds no balance.",
+				Args: graphql.FieldConfigArgument{
+					"address": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					address := normalizeAddress(p.Args["address"].(string))
+					load := loadersFrom(p.Context).balances.load(address)
+					return func() (interface{}, error) {
+						balances, err := load()
+						if err != nil || len(balances) == 0 {
+							return nil, err
+						}
+						return account{Address: address}, nil
+					}, nil
+				},
+			},
+			"validatorStake": &graphql.Field{
+				Type:        validatorStakeType,
+				Description: "The latest stake of a node.",
+				Args: graphql.FieldConfigArgument{
+					"nodeId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					details, err := models.FetchValidatorStakeByNodeID(db, p.Args["nodeId"].(string))
+					if err == sql.ErrNoRows {
+						return nil, nil
+					} else if err != nil {
+						return nil, loadError(err, "unable to retrieve validator stake")
+					}
+					return details.ValidatorStake, nil
+				},
+			},
+			"validatorStakes": &graphql.Field{
+				Type:        newPageType(validatorStakeType),
+				Description: "The validator stakes, newest first, optionally with a status.",
+				Args: withPageArgs(graphql.FieldConfigArgument{
+					"status": &graphql.ArgumentConfig{Type: graphql.String},
+				}),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					status, _ := p.Args["status"].(string)
+					if status != "" && !models.IsValidatorStatus(status) {
+						return nil, errors.New("invalid status, must be pending, active or withdrawn")
+					}
+					return resolvePage(p, "unable to retrieve validator stakes", func(page models.Page) (interface{}, string, error) {
+						return nonNil(models.FetchAllValidatorStakes(db, status, page))
+					})
+				},
+			},
+			"health": &graphql.Field{
+				Type:        graphql.NewNonNull(healthType),
+				Description: "The current health of the subscriber.",
+				Resolve: func(graphql.ResolveParams) (interface{}, error) {
+					return health(), nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// newHealthType returns the type of the health status, as returned by the health endpoint
+func newHealthType() *graphql.Object {
+	blockchainStatsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BlockchainStats",
+		Fields: graphql.Fields{
+			"lastBlockHeight": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"lastBlockHash":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"lastBlockTime":   &graphql.Field{Type: graphql.DateTime},
+			"consensusActive": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	blockGapType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "BlockGap",
+		Fields: graphql.Fields{
+			"startHeight":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"endHeight":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"missingBlocks": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+	syncStatusType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "SyncStatus",
+		Fields: graphql.Fields{
+			"lowestHeight":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"highestHeight": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"missingBlocks": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"gaps":          &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(blockGapType))},
+			"lastScanned":   &graphql.Field{Type: graphql.DateTime},
+			"lastError":     &graphql.Field{Type: graphql.String},
+		},
+	})
+	parserStatusType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ParserStatus",
+		Fields: graphql.Fields{
+			"loaded":      &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"genesisHash": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"source":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"loadedAt":    &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+	ingestQueueType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "IngestQueue",
+		Fields: graphql.Fields{
+			"depth":            &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"lagSeconds":       &graphql.Field{Type: graphql.NewNonNull(graphql.Float)},
+			"oldestEnqueuedAt": &graphql.Field{Type: graphql.DateTime},
+			"lastIngestedAt":   &graphql.Field{Type: graphql.DateTime},
+			"lastError":        &graphql.Field{Type: graphql.String},
+		},
+	})
+	healthEventType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "HealthEvent",
+		Fields: graphql.Fields{
+			"id":           &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"state":        &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"description":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"serviceNames": &graphql.Field{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"startTime":    &graphql.Field{Type: graphql.DateTime},
+			"endTime":      &graphql.Field{Type: graphql.DateTime},
+			"duration":     &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Health",
+		Fields: graphql.Fields{
+			"state":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"details": &graphql.Field{Type: jsonType, Description: "Whether each service is healthy."},
+			"services": &graphql.Field{
+				Type:        jsonType,
+				Description: "The status of each service.",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(models.HealthStatus).ServiceStatuse, nil
+				},
+			},
+			"blockchainStats": &graphql.Field{Type: blockchainStatsType},
+			"syncStatus":      &graphql.Field{Type: syncStatusType},
+			"parserStatus":    &graphql.Field{Type: parserStatusType},
+			"ingestQueue":     &graphql.Field{Type: ingestQueueType},
+			"currentIncident": &graphql.Field{Type: healthEventType},
+		},
+	})
+}
+
+// newPageType returns the type of a page of items
+func newPageType(item *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: item.Name() + "Page",
+		Fields: graphql.Fields{
+			"items":      &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(item)))},
+			"nextCursor": &graphql.Field{Type: graphql.String, Description: "The cursor of the next page, null on the last page."},
+		},
+	})
+}
+
+// isPage reports whether an object is a page type
+func isPage(object *graphql.Object) bool {
+	return strings.HasSuffix(object.Name(), "Page")
+}
+
+// resolvePage reads the limit and cursor arguments of a list and fetches its page
+func resolvePage(p graphql.ResolveParams, message string, fetch func(models.Page) (interface{}, string, error)) (interface{}, error) {
+	pageArgs := models.Page{Limit: defaultPageSize}
+	if limit, ok := p.Args["limit"].(int); ok {
+		if limit < 1 || limit > maxPageSize {
+			return nil, fmt.Errorf("invalid limit, must be between 1 and %d", maxPageSize)
+		}
+		pageArgs.Limit = limit
+	}
+	if value, ok := p.Args["cursor"].(string); ok && value != "" {
+		cursor, err := models.DecodeCursor(value)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		pageArgs.Cursor = cursor
+	}
+
+	items, nextCursor, err := fetch(pageArgs)
+	if err != nil {
+		return nil, loadError(err, message)
+	}
+	result := page{Items: items}
+	if nextCursor != "" {
+		result.NextCursor = nextCursor
+	}
+	return result, nil
+}
+
+// nonNil returns the items of a page as an empty list rather than null when there are none
+func nonNil[T any](items []T, nextCursor string, err error) (interface{}, string, error) {
+	if items == nil {
+		items = []T{}
+	}
+	return items, nextCursor, err
+}
+
+// one adapts a loader of a single value to a resolver, resolving to null when it is missing
+func one[V any](load func() (*V, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		value, err := load()
+		if err != nil || value == nil {
+			return nil, err
+		}
+		return *value, nil
+	}
+}
+
+// many adapts a loader of a list to a resolver
+func many[V any](load func() ([]V, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		values, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+}
+
+// normalizeAddress returns an address as it is stored, without the 0x prefix
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(address), "0x"))
+}
@@ -4,7 +4,13 @@
 package main
 
 import (
+	"database/sql"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -14,26 +20,74 @@ import (
 	"github.com/nuklai/nuklaivm-external-subscriber/api"
 	"github.com/nuklai/nuklaivm-external-subscriber/config"
 	"github.com/nuklai/nuklaivm-external-subscriber/db"
+	"github.com/nuklai/nuklaivm-external-subscriber/events"
+	"github.com/nuklai/nuklaivm-external-subscriber/graph"
 	"github.com/nuklai/nuklaivm-external-subscriber/models"
 	"github.com/nuklai/nuklaivm-external-subscriber/server"
+	"github.com/nuklai/nuklaivm-external-subscriber/webhooks"
 )
 
 // main function to register routes and start servers
 func main() {
 	// Initialize the database
 	connStr := config.GetDatabaseURL()
+
+	// Schema migrations are managed on the database as it is, before InitDB migrates it
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(connStr, os.Args[2:])
+		return
+	}
+
 	database, err := db.InitDB(connStr)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.Close()
 
-	// Start the gRPC server
+	// Populate the balances of a database indexed before they were tracked
+	if err := server.SeedBalances(database); err != nil {
+		log.Fatalf("Failed to seed balances: %v", err)
+	}
+
+	// Run a one-off command instead of the servers if requested
+	if len(os.Args) > 1 {
+		runCommand(database, os.Args[1], os.Args[2:])
+		return
+	}
+
+	// Start the gRPC server. Accepted blocks are queued on disk and written to the
+	// database by the ingest worker, which then publishes their events to the stream hub.
 	grpcPort := "50051"
-	go server.StartGRPCServerWithRetries(database, grpcPort, 60)
+	eventHub := events.NewHub()
+	subscriber, err := server.NewServer(database, config.GetIngestQueueDir(), eventHub)
+	if err != nil {
+		log.Fatalf("Failed to open ingest queue: %v", err)
+	}
+	go subscriber.RunIngestWorker()
+	go server.StartGRPCServerWithRetries(subscriber, grpcPort, 60)
+
+	// Start the gRPC query service for internal services, authorized with its own token
+	if token := config.GetQueryAPIToken(); token != "" {
+		go server.StartQueryServerWithRetries(server.NewQueryServer(database, eventHub), config.GetQueryGRPCPort(), token, 60)
+	} else {
+		log.Printf("QUERY_API_TOKEN is not set, the gRPC query service is disabled")
+	}
+
+	// Send the webhook deliveries queued by the ingest worker
+	go webhooks.NewDispatcher(database).Run()
+
+	// Start scanning for missing blocks (1m)
+	gapScanner := api.InitGapScanner(database)
+	go gapScanner.Start(time.Minute)
 
 	// Init the health monitor
-	healthMonitor := api.InitHealthMonitor(database, grpcPort)
+	healthMonitor := api.InitHealthMonitor(database, grpcPort, gapScanner, subscriber)
+
+	// Init the GraphQL executor
+	graphExecutor, err := graph.NewExecutor(database, healthMonitor.GetHealthStatus)
+	if err != nil {
+		log.Fatalf("Failed to build GraphQL schema: %v", err)
+	}
 
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode)
@@ -57,12 +111,32 @@ func main() {
 	r.GET("/health/history", api.GetHealthHistory(database))      // Get health insidents
 	r.GET("/health/history/90days", api.Get90DayHealth(database)) // Get 90-day health history
 
+	r.GET("/sync/gaps", api.GetSyncGaps(gapScanner)) // Get missing block height ranges
+
+	r.GET("/stream", api.StreamEvents(database, eventHub))             // Stream new blocks, transactions and actions as Server-Sent Events
+	r.GET("/stream/ws", api.StreamEventsWebSocket(database, eventHub)) // Stream new blocks, transactions and actions over a WebSocket
+
+	r.GET("/graphql", api.GraphQL(graphExecutor))  // Run a GraphQL query given in the query parameters
+	r.POST("/graphql", api.GraphQL(graphExecutor)) // Run a GraphQL query given in the body
+
+	// Webhook management, authorized with the admin token
+	webhookRoutes := r.Group("/webhooks", api.RequireAdminToken(config.GetWebhookAdminToken()))
+	webhookRoutes.POST("", api.CreateWebhook(database))
+	webhookRoutes.GET("", api.GetWebhooks(database))
+	webhookRoutes.GET("/:id", api.GetWebhook(database))
+	webhookRoutes.PUT("/:id", api.UpdateWebhook(database))
+	webhookRoutes.DELETE("/:id", api.DeleteWebhook(database))
+	webhookRoutes.GET("/:id/deliveries", api.GetWebhookDeliveries(database)) // Fetch the delivery log of a webhook
+
 	// Other endpoints
 	r.GET("/genesis", api.GetGenesisData(database))
+	r.GET("/genesis/epochs", api.GetNetworkEpochs(database)) // Get indexed network epochs
 
 	r.GET("/blocks", api.GetAllBlocks(database))         // Get all blocks
 	r.GET("/blocks/:identifier", api.GetBlock(database)) // Get blocks by height or hash
 
+	r.GET("/reorgs", api.GetChainReorgs(database)) // Get detected chain reorganizations
+
 	r.GET("/transactions", api.GetAllTransactions(database))
 	r.GET("/transactions/:tx_hash", api.GetTransactionByHash(database))            // Fetch by transaction hash
 	r.GET("/transactions/block/:identifier", api.GetTransactionsByBlock(database)) // Fetch transactions by block height or hash
@@ -84,11 +158,36 @@ func main() {
 
 	r.GET("/assets", api.GetAllAssets(database))
 	r.GET("/assets/:asset_address", api.GetAssetByAddress(database))
-	r.GET("/assets/type/:type", api.GetAssetsByType(database)) // Fetch assets by type
-	r.GET("/assets/user/:user", api.GetAssetsByUser(database)) // Fetch assets by user
+	r.GET("/assets/:asset_address/history", api.GetAssetHistory(database))                     // Fetch the changes of an asset
+	r.GET("/assets/:asset_address/supply", api.GetAssetSupply(database))                       // Fetch the supply changes of an asset
+	r.GET("/assets/:asset_address/nfts", api.GetCollectionNFTs(database))                      // Fetch the tokens of an NFT collection
+	r.GET("/assets/:asset_address/nfts/:unique_id", api.GetNFT(database))                      // Fetch a single NFT
+	r.GET("/assets/:asset_address/nfts/:unique_id/provenance", api.GetNFTProvenance(database)) // Fetch the transfers of a single NFT
+	r.GET("/assets/type/:type", api.GetAssetsByType(database))                                 // Fetch assets by type
+	r.GET("/assets/user/:user", api.GetAssetsByUser(database))                                 // Fetch assets by user
+
+	r.GET("/datasets", api.GetAllDatasets(database))
+	r.GET("/datasets/:dataset_address", api.GetDatasetByAddress(database))
+	r.GET("/datasets/:dataset_address/history", api.GetDatasetHistory(database))             // Fetch the revisions of a dataset
+	r.GET("/datasets/:dataset_address/contributions", api.GetDatasetContributions(database)) // Fetch the contributions to a dataset
+	r.GET("/datasets/owner/:address", api.GetDatasetsByOwner(database))                      // Fetch datasets by owner
+
+	r.GET("/marketplace/listings", api.GetMarketplaceListings(database))
+	r.GET("/marketplace/listings/:dataset_address", api.GetMarketplaceListing(database))
+	r.GET("/marketplace/listings/:dataset_address/subscriptions", api.GetMarketplaceSubscriptions(database)) // Fetch the subscribers of a dataset
+	r.GET("/marketplace/listings/:dataset_address/payments", api.GetMarketplacePayments(database))           // Fetch the payments claimed for a dataset
+	r.GET("/marketplace/revenue/datasets/:dataset_address", api.GetDatasetRevenue(database))                 // Fetch the revenue of a dataset over a period
+	r.GET("/marketplace/revenue/owners/:address", api.GetOwnerRevenue(database))                             // Fetch the revenue of an owner over a period
+	r.GET("/marketplace/payments/unclaimed", api.GetUnclaimedPayments(database))                             // Fetch the listings with payments left to claim
+
+	r.GET("/contracts", api.GetAllContracts(database))
+	r.GET("/contracts/:address", api.GetContractByAddress(database))
+	r.GET("/contracts/:address/calls", api.GetContractCalls(database)) // Fetch the calls made to a contract
 
 	r.GET("/validator_stake", api.GetAllValidatorStakes(database))
 	r.GET("/validator_stake/:node_id", api.GetValidatorStakeByNodeID(database))
+	r.GET("/validator_stake/:node_id/rewards", api.GetValidatorRewards(database))       // Fetch the rewards paid out to a validator
+	r.GET("/validator_stake/:node_id/delegators", api.GetValidatorDelegators(database)) // Fetch the delegations to a validator
 
 	// Start the health monitor (6s)
 	go func() {
@@ -120,9 +219,100 @@ func main() {
 	r.GET("/accounts", api.GetAllAccounts(database))
 	r.GET("/accounts/:address", api.GetAccountDetails(database))
 	r.GET("/accounts/stats", api.GetAccountStats(database))
+	r.GET("/accounts/:address/nfts", api.GetNFTsByOwner(database))                      // Fetch the NFTs held by an address
+	r.GET("/accounts/:address/balance_changes", api.GetAccountBalanceChanges(database)) // Fetch the balance changes of an address
+	r.GET("/accounts/:address/delegations", api.GetAccountDelegations(database))        // Fetch the delegations of an address
+	r.GET("/accounts/:address/contributions", api.GetAccountContributions(database))    // Fetch the dataset contributions of an address
 
 	// Start HTTP server
 	if err := r.Run(":8080"); err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
 }
+
+// runCommand runs a one-off subscriber command
+func runCommand(database *sql.DB, command string, args []string) {
+	switch command {
+	case "backfill":
+		flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+		path := flags.String("path", "", "file or directory containing serialized ExecutedBlock bytes")
+		flags.Parse(args)
+		if *path == "" {
+			log.Fatal("Usage: subscriber backfill -path <file|directory>")
+		}
+
+		if err := server.Backfill(database, *path); err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+	case "reindex":
+		if err := server.ReindexBlocks(database); err != nil {
+			log.Fatalf("Reindex failed: %v", err)
+		}
+	case "reindex-actions":
+		flags := flag.NewFlagSet("reindex-actions", flag.ExitOnError)
+		tables := flags.String("tables", "", "comma separated tables to rebuild (default: all indexed tables)")
+		flags.Parse(args)
+
+		var selected []string
+		if *tables != "" {
+			selected = strings.Split(*tables, ",")
+		}
+		if err := server.ReindexActions(database, selected); err != nil {
+			log.Fatalf("Reindex failed: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown command %q", command)
+	}
+}
+
+// runMigrateCommand shows or changes the schema version of the database
+func runMigrateCommand(connStr string, args []string) {
+	usage := "Usage: subscriber migrate status|up|down|to <version>"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	database, err := db.Connect(connStr)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatuses(database)
+		if err != nil {
+			log.Fatalf("Failed to fetch migration status: %v", err)
+		}
+		version, err := db.SchemaVersion(database)
+		if err != nil {
+			log.Fatalf("Failed to fetch schema version: %v", err)
+		}
+		fmt.Printf("Schema version: %d\n", version)
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	case "up":
+		err = db.MigrateUp(database)
+	case "down":
+		err = db.MigrateDown(database)
+	case "to":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		version, parseErr := strconv.Atoi(args[1])
+		if parseErr != nil {
+			log.Fatalf("Invalid schema version %q", args[1])
+		}
+		err = db.MigrateTo(database, version)
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
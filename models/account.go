@@ -5,77 +5,64 @@ package models
 
 import (
 	"database/sql"
-	"fmt"
+	"encoding/json"
 	"log"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/nuklai/nuklaivm/storage"
 )
 
+// naiAddress is the asset address of the native NAI token
+var naiAddress = storage.NAIAddress.String()
+
 type AccountStats struct {
-	TotalAccounts  int `json:"total_accounts"`
-	TotalNAIHeld   int `json:"total_nai_held"`
-	ActiveAccounts int `json:"active_accounts"`
+	TotalAccounts  int         `json:"total_accounts"`
+	TotalNAIHeld   json.Number `json:"total_nai_held"`
+	ActiveAccounts int         `json:"active_accounts"`
 }
 
 type Account struct {
-	Address          string  `json:"address"`
-	Balance          float64 `json:"balance"`
-	TransactionCount int     `json:"transaction_count"`
+	Address          string           `json:"address"`
+	Balance          json.Number      `json:"balance"`
+	TransactionCount int              `json:"transaction_count"`
+	Balances         []AccountBalance `json:"balances,omitempty"`
+}
+
+type AccountBalance struct {
+	AssetAddress string      `json:"asset_address"`
+	Balance      json.Number `json:"balance"`
+}
+
+type BalanceChange struct {
+	ID              int          `json:"id"`
+	AssetAddress    string       `json:"asset_address"`
+	TxHash          *string      `json:"tx_hash"`
+	BlockHeight     int64        `json:"block_height"`
+	ActionName      string       `json:"action_name"`
+	PreviousBalance *json.Number `json:"previous_balance"`
+	NewBalance      json.Number  `json:"new_balance"`
+	Timestamp       string       `json:"timestamp"`
 }
 
+// accountTransactionCount counts the transactions an address sponsored or took part in
+const accountTransactionCount = `
+	(SELECT COUNT(*) FROM transactions t
+	 WHERE t.sponsor = accounts.address OR accounts.address = ANY(t.actors) OR accounts.address = ANY(t.receivers))`
+
 // FetchAccountStats retrieves all account stats
 func FetchAccountStats(db *sql.DB) (AccountStats, error) {
 	var stats AccountStats
 
-	// Get the total unique addresses from actors & receivers from transactions table
 	err := db.QueryRow(`
-        WITH unique_addresses AS (
-            SELECT DISTINCT sponsor FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(actors) FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(receivers) FROM transactions
-        )
-        SELECT COUNT(*) FROM unique_addresses
-    `).Scan(&stats.TotalAccounts)
+        SELECT COUNT(DISTINCT address), COALESCE(SUM(balance) FILTER (WHERE asset_address = $1), 0)
+        FROM balances
+    `, naiAddress).Scan(&stats.TotalAccounts, &stats.TotalNAIHeld)
 	if err != nil {
 		log.Printf("Error counting total accounts: %v", err)
 		return stats, err
 	}
 
-	err = db.QueryRow(`
-        WITH latest_balances AS (
-            SELECT 
-                CASE 
-                    WHEN TRIM(BOTH '"' FROM REPLACE(t.sponsor, '0x', '')) = TRIM(BOTH '"' FROM REPLACE(a.input->>'to', '0x', ''))
-                        THEN CAST(a.output->>'receiver_balance' AS NUMERIC)
-                    ELSE CAST(a.output->>'sender_balance' AS NUMERIC)
-                END as balance,
-                t.sponsor as address
-            FROM transactions t
-            JOIN actions a ON t.tx_hash = a.tx_hash
-            WHERE a.action_type = 0 
-            AND a.action_name = 'Transfer'
-            UNION ALL
-            SELECT 
-                CAST(a.output->>'receiver_balance' AS NUMERIC) as balance,
-                r.receiver as address
-            FROM transactions t
-            CROSS JOIN UNNEST(t.receivers) as r(receiver)
-            JOIN actions a ON t.tx_hash = a.tx_hash
-            WHERE a.action_type = 0 
-            AND a.action_name = 'Transfer'
-        )
-        SELECT COALESCE(SUM(balance), 0)
-        FROM (
-            SELECT DISTINCT ON (address) address, balance
-            FROM latest_balances
-            ORDER BY address, balance DESC
-        ) final_balances
-    `).Scan(&stats.TotalNAIHeld)
-	if err != nil {
-		log.Printf("Error calculating total NAI held: %v", err)
-		return stats, err
-	}
-
 	// Get active accounts - 24h
 	err = db.QueryRow(`
         WITH active_addresses AS (
@@ -100,18 +87,7 @@ func FetchAccountStats(db *sql.DB) (AccountStats, error) {
 
 func CountAccounts(db *sql.DB) (int, error) {
 	var count int
-	query := `
-        WITH unique_addresses AS (
-            SELECT DISTINCT sponsor FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(actors) FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(receivers) FROM transactions
-        )
-        SELECT COUNT(*) FROM unique_addresses
-    `
-
-	err := db.QueryRow(query).Scan(&count)
+	err := db.QueryRow(`SELECT COUNT(DISTINCT address) FROM balances`).Scan(&count)
 	if err != nil {
 		log.Printf("Error counting accounts: %v", err)
 		return 0, err
@@ -120,65 +96,31 @@ func CountAccounts(db *sql.DB) (int, error) {
 	return count, nil
 }
 
-// FetchAllAccounts retrieves accounts address, balance, transaction count
-func FetchAllAccounts(db *sql.DB, limit, offset string) ([]Account, error) {
+// accountOrder sorts accounts from the largest NAI balance
+var accountOrder = keyset{
+	{expr: "accounts.balance", cast: "numeric", desc: true},
+	{expr: "accounts.address", cast: "text"},
+}
+
+// FetchAllAccounts retrieves a page of accounts with their address, NAI balance and
+// transaction count ordered by NAI balance, and the cursor of the next page
+func FetchAllAccounts(db *sql.DB, page Page) ([]Account, string, error) {
 	query := `
-        WITH unique_addresses AS (
-            SELECT DISTINCT sponsor as address FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(actors) as address FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(receivers) as address FROM transactions
-        ), transfer_balances AS (
-            SELECT 
-                CASE 
-                    WHEN TRIM(BOTH '"' FROM REPLACE(t.sponsor, '0x', '')) = TRIM(BOTH '"' FROM REPLACE(a.input->>'to', '0x', ''))
-                        THEN CAST(a.output->>'receiver_balance' AS NUMERIC)
-                    ELSE CAST(a.output->>'sender_balance' AS NUMERIC)
-                END as balance,
-                t.sponsor as address
-            FROM transactions t
-            JOIN actions a ON t.tx_hash = a.tx_hash
-            WHERE a.action_type = 0 
-            AND a.action_name = 'Transfer'
-            UNION ALL
-            SELECT 
-                CAST(a.output->>'receiver_balance' AS NUMERIC) as balance,
-                r.receiver as address
-            FROM transactions t
-            CROSS JOIN UNNEST(t.receivers) as r(receiver)
-            JOIN actions a ON t.tx_hash = a.tx_hash
-            WHERE a.action_type = 0 
-            AND a.action_name = 'Transfer'
-        ), latest_balances AS (
-            SELECT DISTINCT ON (address) 
-                address,
-                balance
-            FROM transfer_balances
-            ORDER BY address, balance DESC
+        WITH accounts AS (
+            SELECT address, COALESCE(SUM(balance) FILTER (WHERE asset_address = $1), 0) AS balance
+            FROM balances
+            GROUP BY address
         )
-        SELECT 
-            ua.address,
-            COALESCE(lb.balance, 0) as balance,
-            COUNT(DISTINCT t.tx_hash) as tx_count
-        FROM unique_addresses ua
-        LEFT JOIN latest_balances lb ON ua.address = lb.address
-        LEFT JOIN transactions t ON 
-            ua.address = t.sponsor 
-            OR ua.address = ANY(t.actors) 
-            OR ua.address = ANY(t.receivers)
-        GROUP BY ua.address, lb.balance
-        ORDER BY COUNT(DISTINCT t.tx_hash) DESC
-        LIMIT $1 OFFSET $2
-    `
-
-	rows, err := db.Query(query, limit, offset)
-	if err != nil {
-		log.Printf("Error fetching accounts: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
+        SELECT accounts.address, accounts.balance,` + accountTransactionCount + `
+        FROM accounts
+        WHERE TRUE`
 
+	return fetchPage(db, accountOrder, query, []interface{}{naiAddress}, page, scanAccounts,
+		func(account Account) []interface{} { return []interface{}{account.Balance, account.Address} })
+}
+
+// scanAccounts scans account rows
+func scanAccounts(rows *sql.Rows) ([]Account, error) {
 	var accounts []Account
 	for rows.Next() {
 		var account Account
@@ -189,7 +131,7 @@ func FetchAllAccounts(db *sql.DB, limit, offset string) ([]Account, error) {
 		accounts = append(accounts, account)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		log.Printf("Error iterating account rows: %v", err)
 		return nil, err
 	}
@@ -197,67 +139,142 @@ func FetchAllAccounts(db *sql.DB, limit, offset string) ([]Account, error) {
 	return accounts, nil
 }
 
-// FetchAccountByAddress retrieves details for a specific account/address
+// FetchAccountByAddress retrieves details for a specific account/address, including its
+// balance in every asset it holds. It returns sql.ErrNoRows when the address holds no balance.
 func FetchAccountByAddress(db *sql.DB, address string) (*Account, error) {
-	query := `
-        WITH unique_addresses AS (
-            SELECT DISTINCT sponsor as address FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(actors) as address FROM transactions
-            UNION
-            SELECT DISTINCT UNNEST(receivers) as address FROM transactions
-        ), transfer_balances AS (
-            SELECT 
-                CASE 
-                    WHEN TRIM(BOTH '"' FROM REPLACE(t.sponsor, '0x', '')) = TRIM(BOTH '"' FROM REPLACE(a.input->>'to', '0x', ''))
-                        THEN CAST(a.output->>'receiver_balance' AS NUMERIC)
-                    ELSE CAST(a.output->>'sender_balance' AS NUMERIC)
-                END as balance,
-                t.sponsor as address
-            FROM transactions t
-            JOIN actions a ON t.tx_hash = a.tx_hash
-            WHERE a.action_type = 0 
-            AND a.action_name = 'Transfer'
-            UNION ALL
-            SELECT 
-                CAST(a.output->>'receiver_balance' AS NUMERIC) as balance,
-                r.receiver as address
-            FROM transactions t
-            CROSS JOIN UNNEST(t.receivers) as r(receiver)
-            JOIN actions a ON t.tx_hash = a.tx_hash
-            WHERE a.action_type = 0 
-            AND a.action_name = 'Transfer'
-        ), latest_balances AS (
-            SELECT DISTINCT ON (address) 
-                address,
-                balance
-            FROM transfer_balances
-            ORDER BY address, balance DESC
-        )
-        SELECT 
-            ua.address,
-            COALESCE(lb.balance, 0) as balance,
-            COUNT(DISTINCT t.tx_hash) as tx_count
-        FROM unique_addresses ua
-        LEFT JOIN latest_balances lb ON ua.address = lb.address
-        LEFT JOIN transactions t ON 
-            ua.address = t.sponsor 
-            OR ua.address = ANY(t.actors) 
-            OR ua.address = ANY(t.receivers)
-        WHERE ua.address = $1
-        GROUP BY ua.address, lb.balance
-    `
-
-	row := db.QueryRow(query, address)
-
-	var account Account
-	if err := row.Scan(&account.Address, &account.Balance, &account.TransactionCount); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("no account found for address %s", address)
+	address = strings.TrimPrefix(address, "0x")
+
+	rows, err := db.Query(`
+        SELECT asset_address, balance
+        FROM balances
+        WHERE address = $1
+        ORDER BY asset_address <> $2, asset_address
+    `, address, naiAddress)
+	if err != nil {
+		log.Printf("Error fetching account balances: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	account := Account{Address: address, Balance: "0", Balances: []AccountBalance{}}
+	for rows.Next() {
+		var balance AccountBalance
+		if err := rows.Scan(&balance.AssetAddress, &balance.Balance); err != nil {
+			log.Printf("Error scanning account balance row: %v", err)
+			return nil, err
 		}
-		log.Printf("Error scanning account row: %v", err)
+		if balance.AssetAddress == naiAddress {
+			account.Balance = balance.Balance
+		}
+		account.Balances = append(account.Balances, balance)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating account balance rows: %v", err)
+		return nil, err
+	}
+	if len(account.Balances) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	err = db.QueryRow(`SELECT`+accountTransactionCount+` FROM (SELECT $1::TEXT AS address) accounts`, address).Scan(&account.TransactionCount)
+	if err != nil {
+		log.Printf("Error counting account transactions: %v", err)
 		return nil, err
 	}
 
 	return &account, nil
 }
+
+// AddressBalance is the balance of an address in an asset
+type AddressBalance struct {
+	Address string
+	AccountBalance
+}
+
+// FetchBalancesByAddresses retrieves the balances of the given addresses in every asset
+// they hold, NAI first
+func FetchBalancesByAddresses(db *sql.DB, addresses []string) ([]AddressBalance, error) {
+	rows, err := db.Query(`
+        SELECT address, asset_address, balance
+        FROM balances
+        WHERE address = ANY($1)
+        ORDER BY address, asset_address <> $2, asset_address
+    `, pq.Array(addresses), naiAddress)
+	if err != nil {
+		log.Printf("Error fetching account balances: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []AddressBalance
+	for rows.Next() {
+		var balance AddressBalance
+		if err := rows.Scan(&balance.Address, &balance.AssetAddress, &balance.Balance); err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	return balances, rows.Err()
+}
+
+// FetchTransactionCounts retrieves the number of transactions each of the given addresses
+// sponsored or took part in
+func FetchTransactionCounts(db *sql.DB, addresses []string) (map[string]int, error) {
+	rows, err := db.Query(`SELECT accounts.address,`+accountTransactionCount+`
+		FROM unnest($1::TEXT[]) AS accounts (address)`, pq.Array(addresses))
+	if err != nil {
+		log.Printf("Error counting account transactions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(addresses))
+	for rows.Next() {
+		var address string
+		var count int
+		if err := rows.Scan(&address, &count); err != nil {
+			return nil, err
+		}
+		counts[address] = count
+	}
+	return counts, rows.Err()
+}
+
+// CountBalanceChanges gets total count of balance changes of an address, optionally in a single asset
+func CountBalanceChanges(db *sql.DB, address, assetAddress string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM balance_changes
+		WHERE address = $1 AND ($2 = '' OR asset_address = $2)`,
+		strings.TrimPrefix(address, "0x"), strings.TrimPrefix(assetAddress, "0x")).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting balance changes: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchBalanceChanges retrieves a page of the balance changes of an address, newest first,
+// and the cursor of the next page
+func FetchBalanceChanges(db *sql.DB, address, assetAddress string, page Page) ([]BalanceChange, string, error) {
+	return fetchPage(db, blockHeightOrder("balance_changes", true), `
+		SELECT id, asset_address, tx_hash, block_height, action_name, previous_balance, new_balance, timestamp
+		FROM balance_changes
+		WHERE address = $1 AND ($2 = '' OR asset_address = $2)`,
+		[]interface{}{strings.TrimPrefix(address, "0x"), strings.TrimPrefix(assetAddress, "0x")}, page, scanBalanceChanges,
+		func(change BalanceChange) []interface{} { return []interface{}{change.BlockHeight, change.ID} })
+}
+
+// scanBalanceChanges scans balance change rows
+func scanBalanceChanges(rows *sql.Rows) ([]BalanceChange, error) {
+	changes := []BalanceChange{}
+	for rows.Next() {
+		var change BalanceChange
+		if err := rows.Scan(&change.ID, &change.AssetAddress, &change.TxHash, &change.BlockHeight, &change.ActionName,
+			&change.PreviousBalance, &change.NewBalance, &change.Timestamp); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
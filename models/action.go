@@ -10,6 +10,8 @@ import (
 	"log"
 	"strconv"
 	"strings"
+
+	"github.com/lib/pq"
 )
 
 type Action struct {
@@ -23,9 +25,26 @@ type Action struct {
 	Timestamp   string                 `json:"Timestamp"`
 }
 
-// FetchAllActions retrieves actions from the database with pagination
-func FetchAllActions(db *sql.DB, limit, offset string) ([]Action, error) {
-	rows, err := db.Query(`SELECT * FROM actions ORDER BY timestamp DESC LIMIT $1 OFFSET $2`, limit, offset)
+// actionOrder sorts actions from the newest
+var actionOrder = keyset{
+	{expr: "actions.timestamp", cast: "timestamp", desc: true},
+	{expr: "actions.id", cast: "bigint", desc: true},
+}
+
+// FetchAllActions retrieves a page of actions and the cursor of the next page
+func FetchAllActions(db *sql.DB, page Page) ([]Action, string, error) {
+	return fetchActionPage(db, `SELECT * FROM actions WHERE TRUE`, nil, page)
+}
+
+// fetchActionPage runs an action query ending inside its WHERE clause for a page
+func fetchActionPage(db *sql.DB, query string, args []interface{}, page Page) ([]Action, string, error) {
+	return fetchPage(db, actionOrder, query, args, page, scanActions,
+		func(action Action) []interface{} { return []interface{}{action.Timestamp, action.ID} })
+}
+
+// FetchActionsByTransactionHash retrieves actions associated with a transaction by its hash
+func FetchActionsByTransactionHash(db *sql.DB, txHash string) ([]Action, error) {
+	rows, err := db.Query(`SELECT * FROM actions WHERE tx_hash = $1`, txHash)
 	if err != nil {
 		log.Printf("Database query error: %v", err)
 		return nil, err
@@ -35,9 +54,10 @@ func FetchAllActions(db *sql.DB, limit, offset string) ([]Action, error) {
 	return scanActions(rows)
 }
 
-// FetchActionsByTransactionHash retrieves actions associated with a transaction by its hash
-func FetchActionsByTransactionHash(db *sql.DB, txHash string) ([]Action, error) {
-	rows, err := db.Query(`SELECT * FROM actions WHERE tx_hash = $1`, txHash)
+// FetchActionsByTransactionHashes retrieves the actions of the transactions with the
+// given hashes, in the order they were executed
+func FetchActionsByTransactionHashes(db *sql.DB, txHashes []string) ([]Action, error) {
+	rows, err := db.Query(`SELECT * FROM actions WHERE tx_hash = ANY($1) ORDER BY tx_hash, action_index`, pq.Array(txHashes))
 	if err != nil {
 		log.Printf("Database query error: %v", err)
 		return nil, err
@@ -76,45 +96,25 @@ func FetchActionsByBlock(db *sql.DB, blockIdentifier string) ([]Action, error) {
 	return scanActions(rows)
 }
 
-func FetchActionsByType(db *sql.DB, actionType, limit, offset string) ([]Action, error) {
-	rows, err := db.Query(`
-        SELECT * FROM actions
-        WHERE action_type = $1
-        ORDER BY timestamp DESC
-        LIMIT $2 OFFSET $3`, actionType, limit, offset)
-	if err != nil {
-		log.Printf("Database query error: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	return scanActions(rows)
+// FetchActionsByType retrieves a page of the actions of a type and the cursor of the next page
+func FetchActionsByType(db *sql.DB, actionType string, page Page) ([]Action, string, error) {
+	return fetchActionPage(db, `SELECT * FROM actions WHERE action_type = $1`, []interface{}{actionType}, page)
 }
 
-func FetchActionsByName(db *sql.DB, actionName, limit, offset string) ([]Action, error) {
-	rows, err := db.Query(`
-        SELECT * FROM actions
-        WHERE action_name ILIKE $1
-        ORDER BY timestamp DESC
-        LIMIT $2 OFFSET $3`, actionName, limit, offset)
-	if err != nil {
-		log.Printf("Database query error: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	return scanActions(rows)
+// FetchActionsByName retrieves a page of the actions with a name and the cursor of the next page
+func FetchActionsByName(db *sql.DB, actionName string, page Page) ([]Action, string, error) {
+	return fetchActionPage(db, `SELECT * FROM actions WHERE action_name ILIKE $1`, []interface{}{actionName}, page)
 }
 
-// FetchActionsByUser retrieves actions by user with pagination
-func FetchActionsByUser(db *sql.DB, user, limit, offset string) ([]Action, error) {
+// FetchActionsByUser retrieves a page of the actions of a user and the cursor of the next page
+func FetchActionsByUser(db *sql.DB, user string, page Page) ([]Action, string, error) {
 	normalizedUser := "%" + strings.TrimPrefix(user, "0x") + "%"
 
-	rows, err := db.Query(`
+	return fetchActionPage(db, `
         SELECT actions.*
         FROM actions
         INNER JOIN transactions ON actions.tx_hash = transactions.tx_hash
-        WHERE
+        WHERE (
             transactions.sponsor ILIKE $1
             OR EXISTS (
                 SELECT 1
@@ -126,16 +126,7 @@ func FetchActionsByUser(db *sql.DB, user, limit, offset string) ([]Action, error
                 FROM unnest(transactions.receivers) AS receiver
                 WHERE receiver ILIKE $1
             )
-        ORDER BY actions.timestamp DESC
-        LIMIT $2 OFFSET $3
-    `, normalizedUser, limit, offset)
-	if err != nil {
-		log.Printf("Database query error: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
-
-	return scanActions(rows)
+        )`, []interface{}{normalizedUser}, page)
 }
 
 // Helper function to scan action rows and unmarshal action details
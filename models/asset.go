@@ -5,29 +5,40 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"log"
+
+	"github.com/lib/pq"
 )
 
 type Asset struct {
-	ID                           int    `json:"id"` // New field for the primary key
-	AssetAddress                 string `json:"asset_address"`
-	AssetTypeID                  int    `json:"asset_type_id"`
-	AssetType                    string `json:"asset_type"`
-	AssetCreator                 string `json:"asset_creator"`
-	TxHash                       string `json:"tx_hash"`
-	Name                         string `json:"name"`
-	Symbol                       string `json:"symbol"`
-	Decimals                     int    `json:"decimals"`
-	Metadata                     string `json:"metadata"`
-	MaxSupply                    int64  `json:"max_supply"`
-	MintAdmin                    string `json:"mint_admin"`
-	PauseUnpauseAdmin            string `json:"pause_unpause_admin"`
-	FreezeUnfreezeAdmin          string `json:"freeze_unfreeze_admin"`
-	EnableDisableKYCAccountAdmin string `json:"enable_disable_kyc_account_admin"`
-	Timestamp                    string `json:"timestamp"`
+	ID                           int         `json:"id"` // New field for the primary key
+	AssetAddress                 string      `json:"asset_address"`
+	AssetTypeID                  int         `json:"asset_type_id"`
+	AssetType                    string      `json:"asset_type"`
+	AssetCreator                 string      `json:"asset_creator"`
+	TxHash                       string      `json:"tx_hash"`
+	Name                         string      `json:"name"`
+	Symbol                       string      `json:"symbol"`
+	Decimals                     int         `json:"decimals"`
+	Metadata                     string      `json:"metadata"`
+	MaxSupply                    uint64      `json:"max_supply"`
+	Owner                        string      `json:"owner"`
+	MintAdmin                    string      `json:"mint_admin"`
+	PauseUnpauseAdmin            string      `json:"pause_unpause_admin"`
+	FreezeUnfreezeAdmin          string      `json:"freeze_unfreeze_admin"`
+	EnableDisableKYCAccountAdmin string      `json:"enable_disable_kyc_account_admin"`
+	TotalMinted                  json.Number `json:"total_minted"`
+	TotalBurned                  json.Number `json:"total_burned"`
+	CurrentSupply                json.Number `json:"current_supply"`
+	Timestamp                    string      `json:"timestamp"`
 }
 
+// assetColumns lists the asset columns in the order expected by scanAsset
+const assetColumns = `id, asset_address, asset_type_id, asset_type, asset_creator, tx_hash, name, symbol, decimals, metadata,
+	max_supply, COALESCE(owner, asset_creator), mint_admin, pause_unpause_admin, freeze_unfreeze_admin, enable_disable_kyc_account_admin,
+	total_minted, total_burned, current_supply, timestamp`
+
 // CountFilteredAssets counts assets based on optional filters
 func CountFilteredAssets(db *sql.DB, assetType, user, assetAddress, name, symbol string) (int, error) {
 	query, args := buildAssetFilterQuery("COUNT(*)", assetType, user, assetAddress, name, symbol)
@@ -36,20 +47,22 @@ func CountFilteredAssets(db *sql.DB, assetType, user, assetAddress, name, symbol
 	return count, err
 }
 
-// FetchFilteredAssets retrieves assets based on optional filters
-func FetchFilteredAssets(db *sql.DB, assetType, user, assetAddress, name, symbol, limit, offset string) ([]Asset, error) {
-	query, args := buildAssetFilterQuery("*", assetType, user, assetAddress, name, symbol)
-	query = fmt.Sprintf("%s ORDER BY timestamp DESC LIMIT $%d OFFSET $%d", query, len(args)+1, len(args)+2)
+// assetOrder sorts assets from the most recently created
+var assetOrder = keyset{
+	{expr: "timestamp", cast: "timestamp", desc: true},
+	{expr: "id", cast: "bigint", desc: true},
+}
 
-	args = append(args, limit, offset)
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		log.Printf("Database query error: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
+// FetchFilteredAssets retrieves a page of assets based on optional filters and the cursor of the next page
+func FetchFilteredAssets(db *sql.DB, assetType, user, assetAddress, name, symbol string, page Page) ([]Asset, string, error) {
+	query, args := buildAssetFilterQuery(assetColumns, assetType, user, assetAddress, name, symbol)
+	return fetchAssetPage(db, query, args, page)
+}
 
-	return scanAssets(rows)
+// fetchAssetPage runs an asset query ending inside its WHERE clause for a page
+func fetchAssetPage(db *sql.DB, query string, args []interface{}, page Page) ([]Asset, string, error) {
+	return fetchPage(db, assetOrder, query, args, page, scanAssets,
+		func(asset Asset) []interface{} { return []interface{}{asset.Timestamp, asset.ID} })
 }
 
 // Helper function to construct filter queries for assets
@@ -91,14 +104,26 @@ func buildAssetFilterQuery(selectFields, assetType, user, assetAddress, name, sy
 	return query, args
 }
 
-func FetchAssetsByType(db *sql.DB, assetType, limit, offset string) ([]Asset, error) {
-	rows, err := db.Query(`
-		SELECT * FROM assets
-		WHERE asset_type_id = $1
-		ORDER BY timestamp DESC
-		LIMIT $2 OFFSET $3`, assetType, limit, offset)
+// FetchAssetsByType retrieves a page of the assets of a type and the cursor of the next page
+func FetchAssetsByType(db *sql.DB, assetType string, page Page) ([]Asset, string, error) {
+	return fetchAssetPage(db, `SELECT `+assetColumns+` FROM assets WHERE asset_type_id = $1`, []interface{}{assetType}, page)
+}
+
+// FetchAssetsByUser retrieves a page of the assets created by a user and the cursor of the next page
+func FetchAssetsByUser(db *sql.DB, user string, page Page) ([]Asset, string, error) {
+	return fetchAssetPage(db, `SELECT `+assetColumns+` FROM assets WHERE asset_creator ILIKE $1`, []interface{}{"%" + user + "%"}, page)
+}
+
+// FetchAssetByAddress retrieves a specific asset by its asset address
+func FetchAssetByAddress(db *sql.DB, assetAddress string) (Asset, error) {
+	row := db.QueryRow(`SELECT `+assetColumns+` FROM assets WHERE asset_address ILIKE $1`, "%"+assetAddress+"%")
+	return scanAsset(row)
+}
+
+// FetchAssetsByAddresses retrieves the assets with the given addresses
+func FetchAssetsByAddresses(db *sql.DB, assetAddresses []string) ([]Asset, error) {
+	rows, err := db.Query(`SELECT `+assetColumns+` FROM assets WHERE asset_address = ANY($1)`, pq.Array(assetAddresses))
 	if err != nil {
-		log.Printf("Database query error: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -106,14 +131,14 @@ func FetchAssetsByType(db *sql.DB, assetType, limit, offset string) ([]Asset, er
 	return scanAssets(rows)
 }
 
-func FetchAssetsByUser(db *sql.DB, user, limit, offset string) ([]Asset, error) {
+// FetchAssetsByCreators retrieves the assets created by the given addresses, newest first
+func FetchAssetsByCreators(db *sql.DB, creators []string) ([]Asset, error) {
 	rows, err := db.Query(`
-		SELECT * FROM assets
-		WHERE asset_creator ILIKE $1
-		ORDER BY timestamp DESC
-		LIMIT $2 OFFSET $3`, "%"+user+"%", limit, offset)
+		SELECT `+assetColumns+`
+		FROM assets
+		WHERE asset_creator = ANY($1)
+		ORDER BY timestamp DESC, id DESC`, pq.Array(creators))
 	if err != nil {
-		log.Printf("Database query error: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -121,19 +146,16 @@ func FetchAssetsByUser(db *sql.DB, user, limit, offset string) ([]Asset, error)
 	return scanAssets(rows)
 }
 
-// FetchAssetByAddress retrieves a specific asset by its asset address
-func FetchAssetByAddress(db *sql.DB, assetAddress string) (Asset, error) {
+// scanAsset scans a single row selected with assetColumns
+func scanAsset(row interface{ Scan(...interface{}) error }) (Asset, error) {
 	var asset Asset
-	err := db.QueryRow(`SELECT * FROM assets WHERE asset_address ILIKE $1`, "%"+assetAddress+"%").Scan(
+	err := row.Scan(
 		&asset.ID, &asset.AssetAddress, &asset.AssetTypeID, &asset.AssetType, &asset.AssetCreator,
 		&asset.TxHash, &asset.Name, &asset.Symbol, &asset.Decimals, &asset.Metadata,
-		&asset.MaxSupply, &asset.MintAdmin, &asset.PauseUnpauseAdmin, &asset.FreezeUnfreezeAdmin,
-		&asset.EnableDisableKYCAccountAdmin, &asset.Timestamp,
+		&asset.MaxSupply, &asset.Owner, &asset.MintAdmin, &asset.PauseUnpauseAdmin, &asset.FreezeUnfreezeAdmin,
+		&asset.EnableDisableKYCAccountAdmin, &asset.TotalMinted, &asset.TotalBurned, &asset.CurrentSupply, &asset.Timestamp,
 	)
-	if err != nil {
-		return asset, err
-	}
-	return asset, nil
+	return asset, err
 }
 
 // Helper function to scan asset rows
@@ -141,9 +163,8 @@ func scanAssets(rows *sql.Rows) ([]Asset, error) {
 	var assets []Asset
 
 	for rows.Next() {
-		var asset Asset
-		if err := rows.Scan(&asset.ID, &asset.AssetAddress, &asset.AssetTypeID, &asset.AssetType, &asset.AssetCreator, &asset.TxHash, &asset.Name, &asset.Symbol, &asset.Decimals, &asset.Metadata,
-			&asset.MaxSupply, &asset.MintAdmin, &asset.PauseUnpauseAdmin, &asset.FreezeUnfreezeAdmin, &asset.EnableDisableKYCAccountAdmin, &asset.Timestamp); err != nil {
+		asset, err := scanAsset(rows)
+		if err != nil {
 			return nil, err
 		}
 		assets = append(assets, asset)
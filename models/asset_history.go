@@ -0,0 +1,66 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+type AssetHistory struct {
+	ID             int                    `json:"id"`
+	AssetAddress   string                 `json:"asset_address"`
+	TxHash         string                 `json:"tx_hash"`
+	BlockHeight    int64                  `json:"block_height"`
+	ActionName     string                 `json:"action_name"`
+	ChangedFields  map[string]interface{} `json:"changed_fields"`
+	PreviousValues map[string]interface{} `json:"previous_values"`
+	Timestamp      string                 `json:"timestamp"`
+}
+
+// CountAssetHistory gets total count of recorded changes of an asset
+func CountAssetHistory(db *sql.DB, assetAddress string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM asset_history WHERE asset_address = $1`, assetAddress).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting asset history: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchAssetHistory retrieves a page of the recorded changes of an asset, most recent first,
+// and the cursor of the next page
+func FetchAssetHistory(db *sql.DB, assetAddress string, page Page) ([]AssetHistory, string, error) {
+	return fetchPage(db, blockHeightOrder("asset_history", true), `
+		SELECT id, asset_address, tx_hash, block_height, action_name, changed_fields, previous_values, timestamp
+		FROM asset_history
+		WHERE asset_address = $1`, []interface{}{assetAddress}, page, scanAssetHistory,
+		func(entry AssetHistory) []interface{} { return []interface{}{entry.BlockHeight, entry.ID} })
+}
+
+// scanAssetHistory scans asset history rows
+func scanAssetHistory(rows *sql.Rows) ([]AssetHistory, error) {
+	history := []AssetHistory{}
+	for rows.Next() {
+		var (
+			entry                           AssetHistory
+			changedJSON, previousValuesJSON []byte
+		)
+		if err := rows.Scan(&entry.ID, &entry.AssetAddress, &entry.TxHash, &entry.BlockHeight, &entry.ActionName,
+			&changedJSON, &previousValuesJSON, &entry.Timestamp); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changedJSON, &entry.ChangedFields); err != nil {
+			return nil, errors.New("unable to parse changed fields")
+		}
+		if err := json.Unmarshal(previousValuesJSON, &entry.PreviousValues); err != nil {
+			return nil, errors.New("unable to parse previous values")
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
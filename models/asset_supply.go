@@ -0,0 +1,58 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+type AssetSupplyChange struct {
+	ID            int         `json:"id"`
+	BlockHeight   int64       `json:"block_height"`
+	TxHash        string      `json:"tx_hash"`
+	ActionName    string      `json:"action_name"`
+	Minted        json.Number `json:"minted"`
+	Burned        json.Number `json:"burned"`
+	TotalMinted   json.Number `json:"total_minted"`
+	TotalBurned   json.Number `json:"total_burned"`
+	CurrentSupply json.Number `json:"current_supply"`
+	Timestamp     string      `json:"timestamp"`
+}
+
+// CountAssetSupplyChanges gets total count of supply changes of an asset
+func CountAssetSupplyChanges(db *sql.DB, assetAddress string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM asset_supply_changes WHERE asset_address = $1`, assetAddress).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting asset supply changes: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchAssetSupplyChanges retrieves a page of the supply changes of an asset in chain order
+// and the cursor of the next page
+func FetchAssetSupplyChanges(db *sql.DB, assetAddress string, page Page) ([]AssetSupplyChange, string, error) {
+	return fetchPage(db, blockHeightOrder("asset_supply_changes", false), `
+		SELECT id, block_height, tx_hash, action_name, minted, burned, total_minted, total_burned, current_supply, timestamp
+		FROM asset_supply_changes
+		WHERE asset_address = $1`, []interface{}{assetAddress}, page, scanAssetSupplyChanges,
+		func(change AssetSupplyChange) []interface{} { return []interface{}{change.BlockHeight, change.ID} })
+}
+
+// scanAssetSupplyChanges scans asset supply change rows
+func scanAssetSupplyChanges(rows *sql.Rows) ([]AssetSupplyChange, error) {
+	changes := []AssetSupplyChange{}
+	for rows.Next() {
+		var change AssetSupplyChange
+		if err := rows.Scan(&change.ID, &change.BlockHeight, &change.TxHash, &change.ActionName, &change.Minted, &change.Burned,
+			&change.TotalMinted, &change.TotalBurned, &change.CurrentSupply, &change.Timestamp); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
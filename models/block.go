@@ -7,6 +7,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+
+	"github.com/lib/pq"
 )
 
 type Block struct {
@@ -22,16 +24,13 @@ type Block struct {
 	Timestamp          string  `json:"Timestamp"`
 }
 
-// FetchAllBlocks retrieves blocks from the database with pagination
-func FetchAllBlocks(db *sql.DB, limit, offset string) ([]Block, error) {
-	rows, err := db.Query(`SELECT * FROM blocks ORDER BY block_height DESC LIMIT $1 OFFSET $2`, limit, offset)
-	if err != nil {
-		log.Printf("Database query error: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
+// blockOrder sorts blocks from the highest
+var blockOrder = keyset{{expr: "block_height", cast: "bigint", desc: true}}
 
-	return scanBlocks(rows)
+// FetchAllBlocks retrieves a page of blocks, highest first, and the cursor of the next page
+func FetchAllBlocks(db *sql.DB, page Page) ([]Block, string, error) {
+	return fetchPage(db, blockOrder, `SELECT * FROM blocks WHERE TRUE`, nil, page, scanBlocks,
+		func(block Block) []interface{} { return []interface{}{block.BlockHeight} })
 }
 
 // FetchBlock retrieves a block by its height or hash.
@@ -59,6 +58,18 @@ func FetchBlock(db *sql.DB, height, hash string) (Block, error) {
 	return block, err
 }
 
+// FetchBlocksByHashes retrieves the blocks with the given hashes
+func FetchBlocksByHashes(db *sql.DB, hashes []string) ([]Block, error) {
+	rows, err := db.Query(`SELECT * FROM blocks WHERE block_hash = ANY($1)`, pq.Array(hashes))
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanBlocks(rows)
+}
+
 // Helper function to scan block rows
 func scanBlocks(rows *sql.Rows) ([]Block, error) {
 	var blocks []Block
@@ -0,0 +1,188 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Contract types
+const (
+	ContractTypeProgram  = "program"
+	ContractTypeInstance = "instance"
+)
+
+type Contract struct {
+	ID              int     `json:"id"`
+	ContractType    string  `json:"contract_type"`
+	ContractAddress *string `json:"contract_address"`
+	ProgramID       string  `json:"program_id"`
+	BytecodeHash    *string `json:"bytecode_hash"`
+	Size            *int    `json:"size"`
+	Creator         string  `json:"creator"`
+	Instances       int     `json:"instances"`
+	Calls           int     `json:"calls"`
+	TxHash          string  `json:"tx_hash"`
+	BlockHeight     int64   `json:"block_height"`
+	Timestamp       string  `json:"timestamp"`
+}
+
+type ContractCall struct {
+	ID              int         `json:"id"`
+	ContractAddress string      `json:"contract_address"`
+	Caller          string      `json:"caller"`
+	FunctionName    string      `json:"function_name"`
+	Value           json.Number `json:"value"`
+	FuelLimit       int64       `json:"fuel_limit"`
+	FuelConsumed    int64       `json:"fuel_consumed"`
+	Result          string      `json:"result"`
+	TxHash          string      `json:"tx_hash"`
+	BlockHeight     int64       `json:"block_height"`
+	Timestamp       string      `json:"timestamp"`
+}
+
+// contractColumns lists the contract columns in the order expected by scanContract. Instances
+// report the bytecode of the program they were deployed from, programs count the instances
+// deployed from them and instances the calls made to them.
+const contractColumns = `contracts.id, contracts.contract_type, contracts.contract_address, contracts.program_id,
+	COALESCE(contracts.bytecode_hash, programs.bytecode_hash), COALESCE(contracts.size, programs.size),
+	contracts.creator,
+	CASE WHEN contracts.contract_type = 'program' THEN
+		(SELECT COUNT(*) FROM contracts AS instances
+		 WHERE instances.contract_type = 'instance' AND instances.program_id = contracts.program_id)
+	ELSE 0 END,
+	CASE WHEN contracts.contract_type = 'instance' THEN
+		(SELECT COUNT(*) FROM contract_calls WHERE contract_calls.contract_address = contracts.contract_address)
+	ELSE 0 END,
+	contracts.tx_hash, contracts.block_height, contracts.timestamp`
+
+// contractsFrom joins every instance to the program it was deployed from
+const contractsFrom = `
+	FROM contracts
+	LEFT JOIN contracts AS programs
+	       ON contracts.contract_type = 'instance'
+	      AND programs.contract_type = 'program'
+	      AND programs.program_id = contracts.program_id`
+
+// IsContractType reports whether contractType is a known contract type
+func IsContractType(contractType string) bool {
+	return contractType == ContractTypeProgram || contractType == ContractTypeInstance
+}
+
+// CountFilteredContracts counts contracts based on optional filters
+func CountFilteredContracts(db *sql.DB, contractType, creator, programID string) (int, error) {
+	query, args := buildContractFilterQuery("COUNT(*)", contractType, creator, programID)
+	var count int
+	err := db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// FetchFilteredContracts retrieves a page of contracts based on optional filters, newest first,
+// and the cursor of the next page
+func FetchFilteredContracts(db *sql.DB, contractType, creator, programID string, page Page) ([]Contract, string, error) {
+	query, args := buildContractFilterQuery(contractColumns, contractType, creator, programID)
+	return fetchPage(db, blockHeightOrder("contracts", true), query, args, page, scanContracts,
+		func(contract Contract) []interface{} { return []interface{}{contract.BlockHeight, contract.ID} })
+}
+
+// Helper function to construct filter queries for contracts
+func buildContractFilterQuery(selectFields, contractType, creator, programID string) (string, []interface{}) {
+	query := fmt.Sprintf("SELECT %s %s WHERE 1=1", selectFields, contractsFrom)
+	args := []interface{}{}
+	argCounter := 1
+
+	if contractType != "" {
+		query += fmt.Sprintf(" AND contracts.contract_type = $%d", argCounter)
+		args = append(args, contractType)
+		argCounter++
+	}
+
+	if creator != "" {
+		query += fmt.Sprintf(" AND contracts.creator = $%d", argCounter)
+		args = append(args, creator)
+		argCounter++
+	}
+
+	if programID != "" {
+		query += fmt.Sprintf(" AND contracts.program_id = $%d", argCounter)
+		args = append(args, programID)
+		argCounter++
+	}
+
+	return query, args
+}
+
+// FetchContract retrieves a deployed contract by its address, or a published program by its ID
+func FetchContract(db *sql.DB, address string) (Contract, error) {
+	row := db.QueryRow(`SELECT `+contractColumns+contractsFrom+`
+		WHERE contracts.contract_address = $1
+		   OR (contracts.contract_type = 'program' AND contracts.program_id = $1)
+		ORDER BY contracts.contract_type = 'instance' DESC
+		LIMIT 1`, address)
+	return scanContract(row)
+}
+
+// CountContractCalls gets total count of calls made to a contract, optionally to a given function
+func CountContractCalls(db *sql.DB, contractAddress, functionName string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM contract_calls
+		WHERE contract_address = $1 AND ($2 = '' OR function_name = $2)`, contractAddress, functionName).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting contract calls: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchContractCalls retrieves a page of the calls made to a contract, newest first, and the
+// cursor of the next page
+func FetchContractCalls(db *sql.DB, contractAddress, functionName string, page Page) ([]ContractCall, string, error) {
+	return fetchPage(db, blockHeightOrder("contract_calls", true), `
+		SELECT id, contract_address, caller, function_name, value, fuel_limit, fuel_consumed, result,
+		       tx_hash, block_height, timestamp
+		FROM contract_calls
+		WHERE contract_address = $1 AND ($2 = '' OR function_name = $2)`,
+		[]interface{}{contractAddress, functionName}, page, scanContractCalls,
+		func(call ContractCall) []interface{} { return []interface{}{call.BlockHeight, call.ID} })
+}
+
+// scanContractCalls scans contract call rows
+func scanContractCalls(rows *sql.Rows) ([]ContractCall, error) {
+	calls := []ContractCall{}
+	for rows.Next() {
+		var call ContractCall
+		if err := rows.Scan(&call.ID, &call.ContractAddress, &call.Caller, &call.FunctionName, &call.Value, &call.FuelLimit,
+			&call.FuelConsumed, &call.Result, &call.TxHash, &call.BlockHeight, &call.Timestamp); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
+}
+
+// scanContract scans a single row selected with contractColumns
+func scanContract(row interface{ Scan(...interface{}) error }) (Contract, error) {
+	var contract Contract
+	err := row.Scan(&contract.ID, &contract.ContractType, &contract.ContractAddress, &contract.ProgramID, &contract.BytecodeHash,
+		&contract.Size, &contract.Creator, &contract.Instances, &contract.Calls, &contract.TxHash,
+		&contract.BlockHeight, &contract.Timestamp)
+	return contract, err
+}
+
+// scanContracts scans contract rows selected with contractColumns
+func scanContracts(rows *sql.Rows) ([]Contract, error) {
+	contracts := []Contract{}
+	for rows.Next() {
+		contract, err := scanContract(rows)
+		if err != nil {
+			return nil, err
+		}
+		contracts = append(contracts, contract)
+	}
+	return contracts, rows.Err()
+}
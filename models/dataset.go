@@ -0,0 +1,207 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+type Dataset struct {
+	ID                      int     `json:"id"`
+	DatasetAddress          string  `json:"dataset_address"`
+	AssetAddress            string  `json:"asset_address"`
+	ParentNFTAddress        string  `json:"parent_nft_address"`
+	Owner                   string  `json:"owner"`
+	Name                    string  `json:"name"`
+	Description             string  `json:"description"`
+	Categories              string  `json:"categories"`
+	LicenseName             string  `json:"license_name"`
+	LicenseSymbol           string  `json:"license_symbol"`
+	LicenseURL              string  `json:"license_url"`
+	Metadata                string  `json:"metadata"`
+	IsCommunityDataset      bool    `json:"is_community_dataset"`
+	MarketplaceAssetAddress *string `json:"marketplace_asset_address"`
+	Revisions               int     `json:"revisions"`
+	TxHash                  string  `json:"tx_hash"`
+	BlockHeight             int64   `json:"block_height"`
+	Timestamp               string  `json:"timestamp"`
+}
+
+type DatasetHistory struct {
+	ID             int                    `json:"id"`
+	DatasetAddress string                 `json:"dataset_address"`
+	TxHash         string                 `json:"tx_hash"`
+	BlockHeight    int64                  `json:"block_height"`
+	ActionName     string                 `json:"action_name"`
+	ChangedFields  map[string]interface{} `json:"changed_fields"`
+	PreviousValues map[string]interface{} `json:"previous_values"`
+	Timestamp      string                 `json:"timestamp"`
+}
+
+// datasetColumns lists the dataset columns in the order expected by scanDataset. The
+// revisions do not count the creation of the dataset.
+const datasetColumns = `id, dataset_address, asset_address, COALESCE(parent_nft_address, ''), owner, name,
+	COALESCE(description, ''), COALESCE(categories, ''), COALESCE(license_name, ''), COALESCE(license_symbol, ''),
+	COALESCE(license_url, ''), COALESCE(metadata, ''), is_community_dataset, marketplace_asset_address,
+	(SELECT COUNT(*) FROM dataset_history
+	 WHERE dataset_history.dataset_address = datasets.dataset_address AND dataset_history.action_name <> 'CreateDataset'),
+	tx_hash, block_height, timestamp`
+
+// CountFilteredDatasets counts datasets based on optional filters
+func CountFilteredDatasets(db *sql.DB, owner, datasetAddress, name, category, community string) (int, error) {
+	query, args := buildDatasetFilterQuery("COUNT(*)", owner, datasetAddress, name, category, community)
+	var count int
+	err := db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// FetchFilteredDatasets retrieves a page of datasets based on optional filters, newest first,
+// and the cursor of the next page
+func FetchFilteredDatasets(db *sql.DB, owner, datasetAddress, name, category, community string, page Page) ([]Dataset, string, error) {
+	query, args := buildDatasetFilterQuery(datasetColumns, owner, datasetAddress, name, category, community)
+	return fetchPage(db, blockHeightOrder("datasets", true), query, args, page, scanDatasets, datasetKey)
+}
+
+// Helper function to construct filter queries for datasets
+func buildDatasetFilterQuery(selectFields, owner, datasetAddress, name, category, community string) (string, []interface{}) {
+	query := fmt.Sprintf("SELECT %s FROM datasets WHERE 1=1", selectFields)
+	args := []interface{}{}
+	argCounter := 1
+
+	if owner != "" {
+		query += fmt.Sprintf(" AND owner ILIKE $%d", argCounter)
+		args = append(args, "%"+owner+"%")
+		argCounter++
+	}
+
+	if datasetAddress != "" {
+		query += fmt.Sprintf(" AND dataset_address ILIKE $%d", argCounter)
+		args = append(args, "%"+datasetAddress+"%")
+		argCounter++
+	}
+
+	if name != "" {
+		query += fmt.Sprintf(" AND name ILIKE $%d", argCounter)
+		args = append(args, "%"+name+"%")
+		argCounter++
+	}
+
+	if category != "" {
+		query += fmt.Sprintf(" AND categories ILIKE $%d", argCounter)
+		args = append(args, "%"+category+"%")
+		argCounter++
+	}
+
+	if community != "" {
+		query += fmt.Sprintf(" AND is_community_dataset = $%d", argCounter)
+		args = append(args, community)
+		argCounter++
+	}
+
+	return query, args
+}
+
+// CountDatasetsByOwner gets total count of datasets owned by an address
+func CountDatasetsByOwner(db *sql.DB, owner string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM datasets WHERE owner = $1`, owner).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting owned datasets: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchDatasetsByOwner retrieves a page of the datasets owned by an address, newest first,
+// and the cursor of the next page
+func FetchDatasetsByOwner(db *sql.DB, owner string, page Page) ([]Dataset, string, error) {
+	return fetchPage(db, blockHeightOrder("datasets", true), `
+		SELECT `+datasetColumns+`
+		FROM datasets
+		WHERE owner = $1`, []interface{}{owner}, page, scanDatasets, datasetKey)
+}
+
+// FetchDatasetByAddress retrieves a specific dataset by its address
+func FetchDatasetByAddress(db *sql.DB, datasetAddress string) (Dataset, error) {
+	row := db.QueryRow(`SELECT `+datasetColumns+` FROM datasets WHERE dataset_address = $1`, datasetAddress)
+	return scanDataset(row)
+}
+
+// CountDatasetHistory gets total count of recorded revisions of a dataset
+func CountDatasetHistory(db *sql.DB, datasetAddress string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM dataset_history WHERE dataset_address = $1`, datasetAddress).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting dataset history: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchDatasetHistory retrieves a page of the recorded revisions of a dataset, most recent
+// first, and the cursor of the next page
+func FetchDatasetHistory(db *sql.DB, datasetAddress string, page Page) ([]DatasetHistory, string, error) {
+	return fetchPage(db, blockHeightOrder("dataset_history", true), `
+		SELECT id, dataset_address, tx_hash, block_height, action_name, changed_fields, previous_values, timestamp
+		FROM dataset_history
+		WHERE dataset_address = $1`, []interface{}{datasetAddress}, page, scanDatasetHistory,
+		func(entry DatasetHistory) []interface{} { return []interface{}{entry.BlockHeight, entry.ID} })
+}
+
+// scanDatasetHistory scans dataset history rows
+func scanDatasetHistory(rows *sql.Rows) ([]DatasetHistory, error) {
+	history := []DatasetHistory{}
+	for rows.Next() {
+		var (
+			entry                           DatasetHistory
+			changedJSON, previousValuesJSON []byte
+		)
+		if err := rows.Scan(&entry.ID, &entry.DatasetAddress, &entry.TxHash, &entry.BlockHeight, &entry.ActionName,
+			&changedJSON, &previousValuesJSON, &entry.Timestamp); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changedJSON, &entry.ChangedFields); err != nil {
+			return nil, errors.New("unable to parse changed fields")
+		}
+		if err := json.Unmarshal(previousValuesJSON, &entry.PreviousValues); err != nil {
+			return nil, errors.New("unable to parse previous values")
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// scanDataset scans a single row selected with datasetColumns
+func scanDataset(row interface{ Scan(...interface{}) error }) (Dataset, error) {
+	var dataset Dataset
+	err := row.Scan(
+		&dataset.ID, &dataset.DatasetAddress, &dataset.AssetAddress, &dataset.ParentNFTAddress, &dataset.Owner, &dataset.Name,
+		&dataset.Description, &dataset.Categories, &dataset.LicenseName, &dataset.LicenseSymbol,
+		&dataset.LicenseURL, &dataset.Metadata, &dataset.IsCommunityDataset, &dataset.MarketplaceAssetAddress,
+		&dataset.Revisions, &dataset.TxHash, &dataset.BlockHeight, &dataset.Timestamp,
+	)
+	return dataset, err
+}
+
+// Helper function to scan dataset rows
+func scanDatasets(rows *sql.Rows) ([]Dataset, error) {
+	datasets := []Dataset{}
+	for rows.Next() {
+		dataset, err := scanDataset(rows)
+		if err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, dataset)
+	}
+	return datasets, rows.Err()
+}
+
+// datasetKey returns the sort key of a dataset
+func datasetKey(dataset Dataset) []interface{} {
+	return []interface{}{dataset.BlockHeight, dataset.ID}
+}
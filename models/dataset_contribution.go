@@ -0,0 +1,136 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Dataset contribution statuses
+const (
+	ContributionStatusPending  = "pending"
+	ContributionStatusAccepted = "accepted"
+	ContributionStatusExpired  = "expired"
+)
+
+type DatasetContribution struct {
+	ID                     int          `json:"id"`
+	ContributionID         string       `json:"contribution_id"`
+	DatasetAddress         string       `json:"dataset_address"`
+	Contributor            string       `json:"contributor"`
+	DataLocation           string       `json:"data_location"`
+	DataIdentifier         string       `json:"data_identifier"`
+	CollateralAssetAddress string       `json:"collateral_asset_address"`
+	CollateralAmount       json.Number  `json:"collateral_amount"`
+	Status                 string       `json:"status"`
+	TxHash                 string       `json:"tx_hash"`
+	BlockHeight            int64        `json:"block_height"`
+	Timestamp              string       `json:"timestamp"`
+	ContributionNFTAddress *string      `json:"contribution_nft_address"`
+	CollateralRefunded     *json.Number `json:"collateral_refunded"`
+	CompletionTxHash       *string      `json:"completion_tx_hash"`
+	CompletionBlockHeight  *int64       `json:"completion_block_height"`
+	CompletedAt            *string      `json:"completed_at"`
+}
+
+// contributionsWithStatus selects a DatasetContribution from dataset_contributions. A pending
+// contribution is reported as expired once its dataset is published to the marketplace,
+// since contributions can no longer be completed from then on.
+const contributionsWithStatus = `
+	SELECT * FROM (
+		SELECT dataset_contributions.contribution_id, dataset_contributions.dataset_address,
+		       dataset_contributions.contributor, dataset_contributions.data_location, dataset_contributions.data_identifier,
+		       COALESCE(dataset_contributions.collateral_asset_address, ''), dataset_contributions.collateral_amount,
+		       CASE
+		           WHEN dataset_contributions.status = 'pending' AND EXISTS (
+		               SELECT 1 FROM datasets
+		               WHERE datasets.dataset_address = dataset_contributions.dataset_address
+		                 AND datasets.marketplace_asset_address IS NOT NULL
+		           ) THEN 'expired'
+		           ELSE dataset_contributions.status
+		       END,
+		       dataset_contributions.tx_hash, dataset_contributions.block_height, dataset_contributions.timestamp,
+		       dataset_contributions.contribution_nft_address, dataset_contributions.collateral_refunded,
+		       dataset_contributions.completion_tx_hash, dataset_contributions.completion_block_height,
+		       dataset_contributions.completed_at, dataset_contributions.id
+		FROM dataset_contributions
+	) AS contributions (
+		contribution_id, dataset_address, contributor, data_location, data_identifier, collateral_asset_address,
+		collateral_amount, status, tx_hash, block_height, timestamp, contribution_nft_address, collateral_refunded,
+		completion_tx_hash, completion_block_height, completed_at, id
+	)`
+
+// IsContributionStatus reports whether status is a known dataset contribution status
+func IsContributionStatus(status string) bool {
+	switch status {
+	case ContributionStatusPending, ContributionStatusAccepted, ContributionStatusExpired:
+		return true
+	}
+	return false
+}
+
+// CountDatasetContributions gets total count of contributions to a dataset, optionally with a given status
+func CountDatasetContributions(db *sql.DB, datasetAddress, status string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+contributionsWithStatus+`
+		WHERE dataset_address = $1 AND ($2 = '' OR status = $2)) AS filtered`, datasetAddress, status).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting dataset contributions: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchDatasetContributions retrieves a page of the contributions to a dataset, newest first,
+// and the cursor of the next page
+func FetchDatasetContributions(db *sql.DB, datasetAddress, status string, page Page) ([]DatasetContribution, string, error) {
+	return fetchPage(db, blockHeightOrder("contributions", true), contributionsWithStatus+`
+		WHERE dataset_address = $1 AND ($2 = '' OR status = $2)`,
+		[]interface{}{datasetAddress, status}, page, scanDatasetContributions, contributionKey)
+}
+
+// CountAccountContributions gets total count of contributions of an address, optionally with a given status
+func CountAccountContributions(db *sql.DB, address, status string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+contributionsWithStatus+`
+		WHERE contributor = $1 AND ($2 = '' OR status = $2)) AS filtered`,
+		strings.TrimPrefix(address, "0x"), status).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting account contributions: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchAccountContributions retrieves a page of the contributions of an address, newest
+// first, and the cursor of the next page
+func FetchAccountContributions(db *sql.DB, address, status string, page Page) ([]DatasetContribution, string, error) {
+	return fetchPage(db, blockHeightOrder("contributions", true), contributionsWithStatus+`
+		WHERE contributor = $1 AND ($2 = '' OR status = $2)`,
+		[]interface{}{strings.TrimPrefix(address, "0x"), status}, page, scanDatasetContributions, contributionKey)
+}
+
+func scanDatasetContributions(rows *sql.Rows) ([]DatasetContribution, error) {
+	contributions := []DatasetContribution{}
+	for rows.Next() {
+		var contribution DatasetContribution
+		if err := rows.Scan(&contribution.ContributionID, &contribution.DatasetAddress, &contribution.Contributor,
+			&contribution.DataLocation, &contribution.DataIdentifier, &contribution.CollateralAssetAddress,
+			&contribution.CollateralAmount, &contribution.Status, &contribution.TxHash, &contribution.BlockHeight,
+			&contribution.Timestamp, &contribution.ContributionNFTAddress, &contribution.CollateralRefunded,
+			&contribution.CompletionTxHash, &contribution.CompletionBlockHeight, &contribution.CompletedAt, &contribution.ID); err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, contribution)
+	}
+	return contributions, rows.Err()
+}
+
+// contributionKey returns the sort key of a contribution
+func contributionKey(contribution DatasetContribution) []interface{} {
+	return []interface{}{contribution.BlockHeight, contribution.ID}
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Delegation statuses
+const (
+	DelegationStatusPending     = "pending"
+	DelegationStatusActive      = "active"
+	DelegationStatusUndelegated = "undelegated"
+)
+
+type Delegation struct {
+	ID                      int         `json:"id"`
+	Delegator               string      `json:"delegator"`
+	NodeID                  string      `json:"node_id"`
+	StakedAmount            int64       `json:"staked_amount"`
+	StakeStartBlock         int64       `json:"stake_start_block"`
+	StakeEndBlock           int64       `json:"stake_end_block"`
+	Status                  string      `json:"status"`
+	RewardsClaimed          json.Number `json:"rewards_claimed"`
+	TxHash                  string      `json:"tx_hash"`
+	BlockHeight             int64       `json:"block_height"`
+	Timestamp               string      `json:"timestamp"`
+	UndelegationTxHash      *string     `json:"undelegation_tx_hash"`
+	UndelegationBlockHeight *int64      `json:"undelegation_block_height"`
+	UndelegatedAt           *string     `json:"undelegated_at"`
+}
+
+// delegationsWithStatus selects a Delegation from delegations. An active delegation is
+// reported as pending until the indexed chain reaches its start block.
+const delegationsWithStatus = `
+	SELECT * FROM (
+		SELECT delegations.delegator, delegations.node_id, delegations.staked_amount,
+		       delegations.stake_start_block, delegations.stake_end_block,
+		       CASE
+		           WHEN delegations.status = 'active'
+		            AND (SELECT COALESCE(MAX(block_height), 0) FROM blocks) < delegations.stake_start_block THEN 'pending'
+		           ELSE delegations.status
+		       END,
+		       (SELECT COALESCE(SUM(reward_amount), 0) FROM delegation_rewards
+		        WHERE delegation_rewards.delegator = delegations.delegator
+		          AND delegation_rewards.node_id = delegations.node_id
+		          AND delegation_rewards.stake_start_block = delegations.stake_start_block),
+		       delegations.tx_hash, delegations.block_height, delegations.timestamp,
+		       delegations.undelegation_tx_hash, delegations.undelegation_block_height, delegations.undelegated_at,
+		       delegations.id
+		FROM delegations
+	) AS delegations (
+		delegator, node_id, staked_amount, stake_start_block, stake_end_block, status, rewards_claimed,
+		tx_hash, block_height, timestamp, undelegation_tx_hash, undelegation_block_height, undelegated_at, id
+	)`
+
+// IsDelegationStatus reports whether status is a known delegation status
+func IsDelegationStatus(status string) bool {
+	switch status {
+	case DelegationStatusPending, DelegationStatusActive, DelegationStatusUndelegated:
+		return true
+	}
+	return false
+}
+
+// CountValidatorDelegations gets total count of delegations to a node, optionally with a given status
+func CountValidatorDelegations(db *sql.DB, nodeID, status string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+delegationsWithStatus+`
+		WHERE node_id = $1 AND ($2 = '' OR status = $2)) AS filtered`, nodeID, status).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting validator delegations: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchValidatorDelegations retrieves a page of the delegations to a node, newest first,
+// and the cursor of the next page
+func FetchValidatorDelegations(db *sql.DB, nodeID, status string, page Page) ([]Delegation, string, error) {
+	return fetchPage(db, blockHeightOrder("delegations", true), delegationsWithStatus+`
+		WHERE node_id = $1 AND ($2 = '' OR status = $2)`,
+		[]interface{}{nodeID, status}, page, scanDelegations, delegationKey)
+}
+
+// CountAccountDelegations gets total count of delegations of an address, optionally with a given status
+func CountAccountDelegations(db *sql.DB, address, status string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+delegationsWithStatus+`
+		WHERE delegator = $1 AND ($2 = '' OR status = $2)) AS filtered`,
+		strings.TrimPrefix(address, "0x"), status).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting account delegations: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchAccountDelegations retrieves a page of the delegations of an address, newest first,
+// and the cursor of the next page
+func FetchAccountDelegations(db *sql.DB, address, status string, page Page) ([]Delegation, string, error) {
+	return fetchPage(db, blockHeightOrder("delegations", true), delegationsWithStatus+`
+		WHERE delegator = $1 AND ($2 = '' OR status = $2)`,
+		[]interface{}{strings.TrimPrefix(address, "0x"), status}, page, scanDelegations, delegationKey)
+}
+
+func scanDelegations(rows *sql.Rows) ([]Delegation, error) {
+	delegations := []Delegation{}
+	for rows.Next() {
+		var delegation Delegation
+		if err := rows.Scan(&delegation.Delegator, &delegation.NodeID, &delegation.StakedAmount,
+			&delegation.StakeStartBlock, &delegation.StakeEndBlock, &delegation.Status, &delegation.RewardsClaimed,
+			&delegation.TxHash, &delegation.BlockHeight, &delegation.Timestamp,
+			&delegation.UndelegationTxHash, &delegation.UndelegationBlockHeight, &delegation.UndelegatedAt, &delegation.ID); err != nil {
+			return nil, err
+		}
+		delegations = append(delegations, delegation)
+	}
+	return delegations, rows.Err()
+}
+
+// delegationKey returns the sort key of a delegation
+func delegationKey(delegation Delegation) []interface{} {
+	return []interface{}{delegation.BlockHeight, delegation.ID}
+}
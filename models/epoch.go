@@ -0,0 +1,40 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"log"
+)
+
+type NetworkEpoch struct {
+	ID            int     `json:"id"`
+	GenesisHash   string  `json:"genesis_hash"`
+	ArchiveSchema *string `json:"archive_schema"`
+	StartedAt     string  `json:"started_at"`
+	ArchivedAt    *string `json:"archived_at"`
+}
+
+// FetchNetworkEpochs retrieves every network epoch, most recent first
+func FetchNetworkEpochs(db *sql.DB) ([]NetworkEpoch, error) {
+	rows, err := db.Query(`
+		SELECT id, genesis_hash, archive_schema, started_at, archived_at
+		FROM network_epochs
+		ORDER BY id DESC`)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	epochs := []NetworkEpoch{}
+	for rows.Next() {
+		var epoch NetworkEpoch
+		if err := rows.Scan(&epoch.ID, &epoch.GenesisHash, &epoch.ArchiveSchema, &epoch.StartedAt, &epoch.ArchivedAt); err != nil {
+			return nil, err
+		}
+		epochs = append(epochs, epoch)
+	}
+	return epochs, rows.Err()
+}
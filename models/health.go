@@ -52,9 +52,28 @@ type HealthStatus struct {
 	Details         map[string]bool           `json:"details"`
 	ServiceStatuse  map[string]*ServiceStatus `json:"service_statuse"`
 	BlockchainStats *BlockchainStats          `json:"blockchain_stats"`
+	SyncStatus      *SyncStatus               `json:"sync_status"`
+	ParserStatus    *ParserStatus             `json:"parser_status"`
+	IngestQueue     *IngestQueueStatus        `json:"ingest_queue"`
 	CurrentIncident *HealthEvent              `json:"current_incident"`
 }
 
+type ParserStatus struct {
+	Loaded      bool       `json:"loaded"`
+	GenesisHash string     `json:"genesis_hash"`
+	Source      string     `json:"source"`
+	LoadedAt    *time.Time `json:"loaded_at"`
+}
+
+type IngestQueueStatus struct {
+	Depth            int        `json:"depth"`
+	LagSeconds       float64    `json:"lag_seconds"`
+	OldestEnqueuedAt *time.Time `json:"oldest_enqueued_at"`
+	LastIngestedAt   *time.Time `json:"last_ingested_at"`
+	LastError        string     `json:"last_error,omitempty"`
+	DeadLettered     int        `json:"dead_lettered"`
+}
+
 type DailyHealthSummary struct {
 	Date      time.Time   `json:"date"`
 	State     HealthState `json:"state"`
@@ -0,0 +1,362 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+)
+
+// Marketplace subscription statuses
+const (
+	SubscriptionStatusActive  = "active"
+	SubscriptionStatusExpired = "expired"
+)
+
+// nativeAssetDecimals is the number of decimals of the native NAI asset, which is not
+// created by a CreateAsset action and therefore missing from the assets table
+const nativeAssetDecimals = "9"
+
+type MarketplaceListing struct {
+	ID                      int         `json:"id"`
+	DatasetAddress          string      `json:"dataset_address"`
+	MarketplaceAssetAddress string      `json:"marketplace_asset_address"`
+	PaymentAssetAddress     string      `json:"payment_asset_address"`
+	PricePerBlock           json.Number `json:"price_per_block"`
+	Publisher               string      `json:"publisher"`
+	Subscriptions           int         `json:"subscriptions"`
+	ActiveSubscriptions     int         `json:"active_subscriptions"`
+	Revenue                 json.Number `json:"revenue"`
+	PaymentClaimed          json.Number `json:"payment_claimed"`
+	PaymentRemaining        json.Number `json:"payment_remaining"`
+	AccruedUnclaimed        json.Number `json:"accrued_unclaimed"`
+	LastClaimedBlock        *int64      `json:"last_claimed_block"`
+	TxHash                  string      `json:"tx_hash"`
+	BlockHeight             int64       `json:"block_height"`
+	Timestamp               string      `json:"timestamp"`
+}
+
+type MarketplaceSubscription struct {
+	ID                      int         `json:"id"`
+	DatasetAddress          *string     `json:"dataset_address"`
+	MarketplaceAssetAddress string      `json:"marketplace_asset_address"`
+	Subscriber              string      `json:"subscriber"`
+	SubscriptionNFTAddress  string      `json:"subscription_nft_address"`
+	PaymentAssetAddress     string      `json:"payment_asset_address"`
+	PricePerBlock           json.Number `json:"price_per_block"`
+	TotalCost               json.Number `json:"total_cost"`
+	NumBlocks               int64       `json:"num_blocks"`
+	IssuanceBlock           int64       `json:"issuance_block"`
+	ExpirationBlock         int64       `json:"expiration_block"`
+	Status                  string      `json:"status"`
+	TxHash                  string      `json:"tx_hash"`
+	BlockHeight             int64       `json:"block_height"`
+	Timestamp               string      `json:"timestamp"`
+}
+
+type MarketplacePayment struct {
+	ID                      int         `json:"id"`
+	MarketplaceAssetAddress string      `json:"marketplace_asset_address"`
+	PaymentAssetAddress     string      `json:"payment_asset_address"`
+	Amount                  json.Number `json:"amount"`
+	DistributedTo           string      `json:"distributed_to"`
+	PaymentClaimed          json.Number `json:"payment_claimed"`
+	PaymentRemaining        json.Number `json:"payment_remaining"`
+	LastClaimedBlock        int64       `json:"last_claimed_block"`
+	TxHash                  string      `json:"tx_hash"`
+	BlockHeight             int64       `json:"block_height"`
+	Timestamp               string      `json:"timestamp"`
+}
+
+type MarketplaceRevenue struct {
+	PaymentAssetAddress string      `json:"payment_asset_address"`
+	Subscriptions       int         `json:"subscriptions"`
+	Revenue             json.Number `json:"revenue"`
+	PaymentClaimed      json.Number `json:"payment_claimed"`
+}
+
+// listingsWithStats selects a MarketplaceListing from marketplace_listings. The payments
+// escrowed by subscriptions are released to the owner at one unit of the payment asset per
+// block since the last claim, so the accrued payment is computed against the indexed tip.
+const listingsWithStats = `
+	SELECT * FROM (
+		SELECT listings.dataset_address, listings.marketplace_asset_address, listings.payment_asset_address,
+		       listings.price_per_block, listings.publisher,
+		       subscriptions.count, subscriptions.active, subscriptions.revenue, payments.claimed,
+		       subscriptions.revenue - payments.claimed,
+		       COALESCE(LEAST(
+		           subscriptions.revenue - payments.claimed,
+		           GREATEST(tip.height - COALESCE(payments.last_claimed_block, subscriptions.first_issuance_block), 0)
+		               * POWER(10::NUMERIC, COALESCE(assets.decimals, ` + nativeAssetDecimals + `))
+		       ), 0),
+		       COALESCE(payments.last_claimed_block, subscriptions.first_issuance_block),
+		       listings.tx_hash, listings.block_height, listings.timestamp, listings.id
+		FROM marketplace_listings AS listings
+		CROSS JOIN (SELECT COALESCE(MAX(block_height), 0) AS height FROM blocks) AS tip
+		LEFT JOIN assets ON assets.asset_address = listings.payment_asset_address
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS count,
+			       COUNT(*) FILTER (WHERE marketplace_subscriptions.expiration_block > tip.height) AS active,
+			       COALESCE(SUM(marketplace_subscriptions.total_cost), 0) AS revenue,
+			       MIN(marketplace_subscriptions.issuance_block) AS first_issuance_block
+			FROM marketplace_subscriptions
+			WHERE marketplace_subscriptions.marketplace_asset_address = listings.marketplace_asset_address
+		) AS subscriptions
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(SUM(marketplace_payments.amount), 0) AS claimed,
+			       MAX(marketplace_payments.last_claimed_block) AS last_claimed_block
+			FROM marketplace_payments
+			WHERE marketplace_payments.marketplace_asset_address = listings.marketplace_asset_address
+		) AS payments
+	) AS listings (
+		dataset_address, marketplace_asset_address, payment_asset_address, price_per_block, publisher,
+		subscriptions, active_subscriptions, revenue, payment_claimed, payment_remaining, accrued_unclaimed,
+		last_claimed_block, tx_hash, block_height, timestamp, id
+	)`
+
+// subscriptionsWithStatus selects a MarketplaceSubscription from marketplace_subscriptions.
+// A subscription is active until the indexed chain reaches its expiration block.
+const subscriptionsWithStatus = `
+	SELECT * FROM (
+		SELECT marketplace_listings.dataset_address, subscriptions.marketplace_asset_address, subscriptions.subscriber,
+		       subscriptions.subscription_nft_address, subscriptions.payment_asset_address, subscriptions.price_per_block,
+		       subscriptions.total_cost, subscriptions.num_blocks, subscriptions.issuance_block, subscriptions.expiration_block,
+		       CASE
+		           WHEN (SELECT COALESCE(MAX(block_height), 0) FROM blocks) < subscriptions.expiration_block THEN 'active'
+		           ELSE 'expired'
+		       END,
+		       subscriptions.tx_hash, subscriptions.block_height, subscriptions.timestamp, subscriptions.id
+		FROM marketplace_subscriptions AS subscriptions
+		LEFT JOIN marketplace_listings
+		       ON marketplace_listings.marketplace_asset_address = subscriptions.marketplace_asset_address
+	) AS subscriptions (
+		dataset_address, marketplace_asset_address, subscriber, subscription_nft_address, payment_asset_address,
+		price_per_block, total_cost, num_blocks, issuance_block, expiration_block, status, tx_hash, block_height,
+		timestamp, id
+	)`
+
+// IsSubscriptionStatus reports whether status is a known marketplace subscription status
+func IsSubscriptionStatus(status string) bool {
+	switch status {
+	case SubscriptionStatusActive, SubscriptionStatusExpired:
+		return true
+	}
+	return false
+}
+
+// CountMarketplaceListings gets total count of listings, optionally of a given publisher
+func CountMarketplaceListings(db *sql.DB, publisher string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM marketplace_listings WHERE $1 = '' OR publisher = $1`, publisher).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting marketplace listings: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchMarketplaceListings retrieves a page of the listings with their statistics, newest
+// first, optionally of a given publisher, and the cursor of the next page
+func FetchMarketplaceListings(db *sql.DB, publisher string, page Page) ([]MarketplaceListing, string, error) {
+	return fetchPage(db, blockHeightOrder("listings", true), listingsWithStats+`
+		WHERE ($1 = '' OR publisher = $1)`, []interface{}{publisher}, page, scanMarketplaceListings,
+		func(listing MarketplaceListing) []interface{} { return []interface{}{listing.BlockHeight, listing.ID} })
+}
+
+// FetchMarketplaceListing retrieves the listing of a dataset with its statistics
+func FetchMarketplaceListing(db *sql.DB, datasetAddress string) (MarketplaceListing, error) {
+	row := db.QueryRow(listingsWithStats+` WHERE dataset_address = $1`, datasetAddress)
+	return scanMarketplaceListing(row)
+}
+
+// CountUnclaimedListings gets total count of listings with payments left to claim, optionally of a given publisher
+func CountUnclaimedListings(db *sql.DB, publisher string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+listingsWithStats+`
+		WHERE payment_remaining > 0 AND ($1 = '' OR publisher = $1)) AS filtered`, publisher).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting unclaimed listings: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// unclaimedOrder sorts listings by accrued payment, then by remaining payment
+var unclaimedOrder = keyset{
+	{expr: "listings.accrued_unclaimed", cast: "numeric", desc: true},
+	{expr: "listings.payment_remaining", cast: "numeric", desc: true},
+	{expr: "listings.id", cast: "bigint", desc: true},
+}
+
+// FetchUnclaimedListings retrieves a page of the listings with payments left to claim, largest
+// accrued payment first, and the cursor of the next page
+func FetchUnclaimedListings(db *sql.DB, publisher string, page Page) ([]MarketplaceListing, string, error) {
+	return fetchPage(db, unclaimedOrder, listingsWithStats+`
+		WHERE payment_remaining > 0 AND ($1 = '' OR publisher = $1)`, []interface{}{publisher}, page, scanMarketplaceListings,
+		func(listing MarketplaceListing) []interface{} {
+			return []interface{}{listing.AccruedUnclaimed, listing.PaymentRemaining, listing.ID}
+		})
+}
+
+// CountDatasetSubscriptions gets total count of subscriptions to a dataset, optionally with a given status
+func CountDatasetSubscriptions(db *sql.DB, datasetAddress, status string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+subscriptionsWithStatus+`
+		WHERE dataset_address = $1 AND ($2 = '' OR status = $2)) AS filtered`, datasetAddress, status).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting dataset subscriptions: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchDatasetSubscriptions retrieves a page of the subscriptions to a dataset, newest first,
+// and the cursor of the next page
+func FetchDatasetSubscriptions(db *sql.DB, datasetAddress, status string, page Page) ([]MarketplaceSubscription, string, error) {
+	return fetchPage(db, blockHeightOrder("subscriptions", true), subscriptionsWithStatus+`
+		WHERE dataset_address = $1 AND ($2 = '' OR status = $2)`, []interface{}{datasetAddress, status}, page,
+		scanMarketplaceSubscriptions,
+		func(subscription MarketplaceSubscription) []interface{} {
+			return []interface{}{subscription.BlockHeight, subscription.ID}
+		})
+}
+
+func scanMarketplaceSubscriptions(rows *sql.Rows) ([]MarketplaceSubscription, error) {
+	subscriptions := []MarketplaceSubscription{}
+	for rows.Next() {
+		var subscription MarketplaceSubscription
+		if err := rows.Scan(&subscription.DatasetAddress, &subscription.MarketplaceAssetAddress, &subscription.Subscriber,
+			&subscription.SubscriptionNFTAddress, &subscription.PaymentAssetAddress, &subscription.PricePerBlock,
+			&subscription.TotalCost, &subscription.NumBlocks, &subscription.IssuanceBlock, &subscription.ExpirationBlock,
+			&subscription.Status, &subscription.TxHash, &subscription.BlockHeight, &subscription.Timestamp,
+			&subscription.ID); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// CountDatasetPayments gets total count of payments claimed from the listing of a dataset
+func CountDatasetPayments(db *sql.DB, datasetAddress string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM marketplace_payments
+		INNER JOIN marketplace_listings
+		        ON marketplace_listings.marketplace_asset_address = marketplace_payments.marketplace_asset_address
+		WHERE marketplace_listings.dataset_address = $1`, datasetAddress).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting dataset payments: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchDatasetPayments retrieves a page of the payments claimed from the listing of a dataset,
+// newest first, and the cursor of the next page
+func FetchDatasetPayments(db *sql.DB, datasetAddress string, page Page) ([]MarketplacePayment, string, error) {
+	return fetchPage(db, blockHeightOrder("marketplace_payments", true), `
+		SELECT marketplace_payments.id, marketplace_payments.marketplace_asset_address,
+		       marketplace_payments.payment_asset_address, marketplace_payments.amount,
+		       marketplace_payments.distributed_to, marketplace_payments.payment_claimed,
+		       marketplace_payments.payment_remaining, marketplace_payments.last_claimed_block,
+		       marketplace_payments.tx_hash, marketplace_payments.block_height, marketplace_payments.timestamp
+		FROM marketplace_payments
+		INNER JOIN marketplace_listings
+		        ON marketplace_listings.marketplace_asset_address = marketplace_payments.marketplace_asset_address
+		WHERE marketplace_listings.dataset_address = $1`, []interface{}{datasetAddress}, page, scanMarketplacePayments,
+		func(payment MarketplacePayment) []interface{} { return []interface{}{payment.BlockHeight, payment.ID} })
+}
+
+func scanMarketplacePayments(rows *sql.Rows) ([]MarketplacePayment, error) {
+	payments := []MarketplacePayment{}
+	for rows.Next() {
+		var payment MarketplacePayment
+		if err := rows.Scan(&payment.ID, &payment.MarketplaceAssetAddress, &payment.PaymentAssetAddress, &payment.Amount,
+			&payment.DistributedTo, &payment.PaymentClaimed, &payment.PaymentRemaining, &payment.LastClaimedBlock,
+			&payment.TxHash, &payment.BlockHeight, &payment.Timestamp); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// FetchDatasetRevenue retrieves the revenue of the listing of a dataset over the given interval,
+// per payment asset
+func FetchDatasetRevenue(db *sql.DB, datasetAddress, interval string) ([]MarketplaceRevenue, error) {
+	return fetchMarketplaceRevenue(db, "dataset_address", datasetAddress, interval)
+}
+
+// FetchOwnerRevenue retrieves the revenue of all listings published by an address over the
+// given interval, per payment asset
+func FetchOwnerRevenue(db *sql.DB, owner, interval string) ([]MarketplaceRevenue, error) {
+	return fetchMarketplaceRevenue(db, "publisher", owner, interval)
+}
+
+// fetchMarketplaceRevenue sums the subscriptions paid and the payments claimed over the
+// interval for the listings whose column equals value
+func fetchMarketplaceRevenue(db *sql.DB, column, value, interval string) ([]MarketplaceRevenue, error) {
+	rows, err := db.Query(`
+		SELECT payment_asset_address, SUM(subscriptions), SUM(revenue), SUM(claimed)
+		FROM (
+			SELECT subscriptions.payment_asset_address, COUNT(*) AS subscriptions,
+			       SUM(subscriptions.total_cost) AS revenue, 0 AS claimed
+			FROM marketplace_subscriptions AS subscriptions
+			INNER JOIN marketplace_listings AS listings
+			        ON listings.marketplace_asset_address = subscriptions.marketplace_asset_address
+			WHERE listings.`+column+` = $1
+			  AND subscriptions.timestamp >= NOW() - $2::interval
+			GROUP BY subscriptions.payment_asset_address
+			UNION ALL
+			SELECT payments.payment_asset_address, 0, 0, SUM(payments.amount)
+			FROM marketplace_payments AS payments
+			INNER JOIN marketplace_listings AS listings
+			        ON listings.marketplace_asset_address = payments.marketplace_asset_address
+			WHERE listings.`+column+` = $1
+			  AND payments.timestamp >= NOW() - $2::interval
+			GROUP BY payments.payment_asset_address
+		) AS revenue
+		GROUP BY payment_asset_address
+		ORDER BY payment_asset_address`, value, interval)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	revenue := []MarketplaceRevenue{}
+	for rows.Next() {
+		var item MarketplaceRevenue
+		if err := rows.Scan(&item.PaymentAssetAddress, &item.Subscriptions, &item.Revenue, &item.PaymentClaimed); err != nil {
+			return nil, err
+		}
+		revenue = append(revenue, item)
+	}
+	return revenue, rows.Err()
+}
+
+// scanMarketplaceListing scans a single row selected from listingsWithStats
+func scanMarketplaceListing(row interface{ Scan(...interface{}) error }) (MarketplaceListing, error) {
+	var listing MarketplaceListing
+	err := row.Scan(&listing.DatasetAddress, &listing.MarketplaceAssetAddress, &listing.PaymentAssetAddress,
+		&listing.PricePerBlock, &listing.Publisher, &listing.Subscriptions, &listing.ActiveSubscriptions,
+		&listing.Revenue, &listing.PaymentClaimed, &listing.PaymentRemaining, &listing.AccruedUnclaimed,
+		&listing.LastClaimedBlock, &listing.TxHash, &listing.BlockHeight, &listing.Timestamp, &listing.ID)
+	return listing, err
+}
+
+func scanMarketplaceListings(rows *sql.Rows) ([]MarketplaceListing, error) {
+	listings := []MarketplaceListing{}
+	for rows.Next() {
+		listing, err := scanMarketplaceListing(rows)
+		if err != nil {
+			return nil, err
+		}
+		listings = append(listings, listing)
+	}
+	return listings, rows.Err()
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"log"
+)
+
+type NFTToken struct {
+	ID                int     `json:"id"`
+	CollectionAddress string  `json:"collection_address"`
+	UniqueID          int64   `json:"unique_id"`
+	NFTAddress        string  `json:"nft_address"`
+	Owner             string  `json:"owner"`
+	Metadata          string  `json:"metadata"`
+	MintTxHash        string  `json:"mint_tx_hash"`
+	MintBlockHeight   int64   `json:"mint_block_height"`
+	MintedAt          string  `json:"minted_at"`
+	Burned            bool    `json:"burned"`
+	BurnTxHash        *string `json:"burn_tx_hash"`
+	BurnedAt          *string `json:"burned_at"`
+}
+
+type NFTTransfer struct {
+	ActionName  string  `json:"action_name"`
+	From        *string `json:"from"`
+	To          *string `json:"to"`
+	TxHash      string  `json:"tx_hash"`
+	BlockHeight int64   `json:"block_height"`
+	Timestamp   string  `json:"timestamp"`
+}
+
+const nftTokenColumns = `id, collection_address, unique_id, nft_address, owner, COALESCE(metadata, ''), mint_tx_hash,
+	mint_block_height, minted_at, burned, burn_tx_hash, burned_at`
+
+// CountCollectionNFTs gets total count of tokens minted in a collection
+func CountCollectionNFTs(db *sql.DB, collectionAddress string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM nft_tokens WHERE collection_address = $1`, collectionAddress).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting collection tokens: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// collectionNFTOrder sorts the tokens of a collection in mint order
+var collectionNFTOrder = keyset{{expr: "nft_tokens.unique_id", cast: "bigint"}}
+
+// FetchCollectionNFTs retrieves a page of the tokens minted in a collection, in mint order,
+// and the cursor of the next page
+func FetchCollectionNFTs(db *sql.DB, collectionAddress string, page Page) ([]NFTToken, string, error) {
+	return fetchPage(db, collectionNFTOrder, `
+		SELECT `+nftTokenColumns+`
+		FROM nft_tokens
+		WHERE collection_address = $1`, []interface{}{collectionAddress}, page, scanNFTTokens,
+		func(token NFTToken) []interface{} { return []interface{}{token.UniqueID} })
+}
+
+// CountNFTsByOwner gets total count of tokens currently held by an address
+func CountNFTsByOwner(db *sql.DB, owner string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM nft_tokens WHERE owner = $1 AND NOT burned`, owner).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting owned tokens: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// ownedNFTOrder sorts tokens by mint block, newest first
+var ownedNFTOrder = keyset{
+	{expr: "nft_tokens.mint_block_height", cast: "bigint", desc: true},
+	{expr: "nft_tokens.id", cast: "bigint", desc: true},
+}
+
+// FetchNFTsByOwner retrieves a page of the tokens currently held by an address and the cursor
+// of the next page
+func FetchNFTsByOwner(db *sql.DB, owner string, page Page) ([]NFTToken, string, error) {
+	return fetchPage(db, ownedNFTOrder, `
+		SELECT `+nftTokenColumns+`
+		FROM nft_tokens
+		WHERE owner = $1 AND NOT burned`, []interface{}{owner}, page, scanNFTTokens,
+		func(token NFTToken) []interface{} { return []interface{}{token.MintBlockHeight, token.ID} })
+}
+
+// FetchNFT retrieves a single token of a collection
+func FetchNFT(db *sql.DB, collectionAddress, uniqueID string) (NFTToken, error) {
+	var token NFTToken
+	err := db.QueryRow(`
+		SELECT `+nftTokenColumns+`
+		FROM nft_tokens
+		WHERE collection_address = $1 AND unique_id = $2`, collectionAddress, uniqueID).Scan(
+		&token.ID, &token.CollectionAddress, &token.UniqueID, &token.NFTAddress, &token.Owner, &token.Metadata, &token.MintTxHash,
+		&token.MintBlockHeight, &token.MintedAt, &token.Burned, &token.BurnTxHash, &token.BurnedAt,
+	)
+	return token, err
+}
+
+// FetchNFTProvenance retrieves every mint, transfer and burn of a token in chain order
+func FetchNFTProvenance(db *sql.DB, collectionAddress, uniqueID string) ([]NFTTransfer, error) {
+	rows, err := db.Query(`
+		SELECT action_name, from_address, to_address, tx_hash, block_height, timestamp
+		FROM nft_transfers
+		WHERE collection_address = $1 AND unique_id = $2
+		ORDER BY block_height, id`, collectionAddress, uniqueID)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []NFTTransfer{}
+	for rows.Next() {
+		var transfer NFTTransfer
+		if err := rows.Scan(&transfer.ActionName, &transfer.From, &transfer.To, &transfer.TxHash, &transfer.BlockHeight, &transfer.Timestamp); err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, rows.Err()
+}
+
+// Helper function to scan token rows
+func scanNFTTokens(rows *sql.Rows) ([]NFTToken, error) {
+	tokens := []NFTToken{}
+	for rows.Next() {
+		var token NFTToken
+		if err := rows.Scan(
+			&token.ID, &token.CollectionAddress, &token.UniqueID, &token.NFTAddress, &token.Owner, &token.Metadata, &token.MintTxHash,
+			&token.MintBlockHeight, &token.MintedAt, &token.Burned, &token.BurnTxHash, &token.BurnedAt,
+		); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
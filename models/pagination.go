@@ -0,0 +1,201 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a cursor does not belong to the requested list
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page selects the items of a list to return. A page starts right after the item the
+// cursor points to when it is set, and at Offset otherwise.
+type Page struct {
+	Limit  int
+	Offset int
+	Cursor Cursor
+}
+
+// Cursor holds the sort key of the last item of a page
+type Cursor []string
+
+// EncodeCursor returns the opaque token of a cursor
+func EncodeCursor(values ...interface{}) string {
+	cursor := make(Cursor, len(values))
+	for i, value := range values {
+		cursor[i] = fmt.Sprint(value)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a token returned by EncodeCursor
+func DecodeCursor(token string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || len(cursor) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// sortColumn is a column of the order of a list
+type sortColumn struct {
+	expr string
+	// cast is the type of the column the cursor value is converted to
+	cast string
+	desc bool
+}
+
+// keyset is the order of a list. The columns must identify a row, so the page after a
+// cursor starts right after the row it was taken from even while rows are added.
+type keyset []sortColumn
+
+// blockHeightOrder sorts the rows of a table by block height, then by insertion order
+func blockHeightOrder(table string, desc bool) keyset {
+	return keyset{
+		{expr: table + ".block_height", cast: "bigint", desc: desc},
+		{expr: table + ".id", cast: "bigint", desc: desc},
+	}
+}
+
+// fetchPage runs query, which must end inside its WHERE clause, for a page of a list
+// sorted by order. It returns the items read by scan and the cursor of the next page
+// built from the sort key of the last item.
+func fetchPage[T any](db *sql.DB, order keyset, query string, args []interface{}, page Page,
+	scan func(*sql.Rows) ([]T, error), key func(T) []interface{},
+) ([]T, string, error) {
+	query, args, err := order.paginate(query, args, page)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	items, err := scan(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	return items, nextCursor(page, len(items), func() []interface{} { return key(items[len(items)-1]) }), nil
+}
+
+// paginate appends the condition selecting the rows after the cursor, the ORDER BY and
+// the LIMIT of the page to query, which must end inside a WHERE clause. The placeholders
+// are numbered after args.
+func (k keyset) paginate(query string, args []interface{}, page Page) (string, []interface{}, error) {
+	if page.Cursor != nil {
+		condition, cursorArgs, err := k.after(page.Cursor, len(args)+1)
+		if err != nil {
+			return "", nil, err
+		}
+		query += " AND " + condition
+		args = append(args, cursorArgs...)
+	}
+
+	order := make([]string, len(k))
+	for i, column := range k {
+		order[i] = column.expr
+		if column.desc {
+			order[i] += " DESC"
+		}
+	}
+	query += fmt.Sprintf(" ORDER BY %s LIMIT $%d", strings.Join(order, ", "), len(args)+1)
+	args = append(args, page.Limit)
+
+	if page.Cursor == nil && page.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET $%d", len(args)+1)
+		args = append(args, page.Offset)
+	}
+	return query, args, nil
+}
+
+// after returns the condition selecting the rows that follow the cursor
+func (k keyset) after(cursor Cursor, argIndex int) (string, []interface{}, error) {
+	if len(cursor) != len(k) {
+		return "", nil, ErrInvalidCursor
+	}
+
+	columns := make([]string, len(k))
+	values := make([]string, len(k))
+	args := make([]interface{}, len(k))
+	sameDirection := true
+	for i, column := range k {
+		if !validCursorValue(cursor[i], column.cast) {
+			return "", nil, ErrInvalidCursor
+		}
+		columns[i] = column.expr
+		values[i] = fmt.Sprintf("$%d::%s", argIndex+i, column.cast)
+		args[i] = cursor[i]
+		sameDirection = sameDirection && column.desc == k[0].desc
+	}
+
+	// Compare rows when all columns sort the same way, so the index on them can be used
+	if sameDirection {
+		operator := ">"
+		if k[0].desc {
+			operator = "<"
+		}
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), operator, strings.Join(values, ", ")), args, nil
+	}
+
+	// Otherwise a row follows the cursor if it follows it on the first column that differs
+	alternatives := make([]string, len(k))
+	for i, column := range k {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", columns[j], values[j]))
+		}
+		operator := ">"
+		if column.desc {
+			operator = "<"
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", columns[i], operator, values[i]))
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args, nil
+}
+
+// validCursorValue checks that a cursor value can be converted to the column type, so a
+// tampered cursor is rejected instead of failing the query
+func validCursorValue(value, cast string) bool {
+	switch cast {
+	case "bigint":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	case "numeric":
+		_, ok := new(big.Float).SetString(value)
+		return ok
+	case "timestamp":
+		_, err := time.Parse(time.RFC3339Nano, value)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// nextCursor returns the cursor of the page following a page of count items, or an empty
+// string when the page is the last one. last returns the sort key of the last item.
+func nextCursor(page Page, count int, last func() []interface{}) string {
+	if count == 0 || count < page.Limit {
+		return ""
+	}
+	return EncodeCursor(last()...)
+}
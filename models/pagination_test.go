@@ -0,0 +1,90 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	token := EncodeCursor(int64(42), "2024-11-05T10:00:00Z", 7)
+	cursor, err := DecodeCursor(token)
+	if err != nil {
+		t.Fatalf("DecodeCursor() error = %v", err)
+	}
+	if want := (Cursor{"42", "2024-11-05T10:00:00Z", "7"}); !reflect.DeepEqual(cursor, want) {
+		t.Fatalf("DecodeCursor() = %v, want %v", cursor, want)
+	}
+
+	for _, token := range []string{"not base64!", EncodeCursor()} {
+		if _, err := DecodeCursor(token); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q) error = %v, want ErrInvalidCursor", token, err)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	const query = "SELECT * FROM datasets WHERE owner = $1"
+
+	tests := []struct {
+		name      string
+		order     keyset
+		page      Page
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{
+			name:      "offset",
+			order:     blockHeightOrder("datasets", true),
+			page:      Page{Limit: 10, Offset: 20},
+			wantQuery: query + " ORDER BY datasets.block_height DESC, datasets.id DESC LIMIT $2 OFFSET $3",
+			wantArgs:  []interface{}{"owner", 10, 20},
+		},
+		{
+			name:  "cursor",
+			order: blockHeightOrder("datasets", true),
+			page:  Page{Limit: 10, Cursor: Cursor{"100", "5"}},
+			wantQuery: query + " AND (datasets.block_height, datasets.id) < ($2::bigint, $3::bigint)" +
+				" ORDER BY datasets.block_height DESC, datasets.id DESC LIMIT $4",
+			wantArgs: []interface{}{"owner", "100", "5", 10},
+		},
+		{
+			name: "mixed directions",
+			order: keyset{
+				{expr: "accounts.balance", cast: "numeric", desc: true},
+				{expr: "accounts.address", cast: "text"},
+			},
+			page: Page{Limit: 5, Cursor: Cursor{"1000", "00abc"}},
+			wantQuery: query + " AND ((accounts.balance < $2::numeric)" +
+				" OR (accounts.balance = $2::numeric AND accounts.address > $3::text))" +
+				" ORDER BY accounts.balance DESC, accounts.address LIMIT $4",
+			wantArgs: []interface{}{"owner", "1000", "00abc", 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotQuery, gotArgs, err := tt.order.paginate(query, []interface{}{"owner"}, tt.page)
+			if err != nil {
+				t.Fatalf("paginate() error = %v", err)
+			}
+			if gotQuery != tt.wantQuery {
+				t.Errorf("paginate() query = %q, want %q", gotQuery, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("paginate() args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestPaginateRejectsForeignCursor(t *testing.T) {
+	order := blockHeightOrder("blocks", true)
+	for _, cursor := range []Cursor{{"100"}, {"abc", "5"}} {
+		if _, _, err := order.paginate("SELECT * FROM blocks WHERE TRUE", nil, Page{Limit: 10, Cursor: cursor}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("paginate() with cursor %v error = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
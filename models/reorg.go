@@ -0,0 +1,94 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+type ChainReorg struct {
+	ID                   int      `json:"id"`
+	ForkHeight           int64    `json:"fork_height"`
+	OldTipHeight         int64    `json:"old_tip_height"`
+	OldTipHash           string   `json:"old_tip_hash"`
+	NewBlockHeight       int64    `json:"new_block_height"`
+	NewBlockHash         string   `json:"new_block_hash"`
+	OrphanedBlocks       int      `json:"orphaned_blocks"`
+	OrphanedTransactions int      `json:"orphaned_transactions"`
+	OrphanedBlockHashes  []string `json:"orphaned_block_hashes"`
+	Timestamp            string   `json:"timestamp"`
+}
+
+// CountChainReorgs gets total count of recorded chain reorganizations
+func CountChainReorgs(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM chain_reorgs`).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting chain reorgs: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// reorgOrder sorts chain reorganizations by the time they were recorded
+var reorgOrder = keyset{
+	{expr: "chain_reorgs.timestamp", cast: "timestamp", desc: true},
+	{expr: "chain_reorgs.id", cast: "bigint", desc: true},
+}
+
+// FetchChainReorgs retrieves a page of recorded chain reorganizations, most recent first, and
+// the cursor of the next page
+func FetchChainReorgs(db *sql.DB, page Page) ([]ChainReorg, string, error) {
+	return fetchPage(db, reorgOrder, `
+		SELECT id, fork_height, old_tip_height, old_tip_hash, new_block_height, new_block_hash,
+		       orphaned_blocks, orphaned_transactions, orphaned_block_hashes, timestamp
+		FROM chain_reorgs
+		WHERE TRUE`, nil, page, scanChainReorgs,
+		func(reorg ChainReorg) []interface{} { return []interface{}{reorg.Timestamp, reorg.ID} })
+}
+
+func scanChainReorgs(rows *sql.Rows) ([]ChainReorg, error) {
+	var reorgs []ChainReorg
+	for rows.Next() {
+		var reorg ChainReorg
+		if err := rows.Scan(
+			&reorg.ID, &reorg.ForkHeight, &reorg.OldTipHeight, &reorg.OldTipHash,
+			&reorg.NewBlockHeight, &reorg.NewBlockHash, &reorg.OrphanedBlocks,
+			&reorg.OrphanedTransactions, pq.Array(&reorg.OrphanedBlockHashes), &reorg.Timestamp,
+		); err != nil {
+			return nil, err
+		}
+		reorgs = append(reorgs, reorg)
+	}
+	return reorgs, rows.Err()
+}
+
+// FetchChainReorgByBlock retrieves the reorg that replaced the blocks from the given height
+// with the given block, or sql.ErrNoRows when the block replaced none
+func FetchChainReorgByBlock(db *sql.DB, height int64, hash string) (ChainReorg, error) {
+	rows, err := db.Query(`
+		SELECT id, fork_height, old_tip_height, old_tip_hash, new_block_height, new_block_hash,
+		       orphaned_blocks, orphaned_transactions, orphaned_block_hashes, timestamp
+		FROM chain_reorgs
+		WHERE new_block_height = $1 AND new_block_hash = $2
+		ORDER BY id DESC
+		LIMIT 1`, height, hash)
+	if err != nil {
+		log.Printf("Error fetching chain reorg of block %d: %v", height, err)
+		return ChainReorg{}, err
+	}
+	defer rows.Close()
+
+	reorgs, err := scanChainReorgs(rows)
+	if err != nil {
+		return ChainReorg{}, err
+	}
+	if len(reorgs) == 0 {
+		return ChainReorg{}, sql.ErrNoRows
+	}
+	return reorgs[0], nil
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type BlockGap struct {
+	StartHeight   int64 `json:"start_height"`
+	EndHeight     int64 `json:"end_height"`
+	MissingBlocks int64 `json:"missing_blocks"`
+}
+
+type SyncStatus struct {
+	LowestHeight  int64      `json:"lowest_height"`
+	HighestHeight int64      `json:"highest_height"`
+	MissingBlocks int64      `json:"missing_blocks"`
+	Gaps          []BlockGap `json:"gaps"`
+	LastScanned   time.Time  `json:"last_scanned"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// FetchBlockGaps retrieves the ranges of block heights missing from the blocks table,
+// including any range between the first block and the lowest stored height
+func FetchBlockGaps(db *sql.DB) ([]BlockGap, error) {
+	rows, err := db.Query(`
+		WITH heights AS (
+			SELECT 0::BIGINT AS block_height
+			UNION ALL
+			SELECT block_height FROM blocks
+		), neighbours AS (
+			SELECT block_height, LEAD(block_height) OVER (ORDER BY block_height) AS next_height
+			FROM heights
+		)
+		SELECT block_height + 1, next_height - 1
+		FROM neighbours
+		WHERE next_height > block_height + 1
+		ORDER BY block_height`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gaps := []BlockGap{}
+	for rows.Next() {
+		var gap BlockGap
+		if err := rows.Scan(&gap.StartHeight, &gap.EndHeight); err != nil {
+			return nil, err
+		}
+		gap.MissingBlocks = gap.EndHeight - gap.StartHeight + 1
+		gaps = append(gaps, gap)
+	}
+	return gaps, rows.Err()
+}
+
+// FetchSyncStatus retrieves the stored height range and the missing block ranges
+func FetchSyncStatus(db *sql.DB) (SyncStatus, error) {
+	status := SyncStatus{LastScanned: time.Now().UTC()}
+
+	err := db.QueryRow(`SELECT COALESCE(MIN(block_height), 0), COALESCE(MAX(block_height), 0) FROM blocks`).Scan(
+		&status.LowestHeight, &status.HighestHeight)
+	if err != nil {
+		return status, err
+	}
+
+	gaps, err := FetchBlockGaps(db)
+	if err != nil {
+		return status, err
+	}
+	status.Gaps = gaps
+	for _, gap := range gaps {
+		status.MissingBlocks += gap.MissingBlocks
+	}
+
+	return status, nil
+}
@@ -65,21 +65,23 @@ func CountFilteredTransactions(db *sql.DB, txHash, blockHash, actionType, action
 	return count, err
 }
 
-// FetchFilteredTransactions retrieves transactions based on optional filters
-func FetchFilteredTransactions(db *sql.DB, txHash, blockHash, actionType, actionName, user, limit, offset string) ([]Transaction, error) {
-	query, args := buildTransactionFilterQuery("*", txHash, blockHash, actionType, actionName, user)
-	query = fmt.Sprintf("%s ORDER BY transactions.timestamp DESC LIMIT $%d OFFSET $%d",
-		query, len(args)+1, len(args)+2)
+// transactionOrder sorts transactions from the newest
+var transactionOrder = keyset{
+	{expr: "transactions.timestamp", cast: "timestamp", desc: true},
+	{expr: "transactions.id", cast: "bigint", desc: true},
+}
 
-	args = append(args, limit, offset)
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		log.Printf("Database query error: %v", err)
-		return nil, err
-	}
-	defer rows.Close()
+// FetchFilteredTransactions retrieves a page of transactions based on optional filters and
+// the cursor of the next page
+func FetchFilteredTransactions(db *sql.DB, txHash, blockHash, actionType, actionName, user string, page Page) ([]Transaction, string, error) {
+	query, args := buildTransactionFilterQuery("*", txHash, blockHash, actionType, actionName, user)
+	return fetchTransactionPage(db, query, args, page)
+}
 
-	return scanTransactions(rows)
+// fetchTransactionPage runs a transaction query ending inside its WHERE clause for a page
+func fetchTransactionPage(db *sql.DB, query string, args []interface{}, page Page) ([]Transaction, string, error) {
+	return fetchPage(db, transactionOrder, query, args, page, scanTransactions,
+		func(tx Transaction) []interface{} { return []interface{}{tx.Timestamp, tx.ID} })
 }
 
 // Helper function to construct filter queries for transactions
@@ -90,7 +92,7 @@ func buildTransactionFilterQuery(selectFields, txHash, blockHash, actionType, ac
 		return query, args
 	}
 
-	query := fmt.Sprintf("SELECT id, tx_hash, transactions.block_hash, blocks.block_height, sponsor, actors, receivers, max_fee, success, fee, actions, transactions.timestamp FROM transactions LEFT JOIN blocks ON transactions.block_hash = blocks.block_hash WHERE 1=1")
+	query := fmt.Sprintf("SELECT transactions.id, tx_hash, transactions.block_hash, blocks.block_height, sponsor, actors, receivers, max_fee, success, fee, actions, transactions.timestamp FROM transactions LEFT JOIN blocks ON transactions.block_hash = blocks.block_hash WHERE 1=1")
 	args := []interface{}{}
 	argCounter := 1
 
@@ -213,28 +215,16 @@ func FetchTransactionsByBlock(db *sql.DB, blockIdentifier string) ([]Transaction
 	return scanTransactions(rows)
 }
 
-// FetchTransactionsByUser retrieves transactions by user (sponsor or actor or receiver) with pagination
-func FetchTransactionsByUser(db *sql.DB, user, limit, offset string) ([]Transaction, error) {
-	normalizedUser := "%" + strings.TrimPrefix(user, "0x") + "%"
+// transactionsWithHeight selects the transactions with the height of their block
+const transactionsWithHeight = `
+	SELECT transactions.id, tx_hash, transactions.block_hash, blocks.block_height, sponsor, actors, receivers,
+	       max_fee, success, fee, actions, transactions.timestamp
+	FROM transactions
+	LEFT JOIN blocks ON transactions.block_hash = blocks.block_hash`
 
-	rows, err := db.Query(`
-		SELECT id, tx_hash, transactions.block_hash, blocks.block_height, sponsor, actors, receivers, max_fee, success, fee, actions, timestamp
-		FROM transactions
-		LEFT JOIN blocks ON transactions.block_hash = blocks.block_hash
-		WHERE sponsor ILIKE $1
-		   OR EXISTS (
-			   SELECT 1
-			   FROM unnest(actors) AS actor
-			   WHERE actor ILIKE $1
-		   )
-		   OR EXISTS (
-			   SELECT 1
-			   FROM unnest(receivers) AS receiver
-			   WHERE receiver ILIKE $1
-		   )
-		ORDER BY timestamp DESC
-		LIMIT $2 OFFSET $3
-	`, normalizedUser, limit, offset)
+// FetchTransactionsByHashes retrieves the transactions with the given hashes
+func FetchTransactionsByHashes(db *sql.DB, txHashes []string) ([]Transaction, error) {
+	rows, err := db.Query(transactionsWithHeight+` WHERE tx_hash = ANY($1)`, pq.Array(txHashes))
 	if err != nil {
 		log.Printf("Database query error: %v", err)
 		return nil, err
@@ -244,6 +234,45 @@ func FetchTransactionsByUser(db *sql.DB, user, limit, offset string) ([]Transact
 	return scanTransactions(rows)
 }
 
+// FetchTransactionsByBlockHashes retrieves the transactions of the blocks with the given
+// hashes, in the order they were indexed
+func FetchTransactionsByBlockHashes(db *sql.DB, blockHashes []string) ([]Transaction, error) {
+	rows, err := db.Query(transactionsWithHeight+`
+		WHERE transactions.block_hash = ANY($1)
+		ORDER BY transactions.id`, pq.Array(blockHashes))
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTransactions(rows)
+}
+
+// FetchTransactionsByUser retrieves a page of the transactions of a user (sponsor or actor
+// or receiver) and the cursor of the next page
+func FetchTransactionsByUser(db *sql.DB, user string, page Page) ([]Transaction, string, error) {
+	normalizedUser := "%" + strings.TrimPrefix(user, "0x") + "%"
+
+	return fetchTransactionPage(db, `
+		SELECT transactions.id, tx_hash, transactions.block_hash, blocks.block_height, sponsor, actors, receivers, max_fee, success, fee, actions, transactions.timestamp
+		FROM transactions
+		LEFT JOIN blocks ON transactions.block_hash = blocks.block_hash
+		WHERE (
+			sponsor ILIKE $1
+			OR EXISTS (
+				SELECT 1
+				FROM unnest(actors) AS actor
+				WHERE actor ILIKE $1
+			)
+			OR EXISTS (
+				SELECT 1
+				FROM unnest(receivers) AS receiver
+				WHERE receiver ILIKE $1
+			)
+		)`, []interface{}{normalizedUser}, page)
+}
+
 // FetchAllActionVolumes retrieves all actions volumes by 12hrs, 24hrs, 7days & 30 days
 func FetchAllActionVolumes(db *sql.DB) ([]ActionVolumes, error) {
 	var volumes []ActionVolumes
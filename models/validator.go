@@ -1,86 +1,209 @@
 package models
 
 import (
-    "database/sql"
-    "log"
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// Validator stake statuses
+const (
+	ValidatorStatusPending   = "pending"
+	ValidatorStatusActive    = "active"
+	ValidatorStatusWithdrawn = "withdrawn"
 )
 
 type ValidatorStake struct {
-    NodeID            string `json:"node_id"`
-    Actor             string `json:"actor"`
-    StakeStartBlock   int64  `json:"stake_start_block"`
-    StakeEndBlock     int64  `json:"stake_end_block"`
-    StakedAmount      int64  `json:"staked_amount"`
-    DelegationFeeRate int64  `json:"delegation_fee_rate"`
-    RewardAddress     string `json:"reward_address"`
-    TxHash            string `json:"tx_hash"`
-    Timestamp         string `json:"timestamp"`
+	ID                    int         `json:"id"`
+	NodeID                string      `json:"node_id"`
+	Actor                 string      `json:"actor"`
+	StakeStartBlock       int64       `json:"stake_start_block"`
+	StakeEndBlock         int64       `json:"stake_end_block"`
+	StakedAmount          int64       `json:"staked_amount"`
+	DelegationFeeRate     int64       `json:"delegation_fee_rate"`
+	RewardAddress         string      `json:"reward_address"`
+	Status                string      `json:"status"`
+	RewardsClaimed        json.Number `json:"rewards_claimed"`
+	TotalDelegated        json.Number `json:"total_delegated"`
+	WithdrawalTxHash      *string     `json:"withdrawal_tx_hash"`
+	WithdrawalBlockHeight *int64      `json:"withdrawal_block_height"`
+	WithdrawnAt           *string     `json:"withdrawn_at"`
+	TxHash                string      `json:"tx_hash"`
+	Timestamp             string      `json:"timestamp"`
+}
+
+// ValidatorStakeDetails is the latest stake of a node with the rewards of all its stakes
+type ValidatorStakeDetails struct {
+	ValidatorStake
+	LifetimeRewards json.Number `json:"lifetime_rewards"`
+}
+
+type ValidatorReward struct {
+	ID              int         `json:"id"`
+	NodeID          string      `json:"node_id"`
+	StakeStartBlock int64       `json:"stake_start_block"`
+	TxHash          string      `json:"tx_hash"`
+	BlockHeight     int64       `json:"block_height"`
+	ActionName      string      `json:"action_name"`
+	RewardAmount    json.Number `json:"reward_amount"`
+	DistributedTo   string      `json:"distributed_to"`
+	Timestamp       string      `json:"timestamp"`
+}
+
+// validatorStakeColumns selects a ValidatorStake from validator_stake. A stake is pending
+// until the indexed chain reaches its start block and active until it is withdrawn, which
+// is possible from its end block on.
+const validatorStakeColumns = `
+	validator_stake.id, validator_stake.node_id, validator_stake.actor, validator_stake.stake_start_block, validator_stake.stake_end_block,
+	validator_stake.staked_amount, validator_stake.delegation_fee_rate, validator_stake.reward_address,
+	CASE
+		WHEN validator_stake.withdrawal_tx_hash IS NOT NULL THEN 'withdrawn'
+		WHEN (SELECT COALESCE(MAX(block_height), 0) FROM blocks) < validator_stake.stake_start_block THEN 'pending'
+		ELSE 'active'
+	END,
+	(SELECT COALESCE(SUM(reward_amount), 0) FROM validator_rewards
+	 WHERE validator_rewards.node_id = validator_stake.node_id
+	   AND validator_rewards.stake_start_block = validator_stake.stake_start_block),
+	(SELECT COALESCE(SUM(staked_amount), 0) FROM delegations
+	 WHERE delegations.node_id = validator_stake.node_id AND delegations.status = 'active'
+	   AND validator_stake.withdrawal_tx_hash IS NULL),
+	validator_stake.withdrawal_tx_hash, validator_stake.withdrawal_block_height, validator_stake.withdrawn_at,
+	validator_stake.tx_hash, validator_stake.timestamp`
+
+// validatorStakesByStatus selects the stakes with the given status, or all stakes if it is empty
+const validatorStakesByStatus = `
+	SELECT * FROM (SELECT ` + validatorStakeColumns + ` FROM validator_stake) AS stakes (
+		id, node_id, actor, stake_start_block, stake_end_block, staked_amount, delegation_fee_rate, reward_address,
+		status, rewards_claimed, total_delegated, withdrawal_tx_hash, withdrawal_block_height, withdrawn_at, tx_hash, timestamp
+	)
+	WHERE ($1 = '' OR status = $1)`
+
+// IsValidatorStatus reports whether status is a known validator stake status
+func IsValidatorStatus(status string) bool {
+	switch status {
+	case ValidatorStatusPending, ValidatorStatusActive, ValidatorStatusWithdrawn:
+		return true
+	}
+	return false
+}
+
+// CountValidatorStakes gets total count of validator stakes, optionally with a given status
+func CountValidatorStakes(db *sql.DB, status string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM (`+validatorStakesByStatus+`) AS filtered`, status).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting validator stakes: %v", err)
+		return 0, err
+	}
+	return count, nil
 }
 
-// CountValidatorStakes gets total count of validator stakes
-func CountValidatorStakes(db *sql.DB) (int, error) {
-    var count int
-    err := db.QueryRow(`SELECT COUNT(*) FROM validator_stake`).Scan(&count)
-    if err != nil {
-        log.Printf("Error counting validator stakes: %v", err)
-        return 0, err
-    }
-    return count, nil
+// validatorStakeOrder sorts validator stakes by the time they were registered
+var validatorStakeOrder = keyset{
+	{expr: "stakes.timestamp", cast: "timestamp", desc: true},
+	{expr: "stakes.id", cast: "bigint", desc: true},
 }
 
-// FetchAllValidatorStakes retrieves validator stakes from the database with pagination
-func FetchAllValidatorStakes(db *sql.DB, limit, offset string) ([]ValidatorStake, error) {
-    rows, err := db.Query(`
-        SELECT node_id, actor, stake_start_block, stake_end_block, 
-               staked_amount, delegation_fee_rate, reward_address, 
-               tx_hash, timestamp
-        FROM validator_stake
-        ORDER BY timestamp DESC
-        LIMIT $1 OFFSET $2`, limit, offset)
-    if err != nil {
-        log.Printf("Database query error: %v", err)
-        return nil, err
-    }
-    defer rows.Close()
-
-    return scanValidatorStakes(rows)
+// FetchAllValidatorStakes retrieves a page of validator stakes, newest first, optionally with
+// a given status, and the cursor of the next page
+func FetchAllValidatorStakes(db *sql.DB, status string, page Page) ([]ValidatorStake, string, error) {
+	return fetchPage(db, validatorStakeOrder, validatorStakesByStatus, []interface{}{status}, page, scanValidatorStakes,
+		func(stake ValidatorStake) []interface{} { return []interface{}{stake.Timestamp, stake.ID} })
 }
 
-// FetchValidatorStakeByNodeID retrieves a specific validator stake by node ID
-func FetchValidatorStakeByNodeID(db *sql.DB, nodeID string) (ValidatorStake, error) {
-    var stake ValidatorStake
-    err := db.QueryRow(`
-        SELECT node_id, actor, stake_start_block, stake_end_block, 
-               staked_amount, delegation_fee_rate, reward_address, 
-               tx_hash, timestamp
-        FROM validator_stake
-        WHERE node_id = $1`, nodeID).Scan(
-            &stake.NodeID, &stake.Actor, &stake.StakeStartBlock,
-            &stake.StakeEndBlock, &stake.StakedAmount,
-            &stake.DelegationFeeRate, &stake.RewardAddress,
-            &stake.TxHash, &stake.Timestamp,
-    )
-    if err != nil {
-        log.Printf("Error fetching validator stake: %v", err)
-        return stake, err
-    }
-    return stake, nil
+// FetchValidatorStakeByNodeID retrieves the latest stake of a node with the rewards
+// claimed over all its stakes
+func FetchValidatorStakeByNodeID(db *sql.DB, nodeID string) (ValidatorStakeDetails, error) {
+	var details ValidatorStakeDetails
+	row := db.QueryRow(`
+		SELECT `+validatorStakeColumns+`,
+		       (SELECT COALESCE(SUM(reward_amount), 0) FROM validator_rewards WHERE node_id = $1)
+		FROM validator_stake
+		WHERE validator_stake.node_id = $1
+		ORDER BY validator_stake.stake_start_block DESC
+		LIMIT 1`, nodeID)
+	if err := scanValidatorStake(row, &details.ValidatorStake, &details.LifetimeRewards); err != nil {
+		log.Printf("Error fetching validator stake: %v", err)
+		return details, err
+	}
+	return details, nil
+}
+
+// FetchValidatorStakesByActors retrieves the stakes registered by the given addresses,
+// newest first
+func FetchValidatorStakesByActors(db *sql.DB, actors []string) ([]ValidatorStake, error) {
+	rows, err := db.Query(`
+		SELECT `+validatorStakeColumns+`
+		FROM validator_stake
+		WHERE validator_stake.actor = ANY($1)
+		ORDER BY validator_stake.timestamp DESC, validator_stake.id DESC`, pq.Array(actors))
+	if err != nil {
+		log.Printf("Error fetching validator stakes: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanValidatorStakes(rows)
+}
+
+// CountValidatorRewards gets total count of reward payouts of a node
+func CountValidatorRewards(db *sql.DB, nodeID string) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM validator_rewards WHERE node_id = $1`, nodeID).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting validator rewards: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// FetchValidatorRewards retrieves a page of the reward payouts of a node, newest first, and
+// the cursor of the next page
+func FetchValidatorRewards(db *sql.DB, nodeID string, page Page) ([]ValidatorReward, string, error) {
+	return fetchPage(db, blockHeightOrder("validator_rewards", true), `
+		SELECT id, node_id, stake_start_block, tx_hash, block_height, action_name, reward_amount, distributed_to, timestamp
+		FROM validator_rewards
+		WHERE node_id = $1`, []interface{}{nodeID}, page, scanValidatorRewards,
+		func(reward ValidatorReward) []interface{} { return []interface{}{reward.BlockHeight, reward.ID} })
+}
+
+func scanValidatorRewards(rows *sql.Rows) ([]ValidatorReward, error) {
+	rewards := []ValidatorReward{}
+	for rows.Next() {
+		var reward ValidatorReward
+		if err := rows.Scan(&reward.ID, &reward.NodeID, &reward.StakeStartBlock, &reward.TxHash, &reward.BlockHeight,
+			&reward.ActionName, &reward.RewardAmount, &reward.DistributedTo, &reward.Timestamp); err != nil {
+			return nil, err
+		}
+		rewards = append(rewards, reward)
+	}
+	return rewards, rows.Err()
+}
+
+// scanValidatorStake scans the validatorStakeColumns of a row, followed by any extra columns
+func scanValidatorStake(row interface{ Scan(...interface{}) error }, stake *ValidatorStake, extra ...interface{}) error {
+	dest := []interface{}{
+		&stake.ID, &stake.NodeID, &stake.Actor, &stake.StakeStartBlock,
+		&stake.StakeEndBlock, &stake.StakedAmount,
+		&stake.DelegationFeeRate, &stake.RewardAddress,
+		&stake.Status, &stake.RewardsClaimed, &stake.TotalDelegated,
+		&stake.WithdrawalTxHash, &stake.WithdrawalBlockHeight, &stake.WithdrawnAt,
+		&stake.TxHash, &stake.Timestamp,
+	}
+	return row.Scan(append(dest, extra...)...)
 }
 
 // Helper function to scan validator stake rows
 func scanValidatorStakes(rows *sql.Rows) ([]ValidatorStake, error) {
-    var stakes []ValidatorStake
-    for rows.Next() {
-        var stake ValidatorStake
-        if err := rows.Scan(
-            &stake.NodeID, &stake.Actor, &stake.StakeStartBlock,
-            &stake.StakeEndBlock, &stake.StakedAmount,
-            &stake.DelegationFeeRate, &stake.RewardAddress,
-            &stake.TxHash, &stake.Timestamp,
-        ); err != nil {
-            return nil, err
-        }
-        stakes = append(stakes, stake)
-    }
-    return stakes, rows.Err()
-}
\ No newline at end of file
+	var stakes []ValidatorStake
+	for rows.Next() {
+		var stake ValidatorStake
+		if err := scanValidatorStake(rows, &stake); err != nil {
+			return nil, err
+		}
+		stakes = append(stakes, stake)
+	}
+	return stakes, rows.Err()
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/consts"
+)
+
+// Statuses of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint notified of the actions matching its filters. Empty filters
+// match every action. The secret is only returned when the webhook is created.
+type Webhook struct {
+	ID           int     `json:"id"`
+	URL          string  `json:"url"`
+	Secret       string  `json:"secret,omitempty"`
+	Address      string  `json:"address"`
+	ActionType   *int    `json:"action_type"`
+	ActionName   string  `json:"action_name"`
+	AssetAddress string  `json:"asset_address"`
+	MinAmount    *string `json:"min_amount"`
+	Active       bool    `json:"active"`
+	CreatedAt    string  `json:"created_at"`
+	UpdatedAt    string  `json:"updated_at"`
+}
+
+// WebhookDelivery is a notification of an action sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	BlockHeight    int64           `json:"block_height"`
+	TxHash         string          `json:"tx_hash"`
+	ActionIndex    int             `json:"action_index"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *string         `json:"next_attempt_at"`
+	LastAttemptAt  *string         `json:"last_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      string          `json:"created_at"`
+	DeliveredAt    *string         `json:"delivered_at"`
+}
+
+const webhookColumns = `id, url, address, action_type, asset_address, min_amount, active, created_at, updated_at`
+
+// CountWebhooks gets total count of registered webhooks
+func CountWebhooks(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM webhooks`).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting webhooks: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// webhookOrder sorts webhooks by the order they were registered
+var webhookOrder = keyset{{expr: "webhooks.id", cast: "bigint"}}
+
+// FetchWebhooks retrieves a page of registered webhooks and the cursor of the next page
+func FetchWebhooks(db *sql.DB, page Page) ([]Webhook, string, error) {
+	return fetchPage(db, webhookOrder, `SELECT `+webhookColumns+` FROM webhooks WHERE TRUE`, nil, page,
+		scanWebhooks, func(webhook Webhook) []interface{} { return []interface{}{webhook.ID} })
+}
+
+// FetchWebhook retrieves a single webhook without its secret
+func FetchWebhook(db *sql.DB, id int) (Webhook, error) {
+	return scanWebhook(db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+}
+
+// CreateWebhook registers a webhook and returns it with its secret
+func CreateWebhook(db *sql.DB, webhook Webhook) (Webhook, error) {
+	created, err := scanWebhook(db.QueryRow(`
+		INSERT INTO webhooks (url, secret, address, action_type, asset_address, min_amount, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW() AT TIME ZONE 'UTC', NOW() AT TIME ZONE 'UTC')
+		RETURNING `+webhookColumns,
+		webhook.URL, webhook.Secret, webhook.Address, webhook.ActionType, webhook.AssetAddress,
+		webhook.MinAmount, webhook.Active))
+	if err != nil {
+		return created, err
+	}
+	created.Secret = webhook.Secret
+	return created, nil
+}
+
+// UpdateWebhook replaces the URL, filters and state of a webhook. The secret is only
+// replaced when a new one is given.
+func UpdateWebhook(db *sql.DB, webhook Webhook) (Webhook, error) {
+	return scanWebhook(db.QueryRow(`
+		UPDATE webhooks
+		SET url = $2,
+		    secret = CASE WHEN $3 = '' THEN secret ELSE $3 END,
+		    address = $4,
+		    action_type = $5,
+		    asset_address = $6,
+		    min_amount = $7,
+		    active = $8,
+		    updated_at = NOW() AT TIME ZONE 'UTC'
+		WHERE id = $1
+		RETURNING `+webhookColumns,
+		webhook.ID, webhook.URL, webhook.Secret, webhook.Address, webhook.ActionType,
+		webhook.AssetAddress, webhook.MinAmount, webhook.Active))
+}
+
+// DeleteWebhook removes a webhook and its deliveries. It reports whether the webhook existed.
+func DeleteWebhook(db *sql.DB, id int) (bool, error) {
+	result, err := db.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// CountWebhookDeliveries gets total count of the deliveries of a webhook, optionally
+// filtered by status
+func CountWebhookDeliveries(db *sql.DB, webhookID int, status string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2)`, webhookID, status).Scan(&count)
+	if err != nil {
+		log.Printf("Error counting webhook deliveries: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// webhookDeliveryOrder sorts deliveries by the order they were queued
+var webhookDeliveryOrder = keyset{{expr: "webhook_deliveries.id", cast: "bigint", desc: true}}
+
+// FetchWebhookDeliveries retrieves a page of the deliveries of a webhook, most recent
+// first, and the cursor of the next page
+func FetchWebhookDeliveries(db *sql.DB, webhookID int, status string, page Page) ([]WebhookDelivery, string, error) {
+	return fetchPage(db, webhookDeliveryOrder, `
+		SELECT id, webhook_id, block_height, tx_hash, action_index, payload, status, attempts,
+		       CASE WHEN status = 'pending' THEN next_attempt_at END, last_attempt_at,
+		       response_status, last_error, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1 AND ($2 = '' OR status = $2)`,
+		[]interface{}{webhookID, status}, page, scanWebhookDeliveries,
+		func(delivery WebhookDelivery) []interface{} { return []interface{}{delivery.ID} })
+}
+
+func scanWebhook(row *sql.Row) (Webhook, error) {
+	var webhook Webhook
+	err := row.Scan(
+		&webhook.ID, &webhook.URL, &webhook.Address, &webhook.ActionType, &webhook.AssetAddress,
+		&webhook.MinAmount, &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt,
+	)
+	webhook.ActionName = webhookActionName(webhook.ActionType)
+	return webhook, err
+}
+
+func scanWebhooks(rows *sql.Rows) ([]Webhook, error) {
+	var webhooks []Webhook
+	for rows.Next() {
+		var webhook Webhook
+		if err := rows.Scan(
+			&webhook.ID, &webhook.URL, &webhook.Address, &webhook.ActionType, &webhook.AssetAddress,
+			&webhook.MinAmount, &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		webhook.ActionName = webhookActionName(webhook.ActionType)
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+func scanWebhookDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+		if err := rows.Scan(
+			&delivery.ID, &delivery.WebhookID, &delivery.BlockHeight, &delivery.TxHash,
+			&delivery.ActionIndex, &payload, &delivery.Status, &delivery.Attempts,
+			&delivery.NextAttemptAt, &delivery.LastAttemptAt, &delivery.ResponseStatus,
+			&delivery.LastError, &delivery.CreatedAt, &delivery.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		delivery.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// webhookActionName returns the name of the action type a webhook is filtered by
+func webhookActionName(actionType *int) string {
+	if actionType == nil {
+		return ""
+	}
+	return consts.ActionNames[uint8(*actionType)]
+}
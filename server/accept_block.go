@@ -7,24 +7,27 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/ava-labs/hypersdk/chain"
 	"github.com/ava-labs/hypersdk/codec"
 	pb "github.com/ava-labs/hypersdk/proto/pb/externalsubscriber"
-	"github.com/lib/pq"
 	"github.com/nuklai/nuklaivm-external-subscriber/consts"
-	"github.com/nuklai/nuklaivm-external-subscriber/db"
-	vmconsts "github.com/nuklai/nuklaivm/consts"
+	"github.com/nuklai/nuklaivm-external-subscriber/webhooks"
 	"github.com/nuklai/nuklaivm/vm"
 )
 
-// handleAcceptBlock processes a new block
-func handleAcceptBlock(dbConn *sql.DB, parser chain.Parser, req *pb.BlockRequest) error {
+// blockIngestLockID is the advisory lock key held while a block is written
+const blockIngestLockID = 7_200_231
+
+// handleAcceptBlock processes a new block. It returns the block once committed, or nil
+// when the block was already indexed.
+func handleAcceptBlock(dbConn *sql.DB, parser chain.Parser, req *pb.BlockRequest) (*chain.ExecutedBlock, error) {
 	if parser == nil {
 		log.Println("Parser is not initialized. Rejecting the request.")
-		return errors.New("parser not initialized")
+		return nil, errors.New("parser not initialized")
 	}
 
 	blockData := req.GetBlockData()
@@ -32,44 +35,61 @@ func handleAcceptBlock(dbConn *sql.DB, parser chain.Parser, req *pb.BlockRequest
 	executedBlock, err := chain.UnmarshalExecutedBlock(blockData, parser)
 	if err != nil {
 		log.Printf("Error parsing block data: %v\n", err)
-		return err
+		return nil, err
 	}
 
-	blk := executedBlock.Block
-	blockHeight := blk.Hght
+	blockHeight := executedBlock.Block.Hght
 
-	if blockHeight == 1 {
-		log.Println("First block detected (genesis). Resetting the database...")
-
-		// Drop all tables
-		_, err := dbConn.Exec(`
-			DROP TABLE IF EXISTS blocks, transactions, actions, assets CASCADE;
-		`)
-		if err != nil {
-			log.Printf("Error dropping existing tables: %v\n", err)
-			return err
-		}
+	// Write the whole block in a single transaction so a failure never leaves
+	// a partially indexed block behind
+	dbTx, err := dbConn.Begin()
+	if err != nil {
+		log.Printf("Error starting database transaction: %v\n", err)
+		return nil, err
+	}
+	defer dbTx.Rollback()
 
-		// Re-create the schema
-		err = db.CreateSchema(dbConn)
-		if err != nil {
-			log.Printf("Error re-creating schema: %v\n", err)
-			return err
-		}
-		log.Println("Database reset and schema re-created successfully.")
+	// Serialize block writes with any other process ingesting blocks (e.g. a backfill)
+	if _, err := dbTx.Exec(`SELECT pg_advisory_xact_lock($1)`, blockIngestLockID); err != nil {
+		log.Printf("Error acquiring block ingestion lock: %v\n", err)
+		return nil, err
 	}
 
-	err = processBlockData(dbConn, executedBlock)
+	indexed, err := processBlockData(dbTx, executedBlock)
 	if err != nil {
 		log.Printf("Error processing block data: %v\n", err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	// Queue the webhook notifications with the block, so they are sent if and only if it is committed
+	if indexed {
+		if _, err := webhooks.QueueDeliveries(dbTx, blockHeight); err != nil {
+			log.Printf("Error queuing webhook deliveries: %v\n", err)
+			return nil, err
+		}
+	}
+
+	// Keep the block bytes so the derived tables can be rebuilt by a reindex
+	if err := storeRawBlock(dbTx, executedBlock, blockData); err != nil {
+		log.Printf("Error archiving block data: %v\n", err)
+		return nil, err
+	}
+
+	if err := dbTx.Commit(); err != nil {
+		log.Printf("Error committing block %d: %v\n", blockHeight, err)
+		return nil, err
+	}
+
+	if !indexed {
+		return nil, nil
+	}
+	return executedBlock, nil
 }
 
-// processBlockData saves block data to the database
-func processBlockData(dbConn *sql.DB, executedBlock *chain.ExecutedBlock) error {
+// processBlockData saves block data to the database within the given transaction and
+// reports whether the block was written, which it is not when it is already indexed.
+// Any error aborts the block and the caller is expected to roll back.
+func processBlockData(dbTx *sql.Tx, executedBlock *chain.ExecutedBlock) (bool, error) {
 	blk := executedBlock.Block
 	blockHash := executedBlock.BlockID.String()
 	blockHeight := blk.Hght
@@ -85,8 +105,19 @@ func processBlockData(dbConn *sql.DB, executedBlock *chain.ExecutedBlock) error
 
 	log.Printf("Block Details: Height: %d, Hash: %s, ParentHash: %s, Transactions: %d\n", blockHeight, blockHash, parentHash, len(blk.Txs))
 
+	// Make sure the block extends the stored chain and roll back any orphaned blocks
+	alreadyIndexed, err := checkChainContinuity(dbTx, blockHeight, blockHash, parentHash)
+	if err != nil {
+		return false, err
+	}
+	if alreadyIndexed {
+		log.Printf("Block %d (%s) is already indexed. Skipping.\n", blockHeight, blockHash)
+		return false, nil
+	}
+
 	uniqueParticipants := make(map[string]struct{})
 	totalFee := uint64(0)
+	writer := newBlockWriter()
 
 	for i, tx := range blk.Txs {
 		txID := tx.ID().String()
@@ -112,8 +143,7 @@ func processBlockData(dbConn *sql.DB, executedBlock *chain.ExecutedBlock) error
 					if err == nil {
 						outputJSON, err := json.Marshal(r)
 						if err == nil {
-							var outputMap map[string]interface{}
-							json.Unmarshal(outputJSON, &outputMap)
+							outputMap, _ := decodeJSONMap(outputJSON)
 							outputs = append(outputs, outputMap)
 
 							// Add actor and receiver to uniqueParticipants and individual maps
@@ -136,6 +166,11 @@ func processBlockData(dbConn *sql.DB, executedBlock *chain.ExecutedBlock) error
 		log.Printf("\tTransaction %d: %s\n", i+1, txID)
 		log.Printf("\tOutputs: %v\n", outputs)
 
+		// The sponsor pays the fee before the actions run, even if the transaction fails
+		if err := balanceLedger.recordFee(dbTx, blockHeight, txID, sponsor, fee, timestamp); err != nil {
+			return false, fmt.Errorf("error recording fee of tx %s: %w", txID, err)
+		}
+
 		// Process and aggregate actions for the transaction
 		for j, action := range tx.Actions {
 			actionType := action.GetTypeID()
@@ -144,24 +179,22 @@ func processBlockData(dbConn *sql.DB, executedBlock *chain.ExecutedBlock) error
 				actionName = "Unknown"
 			}
 
-			actionInputJSON := "{}"
-			if inputDetails, err := json.Marshal(action); err != nil {
-				log.Printf("Error marshaling action input: %v\n", err)
-			} else {
-				actionInputJSON = string(inputDetails)
+			inputDetails, err := json.Marshal(action)
+			if err != nil {
+				return false, fmt.Errorf("error marshaling input of action %d in tx %s: %w", j, txID, err)
 			}
+			actionInputJSON := string(inputDetails)
 
+			// Failed transactions have no outputs
+			var actionOutput map[string]interface{}
 			actionOutputsJSON := "{}"
-			if j < len(outputs) {
-				actionOutputs := outputs[j]
-				if actionOutputs != nil {
-					actionOutputsBytes, err := json.Marshal(actionOutputs)
-					if err != nil {
-						log.Printf("Error marshaling action outputs: %v\n", err)
-					} else {
-						actionOutputsJSON = string(actionOutputsBytes)
-					}
+			if j < len(outputs) && outputs[j] != nil {
+				actionOutput = outputs[j]
+				actionOutputsBytes, err := json.Marshal(actionOutput)
+				if err != nil {
+					return false, fmt.Errorf("error marshaling output of action %d in tx %s: %w", j, txID, err)
 				}
+				actionOutputsJSON = string(actionOutputsBytes)
 			}
 
 			actionEntry := map[string]interface{}{
@@ -174,80 +207,57 @@ func processBlockData(dbConn *sql.DB, executedBlock *chain.ExecutedBlock) error
 
 			log.Printf("\t\tAction %d: Type: %d, Input: %s, Output: %s\n", j+1, actionType, actionInputJSON, actionOutputsJSON)
 
-			// Save the action in the actions table
-			_, err := dbConn.Exec(`
-				INSERT INTO actions (tx_hash, action_type, action_name, action_index, input, output, timestamp)
-				VALUES ($1, $2, $3, $4, $5::json, $6::json, $7)
-				ON CONFLICT (tx_hash, action_type, action_index) DO UPDATE
-				SET input = EXCLUDED.input,
-						output = EXCLUDED.output,
-						timestamp = EXCLUDED.timestamp`,
-				txID, actionType, actionName, j, actionInputJSON, actionOutputsJSON, timestamp)
-			if err != nil {
-				log.Printf("Error saving action to database: %v\n", err)
-			}
+			// Queue the action for the actions table
+			writer.addAction(txID, actionType, actionName, j, actionInputJSON, actionOutputsJSON, timestamp)
 
-			// Update the action total
-			if err := updateActionVolume(dbConn, actionType, actionName); err != nil {
-				log.Printf("Error updating action total: %v\n", err)
+			// Actions are only indexed when the transaction succeeded
+			if actionOutput == nil {
+				continue
 			}
 
-			// Handle special actions
-			// Parse actionInputJSON into map[string]interface{}
-			var actionInput map[string]interface{}
-			err = json.Unmarshal([]byte(actionInputJSON), &actionInput)
+			// Pass the action to the indexers registered for its type
+			actionInput, err := decodeJSONMap(inputDetails)
 			if err != nil {
-				log.Printf("Error unmarshaling action input: %v\n", err)
-				continue
+				return false, fmt.Errorf("error unmarshaling input of action %d in tx %s: %w", j, txID, err)
 			}
-			actionOutput := outputs[j]
-			var actionError error
-			switch actionType {
-			case vmconsts.CreateAssetID:
-				actionError = processCreateAssetID(dbConn, actionInput, actionOutput, sponsor, txID, timestamp)
-			case vmconsts.RegisterValidatorStakeID:
-				actionError = processRegisterValidatorStakeID(dbConn, actionOutput, sponsor, txID, timestamp)
-			}
-			if actionError != nil {
-				log.Printf("Error processing action '%s': %v\n", actionName, actionError)
-				return actionError
+			err = indexAction(dbTx, &IndexedAction{
+				BlockHeight: blockHeight,
+				TxHash:      txID,
+				Sponsor:     sponsor,
+				ActionType:  actionType,
+				ActionName:  actionName,
+				ActionIndex: j,
+				Input:       actionInput,
+				Output:      actionOutput,
+				Timestamp:   timestamp,
+			})
+			if err != nil {
+				return false, fmt.Errorf("error processing action '%s' in tx %s: %w", actionName, txID, err)
 			}
 		}
 
 		// Convert actions to JSON for storing in the transactions table
 		actionsJSON, err := json.Marshal(actions)
 		if err != nil {
-			log.Printf("Error marshaling actions: %v\n", err)
-			continue
+			return false, fmt.Errorf("error marshaling actions of tx %s: %w", txID, err)
 		}
 
 		// Convert actors and receivers to slices of strings
 		actorsSlice := getKeysFromMap(actors)
 		receiversSlice := getKeysFromMap(receivers)
 
-		// Save the transaction with aggregated actions
-		_, err = dbConn.Exec(`
-    INSERT INTO transactions (tx_hash, block_hash, sponsor, actors, receivers, max_fee, success, fee, actions, timestamp)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9::json, $10)
-    ON CONFLICT (tx_hash) DO UPDATE
-    SET block_hash = EXCLUDED.block_hash,
-        sponsor = EXCLUDED.sponsor,
-        actors = EXCLUDED.actors,
-        receivers = EXCLUDED.receivers,
-        max_fee = EXCLUDED.max_fee,
-        success = EXCLUDED.success,
-        fee = EXCLUDED.fee,
-        actions = EXCLUDED.actions,
-        timestamp = EXCLUDED.timestamp`,
-			txID, blockHash, sponsor, pq.Array(actorsSlice), pq.Array(receiversSlice),
-			tx.MaxFee(), success, fee, actionsJSON, timestamp)
-		if err != nil {
-			log.Printf("Error saving transaction to database: %v\n", err)
-		}
+		// Queue the transaction with aggregated actions
+		writer.addTransaction(txID, blockHash, sponsor, actorsSlice, receiversSlice,
+			tx.MaxFee(), success, fee, string(actionsJSON), timestamp)
+	}
+
+	// Write the queued actions and transactions with a few multi-row inserts
+	if err := writer.flush(dbTx); err != nil {
+		return false, fmt.Errorf("error saving block %d: %w", blockHeight, err)
 	}
 
 	// Save the new block data to the database
-	_, err := dbConn.Exec(`
+	_, err = dbTx.Exec(`
         INSERT INTO blocks (block_height, block_hash, parent_block_hash, state_root, block_size, tx_count, total_fee, avg_tx_size, unique_participants, timestamp)
         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
         ON CONFLICT (block_height) DO UPDATE
@@ -262,11 +272,10 @@ func processBlockData(dbConn *sql.DB, executedBlock *chain.ExecutedBlock) error
             timestamp = EXCLUDED.timestamp`,
 		blockHeight, blockHash, parentHash, stateRoot, blockSize, txCount, totalFee, avgTxSize, len(uniqueParticipants), timestamp)
 	if err != nil {
-		log.Printf("Error saving block to database: %v\n", err)
-		return err
+		return false, fmt.Errorf("error saving block %d: %w", blockHeight, err)
 	}
 
-	return nil
+	return true, nil
 }
 
 func getKeysFromMap(m map[string]struct{}) []string {
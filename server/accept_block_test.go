@@ -0,0 +1,73 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/hypersdk/auth"
+	"github.com/ava-labs/hypersdk/chain"
+	"github.com/ava-labs/hypersdk/crypto/ed25519"
+	"github.com/ava-labs/hypersdk/fees"
+	pb "github.com/ava-labs/hypersdk/proto/pb/externalsubscriber"
+	"github.com/nuklai/nuklaivm/vm"
+)
+
+func TestHandleAcceptBlockRollsBackOnFailedStatement(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+
+	parser, err := vm.CreateParser([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+	key, err := ed25519.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed transaction without fee is only written to the actions and transactions tables
+	timestamp := time.Now().Unix() * 1000
+	tx := signedTransfer(t, auth.NewED25519Factory(key), ids.GenerateTestID(), timestamp, auth.NewED25519Address(key.PublicKey()), 100)
+	executedBlock, err := chain.NewExecutedBlock(
+		&chain.StatelessBlock{Prnt: ids.GenerateTestID(), Tmstmp: timestamp, Hght: 10, Txs: []*chain.Transaction{tx}},
+		[]*chain.Result{{Success: false}},
+		fees.Dimensions{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockData, err := executedBlock.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).
+		WithArgs(blockIngestLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStoredBlockHash(mock, 10, "")
+	expectStoredBlockHash(mock, 9, "")
+	expectNoStoredChild(mock, 10)
+	insertErr := errors.New("actions insert failed")
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO actions`)).WillReturnError(insertErr)
+	// Nothing of the block is committed
+	mock.ExpectRollback()
+
+	indexed, err := handleAcceptBlock(dbConn, parser, &pb.BlockRequest{BlockData: blockData})
+	if !errors.Is(err, insertErr) || indexed != nil {
+		t.Fatalf("handleAcceptBlock() = %v, %v; want nil, %v", indexed, err, insertErr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeJSONMap decodes a JSON object keeping numbers as json.Number, so uint64
+// amounts above 2^53 are not rounded
+func decodeJSONMap(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stringValue returns the string stored under key, or an empty string if it is missing
+func stringValue(values map[string]interface{}, key string) string {
+	value, _ := values[key].(string)
+	return value
+}
+
+// addressValue returns the address stored under key without its 0x prefix. Action inputs
+// encode addresses with the prefix while action outputs do not.
+func addressValue(values map[string]interface{}, key string) string {
+	return strings.TrimPrefix(stringValue(values, key), "0x")
+}
+
+// uint64Value returns the unsigned integer stored under key
+func uint64Value(values map[string]interface{}, key string) (uint64, error) {
+	switch value := values[key].(type) {
+	case json.Number:
+		return strconv.ParseUint(value.String(), 10, 64)
+	case float64:
+		return uint64(value), nil
+	case nil:
+		return 0, fmt.Errorf("missing field %s", key)
+	default:
+		return 0, fmt.Errorf("field %s is not a number: %v", key, value)
+	}
+}
+
+// numericValue returns the number stored under key as an exact decimal string that can
+// be written to NUMERIC columns
+func numericValue(values map[string]interface{}, key string) (string, error) {
+	switch value := values[key].(type) {
+	case json.Number:
+		return value.String(), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case nil:
+		return "", fmt.Errorf("missing field %s", key)
+	default:
+		return "", fmt.Errorf("field %s is not a number: %v", key, value)
+	}
+}
+
+// bytesValue returns the bytes stored under key, which encoding/json marshals as base64
+func bytesValue(values map[string]interface{}, key string) ([]byte, error) {
+	value, ok := values[key].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing field %s", key)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("field %s is not base64 encoded: %w", key, err)
+	}
+	return decoded, nil
+}
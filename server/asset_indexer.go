@@ -0,0 +1,234 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+var assetTypes = map[uint64]string{0: "fungible", 1: "non-fungible", 2: "fractional"}
+
+// assetUpdateColumns lists the asset columns that can be changed by UpdateAsset. The
+// UpdateAsset output only sets the fields that were changed.
+var assetUpdateColumns = []string{
+	"name",
+	"symbol",
+	"metadata",
+	"max_supply",
+	"owner",
+	"mint_admin",
+	"pause_unpause_admin",
+	"freeze_unfreeze_admin",
+	"enable_disable_kyc_account_admin",
+}
+
+// assetIndexer keeps the assets table in sync with CreateAsset and UpdateAsset actions
+// and records every change in asset_history
+type assetIndexer struct{}
+
+func init() {
+	indexer := &assetIndexer{}
+	RegisterActionIndexer(vmconsts.CreateAssetID, indexer)
+	RegisterActionIndexer(vmconsts.UpdateAssetID, indexer)
+}
+
+func (*assetIndexer) Tables() []string {
+	return []string{"assets", "asset_history"}
+}
+
+func (i *assetIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.CreateAssetID:
+		return i.indexCreateAsset(dbTx, action)
+	case vmconsts.UpdateAssetID:
+		return i.indexUpdateAsset(dbTx, action)
+	}
+	return nil
+}
+
+func (*assetIndexer) indexCreateAsset(dbTx *sql.Tx, action *IndexedAction) error {
+	assetID := stringValue(action.Output, "asset_address")
+	assetTypeID, err := uint64Value(action.Input, "asset_type")
+	if err != nil {
+		return err
+	}
+	assetType := assetTypes[assetTypeID]
+
+	// Insert asset into the assets table
+	_, err = dbTx.Exec(`
+        INSERT INTO assets (
+            asset_address, asset_type_id, asset_type, asset_creator, tx_hash, name, symbol, decimals, metadata, max_supply, owner, mint_admin, pause_unpause_admin, freeze_unfreeze_admin, enable_disable_kyc_account_admin, timestamp
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+        ON CONFLICT (asset_address) DO UPDATE
+        SET asset_type_id = EXCLUDED.asset_type_id,
+            asset_type = EXCLUDED.asset_type,
+            asset_creator = EXCLUDED.asset_creator,
+            tx_hash = EXCLUDED.tx_hash,
+            name = EXCLUDED.name,
+            symbol = EXCLUDED.symbol,
+            decimals = EXCLUDED.decimals,
+            metadata = EXCLUDED.metadata,
+            max_supply = EXCLUDED.max_supply,
+            owner = EXCLUDED.owner,
+            mint_admin = EXCLUDED.mint_admin,
+            pause_unpause_admin = EXCLUDED.pause_unpause_admin,
+            freeze_unfreeze_admin = EXCLUDED.freeze_unfreeze_admin,
+            enable_disable_kyc_account_admin = EXCLUDED.enable_disable_kyc_account_admin,
+            timestamp = EXCLUDED.timestamp
+    `, assetID, assetTypeID, assetType, action.Sponsor, action.TxHash,
+		action.Input["name"], action.Input["symbol"], action.Input["decimals"],
+		action.Input["metadata"], action.Input["max_supply"], action.Sponsor, action.Input["mint_admin"],
+		action.Input["pause_unpause_admin"], action.Input["freeze_unfreeze_admin"],
+		action.Input["enable_disable_kyc_account_admin"], action.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	initialValues := map[string]interface{}{"owner": action.Sponsor}
+	for _, column := range assetUpdateColumns {
+		if value, ok := action.Input[column]; ok {
+			initialValues[column] = value
+		}
+	}
+	return insertAssetHistory(dbTx, assetID, action, initialValues, map[string]interface{}{})
+}
+
+func (*assetIndexer) indexUpdateAsset(dbTx *sql.Tx, action *IndexedAction) error {
+	assetID := addressValue(action.Input, "asset_address")
+
+	changedFields := map[string]interface{}{}
+	for _, column := range assetUpdateColumns {
+		if column == "max_supply" {
+			if maxSupply, err := numericValue(action.Output, column); err == nil && maxSupply != "0" {
+				changedFields[column] = json.Number(maxSupply)
+			}
+			continue
+		}
+		if value := stringValue(action.Output, column); value != "" {
+			changedFields[column] = value
+		}
+	}
+	if len(changedFields) == 0 {
+		return nil
+	}
+
+	previousValues, err := fetchAssetValues(dbTx, assetID, changedFields)
+	if err != nil {
+		return err
+	}
+
+	if err := updateAssetColumns(dbTx, assetID, changedFields); err != nil {
+		return err
+	}
+	return insertAssetHistory(dbTx, assetID, action, changedFields, previousValues)
+}
+
+// Rollback restores the values replaced by orphaned UpdateAsset actions, newest first,
+// and removes the assets created in orphaned blocks
+func (*assetIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	rows, err := dbTx.Query(`
+		SELECT asset_address, previous_values
+		FROM asset_history
+		WHERE block_height >= $1 AND action_name = 'UpdateAsset'
+		ORDER BY block_height DESC, id DESC`, fromHeight)
+	if err != nil {
+		return err
+	}
+
+	type assetChange struct {
+		assetAddress   string
+		previousValues map[string]interface{}
+	}
+	var changes []assetChange
+	for rows.Next() {
+		var (
+			change             assetChange
+			previousValuesJSON []byte
+		)
+		if err := rows.Scan(&change.assetAddress, &previousValuesJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		if change.previousValues, err = decodeJSONMap(previousValuesJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		changes = append(changes, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if err := updateAssetColumns(dbTx, change.assetAddress, change.previousValues); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM asset_history WHERE block_height >= $1`, fromHeight); err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(`DELETE FROM assets WHERE tx_hash IN (`+orphanedTxsQuery+`)`, fromHeight)
+	return err
+}
+
+// fetchAssetValues returns the current values of the given asset columns
+func fetchAssetValues(dbTx *sql.Tx, assetAddress string, columns map[string]interface{}) (map[string]interface{}, error) {
+	var valuesJSON []byte
+	err := dbTx.QueryRow(`
+		SELECT json_build_object(
+			'name', name, 'symbol', symbol, 'metadata', metadata, 'max_supply', max_supply,
+			'owner', COALESCE(owner, asset_creator), 'mint_admin', mint_admin,
+			'pause_unpause_admin', pause_unpause_admin, 'freeze_unfreeze_admin', freeze_unfreeze_admin,
+			'enable_disable_kyc_account_admin', enable_disable_kyc_account_admin
+		)
+		FROM assets
+		WHERE asset_address = $1`, assetAddress).Scan(&valuesJSON)
+	if err == sql.ErrNoRows {
+		log.Printf("Asset %s updated before it was indexed\n", assetAddress)
+		return map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := decodeJSONMap(valuesJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	previousValues := make(map[string]interface{}, len(columns))
+	for column := range columns {
+		previousValues[column] = current[column]
+	}
+	return previousValues, nil
+}
+
+// updateAssetColumns sets the given asset columns. Only columns from assetUpdateColumns are written.
+func updateAssetColumns(dbTx *sql.Tx, assetAddress string, values map[string]interface{}) error {
+	return updateColumns(dbTx, "assets", "asset_address", assetAddress, assetUpdateColumns, values)
+}
+
+func insertAssetHistory(dbTx *sql.Tx, assetAddress string, action *IndexedAction, changedFields, previousValues map[string]interface{}) error {
+	changedJSON, err := json.Marshal(changedFields)
+	if err != nil {
+		return err
+	}
+	previousValuesJSON, err := json.Marshal(previousValues)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO asset_history (asset_address, tx_hash, block_height, action_name, changed_fields, previous_values, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		assetAddress, action.TxHash, action.BlockHeight, action.ActionName, changedJSON, previousValuesJSON, action.Timestamp)
+	return err
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestAssetIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "create asset",
+			actionType: vmconsts.CreateAssetID,
+			actionName: "CreateAsset",
+			input:      `{"asset_type":1,"name":"Token","symbol":"TKN","decimals":0,"metadata":"meta","max_supply":1000,"mint_admin":"admin1"}`,
+			output:     `{"asset_address":"asset1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO assets`)).
+					WithArgs("asset1", uint64(1), "non-fungible", "sponsor1", "tx1",
+						"Token", "TKN", "0", "meta", "1000", "sponsor1", "admin1",
+						nil, nil, nil, "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO asset_history`)).
+					WithArgs("asset1", "tx1", uint64(10), "CreateAsset",
+						[]byte(`{"max_supply":1000,"metadata":"meta","mint_admin":"admin1","name":"Token","owner":"sponsor1","symbol":"TKN"}`),
+						[]byte(`{}`), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "create asset without asset type",
+			actionType: vmconsts.CreateAssetID,
+			actionName: "CreateAsset",
+			input:      `{"name":"Token"}`,
+			output:     `{"asset_address":"asset1"}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+		{
+			name:       "update asset without changes",
+			actionType: vmconsts.UpdateAssetID,
+			actionName: "UpdateAsset",
+			input:      `{"asset_address":"0xasset1"}`,
+			output:     `{"max_supply":0}`,
+			expect:     func(sqlmock.Sqlmock) {},
+		},
+		{
+			name:       "update asset name",
+			actionType: vmconsts.UpdateAssetID,
+			actionName: "UpdateAsset",
+			input:      `{"asset_address":"0xasset1","name":"New Token"}`,
+			output:     `{"name":"New Token"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT json_build_object(`)).
+					WithArgs("asset1").
+					WillReturnRows(sqlmock.NewRows([]string{"json_build_object"}).
+						AddRow([]byte(`{"name":"Token","symbol":"TKN"}`)))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE assets SET name = $1 WHERE asset_address = $2`)).
+					WithArgs("New Token", "asset1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO asset_history`)).
+					WithArgs("asset1", "tx1", uint64(10), "UpdateAsset",
+						[]byte(`{"name":"New Token"}`), []byte(`{"name":"Token"}`), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&assetIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAssetIndexerRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes *sqlmock.Rows
+		expect  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:    "no updates to revert",
+			changes: sqlmock.NewRows([]string{"asset_address", "previous_values"}),
+			expect:  func(sqlmock.Sqlmock) {},
+		},
+		{
+			name: "reverts updates newest first",
+			changes: sqlmock.NewRows([]string{"asset_address", "previous_values"}).
+				AddRow("asset1", []byte(`{"name":"Second"}`)).
+				AddRow("asset1", []byte(`{"name":"First","symbol":"FST"}`)),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE assets SET name = $1 WHERE asset_address = $2`)).
+					WithArgs("Second", "asset1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE assets SET name = $1, symbol = $2 WHERE asset_address = $3`)).
+					WithArgs("First", "FST", "asset1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM asset_history`)).
+				WithArgs(uint64(10)).
+				WillReturnRows(tt.changes)
+			tt.expect(mock)
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM asset_history WHERE block_height >= $1`)).
+				WithArgs(uint64(10)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM assets WHERE tx_hash IN`)).
+				WithArgs(uint64(10)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			if err := (&assetIndexer{}).Rollback(dbTx, 10); err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAssetIndexerRollbackRestoresRows(t *testing.T) {
+	createAsset := `{"asset_type":0,"name":"Token","symbol":"TKN","decimals":2,"metadata":"meta","max_supply":1000,"mint_admin":"admin1"}`
+	testIndexerRollback(t, &assetIndexer{}, 3, []testAction{
+		{height: 1, actionType: vmconsts.CreateAssetID, actionName: "CreateAsset", input: createAsset, output: `{"asset_address":"asset1"}`},
+		{
+			height: 2, actionType: vmconsts.UpdateAssetID, actionName: "UpdateAsset",
+			input: `{"asset_address":"0xasset1","name":"Second"}`, output: `{"name":"Second"}`,
+		},
+		// The orphaned updates are reverted newest first and the orphaned asset is removed
+		{
+			height: 3, actionType: vmconsts.UpdateAssetID, actionName: "UpdateAsset",
+			input: `{"asset_address":"0xasset1","name":"Third","symbol":"TRD"}`, output: `{"name":"Third","symbol":"TRD"}`,
+		},
+		{
+			height: 4, actionType: vmconsts.UpdateAssetID, actionName: "UpdateAsset",
+			input: `{"asset_address":"0xasset1","name":"Fourth","owner":"owner2"}`, output: `{"name":"Fourth","owner":"owner2"}`,
+		},
+		{height: 4, actionType: vmconsts.CreateAssetID, actionName: "CreateAsset", input: createAsset, output: `{"asset_address":"asset2"}`},
+	})
+}
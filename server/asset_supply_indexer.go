@@ -0,0 +1,101 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/lib/pq"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+// assetSupplyIndexer tracks the minted, burned and current supply of fungible assets
+// and records every change in asset_supply_changes
+type assetSupplyIndexer struct{}
+
+func init() {
+	indexer := &assetSupplyIndexer{}
+	RegisterActionIndexer(vmconsts.MintAssetFTID, indexer)
+	RegisterActionIndexer(vmconsts.BurnAssetFTID, indexer)
+}
+
+func (*assetSupplyIndexer) Tables() []string {
+	return []string{"asset_supply_changes"}
+}
+
+func (*assetSupplyIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	assetAddress := addressValue(action.Input, "asset_address")
+	amount, err := numericValue(action.Input, "value")
+	if err != nil {
+		return err
+	}
+
+	mintedAmount, burnedAmount := amount, "0"
+	if action.ActionType == vmconsts.BurnAssetFTID {
+		mintedAmount, burnedAmount = "0", amount
+	}
+
+	var totalMinted, totalBurned, currentSupply string
+	err = dbTx.QueryRow(`
+		UPDATE assets
+		SET total_minted = total_minted + $2,
+		    total_burned = total_burned + $3,
+		    current_supply = current_supply + $2 - $3
+		WHERE asset_address = $1
+		RETURNING total_minted, total_burned, current_supply`,
+		assetAddress, mintedAmount, burnedAmount).Scan(&totalMinted, &totalBurned, &currentSupply)
+	if err == sql.ErrNoRows {
+		log.Printf("Supply of asset %s changed before it was indexed\n", assetAddress)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO asset_supply_changes (
+			asset_address, tx_hash, block_height, action_name, minted, burned, total_minted, total_burned, current_supply, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		assetAddress, action.TxHash, action.BlockHeight, action.ActionName, mintedAmount, burnedAmount,
+		totalMinted, totalBurned, currentSupply, action.Timestamp)
+	return err
+}
+
+// Rollback removes the supply changes of orphaned blocks and restores the totals of the
+// affected assets from their latest remaining change
+func (*assetSupplyIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	var assetAddresses []string
+	err := dbTx.QueryRow(`
+		SELECT COALESCE(ARRAY_AGG(DISTINCT asset_address), '{}')
+		FROM asset_supply_changes
+		WHERE block_height >= $1`, fromHeight).Scan(pq.Array(&assetAddresses))
+	if err != nil {
+		return err
+	}
+	if len(assetAddresses) == 0 {
+		return nil
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM asset_supply_changes WHERE block_height >= $1`, fromHeight); err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		UPDATE assets
+		SET total_minted = COALESCE(latest.total_minted, 0),
+		    total_burned = COALESCE(latest.total_burned, 0),
+		    current_supply = COALESCE(latest.current_supply, 0)
+		FROM UNNEST($1::TEXT[]) AS affected(asset_address)
+		LEFT JOIN LATERAL (
+			SELECT total_minted, total_burned, current_supply
+			FROM asset_supply_changes
+			WHERE asset_supply_changes.asset_address = affected.asset_address
+			ORDER BY block_height DESC, id DESC
+			LIMIT 1
+		) AS latest ON true
+		WHERE assets.asset_address = affected.asset_address`, pq.Array(assetAddresses))
+	return err
+}
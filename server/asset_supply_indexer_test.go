@@ -0,0 +1,154 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestAssetSupplyIndexerIndex(t *testing.T) {
+	totals := []string{"total_minted", "total_burned", "current_supply"}
+
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "mint",
+			actionType: vmconsts.MintAssetFTID,
+			actionName: "MintAssetFT",
+			input:      `{"asset_address":"0xasset1","value":500}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`UPDATE assets`)).
+					WithArgs("asset1", "500", "0").
+					WillReturnRows(sqlmock.NewRows(totals).AddRow("500", "0", "500"))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO asset_supply_changes`)).
+					WithArgs("asset1", "tx1", uint64(10), "MintAssetFT", "500", "0", "500", "0", "500", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "burn",
+			actionType: vmconsts.BurnAssetFTID,
+			actionName: "BurnAssetFT",
+			input:      `{"asset_address":"0xasset1","value":200}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`UPDATE assets`)).
+					WithArgs("asset1", "0", "200").
+					WillReturnRows(sqlmock.NewRows(totals).AddRow("500", "200", "300"))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO asset_supply_changes`)).
+					WithArgs("asset1", "tx1", uint64(10), "BurnAssetFT", "0", "200", "500", "200", "300", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "asset not indexed",
+			actionType: vmconsts.MintAssetFTID,
+			actionName: "MintAssetFT",
+			input:      `{"asset_address":"0xasset1","value":500}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`UPDATE assets`)).
+					WithArgs("asset1", "500", "0").
+					WillReturnRows(sqlmock.NewRows(totals))
+			},
+		},
+		{
+			name:       "missing value",
+			actionType: vmconsts.MintAssetFTID,
+			actionName: "MintAssetFT",
+			input:      `{"asset_address":"0xasset1"}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&assetSupplyIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      map[string]interface{}{},
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAssetSupplyIndexerRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected string
+		expect   func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:     "no supply changes",
+			affected: "{}",
+			expect:   func(sqlmock.Sqlmock) {},
+		},
+		{
+			name:     "restores the totals of the affected assets",
+			affected: "{asset1,asset2}",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM asset_supply_changes WHERE block_height >= $1`)).
+					WithArgs(uint64(10)).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE assets`)).
+					WithArgs(pq.Array([]string{"asset1", "asset2"})).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM asset_supply_changes`)).
+				WithArgs(uint64(10)).
+				WillReturnRows(sqlmock.NewRows([]string{"asset_addresses"}).AddRow([]byte(tt.affected)))
+			tt.expect(mock)
+
+			if err := (&assetSupplyIndexer{}).Rollback(dbTx, 10); err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAssetSupplyIndexerRollbackRestoresRows(t *testing.T) {
+	testIndexerRollback(t, &assetSupplyIndexer{}, 3, []testAction{
+		{
+			height: 1, actionType: vmconsts.CreateAssetID, actionName: "CreateAsset", indexer: &assetIndexer{},
+			input: `{"asset_type":0,"name":"Token","symbol":"TKN","decimals":2}`, output: `{"asset_address":"asset1"}`,
+		},
+		{height: 2, actionType: vmconsts.MintAssetFTID, actionName: "MintAssetFT", input: `{"asset_address":"0xasset1","value":500}`},
+		// The totals of the asset are restored from the mint of block 2
+		{height: 3, actionType: vmconsts.BurnAssetFTID, actionName: "BurnAssetFT", input: `{"asset_address":"0xasset1","value":100}`},
+		{height: 4, actionType: vmconsts.MintAssetFTID, actionName: "MintAssetFT", input: `{"asset_address":"0xasset1","value":50}`},
+	})
+}
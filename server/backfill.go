@@ -0,0 +1,130 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ava-labs/hypersdk/chain"
+	pb "github.com/ava-labs/hypersdk/proto/pb/externalsubscriber"
+)
+
+// backfillBlock is a serialized block waiting to be ingested
+type backfillBlock struct {
+	path   string
+	height uint64
+}
+
+// Backfill ingests serialized ExecutedBlock bytes from a file or from every file in a
+// directory. Each file holds one block exactly as received in BlockRequest.BlockData.
+// Blocks are ingested in height order through the same path as AcceptBlock. Indexers
+// apply each action on top of the rows of the blocks indexed before it, so when a block
+// is indexed below an already indexed block, every indexed table is rebuilt in chain order.
+func Backfill(dbConn *sql.DB, path string) error {
+	parser, _, err := loadParserFromDB(dbConn)
+	if err != nil {
+		return fmt.Errorf("error loading parser from stored genesis: %w", err)
+	}
+
+	files, err := listBackfillFiles(path)
+	if err != nil {
+		return err
+	}
+
+	blocks, err := readBackfillBlocks(parser, files)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Backfilling %d blocks from %s\n", len(blocks), path)
+	outOfOrder := false
+	for _, blk := range blocks {
+		blockData, err := os.ReadFile(blk.path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", blk.path, err)
+		}
+
+		mu.Lock()
+		indexed, err := handleAcceptBlock(dbConn, parser, &pb.BlockRequest{BlockData: blockData})
+		mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("error ingesting block %d from %s: %w", blk.height, blk.path, err)
+		}
+
+		// A block stored above it was indexed first, whether before the backfill started
+		// or while it ran
+		if indexed != nil && !outOfOrder {
+			if outOfOrder, err = hasBlocksAbove(dbConn, blk.height); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Backfill of %d blocks completed\n", len(blocks))
+	if outOfOrder {
+		log.Println("Blocks were indexed out of chain order. Rebuilding the indexed tables...")
+		if err := ReindexActions(dbConn, nil); err != nil {
+			return fmt.Errorf("error rebuilding the indexed tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// hasBlocksAbove reports whether a block above the given height is stored
+func hasBlocksAbove(dbConn *sql.DB, height uint64) (bool, error) {
+	var exists bool
+	err := dbConn.QueryRow(`SELECT EXISTS (SELECT 1 FROM blocks WHERE block_height > $1)`, height).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking blocks above %d: %w", height, err)
+	}
+	return exists, nil
+}
+
+// readBackfillBlocks reads the height of the block in each file and returns the blocks
+// sorted by height
+func readBackfillBlocks(parser chain.Parser, files []string) ([]backfillBlock, error) {
+	blocks := make([]backfillBlock, 0, len(files))
+	for _, file := range files {
+		blockData, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", file, err)
+		}
+		executedBlock, err := chain.UnmarshalExecutedBlock(blockData, parser)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing block in %s: %w", file, err)
+		}
+		blocks = append(blocks, backfillBlock{path: file, height: executedBlock.Block.Hght})
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].height < blocks[j].height })
+	return blocks, nil
+}
+
+// listBackfillFiles returns the path itself for a file, or the regular files of a directory
+func listBackfillFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backfill path: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backfill directory: %w", err)
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,191 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/hypersdk/chain"
+	"github.com/ava-labs/hypersdk/fees"
+	"github.com/nuklai/nuklaivm/vm"
+)
+
+func TestReadBackfillBlocksSortsByHeight(t *testing.T) {
+	parser, err := vm.CreateParser([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	// The files are named so that their directory order differs from their height order
+	dir := t.TempDir()
+	for i, height := range []uint64{30, 10, 20} {
+		executedBlock := &chain.ExecutedBlock{
+			BlockID: ids.GenerateTestID(),
+			Block:   &chain.StatelessBlock{Prnt: ids.GenerateTestID(), Tmstmp: 1, Hght: height},
+		}
+		blockData, err := executedBlock.Marshal()
+		if err != nil {
+			t.Fatalf("error marshaling block %d: %v", height, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("block_%d", i)), blockData, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := listBackfillFiles(dir)
+	if err != nil {
+		t.Fatalf("listBackfillFiles() error = %v", err)
+	}
+	blocks, err := readBackfillBlocks(parser, files)
+	if err != nil {
+		t.Fatalf("readBackfillBlocks() error = %v", err)
+	}
+
+	want := []backfillBlock{
+		{path: filepath.Join(dir, "block_1"), height: 10},
+		{path: filepath.Join(dir, "block_2"), height: 20},
+		{path: filepath.Join(dir, "block_0"), height: 30},
+	}
+	if !reflect.DeepEqual(blocks, want) {
+		t.Fatalf("readBackfillBlocks() = %v, want %v", blocks, want)
+	}
+}
+
+// writeBackfillBlock marshals the block into a file of dir
+func writeBackfillBlock(t *testing.T, dir string, executedBlock *chain.ExecutedBlock) {
+	t.Helper()
+
+	blockData, err := executedBlock.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling block %d: %v", executedBlock.Block.Hght, err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("block_%d", executedBlock.Block.Hght))
+	if err := os.WriteFile(path, blockData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectEmptyBlockWrite expects a block without transactions to be written in its own
+// transaction, with a stored child referencing it if hasChild is set
+func expectEmptyBlockWrite(mock sqlmock.Sqlmock, executedBlock *chain.ExecutedBlock, hasChild bool) {
+	height := executedBlock.Block.Hght
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).
+		WithArgs(blockIngestLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStoredBlockHash(mock, height, "")
+	expectStoredBlockHash(mock, height-1, "")
+	child := sqlmock.NewRows([]string{"parent_block_hash"})
+	if hasChild {
+		child.AddRow(executedBlock.BlockID.String())
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT parent_block_hash FROM blocks WHERE block_height = $1`)).
+		WithArgs(height + 1).
+		WillReturnRows(child)
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO blocks`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM webhooks`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "action_type", "asset_address", "min_amount"}))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO raw_blocks`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestBackfillRebuildsIndexedTablesBelowTip(t *testing.T) {
+	tests := []struct {
+		name        string
+		blockAbove  bool
+		wantRebuilt []string
+	}{
+		{name: "block indexed above", blockAbove: true, wantRebuilt: []string{"first", "second"}},
+		{name: "highest block", blockAbove: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConn, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error creating mock database: %v", err)
+			}
+			defer dbConn.Close()
+			// A reindex starts by rolling back every row of the indexer
+			rebuilt := recordIndexerRollbacks(t)
+
+			dir := t.TempDir()
+			executedBlock, err := chain.NewExecutedBlock(
+				&chain.StatelessBlock{Prnt: ids.GenerateTestID(), Tmstmp: 1, Hght: 10}, nil, fees.Dimensions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeBackfillBlock(t, dir, executedBlock)
+
+			mock.ExpectQuery(regexp.QuoteMeta(storedGenesisQuery)).
+				WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(`{}`))
+
+			// The empty block fills the gap below block 11
+			expectEmptyBlockWrite(mock, executedBlock, true)
+
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM blocks WHERE block_height > $1)`)).
+				WithArgs(uint64(10)).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.blockAbove))
+
+			// Every indexer is rebuilt from the stored actions in chain order
+			if tt.blockAbove {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).
+					WithArgs(blockIngestLockID).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				for range tt.wantRebuilt {
+					mock.ExpectQuery(regexp.QuoteMeta(`SELECT MIN(block_height), MAX(block_height) FROM blocks`)).
+						WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(nil, nil))
+				}
+				mock.ExpectCommit()
+			}
+
+			if err := Backfill(dbConn, dir); err != nil {
+				t.Fatalf("Backfill() error = %v", err)
+			}
+			if !reflect.DeepEqual(*rebuilt, tt.wantRebuilt) {
+				t.Fatalf("rebuilt %v, want %v", *rebuilt, tt.wantRebuilt)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBackfillBelowIndexedBlockKeepsLatestBalances(t *testing.T) {
+	dbConn := newTestDatabase(t)
+
+	if _, err := dbConn.Exec(`INSERT INTO genesis_data (data) VALUES ($1::json)`, `{}`); err != nil {
+		t.Fatal(err)
+	}
+	parser, err := vm.CreateParser([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	transfers := newTestTransfers(t)
+	first := transfers.block(1, ids.GenerateTestID(), true, 900, 100)
+	second := transfers.block(2, first.BlockID, true, 800, 200)
+
+	// Block 2 is indexed before block 1 is backfilled
+	indexTestBlock(t, dbConn, parser, second)
+	dir := t.TempDir()
+	writeBackfillBlock(t, dir, first)
+	if err := Backfill(dbConn, dir); err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+
+	// The balances reported by block 2 are kept, with the fees of both blocks applied before them
+	want := map[string]string{transfers.sender.String(): "800", transfers.receiver.String(): "200"}
+	if balances := naiBalances(t, dbConn); !reflect.DeepEqual(balances, want) {
+		t.Fatalf("balances = %v, want %v", balances, want)
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+	"github.com/nuklai/nuklaivm/storage"
+)
+
+// genesisActionName is recorded in balance_changes for the allocations of the genesis
+const genesisActionName = "Genesis"
+
+// feeActionName is recorded in balance_changes for the fees paid by transaction sponsors
+const feeActionName = "Fee"
+
+// balanceUpdate is a single balance reported by, or derived from, an action output.
+// Absolute updates replace the stored balance while relative updates are added to it.
+type balanceUpdate struct {
+	address      string
+	assetAddress string
+	amount       string
+	relative     bool
+}
+
+// balanceIndexer maintains the balance of every address in every asset from the action
+// outputs reporting balances and records every change in balance_changes
+type balanceIndexer struct {
+	naiAddress string
+}
+
+func init() {
+	indexer := &balanceIndexer{naiAddress: storage.NAIAddress.String()}
+	for _, actionType := range []uint8{
+		vmconsts.TransferID,
+		vmconsts.CreateAssetID,
+		vmconsts.MintAssetFTID,
+		vmconsts.MintAssetNFTID,
+		vmconsts.BurnAssetFTID,
+		vmconsts.BurnAssetNFTID,
+		vmconsts.RegisterValidatorStakeID,
+		vmconsts.WithdrawValidatorStakeID,
+		vmconsts.ClaimValidatorStakeRewardsID,
+		vmconsts.DelegateUserStakeID,
+		vmconsts.UndelegateUserStakeID,
+		vmconsts.ClaimDelegationStakeRewardsID,
+		vmconsts.InitiateContributeDatasetID,
+		vmconsts.CompleteContributeDatasetID,
+		vmconsts.SubscribeDatasetMarketplaceID,
+		vmconsts.ClaimMarketplacePaymentID,
+		vmconsts.ContractCallID,
+	} {
+		RegisterActionIndexer(actionType, indexer)
+	}
+	balanceLedger = indexer
+}
+
+// balanceLedger is the registered balanceIndexer, used for the fees and genesis allocations
+// that are not part of any action
+var balanceLedger *balanceIndexer
+
+func (*balanceIndexer) Tables() []string {
+	return []string{"balances", "balance_changes"}
+}
+
+func (b *balanceIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	updates, err := b.balanceUpdates(action)
+	if err != nil {
+		return err
+	}
+
+	for _, update := range updates {
+		if update.address == "" || update.assetAddress == "" {
+			continue
+		}
+		err := recordBalance(dbTx, update, action.TxHash, action.BlockHeight, action.ActionName, action.Timestamp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// balanceUpdates returns the balances reported by the output of the action
+func (b *balanceIndexer) balanceUpdates(action *IndexedAction) ([]balanceUpdate, error) {
+	actor := addressValue(action.Output, "actor")
+	if actor == "" {
+		actor = action.Sponsor
+	}
+	assetAddress := addressValue(action.Input, "asset_address")
+
+	updates := &balanceUpdateSet{output: action.Output}
+	switch action.ActionType {
+	case vmconsts.TransferID:
+		updates.set(actor, assetAddress, "sender_balance")
+		updates.set(addressValue(action.Input, "to"), assetAddress, "receiver_balance")
+	case vmconsts.CreateAssetID:
+		// Fractional assets mint one unit and the parent NFT of their dataset to the creator
+		if parentNFT := addressValue(action.Output, "dataset_parent_nft_address"); parentNFT != "" {
+			updates.setAmount(actor, addressValue(action.Output, "asset_address"), "1")
+			updates.setAmount(actor, parentNFT, "1")
+		}
+	case vmconsts.MintAssetFTID:
+		updates.set(addressValue(action.Input, "to"), assetAddress, "new_balance")
+	case vmconsts.MintAssetNFTID:
+		to := addressValue(action.Input, "to")
+		updates.set(to, assetAddress, "new_balance")
+		updates.setAmount(to, addressValue(action.Output, "asset_nft_address"), "1")
+	case vmconsts.BurnAssetFTID, vmconsts.BurnAssetNFTID:
+		updates.set(actor, assetAddress, "new_balance")
+	case vmconsts.RegisterValidatorStakeID:
+		updates.add(actor, b.naiAddress, "staked_amount", true)
+	case vmconsts.DelegateUserStakeID:
+		updates.set(actor, b.naiAddress, "balance_after_stake")
+	case vmconsts.WithdrawValidatorStakeID, vmconsts.UndelegateUserStakeID:
+		updates.set(addressValue(action.Output, "distributed_to"), b.naiAddress, "balance_after_unstake")
+	case vmconsts.ClaimValidatorStakeRewardsID, vmconsts.ClaimDelegationStakeRewardsID:
+		updates.set(addressValue(action.Output, "distributed_to"), b.naiAddress, "balance_after_claim")
+	case vmconsts.InitiateContributeDatasetID:
+		updates.add(actor, addressValue(action.Output, "collateral_asset_address"), "collateral_amount_taken", true)
+	case vmconsts.CompleteContributeDatasetID:
+		updates.add(addressValue(action.Output, "receiver"), addressValue(action.Output, "collateral_asset_address"), "collateral_amount_refunded", false)
+	case vmconsts.SubscribeDatasetMarketplaceID:
+		updates.add(actor, addressValue(action.Output, "payment_asset_address"), "total_cost", true)
+	case vmconsts.ClaimMarketplacePaymentID:
+		updates.add(actor, addressValue(action.Input, "payment_asset_address"), "distributed_reward", false)
+	case vmconsts.ContractCallID:
+		// The value of a call is transferred in NAI from the caller to the contract
+		value, err := numericValue(action.Input, "value")
+		if err != nil {
+			return nil, err
+		}
+		if value != "0" {
+			updates.addAmount(actor, b.naiAddress, "-"+value)
+			updates.addAmount(addressValue(action.Input, "contractAddress"), b.naiAddress, value)
+		}
+	}
+	return updates.updates, updates.err
+}
+
+// balanceUpdateSet collects the balance updates of an action output, keeping the first error
+type balanceUpdateSet struct {
+	output  map[string]interface{}
+	updates []balanceUpdate
+	err     error
+}
+
+// set replaces the balance with the amount stored under key
+func (u *balanceUpdateSet) set(address, assetAddress, key string) {
+	amount, err := numericValue(u.output, key)
+	if err != nil {
+		u.err = err
+		return
+	}
+	u.setAmount(address, assetAddress, amount)
+}
+
+func (u *balanceUpdateSet) setAmount(address, assetAddress, amount string) {
+	u.updates = append(u.updates, balanceUpdate{address: address, assetAddress: assetAddress, amount: amount})
+}
+
+// add adds the amount stored under key to the balance, or subtracts it if negate is set
+func (u *balanceUpdateSet) add(address, assetAddress, key string, negate bool) {
+	amount, err := numericValue(u.output, key)
+	if err != nil {
+		u.err = err
+		return
+	}
+	if negate {
+		amount = "-" + amount
+	}
+	u.addAmount(address, assetAddress, amount)
+}
+
+func (u *balanceUpdateSet) addAmount(address, assetAddress, amount string) {
+	u.updates = append(u.updates, balanceUpdate{address: address, assetAddress: assetAddress, amount: amount, relative: true})
+}
+
+// Rollback restores the balances changed in orphaned blocks to their value before the
+// first orphaned change. Genesis allocations are only removed by a reindex.
+func (*balanceIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	_, err := dbTx.Exec(`
+		WITH earliest AS (
+			SELECT DISTINCT ON (address, asset_address) address, asset_address, previous_balance
+			FROM balance_changes
+			WHERE block_height >= $1 AND action_name <> $2
+			ORDER BY address, asset_address, block_height, id
+		), removed AS (
+			DELETE FROM balances
+			USING earliest
+			WHERE balances.address = earliest.address
+			  AND balances.asset_address = earliest.asset_address
+			  AND earliest.previous_balance IS NULL
+		)
+		UPDATE balances
+		SET balance = earliest.previous_balance
+		FROM earliest
+		WHERE balances.address = earliest.address
+		  AND balances.asset_address = earliest.asset_address
+		  AND earliest.previous_balance IS NOT NULL`, fromHeight, genesisActionName)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`DELETE FROM balance_changes WHERE block_height >= $1 AND action_name <> $2`, fromHeight, genesisActionName)
+	return err
+}
+
+// Reindex rebuilds the balances from the genesis allocations, the fees and the stored
+// actions, in chain order
+func (b *balanceIndexer) Reindex(dbTx *sql.Tx, actionTypes []uint8) error {
+	if _, err := dbTx.Exec(`DELETE FROM balance_changes`); err != nil {
+		return err
+	}
+	if _, err := dbTx.Exec(`DELETE FROM balances`); err != nil {
+		return err
+	}
+
+	genesisData, err := fetchStoredGenesis(dbTx)
+	if err != nil {
+		return err
+	}
+	if len(genesisData) > 0 {
+		if err := b.recordGenesisAllocations(dbTx, genesisData); err != nil {
+			return err
+		}
+	}
+
+	return replayStoredTransactions(dbTx, actionTypes, true, func(tx *storedTransaction) error {
+		if err := b.recordFee(dbTx, tx.BlockHeight, tx.TxHash, tx.Sponsor, tx.Fee, tx.Timestamp); err != nil {
+			return err
+		}
+		for i := range tx.Actions {
+			if err := b.Index(dbTx, &tx.Actions[i]); err != nil {
+				return fmt.Errorf("error replaying action %d of tx %s: %w", tx.Actions[i].ActionIndex, tx.TxHash, err)
+			}
+		}
+		return nil
+	})
+}
+
+// recordFee deducts the fee of a transaction from the NAI balance of its sponsor. Fees are
+// paid before the actions run and also by failed transactions.
+func (b *balanceIndexer) recordFee(dbTx *sql.Tx, blockHeight uint64, txHash, sponsor string, fee uint64, timestamp string) error {
+	if fee == 0 {
+		return nil
+	}
+	update := balanceUpdate{
+		address:      sponsor,
+		assetAddress: b.naiAddress,
+		amount:       "-" + strconv.FormatUint(fee, 10),
+		relative:     true,
+	}
+	return recordBalance(dbTx, update, txHash, blockHeight, feeActionName, timestamp)
+}
+
+// recordGenesisAllocations replaces all balances with the NAI allocations of the genesis.
+// It only runs while no block is stored, so no balance derived from blocks is lost.
+func (b *balanceIndexer) recordGenesisAllocations(dbTx *sql.Tx, genesisData []byte) error {
+	genesis, err := decodeJSONMap(genesisData)
+	if err != nil {
+		return fmt.Errorf("error decoding genesis: %w", err)
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	allocations, _ := genesis["customAllocation"].([]interface{})
+	for _, entry := range allocations {
+		allocation, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		amount, err := numericValue(allocation, "balance")
+		if err != nil {
+			return fmt.Errorf("error reading genesis allocation: %w", err)
+		}
+		update := balanceUpdate{address: addressValue(allocation, "address"), assetAddress: b.naiAddress, amount: amount}
+		if err := recordBalance(dbTx, update, "", 0, genesisActionName, timestamp); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Recorded %d genesis allocations\n", len(allocations))
+	return nil
+}
+
+// storeGenesisBalances seeds the balances with the allocations of a new genesis while
+// no block has been indexed yet
+func storeGenesisBalances(dbTx *sql.Tx, genesisData []byte) error {
+	var hasBlocks bool
+	if err := dbTx.QueryRow(`SELECT EXISTS (SELECT 1 FROM blocks)`).Scan(&hasBlocks); err != nil {
+		return err
+	}
+	if hasBlocks {
+		return nil
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM balance_changes`); err != nil {
+		return err
+	}
+	if _, err := dbTx.Exec(`DELETE FROM balances`); err != nil {
+		return err
+	}
+	return balanceLedger.recordGenesisAllocations(dbTx, genesisData)
+}
+
+// SeedBalances rebuilds the balances of a database whose blocks were indexed before the
+// balances were tracked. Without the genesis allocations and the earlier actions, every
+// new fee would leave its sponsor with a negative balance.
+func SeedBalances(dbConn *sql.DB) error {
+	var unseeded bool
+	err := dbConn.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM balances) AND EXISTS (SELECT 1 FROM blocks)`).Scan(&unseeded)
+	if err != nil {
+		return fmt.Errorf("error checking balances: %w", err)
+	}
+	if !unseeded {
+		return nil
+	}
+
+	log.Println("Blocks were indexed before the balances were tracked. Rebuilding the balances...")
+	return ReindexActions(dbConn, balanceLedger.Tables())
+}
+
+// recordBalance applies a balance update and records it in balance_changes
+func recordBalance(dbTx *sql.Tx, update balanceUpdate, txHash string, blockHeight uint64, actionName, timestamp string) error {
+	_, err := dbTx.Exec(`
+		WITH previous AS (
+			SELECT balance FROM balances WHERE address = $1 AND asset_address = $2
+		), updated AS (
+			INSERT INTO balances (address, asset_address, balance)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (address, asset_address) DO UPDATE
+			SET balance = CASE WHEN $4 THEN balances.balance + EXCLUDED.balance ELSE EXCLUDED.balance END
+			RETURNING balance
+		)
+		INSERT INTO balance_changes (address, asset_address, tx_hash, block_height, action_name, previous_balance, new_balance, timestamp)
+		SELECT $1, $2, NULLIF($5, ''), $6::BIGINT, $7, (SELECT balance FROM previous), (SELECT balance FROM updated), $8::TIMESTAMP`,
+		update.address, update.assetAddress, update.amount, update.relative, txHash, blockHeight, actionName, timestamp)
+	return err
+}
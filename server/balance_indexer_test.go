@@ -0,0 +1,261 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ava-labs/avalanchego/ids"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+	"github.com/nuklai/nuklaivm/vm"
+)
+
+// expectBalance expects recordBalance to apply the update for an action of tx1 at height 10
+func expectBalance(mock sqlmock.Sqlmock, address, assetAddress, amount string, relative bool, actionName string) {
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO balance_changes`)).
+		WithArgs(address, assetAddress, amount, relative, "tx1", uint64(10), actionName, "2024-01-01T00:00:00Z").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestBalanceIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "transfer sets both balances",
+			actionType: vmconsts.TransferID,
+			actionName: "Transfer",
+			input:      `{"asset_address":"0xasset1","to":"0xreceiver1","value":100}`,
+			output:     `{"sender_balance":900,"receiver_balance":100}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBalance(mock, "sponsor1", "asset1", "900", false, "Transfer")
+				expectBalance(mock, "receiver1", "asset1", "100", false, "Transfer")
+			},
+		},
+		{
+			name:       "transfer by the reported actor",
+			actionType: vmconsts.TransferID,
+			actionName: "Transfer",
+			input:      `{"asset_address":"0xasset1","to":"0xreceiver1","value":100}`,
+			output:     `{"actor":"actor1","sender_balance":900,"receiver_balance":100}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBalance(mock, "actor1", "asset1", "900", false, "Transfer")
+				expectBalance(mock, "receiver1", "asset1", "100", false, "Transfer")
+			},
+		},
+		{
+			name:       "validator stake is deducted",
+			actionType: vmconsts.RegisterValidatorStakeID,
+			actionName: "RegisterValidatorStake",
+			input:      `{}`,
+			output:     `{"staked_amount":5000}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBalance(mock, "sponsor1", "nai", "-5000", true, "RegisterValidatorStake")
+			},
+		},
+		{
+			name:       "marketplace payment is credited to the claimer",
+			actionType: vmconsts.ClaimMarketplacePaymentID,
+			actionName: "ClaimMarketplacePayment",
+			input:      `{"marketplace_asset_address":"0xmarketplace1","payment_asset_address":"0xasset1"}`,
+			output:     `{"actor":"owner1","distributed_reward":250,"distributed_to":"owner1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBalance(mock, "owner1", "asset1", "250", true, "ClaimMarketplacePayment")
+			},
+		},
+		{
+			name:       "contract call value is moved to the contract",
+			actionType: vmconsts.ContractCallID,
+			actionName: "ContractCall",
+			input:      `{"contractAddress":"0xcontract1","value":100,"function":"deposit","calldata":"","fuel":10000}`,
+			output:     `{"actor":"caller1","consumedfuel":500}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBalance(mock, "caller1", "nai", "-100", true, "ContractCall")
+				expectBalance(mock, "contract1", "nai", "100", true, "ContractCall")
+			},
+		},
+		{
+			name:       "contract call without value is skipped",
+			actionType: vmconsts.ContractCallID,
+			actionName: "ContractCall",
+			input:      `{"contractAddress":"0xcontract1","value":0,"function":"init","calldata":"","fuel":10000}`,
+			output:     `{"actor":"caller1","consumedfuel":500}`,
+			expect:     func(sqlmock.Sqlmock) {},
+		},
+		{
+			name:       "mint without receiver is skipped",
+			actionType: vmconsts.MintAssetFTID,
+			actionName: "MintAssetFT",
+			input:      `{"asset_address":"0xasset1"}`,
+			output:     `{"new_balance":100}`,
+			expect:     func(sqlmock.Sqlmock) {},
+		},
+		{
+			name:       "missing balance",
+			actionType: vmconsts.TransferID,
+			actionName: "Transfer",
+			input:      `{"asset_address":"0xasset1","to":"0xreceiver1","value":100}`,
+			output:     `{"sender_balance":900}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&balanceIndexer{naiAddress: "nai"}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBalanceIndexerRecordFee(t *testing.T) {
+	tests := []struct {
+		name   string
+		fee    uint64
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "no fee",
+			expect: func(sqlmock.Sqlmock) {},
+		},
+		{
+			name: "fee is deducted from the sponsor",
+			fee:  25,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBalance(mock, "sponsor1", "nai", "-25", true, feeActionName)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&balanceIndexer{naiAddress: "nai"}).recordFee(dbTx, 10, "tx1", "sponsor1", tt.fee, "2024-01-01T00:00:00Z")
+			if err != nil {
+				t.Fatalf("recordFee() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBalanceIndexerRollback(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE balances`)).
+		WithArgs(uint64(10), genesisActionName).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM balance_changes WHERE block_height >= $1 AND action_name <> $2`)).
+		WithArgs(uint64(10), genesisActionName).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := (&balanceIndexer{naiAddress: "nai"}).Rollback(dbTx, 10); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeedBalancesSkipsSeededDatabase(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT NOT EXISTS (SELECT 1 FROM balances) AND EXISTS (SELECT 1 FROM blocks)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"unseeded"}).AddRow(false))
+
+	if err := SeedBalances(dbConn); err != nil {
+		t.Fatalf("SeedBalances() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeedBalancesRebuildsBalancesOfIndexedBlocks(t *testing.T) {
+	dbConn := newTestDatabase(t)
+
+	transfers := newTestTransfers(t)
+	genesis := fmt.Sprintf(`{"customAllocation": [{"address": "0x%s", "balance": 1000}]}`, transfers.sender)
+	if _, err := dbConn.Exec(`INSERT INTO genesis_data (data) VALUES ($1::json)`, genesis); err != nil {
+		t.Fatal(err)
+	}
+	parser, err := vm.CreateParser([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	// The blocks were indexed before the balances were tracked
+	first := transfers.block(1, ids.GenerateTestID(), true, 890, 100)
+	indexTestBlock(t, dbConn, parser, first)
+	indexTestBlock(t, dbConn, parser, transfers.block(2, first.BlockID, false, 0, 0))
+	if _, err := dbConn.Exec(`DELETE FROM balance_changes; DELETE FROM balances`); err != nil {
+		t.Fatal(err)
+	}
+
+	// The genesis allocation is seeded below the transfer and both fees
+	if err := SeedBalances(dbConn); err != nil {
+		t.Fatalf("SeedBalances() error = %v", err)
+	}
+	want := map[string]string{transfers.sender.String(): "880", transfers.receiver.String(): "100"}
+	if balances := naiBalances(t, dbConn); !reflect.DeepEqual(balances, want) {
+		t.Fatalf("balances = %v, want %v", balances, want)
+	}
+}
+
+func TestBalanceIndexerRollbackRestoresRows(t *testing.T) {
+	transfer := func(height uint64, to string, senderBalance, receiverBalance int) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.TransferID, actionName: "Transfer",
+			input:  fmt.Sprintf(`{"asset_address":"0xasset1","to":"0x%s","value":100}`, to),
+			output: fmt.Sprintf(`{"sender_balance":%d,"receiver_balance":%d}`, senderBalance, receiverBalance),
+		}
+	}
+	testIndexerRollback(t, balanceLedger, 2, []testAction{
+		transfer(1, "receiver1", 900, 100),
+		// Each balance goes back to the previous balance of its earliest orphaned change, and
+		// the balances first set in orphaned blocks are removed
+		transfer(2, "receiver1", 800, 200),
+		transfer(3, "receiver1", 700, 300),
+		transfer(3, "receiver2", 600, 100),
+		{
+			height: 3, actionType: vmconsts.ContractCallID, actionName: "ContractCall",
+			input: `{"contractAddress":"0xcontract1","value":50}`,
+		},
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// maxQueryParams is the maximum number of bind parameters PostgreSQL accepts in a statement
+const maxQueryParams = 65535
+
+// blockWriter gathers the transactions and actions of a block in memory so they are
+// written with a few multi-row inserts instead of one round trip per row
+type blockWriter struct {
+	transactions [][]interface{}
+	actions      [][]interface{}
+	// actionVolumes counts the actions of each type in the block
+	actionVolumes map[uint8]*actionVolume
+}
+
+type actionVolume struct {
+	actionName string
+	count      int64
+}
+
+func newBlockWriter() *blockWriter {
+	return &blockWriter{actionVolumes: map[uint8]*actionVolume{}}
+}
+
+// addTransaction queues a transaction row
+func (w *blockWriter) addTransaction(txHash, blockHash, sponsor string, actors, receivers []string, maxFee uint64,
+	success bool, fee uint64, actionsJSON, timestamp string,
+) {
+	w.transactions = append(w.transactions, []interface{}{
+		txHash, blockHash, sponsor, pq.Array(actors), pq.Array(receivers), maxFee, success, fee, actionsJSON, timestamp,
+	})
+}
+
+// addAction queues an action row and counts it in the volume of its type
+func (w *blockWriter) addAction(txHash string, actionType uint8, actionName string, actionIndex int, inputJSON, outputJSON, timestamp string) {
+	w.actions = append(w.actions, []interface{}{
+		txHash, actionType, actionName, actionIndex, inputJSON, outputJSON, timestamp,
+	})
+
+	volume, ok := w.actionVolumes[actionType]
+	if !ok {
+		volume = &actionVolume{actionName: actionName}
+		w.actionVolumes[actionType] = volume
+	}
+	volume.count++
+}
+
+// flush writes every queued row
+func (w *blockWriter) flush(dbTx *sql.Tx) error {
+	err := insertRows(dbTx, `
+		INSERT INTO actions (tx_hash, action_type, action_name, action_index, input, output, timestamp)`, `
+		ON CONFLICT (tx_hash, action_type, action_index) DO UPDATE
+		SET input = EXCLUDED.input,
+			output = EXCLUDED.output,
+			timestamp = EXCLUDED.timestamp`, w.actions)
+	if err != nil {
+		return fmt.Errorf("error saving actions: %w", err)
+	}
+
+	if err := updateActionVolumes(dbTx, w.actionVolumes); err != nil {
+		return fmt.Errorf("error updating action volumes: %w", err)
+	}
+
+	err = insertRows(dbTx, `
+		INSERT INTO transactions (tx_hash, block_hash, sponsor, actors, receivers, max_fee, success, fee, actions, timestamp)`, `
+		ON CONFLICT (tx_hash) DO UPDATE
+		SET block_hash = EXCLUDED.block_hash,
+			sponsor = EXCLUDED.sponsor,
+			actors = EXCLUDED.actors,
+			receivers = EXCLUDED.receivers,
+			max_fee = EXCLUDED.max_fee,
+			success = EXCLUDED.success,
+			fee = EXCLUDED.fee,
+			actions = EXCLUDED.actions,
+			timestamp = EXCLUDED.timestamp`, w.transactions)
+	if err != nil {
+		return fmt.Errorf("error saving transactions: %w", err)
+	}
+	return nil
+}
+
+// updateActionVolumes adds the action counts of a block to the action volumes. Action types
+// are written in a fixed order so concurrent writers lock the rows in the same order.
+func updateActionVolumes(dbTx *sql.Tx, volumes map[uint8]*actionVolume) error {
+	actionTypes := make([]int, 0, len(volumes))
+	for actionType := range volumes {
+		actionTypes = append(actionTypes, int(actionType))
+	}
+	sort.Ints(actionTypes)
+
+	rows := make([][]interface{}, 0, len(actionTypes))
+	for _, actionType := range actionTypes {
+		volume := volumes[uint8(actionType)]
+		rows = append(rows, []interface{}{uint8(actionType), volume.actionName, volume.count})
+	}
+
+	return insertRows(dbTx, `
+		INSERT INTO action_volumes (action_type, action_name, total_count)`, `
+		ON CONFLICT (action_type) DO UPDATE
+		SET total_count = action_volumes.total_count + EXCLUDED.total_count`, rows)
+}
+
+// insertRows writes rows with as few multi-row INSERT statements as the parameter limit
+// allows. insert is the statement up to the column list and suffix follows the VALUES.
+// All rows must have the same number of columns.
+func insertRows(dbTx *sql.Tx, insert, suffix string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	columns := len(rows[0])
+	rowsPerStatement := maxQueryParams / columns
+
+	for start := 0; start < len(rows); start += rowsPerStatement {
+		end := start + rowsPerStatement
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		var query strings.Builder
+		query.WriteString(insert)
+		query.WriteString("\n\t\tVALUES ")
+		args := make([]interface{}, 0, (end-start)*columns)
+		for i, row := range rows[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			query.WriteByte('(')
+			for j := range row {
+				if j > 0 {
+					query.WriteString(", ")
+				}
+				fmt.Fprintf(&query, "$%d", len(args)+j+1)
+			}
+			query.WriteByte(')')
+			args = append(args, row...)
+		}
+		query.WriteString(suffix)
+
+		if _, err := dbTx.Exec(query.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/hypersdk/auth"
+	"github.com/ava-labs/hypersdk/chain"
+	"github.com/ava-labs/hypersdk/codec"
+	"github.com/ava-labs/hypersdk/crypto/ed25519"
+	"github.com/nuklai/nuklaivm/actions"
+	"github.com/nuklai/nuklaivm/storage"
+	"github.com/nuklai/nuklaivm/vm"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/db"
+)
+
+func TestBlockWriterFlush(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+
+	writer := newBlockWriter()
+	writer.addAction("tx1", 4, "CreateAsset", 0, `{}`, `{}`, "2024-01-01T00:00:00Z")
+	writer.addAction("tx1", 0, "Transfer", 1, `{}`, `{}`, "2024-01-01T00:00:00Z")
+	writer.addAction("tx2", 0, "Transfer", 0, `{}`, `{}`, "2024-01-01T00:00:00Z")
+	writer.addTransaction("tx1", "block1", "sponsor1", []string{"sponsor1"}, nil, 100, true, 10, `[]`, "2024-01-01T00:00:00Z")
+	writer.addTransaction("tx2", "block1", "sponsor2", nil, nil, 100, false, 10, `[]`, "2024-01-01T00:00:00Z")
+
+	mock.ExpectExec(regexp.QuoteMeta(`VALUES ($1, $2, $3, $4, $5, $6, $7), ($8, $9, $10, $11, $12, $13, $14), ($15,`)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO action_volumes`)).
+		WithArgs(uint8(0), "Transfer", int64(2), uint8(4), "CreateAsset", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO transactions`)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	if err := writer.flush(dbTx); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertRowsSplitsAtParameterLimit(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+
+	// 10,000 rows of 7 columns exceed the 65,535 parameters of a single statement
+	rows := make([][]interface{}, 10_000)
+	for i := range rows {
+		rows[i] = []interface{}{i, i, i, i, i, i, i}
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO actions`)).WillReturnResult(sqlmock.NewResult(0, 9362))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO actions`)).WillReturnResult(sqlmock.NewResult(0, 638))
+
+	if err := insertRows(dbTx, `INSERT INTO actions (a, b, c, d, e, f, g)`, ``, rows); err != nil {
+		t.Fatalf("insertRows() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkProcessBlockData writes synthetic blocks of transfers to the database at
+// BENCHMARK_DATABASE_URL and reports the ingested transactions per second. Every block
+// is rolled back, so the benchmark can run against a database holding indexed blocks.
+func BenchmarkProcessBlockData(b *testing.B) {
+	connStr := os.Getenv("BENCHMARK_DATABASE_URL")
+	if connStr == "" {
+		b.Skip("BENCHMARK_DATABASE_URL is not set")
+	}
+	// The schema is migrated without InitDB, which would reset the database when DB_RESET is set
+	dbConn, err := db.Connect(connStr)
+	if err != nil {
+		b.Fatalf("error connecting to database: %v", err)
+	}
+	defer dbConn.Close()
+	if err := db.MigrateUp(dbConn); err != nil {
+		b.Fatalf("error migrating database: %v", err)
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, txCount := range []int{1_000, 5_000} {
+		b.Run(fmt.Sprintf("txs=%d", txCount), func(b *testing.B) {
+			executedBlock := syntheticTransferBlock(b, txCount)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				dbTx, err := dbConn.Begin()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := processBlockData(dbTx, executedBlock); err != nil {
+					b.Fatal(err)
+				}
+				if err := dbTx.Rollback(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(b.N*txCount)/b.Elapsed().Seconds(), "txs/s")
+		})
+	}
+}
+
+// syntheticTransferBlock builds an executed block of successful NAI transfers far above
+// any indexed height, so it never conflicts with the stored chain
+func syntheticTransferBlock(b *testing.B, txCount int) *chain.ExecutedBlock {
+	b.Helper()
+
+	privateKey, err := ed25519.GeneratePrivateKey()
+	if err != nil {
+		b.Fatal(err)
+	}
+	receiverKey, err := ed25519.GeneratePrivateKey()
+	if err != nil {
+		b.Fatal(err)
+	}
+	factory := auth.NewED25519Factory(privateKey)
+	sender := auth.NewED25519Address(privateKey.PublicKey())
+	receiver := auth.NewED25519Address(receiverKey.PublicKey())
+
+	output, err := chain.MarshalTyped(&actions.TransferResult{
+		Actor:           sender.String(),
+		Receiver:        receiver.String(),
+		SenderBalance:   1_000_000,
+		ReceiverBalance: 1_000_000,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Transaction expiries are whole seconds
+	timestamp := time.Now().Unix() * 1000
+	block := &chain.StatelessBlock{
+		Prnt:   ids.GenerateTestID(),
+		Tmstmp: timestamp,
+		Hght:   1 << 40,
+	}
+	chainID := ids.GenerateTestID()
+	results := make([]*chain.Result, txCount)
+	for i := 0; i < txCount; i++ {
+		block.Txs = append(block.Txs, signedTransfer(b, factory, chainID, timestamp, receiver, uint64(i+1)))
+		results[i] = &chain.Result{Success: true, Outputs: [][]byte{output}, Fee: 100}
+	}
+
+	return &chain.ExecutedBlock{BlockID: ids.GenerateTestID(), Block: block, Results: results}
+}
+
+// signedTransfer signs a transaction transferring value NAI to receiver
+func signedTransfer(tb testing.TB, factory *auth.ED25519Factory, chainID ids.ID, timestamp int64, receiver codec.Address, value uint64) *chain.Transaction {
+	tb.Helper()
+
+	txData := chain.NewTxData(
+		&chain.Base{Timestamp: timestamp, ChainID: chainID, MaxFee: 1_000},
+		[]chain.Action{&actions.Transfer{To: receiver, AssetAddress: storage.NAIAddress, Value: value}},
+	)
+	tx, err := txData.Sign(factory, vm.ActionParser, vm.AuthParser)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return tx
+}
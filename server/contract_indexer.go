@@ -0,0 +1,136 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+// Contract types stored in the contracts table
+const (
+	contractTypeProgram  = "program"
+	contractTypeInstance = "instance"
+)
+
+// contractIndexer records the programs published with ContractPublish and the instances
+// deployed from them with ContractDeploy in contracts, and every ContractCall in contract_calls
+type contractIndexer struct{}
+
+func init() {
+	indexer := &contractIndexer{}
+	RegisterActionIndexer(vmconsts.ContractPublishID, indexer)
+	RegisterActionIndexer(vmconsts.ContractDeployID, indexer)
+	RegisterActionIndexer(vmconsts.ContractCallID, indexer)
+}
+
+func (*contractIndexer) Tables() []string {
+	return []string{"contracts", "contract_calls"}
+}
+
+func (c *contractIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.ContractPublishID:
+		return c.publish(dbTx, action)
+	case vmconsts.ContractDeployID:
+		return c.deploy(dbTx, action)
+	case vmconsts.ContractCallID:
+		return c.call(dbTx, action)
+	}
+	return nil
+}
+
+// publish records a program. The program ID returned by the VM is derived from the hash
+// of the bytecode, so publishing the same bytecode again keeps the first record.
+func (*contractIndexer) publish(dbTx *sql.Tx, action *IndexedAction) error {
+	bytecode, err := bytesValue(action.Input, "contractBytes")
+	if err != nil {
+		return err
+	}
+	programID, err := bytesValue(action.Output, "value")
+	if err != nil {
+		return err
+	}
+	bytecodeHash := sha256.Sum256(bytecode)
+
+	_, err = dbTx.Exec(`
+		INSERT INTO contracts (
+			contract_type, program_id, bytecode_hash, size, creator, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (program_id) WHERE contract_type = 'program' DO NOTHING`,
+		contractTypeProgram, hex.EncodeToString(programID), hex.EncodeToString(bytecodeHash[:]), len(bytecode),
+		actionActor(action), action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+func (*contractIndexer) deploy(dbTx *sql.Tx, action *IndexedAction) error {
+	programID, err := bytesValue(action.Input, "contractID")
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO contracts (
+			contract_type, contract_address, program_id, creator, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (contract_address) DO NOTHING`,
+		contractTypeInstance, addressValue(action.Output, "address"), hex.EncodeToString(programID),
+		actionActor(action), action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+func (*contractIndexer) call(dbTx *sql.Tx, action *IndexedAction) error {
+	value, err := numericValue(action.Input, "value")
+	if err != nil {
+		return err
+	}
+	fuelLimit, err := uint64Value(action.Input, "fuel")
+	if err != nil {
+		return err
+	}
+	fuelConsumed, err := uint64Value(action.Output, "consumedfuel")
+	if err != nil {
+		return err
+	}
+	// Functions returning nothing have a null result
+	var result []byte
+	if action.Output["value"] != nil {
+		if result, err = bytesValue(action.Output, "value"); err != nil {
+			return err
+		}
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO contract_calls (
+			contract_address, caller, function_name, value, fuel_limit, fuel_consumed, result,
+			tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		addressValue(action.Input, "contractAddress"), actionActor(action), stringValue(action.Input, "function"),
+		value, fuelLimit, fuelConsumed, hex.EncodeToString(result), action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+func (*contractIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	for _, table := range []string{"contract_calls", "contracts"} {
+		if _, err := dbTx.Exec(`DELETE FROM `+table+` WHERE block_height >= $1`, fromHeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// actionActor returns the actor reported in the output of the action, falling back to the
+// sponsor of its transaction
+func actionActor(action *IndexedAction) string {
+	if actor := addressValue(action.Output, "actor"); actor != "" {
+		return actor
+	}
+	return action.Sponsor
+}
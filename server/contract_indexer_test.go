@@ -0,0 +1,159 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestContractIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "publish program",
+			actionType: vmconsts.ContractPublishID,
+			actionName: "ContractPublish",
+			input:      `{"contractBytes":"AGFzbQE="}`,
+			output:     `{"actor":"publisher1","receiver":"","value":"wP/u"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO contracts`)).
+					WithArgs("program", "c0ffee", "42fd6e7502a18143f0c206c99f1e0787fbcac6b1c5f9969a4cb0d2f7035d07e3", 5,
+						"publisher1", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "deploy contract",
+			actionType: vmconsts.ContractDeployID,
+			actionName: "ContractDeploy",
+			input:      `{"contractID":"wP/u","creationInfo":"Kg=="}`,
+			output:     `{"actor":"deployer1","receiver":"","address":"0xcontract1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO contracts`)).
+					WithArgs("instance", "contract1", "c0ffee", "deployer1", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "call contract",
+			actionType: vmconsts.ContractCallID,
+			actionName: "ContractCall",
+			input:      `{"contractAddress":"0xcontract1","value":100,"function":"transfer","calldata":"Kg==","statekeys":null,"fuel":10000}`,
+			output:     `{"actor":"caller1","receiver":"","value":"Kg==","consumedfuel":2500}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO contract_calls`)).
+					WithArgs("contract1", "caller1", "transfer", "100", uint64(10000), uint64(2500), "2a",
+						"tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "call contract without result",
+			actionType: vmconsts.ContractCallID,
+			actionName: "ContractCall",
+			input:      `{"contractAddress":"0xcontract1","value":0,"function":"init","calldata":"","statekeys":null,"fuel":10000}`,
+			output:     `{"actor":"","receiver":"","value":null,"consumedfuel":2500}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO contract_calls`)).
+					WithArgs("contract1", "sponsor1", "init", "0", uint64(10000), uint64(2500), "",
+						"tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "publish program without bytecode",
+			actionType: vmconsts.ContractPublishID,
+			actionName: "ContractPublish",
+			input:      `{"contractBytes":null}`,
+			output:     `{"actor":"publisher1","receiver":"","value":"wP/u"}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&contractIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestContractIndexerRollback(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	for _, table := range []string{"contract_calls", "contracts"} {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table + ` WHERE block_height >= $1`)).
+			WithArgs(uint64(10)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	if err := (&contractIndexer{}).Rollback(dbTx, 10); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContractIndexerRollbackRestoresRows(t *testing.T) {
+	publish := func(height uint64, bytecode, programID string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.ContractPublishID, actionName: "ContractPublish",
+			input:  `{"contractBytes":"` + bytecode + `"}`,
+			output: `{"actor":"publisher1","receiver":"","value":"` + programID + `"}`,
+		}
+	}
+	deploy := func(height uint64, programID, contractAddress string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.ContractDeployID, actionName: "ContractDeploy",
+			input:  `{"contractID":"` + programID + `","creationInfo":"Kg=="}`,
+			output: `{"actor":"deployer1","receiver":"","address":"0x` + contractAddress + `"}`,
+		}
+	}
+	call := func(height uint64, function string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.ContractCallID, actionName: "ContractCall",
+			input:  `{"contractAddress":"0xcontract1","value":0,"function":"` + function + `","calldata":"","statekeys":null,"fuel":10000}`,
+			output: `{"actor":"caller1","receiver":"","value":"Kg==","consumedfuel":2500}`,
+		}
+	}
+	testIndexerRollback(t, &contractIndexer{}, 3, []testAction{
+		publish(1, "AGFzbQE=", "wP/u"),
+		deploy(2, "wP/u", "contract1"),
+		call(2, "init"),
+		// The program published again keeps its first record, the orphaned rows are removed
+		publish(3, "AGFzbQE=", "wP/u"),
+		publish(3, "AGFzbQI=", "wP/v"),
+		deploy(3, "wP/v", "contract2"),
+		call(4, "transfer"),
+	})
+}
@@ -0,0 +1,262 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/hypersdk/auth"
+	"github.com/ava-labs/hypersdk/chain"
+	"github.com/ava-labs/hypersdk/codec"
+	"github.com/ava-labs/hypersdk/crypto/ed25519"
+	"github.com/ava-labs/hypersdk/fees"
+	pb "github.com/ava-labs/hypersdk/proto/pb/externalsubscriber"
+	"github.com/nuklai/nuklaivm/actions"
+	"github.com/nuklai/nuklaivm/storage"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/db"
+)
+
+// newTestDatabase connects to the disposable database at TEST_DATABASE_URL, drops every
+// table of the subscriber and migrates an empty schema. Tests checking the rows written
+// by the SQL statements themselves are skipped when it is not set.
+func newTestDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dbConn, err := db.Connect(connStr)
+	if err != nil {
+		t.Fatalf("error connecting to database: %v", err)
+	}
+	t.Cleanup(func() { dbConn.Close() })
+
+	tables := append(append([]string{}, db.ChainTables...), "network_epochs", "schema_migrations", "webhooks", "webhook_deliveries")
+	if _, err := dbConn.Exec(`DROP TABLE IF EXISTS ` + strings.Join(tables, ", ") + ` CASCADE`); err != nil {
+		t.Fatalf("error resetting database: %v", err)
+	}
+	if err := db.MigrateUp(dbConn); err != nil {
+		t.Fatalf("error migrating database: %v", err)
+	}
+	return dbConn
+}
+
+// testTransfers builds blocks holding a NAI transfer between two new addresses
+type testTransfers struct {
+	t         *testing.T
+	factory   *auth.ED25519Factory
+	sender    codec.Address
+	receiver  codec.Address
+	chainID   ids.ID
+	timestamp int64
+}
+
+func newTestTransfers(t *testing.T) *testTransfers {
+	t.Helper()
+
+	senderKey, err := ed25519.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverKey, err := ed25519.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testTransfers{
+		t:         t,
+		factory:   auth.NewED25519Factory(senderKey),
+		sender:    auth.NewED25519Address(senderKey.PublicKey()),
+		receiver:  auth.NewED25519Address(receiverKey.PublicKey()),
+		chainID:   ids.GenerateTestID(),
+		timestamp: time.Now().Unix() * 1000,
+	}
+}
+
+// block returns the block at height holding a transfer of 100 NAI with a fee of 10. A
+// successful transfer reports the balances after it, a failed one only pays the fee.
+func (tt *testTransfers) block(height uint64, parent ids.ID, success bool, senderBalance, receiverBalance uint64) *chain.ExecutedBlock {
+	tt.t.Helper()
+
+	result := &chain.Result{Success: success, Fee: 10}
+	if success {
+		output, err := chain.MarshalTyped(&actions.TransferResult{
+			Actor:           tt.sender.String(),
+			Receiver:        tt.receiver.String(),
+			SenderBalance:   senderBalance,
+			ReceiverBalance: receiverBalance,
+		})
+		if err != nil {
+			tt.t.Fatal(err)
+		}
+		result.Outputs = [][]byte{output}
+	}
+
+	// Each block has its own timestamp, so the transactions have distinct IDs
+	timestamp := tt.timestamp + int64(height)*1000
+	tx := signedTransfer(tt.t, tt.factory, tt.chainID, timestamp, tt.receiver, 100)
+	executedBlock, err := chain.NewExecutedBlock(
+		&chain.StatelessBlock{Prnt: parent, Tmstmp: timestamp, Hght: height, Txs: []*chain.Transaction{tx}},
+		[]*chain.Result{result},
+		fees.Dimensions{},
+	)
+	if err != nil {
+		tt.t.Fatal(err)
+	}
+	return executedBlock
+}
+
+// indexTestBlock writes a block through the ingest path
+func indexTestBlock(t *testing.T, dbConn *sql.DB, parser chain.Parser, executedBlock *chain.ExecutedBlock) {
+	t.Helper()
+
+	blockData, err := executedBlock.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleAcceptBlock(dbConn, parser, &pb.BlockRequest{BlockData: blockData}); err != nil {
+		t.Fatalf("error indexing block %d: %v", executedBlock.Block.Hght, err)
+	}
+}
+
+// naiBalances returns the stored NAI balance of every address
+func naiBalances(t *testing.T, dbConn *sql.DB) map[string]string {
+	t.Helper()
+
+	rows, err := dbConn.Query(`SELECT address, balance FROM balances WHERE asset_address = $1`, storage.NAIAddress.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	balances := map[string]string{}
+	for rows.Next() {
+		var address, balance string
+		if err := rows.Scan(&address, &balance); err != nil {
+			t.Fatal(err)
+		}
+		balances[address] = balance
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return balances
+}
+
+// testAction is an action indexed by testIndexerRollback in the block at its height
+type testAction struct {
+	height     uint64
+	actionType uint8
+	actionName string
+	input      string
+	// output defaults to an empty object
+	output string
+	// indexer indexes the action instead of the tested indexer, to set up the rows the
+	// tested indexer depends on. Its actions must be below the rolled back height.
+	indexer ActionIndexer
+}
+
+// testIndexerRollback indexes the actions in height order, each in its own transaction,
+// and checks that rolling back from fromHeight restores the tables of the indexers to what
+// they held before the first action at or above fromHeight was indexed.
+func testIndexerRollback(t *testing.T, indexer ActionIndexer, fromHeight uint64, testActions []testAction) {
+	t.Helper()
+
+	dbConn := newTestDatabase(t)
+	dbTx, err := dbConn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbTx.Rollback()
+
+	tables := indexer.Tables()
+	for i := range testActions {
+		if testActions[i].indexer == nil {
+			testActions[i].indexer = indexer
+		} else {
+			tables = append(tables, testActions[i].indexer.Tables()...)
+		}
+	}
+
+	var before map[string]string
+	for i, action := range testActions {
+		if before == nil && action.height >= fromHeight {
+			before = snapshotTables(t, dbTx, tables)
+		}
+
+		blockHash := fmt.Sprintf("block%d", action.height)
+		timestamp := time.Unix(int64(action.height), 0).UTC().Format(time.RFC3339)
+		_, err := dbTx.Exec(`
+			INSERT INTO blocks (block_height, block_hash, block_size, tx_count, total_fee, avg_tx_size, unique_participants, timestamp)
+			VALUES ($1, $2, 0, 0, 0, 0, 0, $3)
+			ON CONFLICT (block_height) DO NOTHING`, action.height, blockHash, timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		txHash := fmt.Sprintf("tx%d", i+1)
+		_, err = dbTx.Exec(`INSERT INTO transactions (tx_hash, block_hash, sponsor, success, timestamp) VALUES ($1, $2, $3, TRUE, $4)`,
+			txHash, blockHash, "sponsor1", timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = action.indexer.Index(dbTx, &IndexedAction{
+			BlockHeight: action.height,
+			TxHash:      txHash,
+			Sponsor:     "sponsor1",
+			ActionType:  action.actionType,
+			ActionName:  action.actionName,
+			Input:       jsonMap(t, action.input),
+			Output:      jsonMap(t, cmp.Or(action.output, `{}`)),
+			Timestamp:   timestamp,
+		})
+		if err != nil {
+			t.Fatalf("error indexing %s at height %d: %v", action.actionName, action.height, err)
+		}
+	}
+	if before == nil {
+		t.Fatalf("no action is indexed at or above height %d", fromHeight)
+	}
+
+	if err := indexer.Rollback(dbTx, fromHeight); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if after := snapshotTables(t, dbTx, tables); !reflect.DeepEqual(after, before) {
+		t.Fatalf("tables after the rollback = %v, want %v", after, before)
+	}
+}
+
+// snapshotTables returns the rows of each table as JSON, without their serial ids, which
+// differ when a row is deleted and inserted again
+func snapshotTables(t *testing.T, dbTx *sql.Tx, tables []string) map[string]string {
+	t.Helper()
+
+	snapshot := make(map[string]string, len(tables))
+	for _, table := range tables {
+		var rows string
+		err := dbTx.QueryRow(`
+			SELECT COALESCE(jsonb_agg(to_jsonb(t) - 'id' ORDER BY (to_jsonb(t) - 'id')::text), '[]')::text
+			FROM ` + table + ` t`).Scan(&rows)
+		if err != nil {
+			t.Fatalf("error reading table %s: %v", table, err)
+		}
+		snapshot[table] = rows
+	}
+	return snapshot
+}
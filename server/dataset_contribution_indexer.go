@@ -0,0 +1,106 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"log"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+	"github.com/nuklai/nuklaivm/storage"
+)
+
+// Contribution statuses stored in the dataset_contributions table
+const (
+	contributionStatusPending  = "pending"
+	contributionStatusAccepted = "accepted"
+)
+
+// datasetContributionIndexer pairs every InitiateContributeDataset action with the
+// CompleteContributeDataset action accepting it in dataset_contributions
+type datasetContributionIndexer struct{}
+
+func init() {
+	indexer := &datasetContributionIndexer{}
+	RegisterActionIndexer(vmconsts.InitiateContributeDatasetID, indexer)
+	RegisterActionIndexer(vmconsts.CompleteContributeDatasetID, indexer)
+}
+
+func (*datasetContributionIndexer) Tables() []string {
+	return []string{"dataset_contributions"}
+}
+
+func (d *datasetContributionIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.InitiateContributeDatasetID:
+		return d.initiate(dbTx, action)
+	case vmconsts.CompleteContributeDatasetID:
+		return d.complete(dbTx, action)
+	}
+	return nil
+}
+
+func (*datasetContributionIndexer) initiate(dbTx *sql.Tx, action *IndexedAction) error {
+	contributor := addressValue(action.Output, "actor")
+	if contributor == "" {
+		contributor = action.Sponsor
+	}
+	// The VM stores contributions without a data location at its default location
+	dataLocation := stringValue(action.Input, "data_location")
+	if dataLocation == "" {
+		dataLocation = storage.DatasetDefaultLocation
+	}
+	collateralAmount, err := numericValue(action.Output, "collateral_amount_taken")
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO dataset_contributions (
+			contribution_id, dataset_address, contributor, data_location, data_identifier,
+			collateral_asset_address, collateral_amount, status, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		stringValue(action.Output, "dataset_contribution_id"), addressValue(action.Input, "dataset_address"), contributor,
+		dataLocation, stringValue(action.Input, "data_identifier"), addressValue(action.Output, "collateral_asset_address"),
+		collateralAmount, contributionStatusPending, action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+// complete marks the contribution as accepted and records the NFT minted for it
+func (*datasetContributionIndexer) complete(dbTx *sql.Tx, action *IndexedAction) error {
+	contributionID := stringValue(action.Input, "dataset_contribution_id")
+	collateralRefunded, err := numericValue(action.Output, "collateral_amount_refunded")
+	if err != nil {
+		return err
+	}
+
+	result, err := dbTx.Exec(`
+		UPDATE dataset_contributions
+		SET status = $2, contribution_nft_address = $3, collateral_refunded = $4,
+		    completion_tx_hash = $5, completion_block_height = $6, completed_at = $7
+		WHERE contribution_id = $1 AND status = $8`,
+		contributionID, contributionStatusAccepted, addressValue(action.Output, "dataset_child_nft_address"), collateralRefunded,
+		action.TxHash, action.BlockHeight, action.Timestamp, contributionStatusPending)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		log.Printf("Completed contribution %s to dataset %s is not indexed\n", contributionID, addressValue(action.Input, "dataset_address"))
+	}
+	return nil
+}
+
+func (*datasetContributionIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	_, err := dbTx.Exec(`
+		UPDATE dataset_contributions
+		SET status = $2, contribution_nft_address = NULL, collateral_refunded = NULL,
+		    completion_tx_hash = NULL, completion_block_height = NULL, completed_at = NULL
+		WHERE completion_block_height >= $1`, fromHeight, contributionStatusPending)
+	if err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(`DELETE FROM dataset_contributions WHERE block_height >= $1`, fromHeight)
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestDatasetContributionIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "initiate contribution",
+			actionType: vmconsts.InitiateContributeDatasetID,
+			actionName: "InitiateContributeDataset",
+			input:      `{"dataset_address":"0xdataset1","data_location":"ipfs","data_identifier":"file1"}`,
+			output:     `{"actor":"contributor1","dataset_contribution_id":"contribution1","collateral_asset_address":"nai","collateral_amount_taken":1000}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO dataset_contributions`)).
+					WithArgs("contribution1", "dataset1", "contributor1", "ipfs", "file1", "nai", "1000",
+						"pending", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "initiate contribution at the default location",
+			actionType: vmconsts.InitiateContributeDatasetID,
+			actionName: "InitiateContributeDataset",
+			input:      `{"dataset_address":"0xdataset1","data_location":"","data_identifier":"file1"}`,
+			output:     `{"actor":"contributor1","dataset_contribution_id":"contribution1","collateral_asset_address":"nai","collateral_amount_taken":1000}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO dataset_contributions`)).
+					WithArgs("contribution1", "dataset1", "contributor1", "default", "file1", "nai", "1000",
+						"pending", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "initiate contribution without collateral",
+			actionType: vmconsts.InitiateContributeDatasetID,
+			actionName: "InitiateContributeDataset",
+			input:      `{"dataset_address":"0xdataset1","data_identifier":"file1"}`,
+			output:     `{"actor":"contributor1","dataset_contribution_id":"contribution1"}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+		{
+			name:       "complete contribution",
+			actionType: vmconsts.CompleteContributeDatasetID,
+			actionName: "CompleteContributeDataset",
+			input:      `{"dataset_contribution_id":"contribution1","dataset_address":"0xdataset1","dataset_contributor":"0xcontributor1"}`,
+			output:     `{"collateral_amount_refunded":1000,"dataset_child_nft_address":"nft1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE dataset_contributions`)).
+					WithArgs("contribution1", "accepted", "nft1", "1000", "tx1", uint64(10), "2024-01-01T00:00:00Z", "pending").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&datasetContributionIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDatasetContributionIndexerRollback(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	mock.ExpectExec(regexp.QuoteMeta(`WHERE completion_block_height >= $1`)).
+		WithArgs(uint64(10), "pending").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM dataset_contributions WHERE block_height >= $1`)).
+		WithArgs(uint64(10)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := (&datasetContributionIndexer{}).Rollback(dbTx, 10); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatasetContributionIndexerRollbackRestoresRows(t *testing.T) {
+	initiate := func(height uint64, contributionID string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.InitiateContributeDatasetID, actionName: "InitiateContributeDataset",
+			input: `{"dataset_address":"0xdataset1","data_location":"ipfs","data_identifier":"` + contributionID + `"}`,
+			output: `{"actor":"contributor1","dataset_contribution_id":"` + contributionID + `",` +
+				`"collateral_asset_address":"nai","collateral_amount_taken":1000}`,
+		}
+	}
+	complete := func(height uint64, contributionID string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.CompleteContributeDatasetID, actionName: "CompleteContributeDataset",
+			input:  `{"dataset_contribution_id":"` + contributionID + `","dataset_address":"0xdataset1"}`,
+			output: `{"collateral_amount_refunded":1000,"dataset_child_nft_address":"nft_` + contributionID + `"}`,
+		}
+	}
+	testIndexerRollback(t, &datasetContributionIndexer{}, 3, []testAction{
+		initiate(1, "contribution1"),
+		initiate(2, "contribution2"),
+		complete(2, "contribution1"),
+		// The orphaned completion is pending again and the orphaned contribution is removed
+		complete(3, "contribution2"),
+		initiate(4, "contribution3"),
+	})
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+// datasetUpdateColumns lists the dataset columns that can be changed after the dataset is
+// created. The UpdateDataset output only sets the text fields that were changed.
+var datasetUpdateColumns = []string{
+	"name",
+	"description",
+	"categories",
+	"license_name",
+	"license_symbol",
+	"license_url",
+	"is_community_dataset",
+	"marketplace_asset_address",
+}
+
+// datasetIndexer keeps the datasets table in sync with CreateDataset, UpdateDataset and
+// PublishDatasetMarketplace actions and records every revision in dataset_history
+type datasetIndexer struct{}
+
+func init() {
+	indexer := &datasetIndexer{}
+	RegisterActionIndexer(vmconsts.CreateDatasetID, indexer)
+	RegisterActionIndexer(vmconsts.UpdateDatasetID, indexer)
+	RegisterActionIndexer(vmconsts.PublishDatasetMarketplaceID, indexer)
+}
+
+func (*datasetIndexer) Tables() []string {
+	return []string{"datasets", "dataset_history"}
+}
+
+func (i *datasetIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.CreateDatasetID:
+		return i.indexCreateDataset(dbTx, action)
+	case vmconsts.UpdateDatasetID:
+		return i.indexUpdateDataset(dbTx, action)
+	case vmconsts.PublishDatasetMarketplaceID:
+		return i.indexPublishDataset(dbTx, action)
+	}
+	return nil
+}
+
+func (*datasetIndexer) indexCreateDataset(dbTx *sql.Tx, action *IndexedAction) error {
+	assetAddress := addressValue(action.Input, "asset_address")
+	datasetAddress := addressValue(action.Output, "dataset_address")
+	if datasetAddress == "" {
+		datasetAddress = assetAddress
+	}
+	owner := addressValue(action.Output, "actor")
+	if owner == "" {
+		owner = action.Sponsor
+	}
+	isCommunityDataset, _ := action.Input["is_community_dataset"].(bool)
+
+	_, err := dbTx.Exec(`
+		INSERT INTO datasets (
+			dataset_address, asset_address, parent_nft_address, owner, name, description, categories,
+			license_name, license_symbol, license_url, metadata, is_community_dataset, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (dataset_address) DO UPDATE
+		SET asset_address = EXCLUDED.asset_address,
+			parent_nft_address = EXCLUDED.parent_nft_address,
+			owner = EXCLUDED.owner,
+			name = EXCLUDED.name,
+			description = EXCLUDED.description,
+			categories = EXCLUDED.categories,
+			license_name = EXCLUDED.license_name,
+			license_symbol = EXCLUDED.license_symbol,
+			license_url = EXCLUDED.license_url,
+			metadata = EXCLUDED.metadata,
+			is_community_dataset = EXCLUDED.is_community_dataset,
+			marketplace_asset_address = NULL,
+			tx_hash = EXCLUDED.tx_hash,
+			block_height = EXCLUDED.block_height,
+			timestamp = EXCLUDED.timestamp`,
+		datasetAddress, assetAddress, addressValue(action.Output, "dataset_parent_nft_address"), owner,
+		stringValue(action.Input, "name"), stringValue(action.Input, "description"), stringValue(action.Input, "categories"),
+		stringValue(action.Input, "license_name"), stringValue(action.Input, "license_symbol"), stringValue(action.Input, "license_url"),
+		stringValue(action.Input, "metadata"), isCommunityDataset, action.TxHash, action.BlockHeight, action.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	initialValues := map[string]interface{}{"is_community_dataset": isCommunityDataset}
+	for _, column := range datasetUpdateColumns {
+		if value := stringValue(action.Input, column); value != "" {
+			initialValues[column] = value
+		}
+	}
+	return insertDatasetHistory(dbTx, datasetAddress, action, initialValues, map[string]interface{}{})
+}
+
+// indexUpdateDataset applies the changed text fields reported by the output. The
+// community flag is always overwritten with the value of the input.
+func (*datasetIndexer) indexUpdateDataset(dbTx *sql.Tx, action *IndexedAction) error {
+	datasetAddress := addressValue(action.Input, "dataset_address")
+
+	current, err := fetchDatasetValues(dbTx, datasetAddress)
+	if err != nil {
+		return err
+	}
+
+	changedFields := map[string]interface{}{}
+	for _, column := range datasetUpdateColumns {
+		if value := stringValue(action.Output, column); value != "" {
+			changedFields[column] = value
+		}
+	}
+	isCommunityDataset, _ := action.Input["is_community_dataset"].(bool)
+	if current != nil && current["is_community_dataset"] != isCommunityDataset {
+		changedFields["is_community_dataset"] = isCommunityDataset
+	}
+	if len(changedFields) == 0 {
+		return nil
+	}
+
+	return applyDatasetChanges(dbTx, datasetAddress, action, current, changedFields)
+}
+
+// indexPublishDataset links the dataset to the marketplace asset created for it
+func (*datasetIndexer) indexPublishDataset(dbTx *sql.Tx, action *IndexedAction) error {
+	datasetAddress := addressValue(action.Input, "dataset_address")
+
+	current, err := fetchDatasetValues(dbTx, datasetAddress)
+	if err != nil {
+		return err
+	}
+
+	changedFields := map[string]interface{}{
+		"marketplace_asset_address": addressValue(action.Output, "marketplace_asset_address"),
+	}
+	return applyDatasetChanges(dbTx, datasetAddress, action, current, changedFields)
+}
+
+// Rollback restores the values replaced by orphaned revisions, newest first, and removes
+// the datasets created in orphaned blocks
+func (*datasetIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	rows, err := dbTx.Query(`
+		SELECT dataset_address, previous_values
+		FROM dataset_history
+		WHERE block_height >= $1 AND action_name <> 'CreateDataset'
+		ORDER BY block_height DESC, id DESC`, fromHeight)
+	if err != nil {
+		return err
+	}
+
+	type datasetChange struct {
+		datasetAddress string
+		previousValues map[string]interface{}
+	}
+	var changes []datasetChange
+	for rows.Next() {
+		var (
+			change             datasetChange
+			previousValuesJSON []byte
+		)
+		if err := rows.Scan(&change.datasetAddress, &previousValuesJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		if change.previousValues, err = decodeJSONMap(previousValuesJSON); err != nil {
+			rows.Close()
+			return err
+		}
+		changes = append(changes, change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if err := updateDatasetColumns(dbTx, change.datasetAddress, change.previousValues); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM dataset_history WHERE block_height >= $1`, fromHeight); err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(`DELETE FROM datasets WHERE block_height >= $1`, fromHeight)
+	return err
+}
+
+// fetchDatasetValues returns the current values of the updatable dataset columns, or nil
+// if the dataset is not indexed
+func fetchDatasetValues(dbTx *sql.Tx, datasetAddress string) (map[string]interface{}, error) {
+	var valuesJSON []byte
+	err := dbTx.QueryRow(`
+		SELECT json_build_object(
+			'name', name, 'description', description, 'categories', categories,
+			'license_name', license_name, 'license_symbol', license_symbol, 'license_url', license_url,
+			'is_community_dataset', is_community_dataset, 'marketplace_asset_address', marketplace_asset_address
+		)
+		FROM datasets
+		WHERE dataset_address = $1`, datasetAddress).Scan(&valuesJSON)
+	if err == sql.ErrNoRows {
+		log.Printf("Dataset %s updated before it was indexed\n", datasetAddress)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeJSONMap(valuesJSON)
+}
+
+// applyDatasetChanges writes the changed columns and records the revision with the values
+// they replaced
+func applyDatasetChanges(dbTx *sql.Tx, datasetAddress string, action *IndexedAction, current, changedFields map[string]interface{}) error {
+	previousValues := make(map[string]interface{}, len(changedFields))
+	if current != nil {
+		for column := range changedFields {
+			previousValues[column] = current[column]
+		}
+	}
+
+	if err := updateDatasetColumns(dbTx, datasetAddress, changedFields); err != nil {
+		return err
+	}
+	return insertDatasetHistory(dbTx, datasetAddress, action, changedFields, previousValues)
+}
+
+// updateDatasetColumns sets the given dataset columns. Only columns from datasetUpdateColumns are written.
+func updateDatasetColumns(dbTx *sql.Tx, datasetAddress string, values map[string]interface{}) error {
+	return updateColumns(dbTx, "datasets", "dataset_address", datasetAddress, datasetUpdateColumns, values)
+}
+
+func insertDatasetHistory(dbTx *sql.Tx, datasetAddress string, action *IndexedAction, changedFields, previousValues map[string]interface{}) error {
+	changedJSON, err := json.Marshal(changedFields)
+	if err != nil {
+		return err
+	}
+	previousValuesJSON, err := json.Marshal(previousValues)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO dataset_history (dataset_address, tx_hash, block_height, action_name, changed_fields, previous_values, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		datasetAddress, action.TxHash, action.BlockHeight, action.ActionName, changedJSON, previousValuesJSON, action.Timestamp)
+	return err
+}
@@ -0,0 +1,179 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestDatasetIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:       "create dataset",
+			actionType: vmconsts.CreateDatasetID,
+			actionName: "CreateDataset",
+			input:      `{"asset_address":"0xasset1","name":"Weather","description":"Observations","license_name":"MIT","is_community_dataset":true}`,
+			output:     `{"actor":"owner1","dataset_address":"asset1","dataset_parent_nft_address":"nft1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO datasets`)).
+					WithArgs("asset1", "asset1", "nft1", "owner1", "Weather", "Observations", "", "MIT", "", "", "",
+						true, "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO dataset_history`)).
+					WithArgs("asset1", "tx1", uint64(10), "CreateDataset",
+						[]byte(`{"description":"Observations","is_community_dataset":true,"license_name":"MIT","name":"Weather"}`),
+						[]byte(`{}`), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "update dataset without changes",
+			actionType: vmconsts.UpdateDatasetID,
+			actionName: "UpdateDataset",
+			input:      `{"dataset_address":"0xasset1","is_community_dataset":false}`,
+			output:     `{"actor":"owner1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT json_build_object(`)).
+					WithArgs("asset1").
+					WillReturnRows(sqlmock.NewRows([]string{"json_build_object"}).
+						AddRow([]byte(`{"name":"Weather","is_community_dataset":false}`)))
+			},
+		},
+		{
+			name:       "update dataset name and community flag",
+			actionType: vmconsts.UpdateDatasetID,
+			actionName: "UpdateDataset",
+			input:      `{"dataset_address":"0xasset1","name":"Climate","is_community_dataset":false}`,
+			output:     `{"actor":"owner1","name":"Climate"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT json_build_object(`)).
+					WithArgs("asset1").
+					WillReturnRows(sqlmock.NewRows([]string{"json_build_object"}).
+						AddRow([]byte(`{"name":"Weather","is_community_dataset":true}`)))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE datasets SET is_community_dataset = $1, name = $2 WHERE dataset_address = $3`)).
+					WithArgs(false, "Climate", "asset1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO dataset_history`)).
+					WithArgs("asset1", "tx1", uint64(10), "UpdateDataset",
+						[]byte(`{"is_community_dataset":false,"name":"Climate"}`),
+						[]byte(`{"is_community_dataset":true,"name":"Weather"}`), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "publish dataset to the marketplace",
+			actionType: vmconsts.PublishDatasetMarketplaceID,
+			actionName: "PublishDatasetMarketplace",
+			input:      `{"dataset_address":"0xasset1"}`,
+			output:     `{"marketplace_asset_address":"market1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT json_build_object(`)).
+					WithArgs("asset1").
+					WillReturnRows(sqlmock.NewRows([]string{"json_build_object"}).
+						AddRow([]byte(`{"marketplace_asset_address":null}`)))
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE datasets SET marketplace_asset_address = $1 WHERE dataset_address = $2`)).
+					WithArgs("market1", "asset1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO dataset_history`)).
+					WithArgs("asset1", "tx1", uint64(10), "PublishDatasetMarketplace",
+						[]byte(`{"marketplace_asset_address":"market1"}`),
+						[]byte(`{"marketplace_asset_address":null}`), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&datasetIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if err != nil {
+				t.Fatalf("Index() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDatasetIndexerRollback(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM dataset_history`)).
+		WithArgs(uint64(10)).
+		WillReturnRows(sqlmock.NewRows([]string{"dataset_address", "previous_values"}).
+			AddRow("asset1", []byte(`{"marketplace_asset_address":null}`)).
+			AddRow("asset1", []byte(`{"name":"Weather"}`)))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE datasets SET marketplace_asset_address = $1 WHERE dataset_address = $2`)).
+		WithArgs(nil, "asset1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE datasets SET name = $1 WHERE dataset_address = $2`)).
+		WithArgs("Weather", "asset1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM dataset_history WHERE block_height >= $1`)).
+		WithArgs(uint64(10)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM datasets WHERE block_height >= $1`)).
+		WithArgs(uint64(10)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := (&datasetIndexer{}).Rollback(dbTx, 10); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatasetIndexerRollbackRestoresRows(t *testing.T) {
+	create := func(height uint64, datasetAddress string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.CreateDatasetID, actionName: "CreateDataset",
+			input: `{"asset_address":"0x` + datasetAddress + `","name":"Data","description":"desc","categories":"ai",` +
+				`"license_name":"MIT","license_symbol":"MIT","license_url":"url","metadata":"meta","is_community_dataset":false}`,
+			output: `{"dataset_address":"` + datasetAddress + `"}`,
+		}
+	}
+	update := func(height uint64, name string, isCommunityDataset bool) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.UpdateDatasetID, actionName: "UpdateDataset",
+			input:  fmt.Sprintf(`{"dataset_address":"0xdataset1","is_community_dataset":%t}`, isCommunityDataset),
+			output: `{"name":"` + name + `"}`,
+		}
+	}
+	testIndexerRollback(t, &datasetIndexer{}, 3, []testAction{
+		create(1, "dataset1"),
+		update(2, "Second", false),
+		// The orphaned revisions are reverted newest first and the orphaned dataset is removed
+		update(3, "Third", true),
+		{
+			height: 4, actionType: vmconsts.PublishDatasetMarketplaceID, actionName: "PublishDatasetMarketplace",
+			input: `{"dataset_address":"0xdataset1"}`, output: `{"marketplace_asset_address":"0xmarketplace1"}`,
+		},
+		create(4, "dataset2"),
+	})
+}
@@ -0,0 +1,152 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+// Delegation statuses stored in the delegations table
+const (
+	delegationStatusActive      = "active"
+	delegationStatusUndelegated = "undelegated"
+)
+
+// delegationIndexer keeps the delegations table in sync with the stakes users delegate to
+// validators and records the rewards they receive in delegation_rewards
+type delegationIndexer struct{}
+
+func init() {
+	indexer := &delegationIndexer{}
+	RegisterActionIndexer(vmconsts.DelegateUserStakeID, indexer)
+	RegisterActionIndexer(vmconsts.UndelegateUserStakeID, indexer)
+	RegisterActionIndexer(vmconsts.ClaimDelegationStakeRewardsID, indexer)
+}
+
+func (*delegationIndexer) Tables() []string {
+	return []string{"delegations", "delegation_rewards"}
+}
+
+func (d *delegationIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.DelegateUserStakeID:
+		return d.delegate(dbTx, action)
+	case vmconsts.UndelegateUserStakeID:
+		return d.undelegate(dbTx, action)
+	case vmconsts.ClaimDelegationStakeRewardsID:
+		return d.claimRewards(dbTx, action)
+	}
+	return nil
+}
+
+// delegator returns the address of the user who delegated the stake of the action
+func delegator(action *IndexedAction) string {
+	if actor := addressValue(action.Output, "actor"); actor != "" {
+		return actor
+	}
+	return action.Sponsor
+}
+
+func (*delegationIndexer) delegate(dbTx *sql.Tx, action *IndexedAction) error {
+	nodeID := stringValue(action.Input, "node_id")
+	stakeStartBlock, err := uint64Value(action.Input, "stake_start_block")
+	if err != nil {
+		return err
+	}
+	stakeEndBlock, err := uint64Value(action.Input, "stake_end_block")
+	if err != nil {
+		return err
+	}
+	stakedAmount, err := uint64Value(action.Output, "staked_amount")
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO delegations (
+			delegator, node_id, staked_amount, stake_start_block, stake_end_block, status, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		delegator(action), nodeID, stakedAmount, stakeStartBlock, stakeEndBlock, delegationStatusActive,
+		action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+// undelegate closes the delegation and records the rewards paid out with the stake
+func (d *delegationIndexer) undelegate(dbTx *sql.Tx, action *IndexedAction) error {
+	nodeID := stringValue(action.Input, "node_id")
+	stakeStartBlock, err := uint64Value(action.Output, "stake_start_block")
+	if err != nil {
+		return err
+	}
+	rewardAmount, err := numericValue(action.Output, "reward_amount")
+	if err != nil {
+		return err
+	}
+
+	result, err := dbTx.Exec(`
+		UPDATE delegations
+		SET status = $4, undelegation_tx_hash = $5, undelegation_block_height = $6, undelegated_at = $7
+		WHERE delegator = $1 AND node_id = $2 AND stake_start_block = $3 AND status = $8`,
+		delegator(action), nodeID, stakeStartBlock, delegationStatusUndelegated,
+		action.TxHash, action.BlockHeight, action.Timestamp, delegationStatusActive)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		log.Printf("Undelegated stake of %s to %s starting at block %d is not indexed\n", delegator(action), nodeID, stakeStartBlock)
+	}
+
+	return d.recordReward(dbTx, action, nodeID, stakeStartBlock, rewardAmount)
+}
+
+// claimRewards records the rewards paid to the delegator
+func (d *delegationIndexer) claimRewards(dbTx *sql.Tx, action *IndexedAction) error {
+	nodeID := stringValue(action.Input, "node_id")
+	stakeStartBlock, err := uint64Value(action.Output, "stake_start_block")
+	if err != nil {
+		return err
+	}
+	balanceBefore, err := uint64Value(action.Output, "balance_before_claim")
+	if err != nil {
+		return err
+	}
+	balanceAfter, err := uint64Value(action.Output, "balance_after_claim")
+	if err != nil {
+		return err
+	}
+	if balanceAfter < balanceBefore {
+		return fmt.Errorf("balance after claim %d is lower than before %d", balanceAfter, balanceBefore)
+	}
+
+	return d.recordReward(dbTx, action, nodeID, stakeStartBlock, fmt.Sprint(balanceAfter-balanceBefore))
+}
+
+func (*delegationIndexer) recordReward(dbTx *sql.Tx, action *IndexedAction, nodeID string, stakeStartBlock uint64, rewardAmount string) error {
+	_, err := dbTx.Exec(`
+		INSERT INTO delegation_rewards (delegator, node_id, stake_start_block, tx_hash, block_height, action_name, reward_amount, distributed_to, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		delegator(action), nodeID, stakeStartBlock, action.TxHash, action.BlockHeight, action.ActionName, rewardAmount,
+		addressValue(action.Output, "distributed_to"), action.Timestamp)
+	return err
+}
+
+func (*delegationIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	if _, err := dbTx.Exec(`DELETE FROM delegation_rewards WHERE block_height >= $1`, fromHeight); err != nil {
+		return err
+	}
+	_, err := dbTx.Exec(`
+		UPDATE delegations
+		SET status = $2, undelegation_tx_hash = NULL, undelegation_block_height = NULL, undelegated_at = NULL
+		WHERE undelegation_block_height >= $1`, fromHeight, delegationStatusActive)
+	if err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(`DELETE FROM delegations WHERE block_height >= $1`, fromHeight)
+	return err
+}
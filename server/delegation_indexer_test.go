@@ -0,0 +1,173 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestDelegationIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "delegate user stake",
+			actionType: vmconsts.DelegateUserStakeID,
+			actionName: "DelegateUserStake",
+			input:      `{"node_id":"node1","stake_start_block":100,"stake_end_block":200,"staked_amount":5000}`,
+			output:     `{"actor":"delegator1","staked_amount":5000,"balance_before_stake":6000,"balance_after_stake":1000}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO delegations`)).
+					WithArgs("delegator1", "node1", uint64(5000), uint64(100), uint64(200), delegationStatusActive,
+						"tx1", uint64(300), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "delegate user stake without end block",
+			actionType: vmconsts.DelegateUserStakeID,
+			actionName: "DelegateUserStake",
+			input:      `{"node_id":"node1","stake_start_block":100}`,
+			output:     `{"staked_amount":5000}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+		{
+			name:       "undelegate user stake",
+			actionType: vmconsts.UndelegateUserStakeID,
+			actionName: "UndelegateUserStake",
+			input:      `{"node_id":"node1"}`,
+			output:     `{"stake_start_block":100,"reward_amount":250,"distributed_to":"delegator1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				// Only an active delegation is closed
+				mock.ExpectExec(regexp.QuoteMeta(`WHERE delegator = $1 AND node_id = $2 AND stake_start_block = $3 AND status = $8`)).
+					WithArgs("sponsor1", "node1", uint64(100), delegationStatusUndelegated,
+						"tx1", uint64(300), "2024-01-01T00:00:00Z", delegationStatusActive).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO delegation_rewards`)).
+					WithArgs("sponsor1", "node1", uint64(100), "tx1", uint64(300), "UndelegateUserStake", "250", "delegator1", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "undelegate user stake that is not indexed",
+			actionType: vmconsts.UndelegateUserStakeID,
+			actionName: "UndelegateUserStake",
+			input:      `{"node_id":"node1"}`,
+			output:     `{"stake_start_block":100,"reward_amount":0,"distributed_to":"delegator1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE delegations`)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO delegation_rewards`)).
+					WithArgs("sponsor1", "node1", uint64(100), "tx1", uint64(300), "UndelegateUserStake", "0", "delegator1", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "claim delegation stake rewards",
+			actionType: vmconsts.ClaimDelegationStakeRewardsID,
+			actionName: "ClaimDelegationStakeRewards",
+			input:      `{"node_id":"node1"}`,
+			output:     `{"actor":"delegator1","stake_start_block":100,"balance_before_claim":1000,"balance_after_claim":1250,"distributed_to":"delegator1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO delegation_rewards`)).
+					WithArgs("delegator1", "node1", uint64(100), "tx1", uint64(300), "ClaimDelegationStakeRewards", "250", "delegator1", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "claim delegation stake rewards with lower balance",
+			actionType: vmconsts.ClaimDelegationStakeRewardsID,
+			actionName: "ClaimDelegationStakeRewards",
+			input:      `{"node_id":"node1"}`,
+			output:     `{"stake_start_block":100,"balance_before_claim":1250,"balance_after_claim":1000}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&delegationIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 300,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDelegationIndexerRollback(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM delegation_rewards WHERE block_height >= $1`)).
+		WithArgs(uint64(300)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Delegations undelegated in the orphaned blocks are active again
+	mock.ExpectExec(regexp.QuoteMeta(`SET status = $2, undelegation_tx_hash = NULL, undelegation_block_height = NULL, undelegated_at = NULL`)).
+		WithArgs(uint64(300), delegationStatusActive).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM delegations WHERE block_height >= $1`)).
+		WithArgs(uint64(300)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := (&delegationIndexer{}).Rollback(dbTx, 300); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDelegationIndexerRollbackRestoresRows(t *testing.T) {
+	delegate := func(height uint64, nodeID string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.DelegateUserStakeID, actionName: "DelegateUserStake",
+			input:  `{"node_id":"` + nodeID + `","stake_start_block":1,"stake_end_block":100}`,
+			output: `{"actor":"0xdelegator1","staked_amount":500}`,
+		}
+	}
+	claim := func(height uint64) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.ClaimDelegationStakeRewardsID, actionName: "ClaimDelegationStakeRewards",
+			input:  `{"node_id":"node1"}`,
+			output: `{"actor":"0xdelegator1","stake_start_block":1,"balance_before_claim":100,"balance_after_claim":130,"distributed_to":"0xdelegator1"}`,
+		}
+	}
+	testIndexerRollback(t, &delegationIndexer{}, 3, []testAction{
+		delegate(1, "node1"),
+		claim(2),
+		// The delegation is active again and the orphaned rewards and delegation are removed
+		claim(3),
+		{
+			height: 3, actionType: vmconsts.UndelegateUserStakeID, actionName: "UndelegateUserStake",
+			input:  `{"node_id":"node1"}`,
+			output: `{"actor":"0xdelegator1","stake_start_block":1,"reward_amount":10,"distributed_to":"0xdelegator1"}`,
+		},
+		delegate(4, "node2"),
+	})
+}
@@ -0,0 +1,351 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nuklai/nuklaivm-external-subscriber/consts"
+)
+
+// reindexBatchSize is the number of stored blocks replayed per query during a reindex
+const reindexBatchSize = 1000
+
+// IndexedAction is a successfully executed action passed to the indexers of its type
+type IndexedAction struct {
+	BlockHeight uint64
+	TxHash      string
+	Sponsor     string
+	ActionType  uint8
+	ActionName  string
+	ActionIndex int
+	Input       map[string]interface{}
+	Output      map[string]interface{}
+	Timestamp   string
+}
+
+// ActionIndexer derives structured rows from the actions of the types it is registered for.
+// Every indexer owns its tables: it is the only code writing to them during ingestion.
+type ActionIndexer interface {
+	// Tables returns the tables owned by the indexer
+	Tables() []string
+	// Index stores the rows derived from a successfully executed action
+	Index(dbTx *sql.Tx, action *IndexedAction) error
+	// Rollback removes or reverts every row derived from blocks at or above fromHeight
+	Rollback(dbTx *sql.Tx, fromHeight uint64) error
+}
+
+// ActionReindexer is implemented by indexers that rebuild their tables differently than
+// by rolling back everything and replaying the stored actions through Index
+type ActionReindexer interface {
+	Reindex(dbTx *sql.Tx, actionTypes []uint8) error
+}
+
+var (
+	// actionIndexers maps each action type to the indexers registered for it
+	actionIndexers = map[uint8][]ActionIndexer{}
+	// registeredIndexers lists every indexer once, in registration order
+	registeredIndexers []ActionIndexer
+)
+
+// RegisterActionIndexer registers an indexer for an action type from consts.ActionNames.
+// The same indexer can be registered for several action types.
+func RegisterActionIndexer(actionType uint8, indexer ActionIndexer) {
+	if _, ok := consts.ActionNames[actionType]; !ok {
+		panic(fmt.Sprintf("cannot register indexer for unknown action type %d", actionType))
+	}
+	actionIndexers[actionType] = append(actionIndexers[actionType], indexer)
+
+	for _, registered := range registeredIndexers {
+		if registered == indexer {
+			return
+		}
+	}
+	registeredIndexers = append(registeredIndexers, indexer)
+}
+
+// indexAction passes the action to every indexer registered for its type
+func indexAction(dbTx *sql.Tx, action *IndexedAction) error {
+	for _, indexer := range actionIndexers[action.ActionType] {
+		if err := indexer.Index(dbTx, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackActionIndexers reverts the rows of every indexer derived from blocks at or above
+// fromHeight. Indexers are rolled back in reverse registration order.
+func rollbackActionIndexers(dbTx *sql.Tx, fromHeight uint64) error {
+	for i := len(registeredIndexers) - 1; i >= 0; i-- {
+		indexer := registeredIndexers[i]
+		if err := indexer.Rollback(dbTx, fromHeight); err != nil {
+			return fmt.Errorf("error rolling back %v: %w", indexer.Tables(), err)
+		}
+	}
+	return nil
+}
+
+// indexerActionTypes returns the action types the indexer is registered for
+func indexerActionTypes(indexer ActionIndexer) []uint8 {
+	var actionTypes []uint8
+	for actionType, indexers := range actionIndexers {
+		for _, registered := range indexers {
+			if registered == indexer {
+				actionTypes = append(actionTypes, actionType)
+				break
+			}
+		}
+	}
+	return actionTypes
+}
+
+// ReindexActions rebuilds the tables of the indexers owning any of the given tables from
+// the actions already stored in the database. All indexers are rebuilt when no table is given.
+func ReindexActions(dbConn *sql.DB, tables []string) error {
+	indexers := selectIndexers(tables)
+	if len(indexers) == 0 {
+		return fmt.Errorf("no indexer owns any of the tables %v", tables)
+	}
+
+	dbTx, err := dbConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	// Keep new blocks from being written while the tables are rebuilt
+	if _, err := dbTx.Exec(`SELECT pg_advisory_xact_lock($1)`, blockIngestLockID); err != nil {
+		return fmt.Errorf("error acquiring block ingestion lock: %w", err)
+	}
+
+	for _, indexer := range indexers {
+		log.Printf("Reindexing %v...\n", indexer.Tables())
+		if err := reindexActionIndexer(dbTx, indexer); err != nil {
+			return fmt.Errorf("error reindexing %v: %w", indexer.Tables(), err)
+		}
+	}
+
+	return dbTx.Commit()
+}
+
+// selectIndexers returns the registered indexers owning any of the given tables
+func selectIndexers(tables []string) []ActionIndexer {
+	if len(tables) == 0 {
+		return registeredIndexers
+	}
+
+	var selected []ActionIndexer
+	for _, indexer := range registeredIndexers {
+		for _, owned := range indexer.Tables() {
+			if containsString(tables, owned) {
+				selected = append(selected, indexer)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// reindexActionIndexer rolls back every row of the indexer and replays the stored
+// successful actions of its types in chain order
+func reindexActionIndexer(dbTx *sql.Tx, indexer ActionIndexer) error {
+	actionTypes := indexerActionTypes(indexer)
+	if reindexer, ok := indexer.(ActionReindexer); ok {
+		return reindexer.Reindex(dbTx, actionTypes)
+	}
+
+	if err := indexer.Rollback(dbTx, 0); err != nil {
+		return err
+	}
+
+	replayed := 0
+	err := replayStoredTransactions(dbTx, actionTypes, false, func(tx *storedTransaction) error {
+		for i := range tx.Actions {
+			if err := indexer.Index(dbTx, &tx.Actions[i]); err != nil {
+				return fmt.Errorf("error replaying action %d of tx %s: %w", tx.Actions[i].ActionIndex, tx.TxHash, err)
+			}
+		}
+		replayed += len(tx.Actions)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Replayed %d actions into %v\n", replayed, indexer.Tables())
+	return nil
+}
+
+// storedTransaction is a transaction read back from the database during a reindex
+type storedTransaction struct {
+	BlockHeight uint64
+	TxHash      string
+	Sponsor     string
+	Fee         uint64
+	Success     bool
+	Timestamp   string
+	// Actions holds the successful actions of the replayed action types
+	Actions []IndexedAction
+}
+
+// replayStoredTransactions calls replay for the stored transactions in chain order. Only
+// transactions with successful actions of the given types are replayed, unless
+// allTransactions is set.
+func replayStoredTransactions(dbTx *sql.Tx, actionTypes []uint8, allTransactions bool, replay func(*storedTransaction) error) error {
+	var lowestHeight, highestHeight sql.NullInt64
+	err := dbTx.QueryRow(`SELECT MIN(block_height), MAX(block_height) FROM blocks`).Scan(&lowestHeight, &highestHeight)
+	if err != nil {
+		return fmt.Errorf("error fetching stored block range: %w", err)
+	}
+	if !lowestHeight.Valid {
+		return nil
+	}
+
+	for fromHeight := lowestHeight.Int64; fromHeight <= highestHeight.Int64; fromHeight += reindexBatchSize {
+		toHeight := fromHeight + reindexBatchSize
+
+		actions, err := fetchStoredActions(dbTx, actionTypes, fromHeight, toHeight)
+		if err != nil {
+			return err
+		}
+		if len(actions) == 0 && !allTransactions {
+			continue
+		}
+
+		txs, err := fetchStoredTransactions(dbTx, fromHeight, toHeight)
+		if err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			tx.Actions = actions[tx.TxHash]
+			if len(tx.Actions) == 0 && !allTransactions {
+				continue
+			}
+			if err := replay(tx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchStoredTransactions returns the stored transactions of the blocks in [fromHeight, toHeight)
+// in chain order
+func fetchStoredTransactions(dbTx *sql.Tx, fromHeight, toHeight int64) ([]*storedTransaction, error) {
+	rows, err := dbTx.Query(`
+		SELECT blocks.block_height, transactions.tx_hash, transactions.sponsor, transactions.fee,
+		       transactions.success, transactions.timestamp
+		FROM transactions
+		INNER JOIN blocks ON transactions.block_hash = blocks.block_hash
+		WHERE blocks.block_height >= $1 AND blocks.block_height < $2
+		ORDER BY blocks.block_height, transactions.id`, fromHeight, toHeight)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching stored transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var txs []*storedTransaction
+	for rows.Next() {
+		var (
+			tx        storedTransaction
+			timestamp time.Time
+		)
+		if err := rows.Scan(&tx.BlockHeight, &tx.TxHash, &tx.Sponsor, &tx.Fee, &tx.Success, &timestamp); err != nil {
+			return nil, err
+		}
+		tx.Timestamp = timestamp.UTC().Format(time.RFC3339)
+		txs = append(txs, &tx)
+	}
+	return txs, rows.Err()
+}
+
+// fetchStoredActions returns the stored successful actions of the given types in the blocks
+// in [fromHeight, toHeight), grouped by transaction hash in action order
+func fetchStoredActions(dbTx *sql.Tx, actionTypes []uint8, fromHeight, toHeight int64) (map[string][]IndexedAction, error) {
+	types := make([]int64, len(actionTypes))
+	for i, actionType := range actionTypes {
+		types[i] = int64(actionType)
+	}
+
+	rows, err := dbTx.Query(`
+		SELECT blocks.block_height, actions.tx_hash, transactions.sponsor,
+		       actions.action_type, actions.action_name, actions.action_index,
+		       actions.input, actions.output, actions.timestamp
+		FROM actions
+		INNER JOIN transactions ON actions.tx_hash = transactions.tx_hash
+		INNER JOIN blocks ON transactions.block_hash = blocks.block_hash
+		WHERE transactions.success
+		  AND actions.action_type = ANY($1)
+		  AND blocks.block_height >= $2 AND blocks.block_height < $3
+		ORDER BY blocks.block_height, actions.tx_hash, actions.action_index`, pq.Array(types), fromHeight, toHeight)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching stored actions: %w", err)
+	}
+	defer rows.Close()
+
+	actions := map[string][]IndexedAction{}
+	for rows.Next() {
+		var (
+			action                IndexedAction
+			inputJSON, outputJSON []byte
+			timestamp             time.Time
+		)
+		if err := rows.Scan(&action.BlockHeight, &action.TxHash, &action.Sponsor,
+			&action.ActionType, &action.ActionName, &action.ActionIndex,
+			&inputJSON, &outputJSON, &timestamp); err != nil {
+			return nil, err
+		}
+		if action.Input, err = decodeJSONMap(inputJSON); err != nil {
+			return nil, fmt.Errorf("error decoding input of action %d of tx %s: %w", action.ActionIndex, action.TxHash, err)
+		}
+		if action.Output, err = decodeJSONMap(outputJSON); err != nil {
+			return nil, fmt.Errorf("error decoding output of action %d of tx %s: %w", action.ActionIndex, action.TxHash, err)
+		}
+		action.Timestamp = timestamp.UTC().Format(time.RFC3339)
+		actions[action.TxHash] = append(actions[action.TxHash], action)
+	}
+	return actions, rows.Err()
+}
+
+// updateColumns sets the given columns of the row of table whose keyColumn equals key.
+// Columns missing from allowedColumns are ignored.
+func updateColumns(dbTx *sql.Tx, table, keyColumn, key string, allowedColumns []string, values map[string]interface{}) error {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		if containsString(allowedColumns, column) {
+			columns = append(columns, column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	sort.Strings(columns)
+
+	assignments := make([]string, len(columns))
+	args := make([]interface{}, 0, len(columns)+1)
+	for i, column := range columns {
+		assignments[i] = fmt.Sprintf("%s = $%d", column, i+1)
+		args = append(args, values[column])
+	}
+	args = append(args, key)
+
+	_, err := dbTx.Exec(fmt.Sprintf(`UPDATE %s SET %s WHERE %s = $%d`, table, strings.Join(assignments, ", "), keyColumn, len(args)), args...)
+	return err
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newMockTx returns a transaction on a mocked database
+func newMockTx(t *testing.T) (*sql.Tx, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mock.ExpectBegin()
+	dbTx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("error beginning transaction: %v", err)
+	}
+	return dbTx, mock
+}
+
+// jsonMap decodes an action input or output the way stored actions are decoded
+func jsonMap(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+
+	values, err := decodeJSONMap([]byte(data))
+	if err != nil {
+		t.Fatalf("error decoding %s: %v", data, err)
+	}
+	return values
+}
+
+// recordingIndexer records the order in which indexers are rolled back
+type recordingIndexer struct {
+	name       string
+	rolledBack *[]string
+}
+
+func (r *recordingIndexer) Tables() []string { return []string{r.name} }
+
+func (*recordingIndexer) Index(*sql.Tx, *IndexedAction) error { return nil }
+
+func (r *recordingIndexer) Rollback(*sql.Tx, uint64) error {
+	*r.rolledBack = append(*r.rolledBack, r.name)
+	return nil
+}
+
+func TestRollbackActionIndexersOrder(t *testing.T) {
+	savedIndexers := registeredIndexers
+	t.Cleanup(func() { registeredIndexers = savedIndexers })
+
+	var rolledBack []string
+	registeredIndexers = []ActionIndexer{
+		&recordingIndexer{name: "first", rolledBack: &rolledBack},
+		&recordingIndexer{name: "second", rolledBack: &rolledBack},
+		&recordingIndexer{name: "third", rolledBack: &rolledBack},
+	}
+
+	if err := rollbackActionIndexers(nil, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"third", "second", "first"}; !reflect.DeepEqual(rolledBack, want) {
+		t.Fatalf("rolled back %v, want %v", rolledBack, want)
+	}
+}
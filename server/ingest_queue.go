@@ -0,0 +1,481 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+const (
+	// ingestSegmentSize is the size after which the queue starts a new segment file
+	ingestSegmentSize = 64 << 20
+	// ingestRecordHeaderSize is the size of the length, checksum and enqueue time of a record
+	ingestRecordHeaderSize = 16
+	// ingestMaxRecordSize bounds the length read from a record header
+	ingestMaxRecordSize = 256 << 20
+
+	ingestSegmentPrefix  = "segment-"
+	ingestSegmentSuffix  = ".log"
+	ingestCheckpointFile = "checkpoint"
+	// ingestDeadLetterDir holds the blocks that could not be written to the database
+	ingestDeadLetterDir = "dead-letter"
+)
+
+// errCorruptRecord is returned when a record is truncated or fails its checksum
+var errCorruptRecord = errors.New("corrupt ingest queue record")
+
+// ingestPosition locates a record in the queue
+type ingestPosition struct {
+	segment uint64
+	offset  int64
+}
+
+// ingestRecord is a block waiting to be written to the database
+type ingestRecord struct {
+	data       []byte
+	enqueuedAt time.Time
+	// next is the position following the record
+	next ingestPosition
+}
+
+// ingestQueue is a durable FIFO of raw blocks stored in append-only segment files.
+// Every record is written as
+//
+//	length (4 bytes) | CRC-32 of the rest (4 bytes) | enqueue time in unix nanoseconds (8 bytes) | block bytes
+//
+// and synced before Enqueue returns. The position of the next record to ingest is kept in
+// the checkpoint file, so the records that were not yet ingested are replayed after a crash.
+// A record can be replayed after it was ingested if the process stops before its checkpoint
+// is written, which is harmless since blocks that are already indexed are skipped.
+type ingestQueue struct {
+	dir         string
+	segmentSize int64
+
+	mu     sync.Mutex
+	writer *os.File
+	head   ingestPosition // next record to ingest
+	tail   ingestPosition // end of the last record
+	depth  int
+	// headEnqueuedAt is the enqueue time of the oldest record, zero if unknown or empty
+	headEnqueuedAt time.Time
+	lastIngestedAt *time.Time
+	lastError      string
+
+	// notify wakes up the worker waiting for new records
+	notify chan struct{}
+}
+
+// openIngestQueue opens the queue stored in dir, creating it if needed. A record left
+// incomplete by a crash at the end of the last segment is discarded.
+func openIngestQueue(dir string) (*ingestQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating ingest queue directory: %w", err)
+	}
+	q := &ingestQueue{dir: dir, segmentSize: ingestSegmentSize, notify: make(chan struct{}, 1)}
+
+	segments, err := q.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	head, err := q.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		head = &ingestPosition{segment: 1}
+		if len(segments) > 0 {
+			head.segment = segments[0]
+		}
+	}
+	q.head = *head
+
+	// Segments before the checkpoint have been ingested
+	for len(segments) > 0 && segments[0] < q.head.segment {
+		if err := os.Remove(q.segmentPath(segments[0])); err != nil {
+			return nil, fmt.Errorf("error removing ingested segment: %w", err)
+		}
+		segments = segments[1:]
+	}
+	// Start from the first remaining segment if the checkpointed one no longer exists
+	if len(segments) == 0 || segments[0] != q.head.segment {
+		q.head.offset = 0
+		if len(segments) > 0 {
+			q.head.segment = segments[0]
+		}
+	}
+
+	// Count the pending records and find the end of the last valid record
+	q.tail = q.head
+	for i, segment := range segments {
+		offset := int64(0)
+		if segment == q.head.segment {
+			offset = q.head.offset
+		}
+		count, end, err := q.scanSegment(segment, offset)
+		if err != nil && (!errors.Is(err, errCorruptRecord) || i < len(segments)-1) {
+			return nil, fmt.Errorf("error reading ingest queue segment %d: %w", segment, err)
+		}
+		if err != nil {
+			log.Printf("Discarding incomplete record at the end of ingest queue segment %d\n", segment)
+			if err := os.Truncate(q.segmentPath(segment), end); err != nil {
+				return nil, fmt.Errorf("error truncating ingest queue segment %d: %w", segment, err)
+			}
+		}
+		q.depth += count
+		q.tail = ingestPosition{segment: segment, offset: end}
+	}
+
+	q.writer, err = os.OpenFile(q.segmentPath(q.tail.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening ingest queue segment: %w", err)
+	}
+	if q.depth > 0 {
+		log.Printf("Ingest queue has %d blocks to replay\n", q.depth)
+	}
+	return q, nil
+}
+
+// Enqueue durably appends a block to the queue
+func (q *ingestQueue) Enqueue(data []byte) error {
+	now := time.Now()
+	record := make([]byte, ingestRecordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint64(record[8:16], uint64(now.UnixNano()))
+	copy(record[ingestRecordHeaderSize:], data)
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[8:]))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.tail.offset >= q.segmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := q.writer.Write(record); err != nil {
+		return fmt.Errorf("error appending to ingest queue: %w", err)
+	}
+	if err := q.writer.Sync(); err != nil {
+		return fmt.Errorf("error syncing ingest queue: %w", err)
+	}
+	q.tail.offset += int64(len(record))
+	q.depth++
+	if q.depth == 1 {
+		q.headEnqueuedAt = now
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// rotate starts a new segment file
+func (q *ingestQueue) rotate() error {
+	if err := q.writer.Close(); err != nil {
+		return fmt.Errorf("error closing ingest queue segment: %w", err)
+	}
+	next := ingestPosition{segment: q.tail.segment + 1}
+	writer, err := os.OpenFile(q.segmentPath(next.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating ingest queue segment: %w", err)
+	}
+	q.writer, q.tail = writer, next
+	return q.syncDir()
+}
+
+// Next returns the oldest record without removing it, or nil if the queue is empty
+func (q *ingestQueue) Next() (*ingestRecord, error) {
+	q.mu.Lock()
+	head, tail := q.head, q.tail
+	q.mu.Unlock()
+
+	for head != tail {
+		file, err := os.Open(q.segmentPath(head.segment))
+		if err != nil {
+			return nil, fmt.Errorf("error opening ingest queue segment: %w", err)
+		}
+		record, err := readIngestRecord(file, head.offset)
+		file.Close()
+		if errors.Is(err, io.EOF) && head.segment < tail.segment {
+			// The segment is exhausted, continue with the next one
+			head = ingestPosition{segment: head.segment + 1}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading ingest queue segment %d: %w", head.segment, err)
+		}
+
+		record.next = ingestPosition{segment: head.segment, offset: head.offset + ingestRecordHeaderSize + int64(len(record.data))}
+		q.mu.Lock()
+		q.headEnqueuedAt = record.enqueuedAt
+		q.mu.Unlock()
+		return record, nil
+	}
+	return nil, nil
+}
+
+// Commit removes a record returned by Next once it has been written to the database
+func (q *ingestQueue) Commit(record *ingestRecord) error {
+	if err := q.writeCheckpoint(record.next); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	previous := q.head.segment
+	q.head = record.next
+	q.depth--
+	q.headEnqueuedAt = time.Time{}
+	now := time.Now().UTC()
+	q.lastIngestedAt = &now
+	q.lastError = ""
+	q.mu.Unlock()
+
+	// Remove the segments that have been fully ingested
+	for segment := previous; segment < record.next.segment; segment++ {
+		if err := os.Remove(q.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing ingested segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeadLetter removes a record returned by Next that cannot be written to the database,
+// so the records after it are ingested. Its block is kept in the dead letter directory,
+// one file per block, which the backfill command reads once the cause is fixed.
+func (q *ingestQueue) DeadLetter(record *ingestRecord) (string, error) {
+	dir := filepath.Join(q.dir, ingestDeadLetterDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("error creating dead letter directory: %w", err)
+	}
+
+	// A crash before the record is committed writes the same file again
+	path := filepath.Join(dir, fmt.Sprintf("block-%d", record.enqueuedAt.UnixNano()))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("error writing dead letter block: %w", err)
+	}
+	if _, err := file.Write(record.data); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error writing dead letter block: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return "", fmt.Errorf("error syncing dead letter block: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("error writing dead letter block: %w", err)
+	}
+
+	return path, q.Commit(record)
+}
+
+// deadLettered returns the number of blocks waiting in the dead letter directory
+func (q *ingestQueue) deadLettered() int {
+	entries, err := os.ReadDir(filepath.Join(q.dir, ingestDeadLetterDir))
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			count++
+		}
+	}
+	return count
+}
+
+// Wait blocks until a record is enqueued or the timeout expires
+func (q *ingestQueue) Wait(timeout time.Duration) {
+	select {
+	case <-q.notify:
+	case <-time.After(timeout):
+	}
+}
+
+// SetError records the error that prevents the oldest record from being ingested
+func (q *ingestQueue) SetError(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastError = err.Error()
+}
+
+// Status reports the number of pending blocks, how long the oldest has been waiting and
+// the number of blocks set aside as dead letters
+func (q *ingestQueue) Status() models.IngestQueueStatus {
+	deadLettered := q.deadLettered()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status := models.IngestQueueStatus{
+		Depth:          q.depth,
+		LastIngestedAt: q.lastIngestedAt,
+		LastError:      q.lastError,
+		DeadLettered:   deadLettered,
+	}
+	if q.depth > 0 && !q.headEnqueuedAt.IsZero() {
+		enqueuedAt := q.headEnqueuedAt.UTC()
+		status.OldestEnqueuedAt = &enqueuedAt
+		status.LagSeconds = time.Since(enqueuedAt).Seconds()
+	}
+	return status
+}
+
+// Close closes the segment being written
+func (q *ingestQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.writer.Close()
+}
+
+// readIngestRecord reads the record at offset. It returns io.EOF at the end of the
+// segment and errCorruptRecord for a truncated or damaged record.
+func readIngestRecord(file *os.File, offset int64) (*ingestRecord, error) {
+	header := make([]byte, ingestRecordHeaderSize)
+	n, err := file.ReadAt(header, offset)
+	if n == 0 && errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if n < ingestRecordHeaderSize {
+		return nil, errCorruptRecord
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > ingestMaxRecordSize {
+		return nil, errCorruptRecord
+	}
+	body := make([]byte, 8+int(length))
+	copy(body, header[8:])
+	if n, _ := file.ReadAt(body[8:], offset+ingestRecordHeaderSize); n < int(length) {
+		return nil, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, errCorruptRecord
+	}
+
+	return &ingestRecord{
+		data:       body[8:],
+		enqueuedAt: time.Unix(0, int64(binary.BigEndian.Uint64(body[0:8]))),
+	}, nil
+}
+
+// scanSegment counts the valid records of a segment from offset and returns the end of
+// the last one. A corrupt record stops the scan with errCorruptRecord.
+func (q *ingestQueue) scanSegment(segment uint64, offset int64) (int, int64, error) {
+	file, err := os.Open(q.segmentPath(segment))
+	if err != nil {
+		return 0, offset, err
+	}
+	defer file.Close()
+
+	count := 0
+	for {
+		record, err := readIngestRecord(file, offset)
+		if errors.Is(err, io.EOF) {
+			return count, offset, nil
+		}
+		if err != nil {
+			return count, offset, err
+		}
+		count++
+		offset += ingestRecordHeaderSize + int64(len(record.data))
+	}
+}
+
+// listSegments returns the numbers of the segment files in ascending order
+func (q *ingestQueue) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing ingest queue directory: %w", err)
+	}
+	var segments []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, ingestSegmentPrefix) || !strings.HasSuffix(name, ingestSegmentSuffix) {
+			continue
+		}
+		segment, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, ingestSegmentPrefix), ingestSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+func (q *ingestQueue) segmentPath(segment uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%s%020d%s", ingestSegmentPrefix, segment, ingestSegmentSuffix))
+}
+
+// readCheckpoint returns the stored position of the next record to ingest, or nil if
+// no record has been ingested yet
+func (q *ingestQueue) readCheckpoint() (*ingestPosition, error) {
+	data, err := os.ReadFile(filepath.Join(q.dir, ingestCheckpointFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading ingest queue checkpoint: %w", err)
+	}
+	var position ingestPosition
+	if _, err := fmt.Sscanf(string(data), "%d %d", &position.segment, &position.offset); err != nil {
+		return nil, fmt.Errorf("error parsing ingest queue checkpoint: %w", err)
+	}
+	return &position, nil
+}
+
+// writeCheckpoint atomically replaces the stored position of the next record to ingest.
+// Like the segments, the checkpoint is synced to disk, so a crash cannot leave it torn.
+func (q *ingestQueue) writeCheckpoint(position ingestPosition) error {
+	path := filepath.Join(q.dir, ingestCheckpointFile)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing ingest queue checkpoint: %w", err)
+	}
+	if _, err := fmt.Fprintf(file, "%d %d\n", position.segment, position.offset); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing ingest queue checkpoint: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error syncing ingest queue checkpoint: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing ingest queue checkpoint: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("error writing ingest queue checkpoint: %w", err)
+	}
+	return q.syncDir()
+}
+
+// syncDir syncs the queue directory, so the created and renamed files survive a crash
+func (q *ingestQueue) syncDir() error {
+	dir, err := os.Open(q.dir)
+	if err != nil {
+		return fmt.Errorf("error opening ingest queue directory: %w", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("error syncing ingest queue directory: %w", err)
+	}
+	return nil
+}
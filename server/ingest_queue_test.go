@@ -0,0 +1,156 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"os"
+	"testing"
+)
+
+func openTestIngestQueue(t *testing.T, dir string) *ingestQueue {
+	t.Helper()
+
+	q, err := openIngestQueue(dir)
+	if err != nil {
+		t.Fatalf("openIngestQueue() error = %v", err)
+	}
+	t.Cleanup(func() { q.Close() })
+	return q
+}
+
+// drainIngestQueue returns the data of every queued record, committing each one
+func drainIngestQueue(t *testing.T, q *ingestQueue) []string {
+	t.Helper()
+
+	var blocks []string
+	for {
+		record, err := q.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if record == nil {
+			return blocks
+		}
+		blocks = append(blocks, string(record.data))
+		if err := q.Commit(record); err != nil {
+			t.Fatalf("Commit() error = %v", err)
+		}
+	}
+}
+
+func assertBlocks(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got blocks %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got blocks %q, want %q", got, want)
+		}
+	}
+}
+
+func TestIngestQueueOrder(t *testing.T) {
+	q := openTestIngestQueue(t, t.TempDir())
+	for _, block := range []string{"block1", "block2", "block3"} {
+		if err := q.Enqueue([]byte(block)); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	status := q.Status()
+	if status.Depth != 3 || status.OldestEnqueuedAt == nil {
+		t.Fatalf("Status() = %+v, want 3 queued blocks", status)
+	}
+
+	assertBlocks(t, drainIngestQueue(t, q), "block1", "block2", "block3")
+	if status := q.Status(); status.Depth != 0 || status.OldestEnqueuedAt != nil || status.LastIngestedAt == nil {
+		t.Fatalf("Status() = %+v, want an empty queue", status)
+	}
+}
+
+func TestIngestQueueReplaysAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openIngestQueue(dir)
+	if err != nil {
+		t.Fatalf("openIngestQueue() error = %v", err)
+	}
+	for _, block := range []string{"block1", "block2", "block3"} {
+		if err := q.Enqueue([]byte(block)); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	record, err := q.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if err := q.Commit(record); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	// The second block is read but never committed, as if the process crashed while writing it
+	if _, err := q.Next(); err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	q.Close()
+
+	reopened := openTestIngestQueue(t, dir)
+	if depth := reopened.Status().Depth; depth != 2 {
+		t.Fatalf("Depth = %d after restart, want 2", depth)
+	}
+	assertBlocks(t, drainIngestQueue(t, reopened), "block2", "block3")
+}
+
+func TestIngestQueueDiscardsIncompleteRecord(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openIngestQueue(dir)
+	if err != nil {
+		t.Fatalf("openIngestQueue() error = %v", err)
+	}
+	if err := q.Enqueue([]byte("block1")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	q.Close()
+
+	// Simulate a crash in the middle of appending the next record
+	segment, err := os.OpenFile(q.segmentPath(1), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := segment.Write([]byte{0, 0, 0, 6, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	segment.Close()
+
+	reopened := openTestIngestQueue(t, dir)
+	if err := reopened.Enqueue([]byte("block2")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	assertBlocks(t, drainIngestQueue(t, reopened), "block1", "block2")
+}
+
+func TestIngestQueueRotatesSegments(t *testing.T) {
+	dir := t.TempDir()
+	q := openTestIngestQueue(t, dir)
+	q.segmentSize = 1
+
+	for _, block := range []string{"block1", "block2", "block3"} {
+		if err := q.Enqueue([]byte(block)); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	segments, err := q.listSegments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+
+	assertBlocks(t, drainIngestQueue(t, q), "block1", "block2", "block3")
+	// Ingested segments are removed, only the segment being written is kept
+	if segments, _ := q.listSegments(); len(segments) != 1 || segments[0] != 3 {
+		t.Fatalf("got segments %v after draining, want [3]", segments)
+	}
+}
@@ -1,12 +1,19 @@
 package server
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/ava-labs/hypersdk/chain"
 	pb "github.com/ava-labs/hypersdk/proto/pb/externalsubscriber"
+	"github.com/nuklai/nuklaivm-external-subscriber/config"
+	"github.com/nuklai/nuklaivm-external-subscriber/db"
 	"github.com/nuklai/nuklaivm/vm"
 )
 
@@ -21,23 +28,158 @@ func handleInitialize(dbConn *sql.DB, req *pb.InitializeRequest) (chain.Parser,
 		return nil, err
 	}
 
-	_, err := dbConn.Exec(`DELETE FROM genesis_data`)
+	parser, err := vm.CreateParser(genesisData)
 	if err != nil {
-		log.Printf("Error deleting old genesis data from database: %v\n", err)
+		log.Println("Error creating parser:", err)
+		return nil, err
 	}
 
-	_, err = dbConn.Exec(`INSERT INTO genesis_data (data) VALUES ($1::json)`, string(genesisData))
-	if err != nil {
+	if err := storeGenesis(dbConn, genesisData); err != nil {
 		log.Printf("Error saving new genesis data to database: %v\n", err)
 		return nil, err
 	}
 
-	parser, err := vm.CreateParser(genesisData)
+	log.Println("Genesis data initialized successfully.")
+	return parser, nil
+}
+
+// storeGenesis saves the genesis data and keeps track of the network epoch it belongs to.
+// A genesis with a different hash than the current epoch starts a new epoch. If blocks
+// of the previous network are stored, they are archived into their own schema, which
+// only happens when ALLOW_REGENESIS is enabled.
+func storeGenesis(dbConn *sql.DB, genesisData []byte) error {
+	dbTx, err := dbConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	// Serialize with block ingestion so no block is written while the tables are swapped
+	if _, err := dbTx.Exec(`SELECT pg_advisory_xact_lock($1)`, blockIngestLockID); err != nil {
+		return fmt.Errorf("error acquiring block ingestion lock: %w", err)
+	}
+
+	newHash := genesisHash(genesisData)
+	epochID, currentHash, err := fetchCurrentEpoch(dbTx)
+	if err != nil {
+		return err
+	}
+
+	if currentHash != newHash {
+		var blockCount int
+		if err := dbTx.QueryRow(`SELECT COUNT(*) FROM blocks`).Scan(&blockCount); err != nil {
+			return fmt.Errorf("error counting stored blocks: %w", err)
+		}
+
+		if currentHash != "" && blockCount > 0 {
+			if !config.GetAllowRegenesis() {
+				return fmt.Errorf("genesis hash %s does not match the indexed network %s; set ALLOW_REGENESIS=true to archive the current data and start a new epoch", newHash, currentHash)
+			}
+
+			archiveSchema := fmt.Sprintf("epoch_%d", epochID)
+			log.Printf("New genesis detected. Archiving %d blocks of epoch %d into schema %s...\n", blockCount, epochID, archiveSchema)
+			if err := db.ArchiveChainTables(dbTx, archiveSchema); err != nil {
+				return err
+			}
+			_, err = dbTx.Exec(`UPDATE network_epochs SET archive_schema = $1, archived_at = $2 WHERE id = $3`,
+				archiveSchema, time.Now().UTC(), epochID)
+			if err != nil {
+				return fmt.Errorf("error archiving epoch %d: %w", epochID, err)
+			}
+		}
+
+		_, err = dbTx.Exec(`INSERT INTO network_epochs (genesis_hash, started_at) VALUES ($1, $2)`, newHash, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("error starting new epoch: %w", err)
+		}
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM genesis_data`); err != nil {
+		return fmt.Errorf("error deleting old genesis data: %w", err)
+	}
+	if _, err := dbTx.Exec(`INSERT INTO genesis_data (data) VALUES ($1::json)`, string(genesisData)); err != nil {
+		return err
+	}
+	if err := storeGenesisBalances(dbTx, genesisData); err != nil {
+		return fmt.Errorf("error storing genesis balances: %w", err)
+	}
+
+	return dbTx.Commit()
+}
+
+// fetchCurrentEpoch returns the ID and genesis hash of the network epoch currently indexed.
+// Databases created before epochs were tracked get an epoch for their stored genesis.
+func fetchCurrentEpoch(dbTx *sql.Tx) (int, string, error) {
+	var (
+		epochID int
+		hash    string
+	)
+	err := dbTx.QueryRow(`SELECT id, genesis_hash FROM network_epochs WHERE archived_at IS NULL ORDER BY id DESC LIMIT 1`).Scan(&epochID, &hash)
+	if err == nil {
+		return epochID, hash, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, "", fmt.Errorf("error fetching current epoch: %w", err)
+	}
+
+	genesisData, err := fetchStoredGenesis(dbTx)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(genesisData) == 0 {
+		return 0, "", nil
+	}
+
+	hash = genesisHash(genesisData)
+	err = dbTx.QueryRow(`INSERT INTO network_epochs (genesis_hash, started_at) VALUES ($1, $2) RETURNING id`, hash, time.Now().UTC()).Scan(&epochID)
+	if err != nil {
+		return 0, "", fmt.Errorf("error recording current epoch: %w", err)
+	}
+	return epochID, hash, nil
+}
+
+// fetchStoredGenesis returns the stored genesis data, or nil if none is stored
+func fetchStoredGenesis(dbTx *sql.Tx) ([]byte, error) {
+	var genesisData string
+	err := dbTx.QueryRow(`SELECT data FROM genesis_data ORDER BY id DESC LIMIT 1`).Scan(&genesisData)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching stored genesis: %w", err)
+	}
+	return []byte(genesisData), nil
+}
+
+// loadGenesisFromDB returns the genesis data stored by handleInitialize
+func loadGenesisFromDB(dbConn *sql.DB) ([]byte, error) {
+	var genesisData string
+	err := dbConn.QueryRow(`SELECT data FROM genesis_data ORDER BY id DESC LIMIT 1`).Scan(&genesisData)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("no genesis data stored")
+	}
 	if err != nil {
-		log.Println("Error creating parser:", err)
 		return nil, err
 	}
+	return []byte(genesisData), nil
+}
 
-	log.Println("Genesis data initialized successfully.")
-	return parser, nil
+// loadParserFromDB creates the chain parser from the genesis data stored by handleInitialize
+func loadParserFromDB(dbConn *sql.DB) (chain.Parser, []byte, error) {
+	genesisData, err := loadGenesisFromDB(dbConn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parser, err := vm.CreateParser(genesisData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parser, genesisData, nil
+}
+
+// genesisHash returns the hex encoded SHA-256 hash of the genesis data
+func genesisHash(genesisData []byte) string {
+	hash := sha256.Sum256(genesisData)
+	return hex.EncodeToString(hash[:])
 }
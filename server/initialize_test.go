@@ -0,0 +1,220 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/db"
+)
+
+const storedGenesisQuery = `SELECT data FROM genesis_data ORDER BY id DESC LIMIT 1`
+
+// expectCopyChainTables expects every chain table of source to be copied into target,
+// with no key, index or serial column to re-create
+func expectCopyChainTables(mock sqlmock.Sqlmock, source, target string) {
+	for _, table := range db.ChainTables {
+		mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE "` + target + `"."` + table + `" (LIKE "` + source + `"."` + table + `"`)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		for _, catalog := range []string{`FROM pg_constraint`, `FROM pg_index`, `FROM pg_attribute`} {
+			mock.ExpectQuery(regexp.QuoteMeta(catalog)).WillReturnRows(sqlmock.NewRows([]string{"statement"}))
+		}
+	}
+}
+
+func TestLoadParserFromDB(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+
+	genesis := `{"stateBranchFactor": 16}`
+	mock.ExpectQuery(regexp.QuoteMeta(storedGenesisQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(genesis))
+
+	parser, genesisData, err := loadParserFromDB(dbConn)
+	if err != nil {
+		t.Fatalf("loadParserFromDB() error = %v", err)
+	}
+	if parser == nil {
+		t.Fatal("expected a parser")
+	}
+	if string(genesisData) != genesis {
+		t.Fatalf("genesis = %s, want %s", genesisData, genesis)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadParserFromDBWithoutGenesis(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta(storedGenesisQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}))
+
+	parser, _, err := loadParserFromDB(dbConn)
+	if err == nil {
+		t.Fatal("expected an error when no genesis is stored")
+	}
+	if parser != nil {
+		t.Fatal("expected no parser when no genesis is stored")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectCurrentEpochWithBlocks expects storeGenesis to find epoch 1 of another genesis
+// with blocks stored
+func expectCurrentEpochWithBlocks(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).
+		WithArgs(blockIngestLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, genesis_hash FROM network_epochs WHERE archived_at IS NULL`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "genesis_hash"}).AddRow(1, "previous"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM blocks`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+}
+
+func TestStoreGenesisRefusesRegenesis(t *testing.T) {
+	t.Setenv("ALLOW_REGENESIS", "false")
+
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+
+	mock.ExpectBegin()
+	expectCurrentEpochWithBlocks(mock)
+	mock.ExpectRollback()
+
+	err = storeGenesis(dbConn, []byte(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "ALLOW_REGENESIS") {
+		t.Fatalf("storeGenesis() error = %v, want a refusal mentioning ALLOW_REGENESIS", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreGenesisArchivesEpoch(t *testing.T) {
+	t.Setenv("ALLOW_REGENESIS", "true")
+
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+
+	mock.ExpectBegin()
+	expectCurrentEpochWithBlocks(mock)
+
+	// The chain tables are moved to the archive schema and created again
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE SCHEMA "epoch_1"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, table := range db.ChainTables {
+		mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE IF EXISTS public.` + table + ` SET SCHEMA "epoch_1"`)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	expectCopyChainTables(mock, "epoch_1", "public")
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE network_epochs SET archive_schema = $1, archived_at = $2 WHERE id = $3`)).
+		WithArgs("epoch_1", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO network_epochs (genesis_hash, started_at)`)).
+		WithArgs(genesisHash([]byte(`{}`)), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	// The new genesis is stored and seeds the empty balances
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM genesis_data`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO genesis_data (data) VALUES ($1::json)`)).
+		WithArgs(`{}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM blocks)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM balance_changes`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM balances`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := storeGenesis(dbConn, []byte(`{}`)); err != nil {
+		t.Fatalf("storeGenesis() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveChainTablesKeepsSchema(t *testing.T) {
+	dbConn := newTestDatabase(t)
+
+	const archive = "epoch_test"
+	if _, err := dbConn.Exec(`DROP SCHEMA IF EXISTS ` + archive + ` CASCADE`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbConn.Exec(`DROP SCHEMA IF EXISTS ` + archive + ` CASCADE`) })
+
+	dbTx, err := dbConn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ArchiveChainTables(dbTx, archive); err != nil {
+		dbTx.Rollback()
+		t.Fatalf("ArchiveChainTables() error = %v", err)
+	}
+	if err := dbTx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The copies have the indexes of the archived tables, under the same names
+	indexNames := func(schema string) []string {
+		rows, err := dbConn.Query(`
+			SELECT indexname FROM pg_indexes
+			WHERE schemaname = $1 AND tablename = ANY($2)
+			ORDER BY indexname`, schema, pq.Array(db.ChainTables))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+	if archived, copied := indexNames(archive), indexNames("public"); !reflect.DeepEqual(copied, archived) {
+		t.Fatalf("indexes = %v, want %v", copied, archived)
+	}
+
+	// Running the migrations again changes nothing, and the serial columns survive the archive
+	if err := db.MigrateUp(dbConn); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+	if _, err := dbConn.Exec(`DROP SCHEMA ` + archive + ` CASCADE`); err != nil {
+		t.Fatal(err)
+	}
+	var id int
+	err = dbConn.QueryRow(`
+		INSERT INTO chain_reorgs (fork_height, old_tip_height, old_tip_hash, new_block_height, new_block_hash,
+			orphaned_blocks, orphaned_transactions, timestamp)
+		VALUES (1, 2, 'old', 2, 'new', 1, 0, NOW())
+		RETURNING id`).Scan(&id)
+	if err != nil || id != 1 {
+		t.Fatalf("inserted chain reorg %d, %v; want id 1", id, err)
+	}
+}
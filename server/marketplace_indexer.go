@@ -0,0 +1,138 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+// marketplaceIndexer records the datasets published to the marketplace in marketplace_listings,
+// their subscriptions in marketplace_subscriptions and the payments claimed by their owners
+// in marketplace_payments
+type marketplaceIndexer struct{}
+
+func init() {
+	indexer := &marketplaceIndexer{}
+	RegisterActionIndexer(vmconsts.PublishDatasetMarketplaceID, indexer)
+	RegisterActionIndexer(vmconsts.SubscribeDatasetMarketplaceID, indexer)
+	RegisterActionIndexer(vmconsts.ClaimMarketplacePaymentID, indexer)
+}
+
+func (*marketplaceIndexer) Tables() []string {
+	return []string{"marketplace_listings", "marketplace_subscriptions", "marketplace_payments"}
+}
+
+func (m *marketplaceIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.PublishDatasetMarketplaceID:
+		return m.publish(dbTx, action)
+	case vmconsts.SubscribeDatasetMarketplaceID:
+		return m.subscribe(dbTx, action)
+	case vmconsts.ClaimMarketplacePaymentID:
+		return m.claimPayment(dbTx, action)
+	}
+	return nil
+}
+
+func (*marketplaceIndexer) publish(dbTx *sql.Tx, action *IndexedAction) error {
+	pricePerBlock, err := numericValue(action.Output, "dataset_price_per_block")
+	if err != nil {
+		return err
+	}
+	publisher := addressValue(action.Output, "publisher")
+	if publisher == "" {
+		publisher = action.Sponsor
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO marketplace_listings (
+			dataset_address, marketplace_asset_address, payment_asset_address, price_per_block, publisher,
+			tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		addressValue(action.Input, "dataset_address"), addressValue(action.Output, "marketplace_asset_address"),
+		addressValue(action.Output, "payment_asset_address"), pricePerBlock, publisher,
+		action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+func (*marketplaceIndexer) subscribe(dbTx *sql.Tx, action *IndexedAction) error {
+	pricePerBlock, err := numericValue(action.Output, "dataset_price_per_block")
+	if err != nil {
+		return err
+	}
+	totalCost, err := numericValue(action.Output, "total_cost")
+	if err != nil {
+		return err
+	}
+	numBlocks, err := uint64Value(action.Output, "num_blocks_to_subscribe")
+	if err != nil {
+		return err
+	}
+	issuanceBlock, err := uint64Value(action.Output, "issuance_block")
+	if err != nil {
+		return err
+	}
+	expirationBlock, err := uint64Value(action.Output, "expiration_block")
+	if err != nil {
+		return err
+	}
+	subscriber := addressValue(action.Output, "actor")
+	if subscriber == "" {
+		subscriber = action.Sponsor
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO marketplace_subscriptions (
+			marketplace_asset_address, subscriber, subscription_nft_address, payment_asset_address, price_per_block,
+			total_cost, num_blocks, issuance_block, expiration_block, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		addressValue(action.Output, "marketplace_asset_address"), subscriber, addressValue(action.Output, "subscription_nft_address"),
+		addressValue(action.Output, "payment_asset_address"), pricePerBlock, totalCost, numBlocks, issuanceBlock, expirationBlock,
+		action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+// claimPayment records the payment distributed to the owner and the totals of the listing after the claim
+func (*marketplaceIndexer) claimPayment(dbTx *sql.Tx, action *IndexedAction) error {
+	amount, err := numericValue(action.Output, "distributed_reward")
+	if err != nil {
+		return err
+	}
+	paymentClaimed, err := numericValue(action.Output, "payment_claimed")
+	if err != nil {
+		return err
+	}
+	paymentRemaining, err := numericValue(action.Output, "payment_remaining")
+	if err != nil {
+		return err
+	}
+	lastClaimedBlock, err := uint64Value(action.Output, "last_claimed_block")
+	if err != nil {
+		return err
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO marketplace_payments (
+			marketplace_asset_address, payment_asset_address, amount, distributed_to, payment_claimed, payment_remaining,
+			last_claimed_block, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		addressValue(action.Input, "marketplace_asset_address"), addressValue(action.Input, "payment_asset_address"),
+		amount, addressValue(action.Output, "distributed_to"), paymentClaimed, paymentRemaining, lastClaimedBlock,
+		action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
+
+func (*marketplaceIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	for _, table := range []string{"marketplace_payments", "marketplace_subscriptions", "marketplace_listings"} {
+		if _, err := dbTx.Exec(`DELETE FROM `+table+` WHERE block_height >= $1`, fromHeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
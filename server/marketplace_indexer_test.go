@@ -0,0 +1,151 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestMarketplaceIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "publish dataset",
+			actionType: vmconsts.PublishDatasetMarketplaceID,
+			actionName: "PublishDatasetMarketplace",
+			input:      `{"dataset_address":"0xdataset1","base_asset_address":"0xnai","base_price":100}`,
+			output:     `{"marketplace_asset_address":"0xmarket1","payment_asset_address":"0xnai","dataset_price_per_block":100,"publisher":"0xowner1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO marketplace_listings`)).
+					WithArgs("dataset1", "market1", "nai", "100", "owner1", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "subscribe to dataset",
+			actionType: vmconsts.SubscribeDatasetMarketplaceID,
+			actionName: "SubscribeDatasetMarketplace",
+			input:      `{"marketplace_asset_address":"0xmarket1","payment_asset_address":"0xnai","num_blocks_to_subscribe":5}`,
+			output: `{"marketplace_asset_address":"0xmarket1","marketplace_asset_num_subscriptions":1,"subscription_nft_address":"0xnft1",` +
+				`"payment_asset_address":"0xnai","dataset_price_per_block":100,"total_cost":500,"num_blocks_to_subscribe":5,` +
+				`"issuance_block":10,"expiration_block":15,"actor":"0xsubscriber1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO marketplace_subscriptions`)).
+					WithArgs("market1", "subscriber1", "nft1", "nai", "100", "500", uint64(5), uint64(10), uint64(15),
+						"tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "subscribe without expiration",
+			actionType: vmconsts.SubscribeDatasetMarketplaceID,
+			actionName: "SubscribeDatasetMarketplace",
+			input:      `{"marketplace_asset_address":"0xmarket1"}`,
+			output:     `{"marketplace_asset_address":"0xmarket1","dataset_price_per_block":100,"total_cost":500,"num_blocks_to_subscribe":5,"issuance_block":10}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+		{
+			name:       "claim payment",
+			actionType: vmconsts.ClaimMarketplacePaymentID,
+			actionName: "ClaimMarketplacePayment",
+			input:      `{"marketplace_asset_address":"0xmarket1","payment_asset_address":"0xnai"}`,
+			output:     `{"last_claimed_block":12,"payment_claimed":200,"payment_remaining":300,"distributed_reward":200,"distributed_to":"0xowner1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO marketplace_payments`)).
+					WithArgs("market1", "nai", "200", "owner1", "200", "300", uint64(12), "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&marketplaceIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMarketplaceIndexerRollback(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	for _, table := range []string{"marketplace_payments", "marketplace_subscriptions", "marketplace_listings"} {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM ` + table + ` WHERE block_height >= $1`)).
+			WithArgs(uint64(10)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	if err := (&marketplaceIndexer{}).Rollback(dbTx, 10); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarketplaceIndexerRollbackRestoresRows(t *testing.T) {
+	publish := func(height uint64, dataset string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.PublishDatasetMarketplaceID, actionName: "PublishDatasetMarketplace",
+			input: `{"dataset_address":"0x` + dataset + `"}`,
+			output: `{"marketplace_asset_address":"0xmarket_` + dataset + `","payment_asset_address":"0xnai",` +
+				`"dataset_price_per_block":100,"publisher":"0xowner1"}`,
+		}
+	}
+	subscribe := func(height uint64, subscriber string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.SubscribeDatasetMarketplaceID, actionName: "SubscribeDatasetMarketplace",
+			input: `{"marketplace_asset_address":"0xmarket_dataset1"}`,
+			output: fmt.Sprintf(`{"marketplace_asset_address":"0xmarket_dataset1","subscription_nft_address":"0xnft_%s",`+
+				`"payment_asset_address":"0xnai","dataset_price_per_block":100,"total_cost":500,"num_blocks_to_subscribe":5,`+
+				`"issuance_block":%d,"expiration_block":%d,"actor":"0x%s"}`, subscriber, height, height+5, subscriber),
+		}
+	}
+	claim := func(height uint64, claimed int) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.ClaimMarketplacePaymentID, actionName: "ClaimMarketplacePayment",
+			input: `{"marketplace_asset_address":"0xmarket_dataset1","payment_asset_address":"0xnai"}`,
+			output: fmt.Sprintf(`{"last_claimed_block":%d,"payment_claimed":%d,"payment_remaining":%d,`+
+				`"distributed_reward":%d,"distributed_to":"0xowner1"}`, height, claimed, 1000-claimed, claimed),
+		}
+	}
+	testIndexerRollback(t, &marketplaceIndexer{}, 3, []testAction{
+		publish(1, "dataset1"),
+		subscribe(2, "subscriber1"),
+		claim(2, 100),
+		// The orphaned listing, subscription and payment are removed
+		publish(3, "dataset2"),
+		subscribe(3, "subscriber2"),
+		claim(4, 300),
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+// nftIndexer keeps the nft_tokens table in sync with MintAssetNFT, BurnAssetNFT and
+// Transfer actions and records the provenance of every token in nft_transfers
+type nftIndexer struct{}
+
+func init() {
+	indexer := &nftIndexer{}
+	RegisterActionIndexer(vmconsts.MintAssetNFTID, indexer)
+	RegisterActionIndexer(vmconsts.BurnAssetNFTID, indexer)
+	RegisterActionIndexer(vmconsts.TransferID, indexer)
+}
+
+func (*nftIndexer) Tables() []string {
+	return []string{"nft_tokens", "nft_transfers"}
+}
+
+func (i *nftIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.MintAssetNFTID:
+		return i.indexMint(dbTx, action)
+	case vmconsts.BurnAssetNFTID:
+		return i.indexBurn(dbTx, action)
+	case vmconsts.TransferID:
+		return i.indexTransfer(dbTx, action)
+	}
+	return nil
+}
+
+func (*nftIndexer) indexMint(dbTx *sql.Tx, action *IndexedAction) error {
+	collectionAddress := addressValue(action.Input, "asset_address")
+	nftAddress := addressValue(action.Output, "asset_nft_address")
+	owner := addressValue(action.Input, "to")
+
+	// Tokens are numbered in the order they are minted within their collection
+	var uniqueID uint64
+	err := dbTx.QueryRow(`
+		INSERT INTO nft_tokens (
+			collection_address, unique_id, nft_address, owner, metadata, mint_tx_hash, mint_block_height, minted_at
+		)
+		SELECT $1, COALESCE(MAX(unique_id) + 1, 0), $2, $3, $4, $5, $6::BIGINT, $7::TIMESTAMP
+		FROM nft_tokens
+		WHERE collection_address = $1
+		RETURNING unique_id`,
+		collectionAddress, nftAddress, owner, stringValue(action.Input, "metadata"),
+		action.TxHash, action.BlockHeight, action.Timestamp).Scan(&uniqueID)
+	if err != nil {
+		return err
+	}
+
+	return insertNFTTransfer(dbTx, action, collectionAddress, uniqueID, nftAddress, "", owner)
+}
+
+func (*nftIndexer) indexBurn(dbTx *sql.Tx, action *IndexedAction) error {
+	nftAddress := addressValue(action.Input, "asset_nft_address")
+
+	var (
+		collectionAddress, owner string
+		uniqueID                 uint64
+	)
+	err := dbTx.QueryRow(`
+		UPDATE nft_tokens
+		SET burned = true, burn_tx_hash = $2, burned_at = $3
+		WHERE nft_address = $1
+		RETURNING collection_address, unique_id, owner`,
+		nftAddress, action.TxHash, action.Timestamp).Scan(&collectionAddress, &uniqueID, &owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return insertNFTTransfer(dbTx, action, collectionAddress, uniqueID, nftAddress, owner, "")
+}
+
+// indexTransfer moves the token when the transferred asset is an indexed NFT. Transfers
+// of any other asset are ignored.
+func (*nftIndexer) indexTransfer(dbTx *sql.Tx, action *IndexedAction) error {
+	nftAddress := addressValue(action.Input, "asset_address")
+	to := addressValue(action.Input, "to")
+
+	var (
+		collectionAddress, from string
+		uniqueID                uint64
+	)
+	err := dbTx.QueryRow(`
+		UPDATE nft_tokens
+		SET owner = $2
+		FROM (SELECT owner FROM nft_tokens WHERE nft_address = $1 AND NOT burned) AS previous
+		WHERE nft_tokens.nft_address = $1 AND NOT nft_tokens.burned
+		RETURNING nft_tokens.collection_address, nft_tokens.unique_id, previous.owner`,
+		nftAddress, to).Scan(&collectionAddress, &uniqueID, &from)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return insertNFTTransfer(dbTx, action, collectionAddress, uniqueID, nftAddress, from, to)
+}
+
+// Rollback removes the tokens minted in orphaned blocks and restores the owner and burn
+// status of the other affected tokens from their latest remaining transfer
+func (*nftIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	var nftAddresses []string
+	err := dbTx.QueryRow(`
+		SELECT COALESCE(ARRAY_AGG(DISTINCT nft_address), '{}')
+		FROM nft_transfers
+		WHERE block_height >= $1`, fromHeight).Scan(pq.Array(&nftAddresses))
+	if err != nil {
+		return err
+	}
+
+	if _, err := dbTx.Exec(`DELETE FROM nft_transfers WHERE block_height >= $1`, fromHeight); err != nil {
+		return err
+	}
+	if _, err := dbTx.Exec(`DELETE FROM nft_tokens WHERE mint_block_height >= $1`, fromHeight); err != nil {
+		return err
+	}
+	if len(nftAddresses) == 0 {
+		return nil
+	}
+
+	// A burn is always the last transfer of a token, so none of the affected tokens is burned anymore
+	_, err = dbTx.Exec(`
+		UPDATE nft_tokens
+		SET owner = latest.to_address, burned = false, burn_tx_hash = NULL, burned_at = NULL
+		FROM (
+			SELECT DISTINCT ON (nft_address) nft_address, to_address
+			FROM nft_transfers
+			WHERE nft_address = ANY($1)
+			ORDER BY nft_address, block_height DESC, id DESC
+		) AS latest
+		WHERE nft_tokens.nft_address = latest.nft_address`, pq.Array(nftAddresses))
+	return err
+}
+
+func insertNFTTransfer(dbTx *sql.Tx, action *IndexedAction, collectionAddress string, uniqueID uint64, nftAddress, from, to string) error {
+	_, err := dbTx.Exec(`
+		INSERT INTO nft_transfers (
+			collection_address, unique_id, nft_address, action_name, from_address, to_address, tx_hash, block_height, timestamp
+		)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9)`,
+		collectionAddress, uniqueID, nftAddress, action.ActionName, from, to, action.TxHash, action.BlockHeight, action.Timestamp)
+	return err
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestNFTIndexerIndex(t *testing.T) {
+	tokenColumns := []string{"collection_address", "unique_id", "owner"}
+
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:       "mint",
+			actionType: vmconsts.MintAssetNFTID,
+			actionName: "MintAssetNFT",
+			input:      `{"asset_address":"0xcollection1","to":"0xowner1","metadata":"meta"}`,
+			output:     `{"asset_nft_address":"nft1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO nft_tokens`)).
+					WithArgs("collection1", "nft1", "owner1", "meta", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnRows(sqlmock.NewRows([]string{"unique_id"}).AddRow(uint64(3)))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO nft_transfers`)).
+					WithArgs("collection1", uint64(3), "nft1", "MintAssetNFT", "", "owner1", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "burn",
+			actionType: vmconsts.BurnAssetNFTID,
+			actionName: "BurnAssetNFT",
+			input:      `{"asset_nft_address":"0xnft1"}`,
+			output:     `{}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SET burned = true`)).
+					WithArgs("nft1", "tx1", "2024-01-01T00:00:00Z").
+					WillReturnRows(sqlmock.NewRows(tokenColumns).AddRow("collection1", uint64(3), "owner1"))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO nft_transfers`)).
+					WithArgs("collection1", uint64(3), "nft1", "BurnAssetNFT", "owner1", "", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "burn of an unknown token",
+			actionType: vmconsts.BurnAssetNFTID,
+			actionName: "BurnAssetNFT",
+			input:      `{"asset_nft_address":"0xnft1"}`,
+			output:     `{}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SET burned = true`)).
+					WithArgs("nft1", "tx1", "2024-01-01T00:00:00Z").
+					WillReturnRows(sqlmock.NewRows(tokenColumns))
+			},
+		},
+		{
+			name:       "transfer",
+			actionType: vmconsts.TransferID,
+			actionName: "Transfer",
+			input:      `{"asset_address":"0xnft1","to":"0xowner2","value":1}`,
+			output:     `{}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SET owner = $2`)).
+					WithArgs("nft1", "owner2").
+					WillReturnRows(sqlmock.NewRows(tokenColumns).AddRow("collection1", uint64(3), "owner1"))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO nft_transfers`)).
+					WithArgs("collection1", uint64(3), "nft1", "Transfer", "owner1", "owner2", "tx1", uint64(10), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "transfer of a fungible asset",
+			actionType: vmconsts.TransferID,
+			actionName: "Transfer",
+			input:      `{"asset_address":"0xasset1","to":"0xowner2","value":100}`,
+			output:     `{}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(`SET owner = $2`)).
+					WithArgs("asset1", "owner2").
+					WillReturnRows(sqlmock.NewRows(tokenColumns))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&nftIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 10,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if err != nil {
+				t.Fatalf("Index() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNFTIndexerRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected string
+		expect   func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:     "no transfers to revert",
+			affected: "{}",
+			expect:   func(sqlmock.Sqlmock) {},
+		},
+		{
+			name:     "restores the owners of the affected tokens",
+			affected: "{nft1}",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`SET owner = latest.to_address`)).
+					WithArgs(pq.Array([]string{"nft1"})).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			mock.ExpectQuery(regexp.QuoteMeta(`FROM nft_transfers`)).
+				WithArgs(uint64(10)).
+				WillReturnRows(sqlmock.NewRows([]string{"nft_addresses"}).AddRow([]byte(tt.affected)))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM nft_transfers WHERE block_height >= $1`)).
+				WithArgs(uint64(10)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM nft_tokens WHERE mint_block_height >= $1`)).
+				WithArgs(uint64(10)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			tt.expect(mock)
+
+			if err := (&nftIndexer{}).Rollback(dbTx, 10); err != nil {
+				t.Fatalf("Rollback() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNFTIndexerRollbackRestoresRows(t *testing.T) {
+	mint := func(height uint64, nftAddress string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.MintAssetNFTID, actionName: "MintAssetNFT",
+			input:  `{"asset_address":"0xcollection1","to":"0xowner1","metadata":"meta"}`,
+			output: `{"asset_nft_address":"` + nftAddress + `"}`,
+		}
+	}
+	transfer := func(height uint64, to string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.TransferID, actionName: "Transfer",
+			input: `{"asset_address":"0xnft1","to":"0x` + to + `","value":1}`,
+		}
+	}
+	testIndexerRollback(t, &nftIndexer{}, 3, []testAction{
+		mint(1, "nft1"),
+		transfer(2, "owner2"),
+		// The token goes back to owner2 unburned and the orphaned token is removed
+		transfer(3, "owner3"),
+		{height: 4, actionType: vmconsts.BurnAssetNFTID, actionName: "BurnAssetNFT", input: `{"asset_nft_address":"0xnft1"}`},
+		mint(4, "nft2"),
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// queryToken authorizes the calls to the QueryService with a bearer token in the
+// authorization metadata. It is independent of the IP whitelist of the ingest service, so
+// the services reading the data cannot push blocks and do not need a whitelisted address.
+type queryToken string
+
+// authorize checks the token of an incoming call
+func (t queryToken) authorize(ctx context.Context, method string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "missing authorization token")
+	}
+	given := strings.TrimPrefix(values[0], "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(t)) != 1 {
+		log.Printf("Unauthorized query service call to %s", method)
+		return status.Error(codes.Unauthenticated, "invalid authorization token")
+	}
+	return nil
+}
+
+// UnaryInterceptor rejects the unary calls without a valid token
+func (t queryToken) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := t.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamInterceptor rejects the streams without a valid token
+func (t queryToken) StreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := t.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
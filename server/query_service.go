@@ -0,0 +1,354 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/events"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+	querypb "github.com/nuklai/nuklaivm-external-subscriber/proto/pb/query"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// defaultQueryPageSize is the number of items of a page when no limit is given
+	defaultQueryPageSize = 10
+	// maxQueryPageSize is the largest limit of a page
+	maxQueryPageSize = 100
+)
+
+// QueryServer implements the QueryService, reading from the same models as the REST API
+type QueryServer struct {
+	querypb.UnimplementedQueryServiceServer
+	db     *sql.DB
+	events *events.Hub
+}
+
+// NewQueryServer creates the query server reading from db. The streamed blocks are
+// received from hub.
+func NewQueryServer(db *sql.DB, hub *events.Hub) *QueryServer {
+	return &QueryServer{db: db, events: hub}
+}
+
+// StartQueryServer serves the QueryService on its own port. The calls are authorized
+// with token instead of the IP whitelist of the ingest service.
+func StartQueryServer(q *QueryServer, port, token string) error {
+	if !strings.HasPrefix(port, ":") {
+		port = ":" + port
+	}
+
+	lis, err := net.Listen("tcp", port)
+	if err != nil {
+		log.Printf("Failed to listen on port %s: %v", port, err)
+		return err
+	}
+
+	auth := queryToken(token)
+	grpcServer := grpc.NewServer(
+		grpc.Creds(insecure.NewCredentials()),
+		grpc.UnaryInterceptor(auth.UnaryInterceptor),
+		grpc.StreamInterceptor(auth.StreamInterceptor),
+	)
+	querypb.RegisterQueryServiceServer(grpcServer, q)
+	reflection.Register(grpcServer)
+
+	log.Printf("Query service is listening on port %s...\n", port)
+	return grpcServer.Serve(lis)
+}
+
+// StartQueryServerWithRetries retries the query server startup in case of failure
+func StartQueryServerWithRetries(q *QueryServer, port, token string, retries int) {
+	for i := 0; i < retries; i++ {
+		err := StartQueryServer(q, port, token)
+		if err != nil {
+			log.Printf("Query server failed to start: %v. Retrying (%d/%d)...", err, i+1, retries)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		return
+	}
+	log.Fatal("Query server failed to start after maximum retries")
+}
+
+// GetBlock returns a block by height or hash
+func (q *QueryServer) GetBlock(ctx context.Context, req *querypb.GetBlockRequest) (*querypb.Block, error) {
+	var block models.Block
+	var err error
+	switch identifier := req.GetIdentifier().(type) {
+	case *querypb.GetBlockRequest_Height:
+		block, err = models.FetchBlock(q.db, strconv.FormatUint(identifier.Height, 10), "")
+	case *querypb.GetBlockRequest_Hash:
+		block, err = models.FetchBlock(q.db, "", identifier.Hash)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "either height or hash must be given")
+	}
+	if err == sql.ErrNoRows {
+		return nil, status.Error(codes.NotFound, "block not found")
+	} else if err != nil {
+		return nil, queryError(err, "unable to retrieve block")
+	}
+	return blockMessage(block), nil
+}
+
+// ListTransactions returns a page of transactions with their actions, newest first
+func (q *QueryServer) ListTransactions(ctx context.Context, req *querypb.ListTransactionsRequest) (*querypb.ListTransactionsResponse, error) {
+	page, err := queryPage(req.GetLimit(), req.GetCursor())
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, nextCursor, err := models.FetchFilteredTransactions(q.db, "", "", "", req.GetActionName(), req.GetUser(), page)
+	if err != nil {
+		return nil, queryError(err, "unable to retrieve transactions")
+	}
+
+	// The actions of the whole page are read at once
+	txHashes := make([]string, len(transactions))
+	for i, tx := range transactions {
+		txHashes[i] = tx.TxHash
+	}
+	actions, err := models.FetchActionsByTransactionHashes(q.db, txHashes)
+	if err != nil {
+		return nil, queryError(err, "unable to retrieve actions")
+	}
+	actionsByTx := make(map[string][]*querypb.Action, len(transactions))
+	for _, action := range actions {
+		message, err := actionMessage(action)
+		if err != nil {
+			return nil, queryError(err, "unable to encode actions")
+		}
+		actionsByTx[action.TxHash] = append(actionsByTx[action.TxHash], message)
+	}
+
+	response := &querypb.ListTransactionsResponse{
+		Transactions: make([]*querypb.Transaction, len(transactions)),
+		NextCursor:   nextCursor,
+	}
+	for i, tx := range transactions {
+		response.Transactions[i] = transactionMessage(tx, actionsByTx[tx.TxHash])
+	}
+	return response, nil
+}
+
+// GetAccount returns the balances and transaction count of an address
+func (q *QueryServer) GetAccount(ctx context.Context, req *querypb.GetAccountRequest) (*querypb.Account, error) {
+	if req.GetAddress() == "" {
+		return nil, status.Error(codes.InvalidArgument, "address must be given")
+	}
+
+	account, err := models.FetchAccountByAddress(q.db, req.GetAddress())
+	if err == sql.ErrNoRows {
+		return nil, status.Error(codes.NotFound, "account not found")
+	} else if err != nil {
+		return nil, queryError(err, "unable to retrieve account")
+	}
+
+	message := &querypb.Account{
+		Address:          account.Address,
+		Balance:          account.Balance.String(),
+		TransactionCount: uint64(account.TransactionCount),
+		Balances:         make([]*querypb.AccountBalance, len(account.Balances)),
+	}
+	for i, balance := range account.Balances {
+		message.Balances[i] = &querypb.AccountBalance{AssetAddress: balance.AssetAddress, Balance: balance.Balance.String()}
+	}
+	return message, nil
+}
+
+// ListAssets returns a page of assets, most recently created first
+func (q *QueryServer) ListAssets(ctx context.Context, req *querypb.ListAssetsRequest) (*querypb.ListAssetsResponse, error) {
+	page, err := queryPage(req.GetLimit(), req.GetCursor())
+	if err != nil {
+		return nil, err
+	}
+	var assetType string
+	if req.AssetType != nil {
+		assetType = strconv.FormatUint(uint64(req.GetAssetType()), 10)
+	}
+	creator := strings.TrimPrefix(req.GetCreator(), "0x")
+
+	assets, nextCursor, err := models.FetchFilteredAssets(q.db, assetType, creator, "", req.GetName(), req.GetSymbol(), page)
+	if err != nil {
+		return nil, queryError(err, "unable to retrieve assets")
+	}
+
+	response := &querypb.ListAssetsResponse{
+		Assets:     make([]*querypb.Asset, len(assets)),
+		NextCursor: nextCursor,
+	}
+	for i, asset := range assets {
+		response.Assets[i] = assetMessage(asset)
+	}
+	return response, nil
+}
+
+// StreamBlocks streams the blocks as they are indexed, after replaying the blocks indexed
+// since from_height when it is set. After a reorg, the replacement blocks are streamed
+// again from the fork height. A subscriber that falls behind is dropped with
+// ResourceExhausted and can resume from the last block it received.
+func (q *QueryServer) StreamBlocks(req *querypb.StreamBlocksRequest, stream querypb.QueryService_StreamBlocksServer) error {
+	fromHeight := int64(-1)
+	if req.FromHeight != nil {
+		if req.GetFromHeight() > math.MaxInt64 {
+			return status.Error(codes.InvalidArgument, "from_height is out of range")
+		}
+		fromHeight = int64(req.GetFromHeight())
+		latest, err := events.LatestHeight(q.db)
+		if err != nil {
+			return queryError(err, "unable to resume the stream")
+		}
+		// Resuming right after the latest block is allowed, a later height is not indexed yet
+		if fromHeight > latest+1 {
+			return status.Errorf(codes.InvalidArgument, "from_height is above the next block %d", latest+1)
+		}
+		if latest-fromHeight >= events.MaxResumeBlocks {
+			return status.Errorf(codes.InvalidArgument, "from_height is more than %d blocks behind the latest block %d",
+				events.MaxResumeBlocks, latest)
+		}
+	}
+
+	send := func(batch []events.Event) error {
+		for _, event := range batch {
+			if block, ok := event.Data.(models.Block); ok {
+				if err := stream.Send(blockMessage(block)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	// gRPC keeps the connection alive, so the stream needs no heartbeat
+	ctx := stream.Context()
+	resumeHeight, err := events.Stream(ctx, q.db, q.events, events.Filter{Topic: events.TopicBlocks}, fromHeight, send, nil)
+	if errors.Is(err, events.ErrSubscriberLagged) {
+		return status.Errorf(codes.ResourceExhausted, "subscriber fell behind, resume with from_height %d", resumeHeight)
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Error streaming blocks: %v", err)
+		return status.Error(codes.Internal, "unable to stream blocks")
+	}
+	return nil
+}
+
+// queryPage reads the limit and cursor of a list request
+func queryPage(limit uint32, cursor string) (models.Page, error) {
+	page := models.Page{Limit: defaultQueryPageSize}
+	if limit != 0 {
+		if limit > maxQueryPageSize {
+			return page, status.Errorf(codes.InvalidArgument, "invalid limit, must be between 1 and %d", maxQueryPageSize)
+		}
+		page.Limit = int(limit)
+	}
+	if cursor != "" {
+		decoded, err := models.DecodeCursor(cursor)
+		if err != nil {
+			return page, status.Error(codes.InvalidArgument, "invalid cursor")
+		}
+		page.Cursor = decoded
+	}
+	return page, nil
+}
+
+// queryError logs the error of a query and returns the status sent to the client, so the
+// queries are not leaked in the response
+func queryError(err error, message string) error {
+	if errors.Is(err, models.ErrInvalidCursor) {
+		return status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+	log.Printf("Query service: %s: %v", message, err)
+	return status.Error(codes.Internal, message)
+}
+
+// blockMessage converts a block to its protobuf message
+func blockMessage(block models.Block) *querypb.Block {
+	return &querypb.Block{
+		BlockHeight:        uint64(block.BlockHeight),
+		BlockHash:          block.BlockHash,
+		ParentBlockHash:    block.ParentBlockHash,
+		StateRoot:          block.StateRoot,
+		BlockSize:          uint64(block.BlockSize),
+		TxCount:            uint64(block.TxCount),
+		TotalFee:           block.TotalFee,
+		AvgTxSize:          block.AvgTxSize,
+		UniqueParticipants: uint64(block.UniqueParticipants),
+		Timestamp:          block.Timestamp,
+	}
+}
+
+// transactionMessage converts a transaction and its actions to its protobuf message
+func transactionMessage(tx models.Transaction, actions []*querypb.Action) *querypb.Transaction {
+	return &querypb.Transaction{
+		TxHash:      tx.TxHash,
+		BlockHash:   tx.BlockHash,
+		BlockHeight: uint64(tx.BlockHeight),
+		Sponsor:     tx.Sponsor,
+		Actors:      tx.Actors,
+		Receivers:   tx.Receivers,
+		MaxFee:      tx.MaxFee,
+		Success:     tx.Success,
+		Fee:         tx.Fee,
+		Actions:     actions,
+		Timestamp:   tx.Timestamp,
+	}
+}
+
+// actionMessage converts an action to its protobuf message
+func actionMessage(action models.Action) (*querypb.Action, error) {
+	input, err := structpb.NewStruct(action.Input)
+	if err != nil {
+		return nil, err
+	}
+	output, err := structpb.NewStruct(action.Output)
+	if err != nil {
+		return nil, err
+	}
+	return &querypb.Action{
+		ActionType:  uint32(action.ActionType),
+		ActionName:  action.ActionName,
+		ActionIndex: uint32(action.ActionIndex),
+		Input:       input,
+		Output:      output,
+		Timestamp:   action.Timestamp,
+	}, nil
+}
+
+// assetMessage converts an asset to its protobuf message
+func assetMessage(asset models.Asset) *querypb.Asset {
+	return &querypb.Asset{
+		AssetAddress:                 asset.AssetAddress,
+		AssetTypeId:                  uint32(asset.AssetTypeID),
+		AssetType:                    asset.AssetType,
+		AssetCreator:                 asset.AssetCreator,
+		TxHash:                       asset.TxHash,
+		Name:                         asset.Name,
+		Symbol:                       asset.Symbol,
+		Decimals:                     uint32(asset.Decimals),
+		Metadata:                     asset.Metadata,
+		MaxSupply:                    asset.MaxSupply,
+		Owner:                        asset.Owner,
+		MintAdmin:                    asset.MintAdmin,
+		PauseUnpauseAdmin:            asset.PauseUnpauseAdmin,
+		FreezeUnfreezeAdmin:          asset.FreezeUnfreezeAdmin,
+		EnableDisableKycAccountAdmin: asset.EnableDisableKYCAccountAdmin,
+		TotalMinted:                  asset.TotalMinted.String(),
+		TotalBurned:                  asset.TotalBurned.String(),
+		CurrentSupply:                asset.CurrentSupply.String(),
+		Timestamp:                    asset.Timestamp,
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nuklai/nuklaivm-external-subscriber/events"
+	querypb "github.com/nuklai/nuklaivm-external-subscriber/proto/pb/query"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newQueryServer returns a query server reading from a mocked database
+func newQueryServer(t *testing.T) (*QueryServer, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewQueryServer(db, nil), mock
+}
+
+func TestQueryServerGetBlock(t *testing.T) {
+	q, mock := newQueryServer(t)
+
+	mock.ExpectQuery(`SELECT \* FROM blocks WHERE block_height = \$1::bigint`).
+		WithArgs("2456").
+		WillReturnRows(sqlmock.NewRows([]string{"block_height", "block_hash", "parent_block_hash", "state_root",
+			"block_size", "tx_count", "total_fee", "avg_tx_size", "unique_participants", "timestamp"}).
+			AddRow(2456, "hash", "parent", "root", 512, 2, 0.5, 256.0, 3, "2024-10-21T12:00:00Z"))
+	mock.ExpectQuery(`SELECT \* FROM blocks WHERE block_hash = \$1`).
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"block_height"}))
+
+	block, err := q.GetBlock(context.Background(), &querypb.GetBlockRequest{Identifier: &querypb.GetBlockRequest_Height{Height: 2456}})
+	if err != nil {
+		t.Fatalf("GetBlock failed: %v", err)
+	}
+	if block.GetBlockHash() != "hash" || block.GetTxCount() != 2 || block.GetUniqueParticipants() != 3 {
+		t.Errorf("unexpected block %v", block)
+	}
+
+	_, err = q.GetBlock(context.Background(), &querypb.GetBlockRequest{Identifier: &querypb.GetBlockRequest_Hash{Hash: "missing"}})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a missing block, got %v", err)
+	}
+
+	_, err = q.GetBlock(context.Background(), &querypb.GetBlockRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument without an identifier, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestQueryServerGetAccount(t *testing.T) {
+	q, mock := newQueryServer(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM balances`)).
+		WithArgs("missing", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"asset_address", "balance"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM balances`)).
+		WithArgs("address1", sqlmock.AnyArg()).
+		WillReturnError(errors.New("connection refused"))
+
+	_, err := q.GetAccount(context.Background(), &querypb.GetAccountRequest{Address: "0xmissing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for an address without balances, got %v", err)
+	}
+
+	_, err = q.GetAccount(context.Background(), &querypb.GetAccountRequest{Address: "address1"})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("expected Internal for a database failure, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	page, err := queryPage(0, "")
+	if err != nil || page.Limit != defaultQueryPageSize {
+		t.Errorf("expected the default page size, got %v, %v", page, err)
+	}
+	if _, err := queryPage(maxQueryPageSize+1, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a limit over %d, got %v", maxQueryPageSize, err)
+	}
+	if _, err := queryPage(10, "not a cursor"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid cursor, got %v", err)
+	}
+}
+
+func TestQueryTokenAuthorize(t *testing.T) {
+	token := queryToken("secret")
+
+	tests := []struct {
+		name string
+		md   metadata.MD
+		code codes.Code
+	}{
+		{"valid token", metadata.Pairs("authorization", "Bearer secret"), codes.OK},
+		{"invalid token", metadata.Pairs("authorization", "Bearer other"), codes.Unauthenticated},
+		{"missing token", metadata.MD{}, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			if code := status.Code(token.authorize(ctx, "/query.QueryService/GetBlock")); code != tt.code {
+				t.Errorf("expected %v, got %v", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestQueryServerStreamBlocksRejectsFromHeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		fromHeight uint64
+		// latest is the latest indexed height, or -1 when it is not queried
+		latest int64
+	}{
+		{name: "beyond int64", fromHeight: math.MaxUint64, latest: -1},
+		{name: "above the next block", fromHeight: 12, latest: 10},
+		{name: "too far behind", fromHeight: 5, latest: 5 + events.MaxResumeBlocks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, mock := newQueryServer(t)
+			if tt.latest >= 0 {
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(block_height), -1) FROM blocks`)).
+					WillReturnRows(sqlmock.NewRows([]string{"height"}).AddRow(tt.latest))
+			}
+
+			// The request is rejected before anything is streamed
+			err := q.StreamBlocks(&querypb.StreamBlocksRequest{FromHeight: &tt.fromHeight}, nil)
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("StreamBlocks() error = %v, want InvalidArgument", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
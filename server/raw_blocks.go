@@ -0,0 +1,62 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ava-labs/hypersdk/chain"
+)
+
+// storeRawBlock archives the serialized block exactly as received from the node, so the
+// derived tables can be rebuilt later without the node
+func storeRawBlock(dbTx *sql.Tx, executedBlock *chain.ExecutedBlock, blockData []byte) error {
+	compressed, err := compressRawBlock(blockData)
+	if err != nil {
+		return fmt.Errorf("error compressing block %d: %w", executedBlock.Block.Hght, err)
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO raw_blocks (block_height, block_hash, data, size, timestamp)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (block_height) DO UPDATE
+		SET block_hash = EXCLUDED.block_hash,
+			data = EXCLUDED.data,
+			size = EXCLUDED.size,
+			timestamp = EXCLUDED.timestamp`,
+		executedBlock.Block.Hght, executedBlock.BlockID.String(), compressed, len(blockData),
+		time.UnixMilli(executedBlock.Block.Tmstmp).UTC())
+	if err != nil {
+		return fmt.Errorf("error archiving block %d: %w", executedBlock.Block.Hght, err)
+	}
+	return nil
+}
+
+// compressRawBlock gzips the serialized block
+func compressRawBlock(blockData []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(blockData); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressRawBlock returns the serialized block stored by compressRawBlock
+func decompressRawBlock(compressed []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
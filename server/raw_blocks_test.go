@@ -0,0 +1,55 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/hypersdk/chain"
+)
+
+func TestRawBlockCompression(t *testing.T) {
+	blockData := bytes.Repeat([]byte("block data "), 100)
+
+	compressed, err := compressRawBlock(blockData)
+	if err != nil {
+		t.Fatalf("compressRawBlock() error = %v", err)
+	}
+	if len(compressed) >= len(blockData) {
+		t.Fatalf("compressed size %d is not below the block size %d", len(compressed), len(blockData))
+	}
+
+	decompressed, err := decompressRawBlock(compressed)
+	if err != nil {
+		t.Fatalf("decompressRawBlock() error = %v", err)
+	}
+	if !bytes.Equal(decompressed, blockData) {
+		t.Fatal("decompressed block differs from the original")
+	}
+}
+
+func TestStoreRawBlock(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+
+	blockID := ids.GenerateTestID()
+	executedBlock := &chain.ExecutedBlock{
+		BlockID: blockID,
+		Block:   &chain.StatelessBlock{Hght: 10, Tmstmp: 1_704_067_200_000},
+	}
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO raw_blocks`)).
+		WithArgs(uint64(10), blockID.String(), sqlmock.AnyArg(), 4, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := storeRawBlock(dbTx, executedBlock, []byte("blk1")); err != nil {
+		t.Fatalf("storeRawBlock() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ava-labs/hypersdk/chain"
+	"github.com/lib/pq"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/db"
+)
+
+const (
+	// reindexStagingSchema is the schema the block tables are rebuilt in
+	reindexStagingSchema = "reindex_staging"
+	// rawBlockBatchSize is the number of archived blocks replayed per transaction
+	rawBlockBatchSize = 100
+)
+
+// ReindexBlocks rebuilds the block tables by replaying every archived raw block into
+// fresh tables, then swaps them in with a single transaction. The replay always covers the
+// whole archive, since the swapped tables replace all stored blocks and the balances and
+// supplies are accumulated from the first block. The replaced tables are kept in a backup
+// schema.
+func ReindexBlocks(dbConn *sql.DB) error {
+	parser, genesisData, err := loadParserFromDB(dbConn)
+	if err != nil {
+		return fmt.Errorf("error loading parser from stored genesis: %w", err)
+	}
+
+	var lowestHeight, highestHeight sql.NullInt64
+	err = dbConn.QueryRow(`SELECT MIN(block_height), MAX(block_height) FROM raw_blocks`).Scan(&lowestHeight, &highestHeight)
+	if err != nil {
+		return fmt.Errorf("error fetching archived block range: %w", err)
+	}
+	if !lowestHeight.Valid {
+		return errors.New("no raw blocks are archived")
+	}
+	fromHeight, toHeight := uint64(lowestHeight.Int64), uint64(highestHeight.Int64)
+
+	// Blocks indexed before the archive existed cannot be replayed and would be lost by the swap
+	var missing int
+	err = dbConn.QueryRow(`
+		SELECT COUNT(*)
+		FROM blocks
+		WHERE NOT EXISTS (SELECT 1 FROM raw_blocks WHERE raw_blocks.block_height = blocks.block_height)`).Scan(&missing)
+	if err != nil {
+		return fmt.Errorf("error checking archived blocks: %w", err)
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d indexed blocks have no archived raw data", missing)
+	}
+
+	log.Printf("Reindexing blocks %d to %d into schema %s...\n", fromHeight, toHeight, reindexStagingSchema)
+	if err := createReindexStaging(dbConn, genesisData); err != nil {
+		return err
+	}
+
+	replayed := 0
+	for batchFrom := fromHeight; batchFrom <= toHeight; batchFrom += rawBlockBatchSize {
+		batchTo := min(batchFrom+rawBlockBatchSize-1, toHeight)
+
+		dbTx, err := beginStagingTx(dbConn)
+		if err != nil {
+			return err
+		}
+		count, err := replayRawBlocks(dbTx, parser, batchFrom, batchTo)
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+		if err := dbTx.Commit(); err != nil {
+			return fmt.Errorf("error committing blocks %d to %d: %w", batchFrom, batchTo, err)
+		}
+		replayed += count
+		log.Printf("Reindexed blocks %d to %d\n", batchFrom, batchTo)
+	}
+
+	dbTx, err := beginStagingTx(dbConn)
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	// Keep new blocks from being written while catching up and swapping the tables
+	if _, err := dbTx.Exec(`SELECT pg_advisory_xact_lock($1)`, blockIngestLockID); err != nil {
+		return fmt.Errorf("error acquiring block ingestion lock: %w", err)
+	}
+
+	// Catch up with the blocks archived while the reindex was running, starting at the
+	// first block replaced by a reorg since it was replayed
+	catchUpFrom, tipHeight, err := fetchReindexCatchUp(dbTx, toHeight)
+	if err != nil {
+		return err
+	}
+	for batchFrom := catchUpFrom; batchFrom <= tipHeight; batchFrom += rawBlockBatchSize {
+		count, err := replayRawBlocks(dbTx, parser, batchFrom, min(batchFrom+rawBlockBatchSize-1, tipHeight))
+		if err != nil {
+			return err
+		}
+		replayed += count
+	}
+
+	backupSchema := fmt.Sprintf("reindex_backup_%d", time.Now().Unix())
+	if err := db.SwapBlockTables(dbTx, reindexStagingSchema, backupSchema); err != nil {
+		return err
+	}
+	if err := dbTx.Commit(); err != nil {
+		return fmt.Errorf("error committing reindexed tables: %w", err)
+	}
+
+	log.Printf("Reindexed %d blocks. The previous tables are kept in schema %s\n", replayed, backupSchema)
+	return nil
+}
+
+// fetchReindexCatchUp returns the range of archived blocks still to replay into the
+// staging tables, which were replayed up to replayedHeight. It starts at the lowest height
+// where the staged and stored blocks differ, or right after replayedHeight.
+func fetchReindexCatchUp(dbTx *sql.Tx, replayedHeight uint64) (uint64, uint64, error) {
+	var divergedHeight, tipHeight sql.NullInt64
+	err := dbTx.QueryRow(`
+		SELECT MIN(COALESCE(live.block_height, staged.block_height))
+		FROM public.blocks AS live
+		FULL OUTER JOIN blocks AS staged ON staged.block_height = live.block_height
+		WHERE COALESCE(live.block_height, staged.block_height) <= $1
+		AND live.block_hash IS DISTINCT FROM staged.block_hash`, replayedHeight).Scan(&divergedHeight)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error comparing reindexed blocks: %w", err)
+	}
+	if err := dbTx.QueryRow(`SELECT MAX(block_height) FROM public.raw_blocks`).Scan(&tipHeight); err != nil {
+		return 0, 0, fmt.Errorf("error fetching latest archived block: %w", err)
+	}
+
+	catchUpFrom := replayedHeight + 1
+	if divergedHeight.Valid {
+		catchUpFrom = uint64(divergedHeight.Int64)
+	}
+	return catchUpFrom, uint64(tipHeight.Int64), nil
+}
+
+// createReindexStaging creates empty copies of the chain tables in the staging schema,
+// holding only the genesis allocations
+func createReindexStaging(dbConn *sql.DB, genesisData []byte) error {
+	quotedSchema := pq.QuoteIdentifier(reindexStagingSchema)
+	if _, err := dbConn.Exec(`DROP SCHEMA IF EXISTS ` + quotedSchema + ` CASCADE`); err != nil {
+		return fmt.Errorf("error dropping previous staging schema: %w", err)
+	}
+
+	dbTx, err := beginStagingTx(dbConn)
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	if _, err := dbTx.Exec(`CREATE SCHEMA ` + quotedSchema); err != nil {
+		return fmt.Errorf("error creating staging schema: %w", err)
+	}
+	if err := db.CopyTables(dbTx, "public", reindexStagingSchema, db.ChainTables); err != nil {
+		return fmt.Errorf("error creating staging tables: %w", err)
+	}
+	if err := storeGenesisBalances(dbTx, genesisData); err != nil {
+		return fmt.Errorf("error storing genesis balances: %w", err)
+	}
+	return dbTx.Commit()
+}
+
+// beginStagingTx starts a transaction resolving the table names to the staging schema
+func beginStagingTx(dbConn *sql.DB) (*sql.Tx, error) {
+	dbTx, err := dbConn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dbTx.Exec(`SET LOCAL search_path TO ` + pq.QuoteIdentifier(reindexStagingSchema) + `, public`); err != nil {
+		dbTx.Rollback()
+		return nil, fmt.Errorf("error selecting staging schema: %w", err)
+	}
+	return dbTx, nil
+}
+
+// replayRawBlocks processes the archived blocks of [fromHeight, toHeight] in height order
+// and returns the number of blocks replayed
+func replayRawBlocks(dbTx *sql.Tx, parser chain.Parser, fromHeight, toHeight uint64) (int, error) {
+	rows, err := dbTx.Query(`
+		SELECT block_height, data
+		FROM public.raw_blocks
+		WHERE block_height BETWEEN $1 AND $2
+		ORDER BY block_height`, fromHeight, toHeight)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching archived blocks: %w", err)
+	}
+
+	// The rows are read before any block is written since the transaction runs a single query at a time
+	type rawBlock struct {
+		height uint64
+		data   []byte
+	}
+	var blocks []rawBlock
+	for rows.Next() {
+		var blk rawBlock
+		if err := rows.Scan(&blk.height, &blk.data); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning archived block: %w", err)
+		}
+		blocks = append(blocks, blk)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error fetching archived blocks: %w", err)
+	}
+
+	for _, blk := range blocks {
+		blockData, err := decompressRawBlock(blk.data)
+		if err != nil {
+			return 0, fmt.Errorf("error decompressing block %d: %w", blk.height, err)
+		}
+		executedBlock, err := chain.UnmarshalExecutedBlock(blockData, parser)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing block %d: %w", blk.height, err)
+		}
+		if _, err := processBlockData(dbTx, executedBlock); err != nil {
+			return 0, fmt.Errorf("error replaying block %d: %w", blk.height, err)
+		}
+	}
+	return len(blocks), nil
+}
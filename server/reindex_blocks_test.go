@@ -0,0 +1,128 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/db"
+)
+
+// expectArchiveRange expects ReindexBlocks to load the parser and read the archived range
+func expectArchiveRange(mock sqlmock.Sqlmock, lowest, highest int64, unarchived int) {
+	mock.ExpectQuery(regexp.QuoteMeta(storedGenesisQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(`{}`))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT MIN(block_height), MAX(block_height) FROM raw_blocks`)).
+		WillReturnRows(sqlmock.NewRows([]string{"min", "max"}).AddRow(lowest, highest))
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE NOT EXISTS (SELECT 1 FROM raw_blocks WHERE raw_blocks.block_height = blocks.block_height)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(unarchived))
+}
+
+// expectStagingTx expects a transaction on the staging tables
+func expectStagingTx(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SET LOCAL search_path TO "reindex_staging", public`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectRawBlocks expects the archived blocks of [from, to] to be read, none being found
+func expectRawBlocks(mock sqlmock.Sqlmock, from, to uint64) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM public.raw_blocks`)).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"block_height", "data"}))
+}
+
+func TestReindexBlocksRefusesUnarchivedBlocks(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+
+	// Blocks 1 to 49 were indexed before the archive started at block 50. They would be
+	// lost by the swap, so no staging table is created.
+	expectArchiveRange(mock, 50, 100, 49)
+
+	if err := ReindexBlocks(dbConn); err == nil {
+		t.Fatal("expected the reindex to be refused")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReindexBlocksReplaysWholeArchive(t *testing.T) {
+	tests := []struct {
+		name           string
+		divergedHeight driver.Value
+		catchUpFrom    uint64
+	}{
+		{name: "blocks accepted during the reindex", divergedHeight: nil, catchUpFrom: 151},
+		{name: "block replaced by a reorg", divergedHeight: int64(120), catchUpFrom: 120},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConn, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error creating mock database: %v", err)
+			}
+			defer dbConn.Close()
+
+			expectArchiveRange(mock, 1, 150, 0)
+
+			// The staging tables are created with the genesis allocations
+			mock.ExpectExec(regexp.QuoteMeta(`DROP SCHEMA IF EXISTS "reindex_staging" CASCADE`)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			expectStagingTx(mock)
+			mock.ExpectExec(regexp.QuoteMeta(`CREATE SCHEMA "reindex_staging"`)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			expectCopyChainTables(mock, "public", reindexStagingSchema)
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM blocks)`)).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM balance_changes`)).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM balances`)).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+
+			// The whole archive is replayed from its lowest block
+			for _, batch := range [][2]uint64{{1, 100}, {101, 150}} {
+				expectStagingTx(mock)
+				expectRawBlocks(mock, batch[0], batch[1])
+				mock.ExpectCommit()
+			}
+
+			// The catch-up and the swap run while ingestion is paused
+			expectStagingTx(mock)
+			mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).
+				WithArgs(blockIngestLockID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(regexp.QuoteMeta(`FULL OUTER JOIN blocks AS staged`)).
+				WithArgs(uint64(150)).
+				WillReturnRows(sqlmock.NewRows([]string{"min"}).AddRow(tt.divergedHeight))
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT MAX(block_height) FROM public.raw_blocks`)).
+				WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(160))
+			expectRawBlocks(mock, tt.catchUpFrom, 160)
+			mock.ExpectExec(`CREATE SCHEMA "reindex_backup_\d+"`).WillReturnResult(sqlmock.NewResult(0, 0))
+			for _, table := range db.BlockTables {
+				mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE public.` + table + ` SET SCHEMA "reindex_backup_`)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "reindex_staging".` + table + ` SET SCHEMA public`)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectExec(regexp.QuoteMeta(`DROP SCHEMA "reindex_staging" CASCADE`)).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+
+			if err := ReindexBlocks(dbConn); err != nil {
+				t.Fatalf("ReindexBlocks() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
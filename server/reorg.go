@@ -0,0 +1,176 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/webhooks"
+)
+
+// orphanedTxsQuery selects the hashes of all transactions included in blocks at or above $1
+const orphanedTxsQuery = `
+	SELECT transactions.tx_hash
+	FROM transactions
+	INNER JOIN blocks ON transactions.block_hash = blocks.block_hash
+	WHERE blocks.block_height >= $1`
+
+// checkChainContinuity verifies that the incoming block extends the stored chain.
+// It returns true when the exact same block has already been indexed, in which case
+// the caller should skip it. When the incoming block conflicts with stored blocks,
+// every stored block from the first conflicting height upwards is rolled back.
+func checkChainContinuity(dbTx *sql.Tx, blockHeight uint64, blockHash, parentHash string) (bool, error) {
+	storedHash, err := fetchStoredBlockHash(dbTx, blockHeight)
+	if err != nil {
+		return false, err
+	}
+	if storedHash == blockHash {
+		return true, nil
+	}
+
+	// The lowest stored height that does not belong to the chain of the incoming block
+	var conflictHeight uint64
+	conflict := false
+
+	// The stored parent must be the block referenced by the incoming block
+	if blockHeight > 0 {
+		storedParentHash, err := fetchStoredBlockHash(dbTx, blockHeight-1)
+		if err != nil {
+			return false, err
+		}
+		if storedParentHash != "" && storedParentHash != parentHash {
+			log.Printf("Reorg detected: block %d references parent %s but stored parent is %s\n", blockHeight, parentHash, storedParentHash)
+			conflictHeight, conflict = blockHeight-1, true
+		}
+	}
+
+	// A different block stored at the same height is replaced
+	if !conflict && storedHash != "" {
+		log.Printf("Reorg detected: block %d has hash %s but stored block has hash %s\n", blockHeight, blockHash, storedHash)
+		conflictHeight, conflict = blockHeight, true
+	}
+
+	// A stored child (e.g. when filling a gap) must reference the incoming block
+	if !conflict {
+		var childParentHash sql.NullString
+		err := dbTx.QueryRow(`SELECT parent_block_hash FROM blocks WHERE block_height = $1`, blockHeight+1).Scan(&childParentHash)
+		if err != nil && err != sql.ErrNoRows {
+			return false, fmt.Errorf("error fetching child of block %d: %w", blockHeight, err)
+		}
+		if err == nil && childParentHash.String != blockHash {
+			log.Printf("Reorg detected: stored block %d does not reference block %s\n", blockHeight+1, blockHash)
+			conflictHeight, conflict = blockHeight+1, true
+		}
+	}
+
+	if !conflict {
+		return false, nil
+	}
+
+	return false, rollbackBlocksFrom(dbTx, conflictHeight, blockHeight, blockHash)
+}
+
+// rollbackBlocksFrom removes every block at or above fromHeight together with all
+// rows derived from them, and records the reorg in the chain_reorgs table
+func rollbackBlocksFrom(dbTx *sql.Tx, fromHeight, newBlockHeight uint64, newBlockHash string) error {
+	var oldTipHeight uint64
+	var oldTipHash string
+	err := dbTx.QueryRow(`SELECT block_height, block_hash FROM blocks ORDER BY block_height DESC LIMIT 1`).Scan(&oldTipHeight, &oldTipHash)
+	if err != nil {
+		return fmt.Errorf("error fetching stored tip: %w", err)
+	}
+
+	var orphanedHashes []string
+	err = dbTx.QueryRow(`
+		SELECT COALESCE(ARRAY_AGG(block_hash ORDER BY block_height), '{}')
+		FROM blocks
+		WHERE block_height >= $1`, fromHeight).Scan(pq.Array(&orphanedHashes))
+	if err != nil {
+		return fmt.Errorf("error fetching orphaned blocks: %w", err)
+	}
+
+	// Revert the action counters before the actions themselves are removed
+	_, err = dbTx.Exec(`
+		UPDATE action_volumes
+		SET total_count = GREATEST(action_volumes.total_count - orphaned.count, 0)
+		FROM (
+			SELECT action_type, COUNT(*) AS count
+			FROM actions
+			WHERE tx_hash IN (`+orphanedTxsQuery+`)
+			GROUP BY action_type
+		) AS orphaned
+		WHERE action_volumes.action_type = orphaned.action_type`, fromHeight)
+	if err != nil {
+		return fmt.Errorf("error reverting action volumes: %w", err)
+	}
+
+	// Rows derived from actions are reverted by the indexers owning them
+	if err := rollbackActionIndexers(dbTx, fromHeight); err != nil {
+		return err
+	}
+
+	// Partners are not notified of the actions that are no longer on the chain
+	cancelled, err := webhooks.CancelDeliveries(dbTx, fromHeight)
+	if err != nil {
+		return fmt.Errorf("error cancelling webhook deliveries: %w", err)
+	}
+	if cancelled > 0 {
+		log.Printf("Cancelled %d pending webhook deliveries of orphaned blocks\n", cancelled)
+	}
+
+	_, err = dbTx.Exec(`DELETE FROM actions WHERE tx_hash IN (`+orphanedTxsQuery+`)`, fromHeight)
+	if err != nil {
+		return fmt.Errorf("error removing orphaned actions: %w", err)
+	}
+
+	result, err := dbTx.Exec(`DELETE FROM transactions WHERE tx_hash IN (`+orphanedTxsQuery+`)`, fromHeight)
+	if err != nil {
+		return fmt.Errorf("error removing orphaned transactions: %w", err)
+	}
+	orphanedTxs, _ := result.RowsAffected()
+
+	_, err = dbTx.Exec(`DELETE FROM blocks WHERE block_height >= $1`, fromHeight)
+	if err != nil {
+		return fmt.Errorf("error removing orphaned blocks: %w", err)
+	}
+
+	_, err = dbTx.Exec(`DELETE FROM raw_blocks WHERE block_height >= $1`, fromHeight)
+	if err != nil {
+		return fmt.Errorf("error removing orphaned raw blocks: %w", err)
+	}
+
+	_, err = dbTx.Exec(`
+		INSERT INTO chain_reorgs (
+			fork_height, old_tip_height, old_tip_hash, new_block_height, new_block_hash,
+			orphaned_blocks, orphaned_transactions, orphaned_block_hashes, timestamp
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		int64(fromHeight)-1, oldTipHeight, oldTipHash, newBlockHeight, newBlockHash,
+		len(orphanedHashes), orphanedTxs, pq.Array(orphanedHashes), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error recording reorg: %w", err)
+	}
+
+	log.Printf("Rolled back %d blocks and %d transactions above height %d\n", len(orphanedHashes), orphanedTxs, int64(fromHeight)-1)
+	return nil
+}
+
+// fetchStoredBlockHash returns the hash of the stored block at the given height,
+// or an empty string if no block is stored there
+func fetchStoredBlockHash(dbTx *sql.Tx, blockHeight uint64) (string, error) {
+	var hash string
+	err := dbTx.QueryRow(`SELECT block_hash FROM blocks WHERE block_height = $1`, blockHeight).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error fetching block %d: %w", blockHeight, err)
+	}
+	return hash, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectStoredBlockHash expects the lookup of the block stored at height, which is
+// missing when hash is empty
+func expectStoredBlockHash(mock sqlmock.Sqlmock, height uint64, hash string) {
+	rows := sqlmock.NewRows([]string{"block_hash"})
+	if hash != "" {
+		rows.AddRow(hash)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT block_hash FROM blocks WHERE block_height = $1`)).
+		WithArgs(height).
+		WillReturnRows(rows)
+}
+
+// expectNoStoredChild expects the lookup of the child of the block at height to find nothing
+func expectNoStoredChild(mock sqlmock.Sqlmock, height uint64) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT parent_block_hash FROM blocks WHERE block_height = $1`)).
+		WithArgs(height + 1).
+		WillReturnRows(sqlmock.NewRows([]string{"parent_block_hash"}))
+}
+
+// recordIndexerRollbacks replaces the registered indexers with recording ones for the test
+func recordIndexerRollbacks(t *testing.T) *[]string {
+	t.Helper()
+
+	savedIndexers := registeredIndexers
+	t.Cleanup(func() { registeredIndexers = savedIndexers })
+
+	var rolledBack []string
+	registeredIndexers = []ActionIndexer{
+		&recordingIndexer{name: "first", rolledBack: &rolledBack},
+		&recordingIndexer{name: "second", rolledBack: &rolledBack},
+	}
+	return &rolledBack
+}
+
+func TestCheckChainContinuityParentMismatch(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	rolledBack := recordIndexerRollbacks(t)
+
+	expectStoredBlockHash(mock, 10, "")
+	expectStoredBlockHash(mock, 9, "stale9")
+
+	// Block 9 and above are rolled back
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT block_height, block_hash FROM blocks ORDER BY block_height DESC LIMIT 1`)).
+		WillReturnRows(sqlmock.NewRows([]string{"block_height", "block_hash"}).AddRow(9, "stale9"))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(ARRAY_AGG(block_hash ORDER BY block_height), '{}')`)).
+		WithArgs(uint64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"hashes"}).AddRow("{stale9}"))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE action_volumes`)).
+		WithArgs(uint64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM webhook_deliveries WHERE block_height >= $1 AND status = $2`)).
+		WithArgs(uint64(9), "pending").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM actions`)).
+		WithArgs(uint64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM transactions`)).
+		WithArgs(uint64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM blocks WHERE block_height >= $1`)).
+		WithArgs(uint64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM raw_blocks WHERE block_height >= $1`)).
+		WithArgs(uint64(9)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO chain_reorgs`)).
+		WithArgs(int64(8), uint64(9), "stale9", uint64(10), "hash10", 1, int64(2), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	alreadyIndexed, err := checkChainContinuity(dbTx, 10, "hash10", "hash9")
+	if err != nil {
+		t.Fatalf("checkChainContinuity() error = %v", err)
+	}
+	if alreadyIndexed {
+		t.Fatal("expected the block not to be reported as already indexed")
+	}
+	if want := []string{"second", "first"}; !reflect.DeepEqual(*rolledBack, want) {
+		t.Fatalf("rolled back %v, want %v", *rolledBack, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckChainContinuityNoRollback(t *testing.T) {
+	tests := []struct {
+		name       string
+		parentHash string
+	}{
+		{name: "matching parent", parentHash: "hash9"},
+		{name: "missing parent", parentHash: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			rolledBack := recordIndexerRollbacks(t)
+
+			expectStoredBlockHash(mock, 10, "")
+			expectStoredBlockHash(mock, 9, tt.parentHash)
+			expectNoStoredChild(mock, 10)
+
+			alreadyIndexed, err := checkChainContinuity(dbTx, 10, "hash10", "hash9")
+			if err != nil {
+				t.Fatalf("checkChainContinuity() error = %v", err)
+			}
+			if alreadyIndexed {
+				t.Fatal("expected the block not to be reported as already indexed")
+			}
+			if len(*rolledBack) != 0 {
+				t.Fatalf("expected no rollback, rolled back %v", *rolledBack)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCheckChainContinuityAlreadyIndexed(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+
+	expectStoredBlockHash(mock, 10, "hash10")
+
+	alreadyIndexed, err := checkChainContinuity(dbTx, 10, "hash10", "hash9")
+	if err != nil {
+		t.Fatalf("checkChainContinuity() error = %v", err)
+	}
+	if !alreadyIndexed {
+		t.Fatal("expected the block to be reported as already indexed")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -6,6 +6,8 @@ package server
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"strings"
@@ -14,6 +16,8 @@ import (
 
 	"github.com/ava-labs/hypersdk/chain"
 	pb "github.com/ava-labs/hypersdk/proto/pb/externalsubscriber"
+	"github.com/nuklai/nuklaivm-external-subscriber/events"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/reflection"
@@ -22,15 +26,70 @@ import (
 
 var mu = &sync.Mutex{}
 
+const (
+	// maxIngestRetryDelay caps the delay between attempts to ingest a failing block
+	maxIngestRetryDelay = 30 * time.Second
+	// maxIngestAttempts is the number of attempts after which a block that cannot be
+	// written is moved out of the queue, a few minutes with the growing delay
+	maxIngestAttempts = 10
+)
+
 // Server implements the ExternalSubscriberServer
 type Server struct {
 	pb.UnimplementedExternalSubscriberServer
 	db     *sql.DB
 	parser chain.Parser
+	queue  *ingestQueue
+	events *events.Hub
+
+	statusMu     sync.RWMutex
+	parserStatus models.ParserStatus
+}
+
+// NewServer creates the subscriber server with the ingest queue stored in queueDir and
+// restores the chain parser from the stored genesis data, so blocks can be ingested
+// before the node calls Initialize. The events of each ingested block are published to hub.
+func NewServer(db *sql.DB, queueDir string, hub *events.Hub) (*Server, error) {
+	queue, err := openIngestQueue(queueDir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{db: db, queue: queue, events: hub}
+
+	parser, genesisData, err := loadParserFromDB(db)
+	if err != nil {
+		log.Printf("Parser not restored, waiting for Initialize: %v", err)
+		return s, nil
+	}
+	s.setParser(parser, genesisData, "database")
+	log.Printf("Parser restored from stored genesis %s", s.ParserStatus().GenesisHash)
+	return s, nil
+}
+
+// setParser replaces the parser used to decode blocks and records where it came from
+func (s *Server) setParser(parser chain.Parser, genesisData []byte, source string) {
+	s.parser = parser
+
+	loadedAt := time.Now().UTC()
+	s.statusMu.Lock()
+	s.parserStatus = models.ParserStatus{
+		Loaded:      true,
+		GenesisHash: genesisHash(genesisData),
+		Source:      source,
+		LoadedAt:    &loadedAt,
+	}
+	s.statusMu.Unlock()
+}
+
+// ParserStatus reports whether the parser is loaded and which genesis it was built from
+func (s *Server) ParserStatus() models.ParserStatus {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	return s.parserStatus
 }
 
 // StartGRPCServer starts the gRPC server for receiving block data
-func StartGRPCServer(db *sql.DB, port string) error {
+func StartGRPCServer(s *Server, port string) error {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic in StartGRPCServer: %v", r)
@@ -54,7 +113,7 @@ func StartGRPCServer(db *sql.DB, port string) error {
 		grpc.UnaryInterceptor(UnaryInterceptor),
 	}
 	grpcServer := grpc.NewServer(serverOptions...)
-	pb.RegisterExternalSubscriberServer(grpcServer, &Server{db: db})
+	pb.RegisterExternalSubscriberServer(grpcServer, s)
 	reflection.Register(grpcServer)
 
 	log.Printf("External Subscriber server is listening on port %s...\n", port)
@@ -62,9 +121,9 @@ func StartGRPCServer(db *sql.DB, port string) error {
 }
 
 // StartGRPCServerWithRetries retries gRPC server startup in case of failure
-func StartGRPCServerWithRetries(db *sql.DB, port string, retries int) {
+func StartGRPCServerWithRetries(s *Server, port string, retries int) {
 	for i := 0; i < retries; i++ {
-		err := StartGRPCServer(db, port)
+		err := StartGRPCServer(s, port)
 		if err != nil {
 			log.Printf("gRPC server failed to start: %v. Retrying (%d/%d)...", err, i+1, retries)
 			time.Sleep(5 * time.Second)
@@ -91,23 +150,139 @@ func (s *Server) Initialize(ctx context.Context, req *pb.InitializeRequest) (*em
 		log.Printf("Error initializing External Subscriber: %v", err)
 		return nil, err
 	}
-	s.parser = parser
+	s.setParser(parser, req.GetGenesis(), "initialize")
 	return &emptypb.Empty{}, nil
 }
 
-// AcceptBlock processes a new block
-func (s *Server) AcceptBlock(ctx context.Context, req *pb.BlockRequest) (*emptypb.Empty, error) {
+// AcceptBlock appends a new block to the ingest queue and returns without waiting for
+// the database. A failure to enqueue is reported back to the node so that the block is
+// retried instead of being silently skipped.
+func (s *Server) AcceptBlock(ctx context.Context, req *pb.BlockRequest) (resp *emptypb.Empty, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic in AcceptBlock: %v", r)
+			resp, err = nil, fmt.Errorf("panic while processing block: %v", r)
+		}
+	}()
+
+	if len(req.GetBlockData()) == 0 {
+		return nil, errors.New("empty block data")
+	}
+	if err := s.queue.Enqueue(req.GetBlockData()); err != nil {
+		log.Printf("Error enqueuing block: %v", err)
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// IngestQueueStatus reports the blocks waiting in the ingest queue
+func (s *Server) IngestQueueStatus() models.IngestQueueStatus {
+	return s.queue.Status()
+}
+
+// RunIngestWorker writes the queued blocks to the database in the order they were
+// accepted. A block that cannot be written is retried with a growing delay while the
+// blocks after it wait, since they depend on it.
+func (s *Server) RunIngestWorker() {
+	failures := 0
+	for {
+		if delay := s.ingestNext(&failures); delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+}
+
+// ingestNext writes the oldest queued block and returns how long to wait before the next
+// attempt. failures counts the failed attempts of the oldest block. After maxIngestAttempts,
+// the block is moved to the dead letter directory of the queue, but only while the parser is
+// loaded and the database is reachable, so an outage never drops blocks.
+func (s *Server) ingestNext(failures *int) time.Duration {
+	record, err := s.queue.Next()
+	if err != nil {
+		log.Printf("Error reading ingest queue: %v", err)
+		s.queue.SetError(err)
+		return ingestRetryDelay(*failures)
+	}
+	if record == nil {
+		s.queue.Wait(time.Second)
+		return 0
+	}
+
+	if err := s.ingestBlock(record.data); err != nil {
+		*failures++
+		if *failures >= maxIngestAttempts && s.ParserStatus().Loaded && s.db.Ping() == nil {
+			path, deadLetterErr := s.queue.DeadLetter(record)
+			if deadLetterErr != nil {
+				log.Printf("Error moving queued block to the dead letters: %v", deadLetterErr)
+				s.queue.SetError(deadLetterErr)
+				return ingestRetryDelay(*failures)
+			}
+			log.Printf("Queued block failed %d times and was moved to %s: %v", *failures, path, err)
+			*failures = 0
+			return 0
+		}
+
+		delay := ingestRetryDelay(*failures)
+		log.Printf("Error ingesting queued block, retrying in %v: %v", delay, err)
+		s.queue.SetError(err)
+		return delay
+	}
+	*failures = 0
+
+	if err := s.queue.Commit(record); err != nil {
+		log.Printf("Error committing ingest queue checkpoint: %v", err)
+		s.queue.SetError(err)
+	}
+	return 0
+}
+
+// ingestRetryDelay doubles the delay after each failed attempt, from one second up to
+// maxIngestRetryDelay
+func ingestRetryDelay(failures int) time.Duration {
+	if failures > 5 {
+		return maxIngestRetryDelay
+	}
+	return min(time.Second<<max(failures-1, 0), maxIngestRetryDelay)
+}
+
+// ingestBlock writes a single queued block to the database, then publishes its events
+func (s *Server) ingestBlock(blockData []byte) error {
+	executedBlock, err := s.writeBlock(blockData)
+	if err != nil {
+		return err
+	}
+	if executedBlock != nil {
+		s.publishBlock(executedBlock.Block.Hght)
+	}
+	return nil
+}
+
+// writeBlock writes a single queued block to the database and returns it, or nil when it
+// was already indexed
+func (s *Server) writeBlock(blockData []byte) (executedBlock *chain.ExecutedBlock, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic while ingesting block: %v", r)
+			executedBlock, err = nil, fmt.Errorf("panic while processing block: %v", r)
 		}
 	}()
 	mu.Lock()
 	defer mu.Unlock()
 
-	err := handleAcceptBlock(s.db, s.parser, req)
+	return handleAcceptBlock(s.db, s.parser, &pb.BlockRequest{BlockData: blockData})
+}
+
+// publishBlock publishes the events of a committed block. The events are read back from
+// the database, so they are identical to the events replayed to a resuming subscriber.
+// A failure only affects the live subscribers, which can resume from the block.
+func (s *Server) publishBlock(height uint64) {
+	if s.events == nil || !s.events.HasSubscribers() {
+		return
+	}
+	blockEvents, err := events.LoadBlockEvents(s.db, int64(height))
 	if err != nil {
-		return nil, err
+		log.Printf("Error loading events of block %d: %v", height, err)
+		return
 	}
-	return &emptypb.Empty{}, nil
+	s.events.Publish(blockEvents)
 }
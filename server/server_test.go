@@ -0,0 +1,114 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ava-labs/avalanchego/ids"
+	"github.com/ava-labs/hypersdk/chain"
+	"github.com/ava-labs/hypersdk/fees"
+	"github.com/nuklai/nuklaivm/vm"
+)
+
+func TestIngestNextDeadLettersFailingBlock(t *testing.T) {
+	dbConn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+	parser, err := vm.CreateParser([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	dir := t.TempDir()
+	s := &Server{db: dbConn, queue: openTestIngestQueue(t, dir)}
+	s.setParser(parser, []byte(`{}`), "initialize")
+
+	// A block that can never be parsed is queued before a valid one
+	executedBlock, err := chain.NewExecutedBlock(
+		&chain.StatelessBlock{Prnt: ids.GenerateTestID(), Tmstmp: 1, Hght: 10}, nil, fees.Dimensions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockData, err := executedBlock.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{[]byte("not a block"), blockData} {
+		if err := s.queue.Enqueue(data); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	failures := 0
+	for attempt := 1; attempt < maxIngestAttempts; attempt++ {
+		if delay := s.ingestNext(&failures); delay != ingestRetryDelay(attempt) {
+			t.Fatalf("attempt %d waits %v, want %v", attempt, delay, ingestRetryDelay(attempt))
+		}
+		if status := s.queue.Status(); status.Depth != 2 || status.LastError == "" {
+			t.Fatalf("attempt %d left status %+v, want the failing block at the head", attempt, status)
+		}
+	}
+
+	// The last attempt moves the block out of the queue and the next block is written
+	if delay := s.ingestNext(&failures); delay != 0 {
+		t.Fatalf("dead-lettering waits %v, want no delay", delay)
+	}
+	expectEmptyBlockWrite(mock, executedBlock, false)
+	if delay := s.ingestNext(&failures); delay != 0 {
+		t.Fatalf("next block waits %v, want no delay", delay)
+	}
+
+	status := s.queue.Status()
+	if status.Depth != 0 || status.DeadLettered != 1 || status.LastError != "" {
+		t.Fatalf("status = %+v, want an empty queue with one dead-lettered block", status)
+	}
+	deadLetters, err := filepath.Glob(filepath.Join(dir, ingestDeadLetterDir, "*"))
+	if err != nil || len(deadLetters) != 1 {
+		t.Fatalf("dead letters = %v, %v, want one file", deadLetters, err)
+	}
+	if data, err := os.ReadFile(deadLetters[0]); err != nil || string(data) != "not a block" {
+		t.Fatalf("dead letter holds %q, %v, want the failing block", data, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIngestNextKeepsBlockWhileDatabaseIsUnreachable(t *testing.T) {
+	dbConn, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("error creating mock database: %v", err)
+	}
+	defer dbConn.Close()
+	parser, err := vm.CreateParser([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("error creating parser: %v", err)
+	}
+
+	s := &Server{db: dbConn, queue: openTestIngestQueue(t, t.TempDir())}
+	s.setParser(parser, []byte(`{}`), "initialize")
+	if err := s.queue.Enqueue([]byte("not a block")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	failures := 0
+	for attempt := 1; attempt <= maxIngestAttempts; attempt++ {
+		s.ingestNext(&failures)
+	}
+
+	if status := s.queue.Status(); status.Depth != 1 || status.DeadLettered != 0 {
+		t.Fatalf("status = %+v, want the block kept in the queue", status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
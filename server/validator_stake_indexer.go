@@ -0,0 +1,151 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+// validatorStakeIndexer keeps the validator_stake table in sync with the lifecycle of
+// validators and records the rewards they claim in validator_rewards
+type validatorStakeIndexer struct{}
+
+func init() {
+	indexer := &validatorStakeIndexer{}
+	RegisterActionIndexer(vmconsts.RegisterValidatorStakeID, indexer)
+	RegisterActionIndexer(vmconsts.WithdrawValidatorStakeID, indexer)
+	RegisterActionIndexer(vmconsts.ClaimValidatorStakeRewardsID, indexer)
+}
+
+func (*validatorStakeIndexer) Tables() []string {
+	return []string{"validator_stake", "validator_rewards"}
+}
+
+func (v *validatorStakeIndexer) Index(dbTx *sql.Tx, action *IndexedAction) error {
+	switch action.ActionType {
+	case vmconsts.RegisterValidatorStakeID:
+		return v.register(dbTx, action)
+	case vmconsts.WithdrawValidatorStakeID:
+		return v.withdraw(dbTx, action)
+	case vmconsts.ClaimValidatorStakeRewardsID:
+		return v.claimRewards(dbTx, action)
+	}
+	return nil
+}
+
+func (*validatorStakeIndexer) register(dbTx *sql.Tx, action *IndexedAction) error {
+	// Parse the action output
+	nodeID := stringValue(action.Output, "node_id")
+	stakeStartBlock, err := uint64Value(action.Output, "stake_start_block")
+	if err != nil {
+		return err
+	}
+	stakeEndBlock, err := uint64Value(action.Output, "stake_end_block")
+	if err != nil {
+		return err
+	}
+	stakedAmount, err := uint64Value(action.Output, "staked_amount")
+	if err != nil {
+		return err
+	}
+	delegationFeeRate, err := uint64Value(action.Output, "delegation_fee_rate")
+	if err != nil {
+		return err
+	}
+	rewardAddress := stringValue(action.Output, "reward_address")
+
+	// Save the validator stake in the database
+	_, err = dbTx.Exec(`
+            INSERT INTO validator_stake (
+                node_id, actor, stake_start_block, stake_end_block, staked_amount, delegation_fee_rate, reward_address, tx_hash, timestamp
+            )
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+            ON CONFLICT (node_id, stake_start_block) DO UPDATE
+            SET stake_end_block = EXCLUDED.stake_end_block,
+                staked_amount = EXCLUDED.staked_amount,
+                delegation_fee_rate = EXCLUDED.delegation_fee_rate,
+                reward_address = EXCLUDED.reward_address,
+                tx_hash = EXCLUDED.tx_hash,
+                timestamp = EXCLUDED.timestamp`,
+		nodeID, action.Sponsor, stakeStartBlock, stakeEndBlock, stakedAmount, delegationFeeRate, rewardAddress, action.TxHash, action.Timestamp,
+	)
+	return err
+}
+
+// withdraw marks the stake as withdrawn and records the rewards paid out with the stake
+func (v *validatorStakeIndexer) withdraw(dbTx *sql.Tx, action *IndexedAction) error {
+	nodeID := stringValue(action.Input, "node_id")
+	stakeStartBlock, err := uint64Value(action.Output, "stake_start_block")
+	if err != nil {
+		return err
+	}
+	rewardAmount, err := numericValue(action.Output, "reward_amount")
+	if err != nil {
+		return err
+	}
+
+	result, err := dbTx.Exec(`
+		UPDATE validator_stake
+		SET withdrawal_tx_hash = $3, withdrawal_block_height = $4, withdrawn_at = $5
+		WHERE node_id = $1 AND stake_start_block = $2`,
+		nodeID, stakeStartBlock, action.TxHash, action.BlockHeight, action.Timestamp)
+	if err != nil {
+		return err
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		log.Printf("Withdrawn validator stake %s starting at block %d is not indexed\n", nodeID, stakeStartBlock)
+	}
+
+	return v.recordReward(dbTx, action, nodeID, stakeStartBlock, rewardAmount)
+}
+
+// claimRewards records the rewards paid to the reward address of the validator
+func (v *validatorStakeIndexer) claimRewards(dbTx *sql.Tx, action *IndexedAction) error {
+	nodeID := stringValue(action.Input, "node_id")
+	stakeStartBlock, err := uint64Value(action.Output, "stake_start_block")
+	if err != nil {
+		return err
+	}
+	balanceBefore, err := uint64Value(action.Output, "balance_before_claim")
+	if err != nil {
+		return err
+	}
+	balanceAfter, err := uint64Value(action.Output, "balance_after_claim")
+	if err != nil {
+		return err
+	}
+	if balanceAfter < balanceBefore {
+		return fmt.Errorf("balance after claim %d is lower than before %d", balanceAfter, balanceBefore)
+	}
+
+	return v.recordReward(dbTx, action, nodeID, stakeStartBlock, fmt.Sprint(balanceAfter-balanceBefore))
+}
+
+func (*validatorStakeIndexer) recordReward(dbTx *sql.Tx, action *IndexedAction, nodeID string, stakeStartBlock uint64, rewardAmount string) error {
+	_, err := dbTx.Exec(`
+		INSERT INTO validator_rewards (node_id, stake_start_block, tx_hash, block_height, action_name, reward_amount, distributed_to, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		nodeID, stakeStartBlock, action.TxHash, action.BlockHeight, action.ActionName, rewardAmount,
+		addressValue(action.Output, "distributed_to"), action.Timestamp)
+	return err
+}
+
+func (*validatorStakeIndexer) Rollback(dbTx *sql.Tx, fromHeight uint64) error {
+	if _, err := dbTx.Exec(`DELETE FROM validator_rewards WHERE block_height >= $1`, fromHeight); err != nil {
+		return err
+	}
+	_, err := dbTx.Exec(`
+		UPDATE validator_stake
+		SET withdrawal_tx_hash = NULL, withdrawal_block_height = NULL, withdrawn_at = NULL
+		WHERE withdrawal_block_height >= $1`, fromHeight)
+	if err != nil {
+		return err
+	}
+	_, err = dbTx.Exec(`DELETE FROM validator_stake WHERE tx_hash IN (`+orphanedTxsQuery+`)`, fromHeight)
+	return err
+}
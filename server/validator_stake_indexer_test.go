@@ -0,0 +1,157 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package server
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	vmconsts "github.com/nuklai/nuklaivm/consts"
+)
+
+func TestValidatorStakeIndexerIndex(t *testing.T) {
+	tests := []struct {
+		name       string
+		actionType uint8
+		actionName string
+		input      string
+		output     string
+		expect     func(mock sqlmock.Sqlmock)
+		wantErr    bool
+	}{
+		{
+			name:       "register validator stake",
+			actionType: vmconsts.RegisterValidatorStakeID,
+			actionName: "RegisterValidatorStake",
+			input:      `{}`,
+			output:     `{"node_id":"node1","stake_start_block":100,"stake_end_block":200,"staked_amount":5000,"delegation_fee_rate":10,"reward_address":"reward1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO validator_stake`)).
+					WithArgs("node1", "sponsor1", uint64(100), uint64(200), uint64(5000), uint64(10), "reward1", "tx1", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "register validator stake without end block",
+			actionType: vmconsts.RegisterValidatorStakeID,
+			actionName: "RegisterValidatorStake",
+			input:      `{}`,
+			output:     `{"node_id":"node1","stake_start_block":100}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+		{
+			name:       "withdraw validator stake",
+			actionType: vmconsts.WithdrawValidatorStakeID,
+			actionName: "WithdrawValidatorStake",
+			input:      `{"node_id":"node1"}`,
+			output:     `{"stake_start_block":100,"reward_amount":250,"distributed_to":"reward1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`UPDATE validator_stake`)).
+					WithArgs("node1", uint64(100), "tx1", uint64(300), "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO validator_rewards`)).
+					WithArgs("node1", uint64(100), "tx1", uint64(300), "WithdrawValidatorStake", "250", "reward1", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "claim validator stake rewards",
+			actionType: vmconsts.ClaimValidatorStakeRewardsID,
+			actionName: "ClaimValidatorStakeRewards",
+			input:      `{"node_id":"node1"}`,
+			output:     `{"stake_start_block":100,"balance_before_claim":1000,"balance_after_claim":1250,"distributed_to":"reward1"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO validator_rewards`)).
+					WithArgs("node1", uint64(100), "tx1", uint64(300), "ClaimValidatorStakeRewards", "250", "reward1", "2024-01-01T00:00:00Z").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:       "claim validator stake rewards with lower balance",
+			actionType: vmconsts.ClaimValidatorStakeRewardsID,
+			actionName: "ClaimValidatorStakeRewards",
+			input:      `{"node_id":"node1"}`,
+			output:     `{"stake_start_block":100,"balance_before_claim":1250,"balance_after_claim":1000}`,
+			expect:     func(sqlmock.Sqlmock) {},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbTx, mock := newMockTx(t)
+			tt.expect(mock)
+
+			err := (&validatorStakeIndexer{}).Index(dbTx, &IndexedAction{
+				BlockHeight: 300,
+				TxHash:      "tx1",
+				Sponsor:     "sponsor1",
+				ActionType:  tt.actionType,
+				ActionName:  tt.actionName,
+				Input:       jsonMap(t, tt.input),
+				Output:      jsonMap(t, tt.output),
+				Timestamp:   "2024-01-01T00:00:00Z",
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestValidatorStakeIndexerRollback(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM validator_rewards WHERE block_height >= $1`)).
+		WithArgs(uint64(300)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`WHERE withdrawal_block_height >= $1`)).
+		WithArgs(uint64(300)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM validator_stake WHERE tx_hash IN`)).
+		WithArgs(uint64(300)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := (&validatorStakeIndexer{}).Rollback(dbTx, 300); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidatorStakeIndexerRollbackRestoresRows(t *testing.T) {
+	register := func(height uint64, nodeID string) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.RegisterValidatorStakeID, actionName: "RegisterValidatorStake",
+			input: `{"node_id":"` + nodeID + `"}`,
+			output: `{"node_id":"` + nodeID + `","stake_start_block":1,"stake_end_block":100,"staked_amount":1000,` +
+				`"delegation_fee_rate":5,"reward_address":"reward1"}`,
+		}
+	}
+	claim := func(height uint64) testAction {
+		return testAction{
+			height: height, actionType: vmconsts.ClaimValidatorStakeRewardsID, actionName: "ClaimValidatorStakeRewards",
+			input:  `{"node_id":"node1"}`,
+			output: `{"stake_start_block":1,"balance_before_claim":100,"balance_after_claim":150,"distributed_to":"0xreward1"}`,
+		}
+	}
+	testIndexerRollback(t, &validatorStakeIndexer{}, 3, []testAction{
+		register(1, "node1"),
+		claim(2),
+		// The orphaned rewards are removed, the stake is no longer withdrawn and the
+		// orphaned validator is removed
+		claim(3),
+		{
+			height: 3, actionType: vmconsts.WithdrawValidatorStakeID, actionName: "WithdrawValidatorStake",
+			input:  `{"node_id":"node1"}`,
+			output: `{"stake_start_block":1,"reward_amount":20,"distributed_to":"0xreward1"}`,
+		},
+		register(4, "node2"),
+	})
+}
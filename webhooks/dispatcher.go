@@ -0,0 +1,219 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+const (
+	// MaxDeliveryAttempts is the number of attempts after which a delivery is marked failed
+	MaxDeliveryAttempts = 10
+	// retryBaseDelay is the delay before the first retry, doubled after every attempt
+	retryBaseDelay = 10 * time.Second
+	// maxRetryDelay caps the delay between two attempts of a delivery
+	maxRetryDelay = time.Hour
+	// deliveryTimeout bounds the time a webhook has to answer a delivery
+	deliveryTimeout = 10 * time.Second
+	// deliveryBatchSize is the number of due deliveries sent at once
+	deliveryBatchSize = 20
+	// pollInterval is the delay before looking for due deliveries again when none was due
+	pollInterval = time.Second
+	// maxErrorLength caps the error recorded for a failed attempt
+	maxErrorLength = 500
+)
+
+// Headers of a delivery
+const (
+	HeaderWebhookID = "X-Webhook-Id"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Dispatcher posts the queued deliveries to their webhooks and retries the failed ones
+// with an exponential backoff
+type Dispatcher struct {
+	db     *sql.DB
+	client *http.Client
+}
+
+// pendingDelivery is a delivery due to be sent
+type pendingDelivery struct {
+	id        int64
+	webhookID int
+	payload   []byte
+	attempts  int
+	url       string
+	secret    string
+}
+
+// NewDispatcher creates a dispatcher sending the deliveries queued in the database
+func NewDispatcher(db *sql.DB) *Dispatcher {
+	return &Dispatcher{db: db, client: &http.Client{Timeout: deliveryTimeout}}
+}
+
+// Run sends the due deliveries until the process exits
+func (d *Dispatcher) Run() {
+	for {
+		sent, err := d.DeliverPending()
+		if err != nil {
+			log.Printf("Error delivering webhooks: %v", err)
+		}
+		if sent == 0 {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// DeliverPending sends a batch of the deliveries that are due and records the outcome of
+// each attempt. It returns the number of deliveries attempted.
+func (d *Dispatcher) DeliverPending() (int, error) {
+	deliveries, err := d.dueDeliveries(time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error fetching due deliveries: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery pendingDelivery) {
+			defer wg.Done()
+			now := time.Now().UTC()
+			status, err := d.send(delivery, now)
+			if err := d.recordAttempt(delivery, status, err, now); err != nil {
+				log.Printf("Error recording delivery %d to webhook %d: %v", delivery.id, delivery.webhookID, err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+// dueDeliveries returns the pending deliveries of active webhooks whose next attempt is due
+func (d *Dispatcher) dueDeliveries(now time.Time) ([]pendingDelivery, error) {
+	rows, err := d.db.Query(`
+		SELECT d.id, d.webhook_id, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= $1 AND w.active
+		ORDER BY d.next_attempt_at, d.id
+		LIMIT $2`, now, deliveryBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []pendingDelivery
+	for rows.Next() {
+		var delivery pendingDelivery
+		if err := rows.Scan(&delivery.id, &delivery.webhookID, &delivery.payload, &delivery.attempts,
+			&delivery.url, &delivery.secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// send posts a delivery to its webhook. It returns the response status, or 0 when no
+// response was received, and an error unless the webhook answered with a 2xx status.
+func (d *Dispatcher) send(delivery pendingDelivery, now time.Time) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, delivery.url, bytes.NewReader(delivery.payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := now.Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "nuklaivm-external-subscriber")
+	request.Header.Set(HeaderWebhookID, strconv.Itoa(delivery.webhookID))
+	request.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.id, 10))
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Signature(delivery.secret, timestamp, delivery.payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook answered with status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// recordAttempt records the outcome of an attempt. A failed delivery is retried after
+// retryDelay until it reaches MaxDeliveryAttempts.
+func (d *Dispatcher) recordAttempt(delivery pendingDelivery, status int, sendErr error, now time.Time) error {
+	var responseStatus interface{}
+	if status != 0 {
+		responseStatus = status
+	}
+	attempts := delivery.attempts + 1
+
+	if sendErr == nil {
+		_, err := d.db.Exec(`
+			UPDATE webhook_deliveries
+			SET status = $2, attempts = $3, last_attempt_at = $4, delivered_at = $4,
+			    response_status = $5, last_error = ''
+			WHERE id = $1`,
+			delivery.id, models.DeliveryDelivered, attempts, now, responseStatus)
+		return err
+	}
+
+	log.Printf("Delivery %d to webhook %d failed (attempt %d/%d): %v",
+		delivery.id, delivery.webhookID, attempts, MaxDeliveryAttempts, sendErr)
+	deliveryStatus := models.DeliveryPending
+	if attempts >= MaxDeliveryAttempts {
+		deliveryStatus = models.DeliveryFailed
+	}
+	lastError := sendErr.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+	_, err := d.db.Exec(`
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, last_attempt_at = $4, next_attempt_at = $5,
+		    response_status = $6, last_error = $7
+		WHERE id = $1`,
+		delivery.id, deliveryStatus, attempts, now, now.Add(retryDelay(attempts)), responseStatus, lastError)
+	return err
+}
+
+// retryDelay returns the delay before the attempt following the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
+
+// Signature returns the signature header of a delivery: the hex encoded HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the secret of the webhook
+func Signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// expectDueDelivery makes the mocked database return a single due delivery to url
+func expectDueDelivery(mock sqlmock.Sqlmock, url string, attempts int) {
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM webhook_deliveries d`)).
+		WithArgs(sqlmock.AnyArg(), deliveryBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "webhook_id", "payload", "attempts", "url", "secret"}).
+			AddRow(7, 3, []byte(`{"event":"action"}`), attempts, url, testSecret))
+}
+
+func TestDeliverPendingSignsTheDelivery(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		if err != nil || r.Header.Get(HeaderSignature) != Signature(testSecret, timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(HeaderWebhookID) != "3" || r.Header.Get(HeaderDelivery) != "7" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer stub.Close()
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer conn.Close()
+
+	expectDueDelivery(mock, stub.URL, 0)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries`)).
+		WithArgs(int64(7), models.DeliveryDelivered, 1, sqlmock.AnyArg(), http.StatusNoContent).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sent, err := NewDispatcher(conn).DeliverPending()
+	if err != nil {
+		t.Fatalf("DeliverPending() error = %v", err)
+	}
+	if sent != 1 {
+		t.Fatalf("DeliverPending() sent %d deliveries, want 1", sent)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliverPendingRetriesFailedDeliveries(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer stub.Close()
+
+	tests := []struct {
+		name     string
+		attempts int
+		status   string
+	}{
+		{name: "retried", attempts: 2, status: models.DeliveryPending},
+		{name: "last attempt", attempts: MaxDeliveryAttempts - 1, status: models.DeliveryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock.New() error = %v", err)
+			}
+			defer conn.Close()
+
+			expectDueDelivery(mock, stub.URL, tt.attempts)
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE webhook_deliveries`)).
+				WithArgs(int64(7), tt.status, tt.attempts+1, sqlmock.AnyArg(), sqlmock.AnyArg(),
+					http.StatusServiceUnavailable, "webhook answered with status 503").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			if _, err := NewDispatcher(conn).DeliverPending(); err != nil {
+				t.Fatalf("DeliverPending() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 2, want: 20 * time.Second},
+		{attempts: 5, want: 160 * time.Second},
+		{attempts: 9, want: 2560 * time.Second},
+		{attempts: 10, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package webhooks
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/nuklai/nuklaivm-external-subscriber/models"
+)
+
+// amountFields are the fields holding the amount of an action, looked up in its input
+// then in its output
+var amountFields = []string{"value", "staked_amount", "total_cost"}
+
+// Filter selects the actions notified to a webhook. Empty fields match every action.
+type Filter struct {
+	// Address matches the sponsor, actors and receivers of the transaction of the action
+	Address string
+	// ActionType matches the type of the action
+	ActionType *int
+	// AssetAddress matches the asset and dataset addresses of the action
+	AssetAddress string
+	// MinAmount is the lowest amount of the action, which must have one
+	MinAmount *big.Int
+}
+
+// Action is a successful action of an indexed block with the participants of its transaction
+type Action struct {
+	BlockHeight int64
+	BlockHash   string
+	TxHash      string
+	Sponsor     string
+	Actors      []string
+	Receivers   []string
+	ActionType  int
+	ActionName  string
+	ActionIndex int
+	Input       map[string]interface{}
+	Output      map[string]interface{}
+	Timestamp   string
+}
+
+// Payload is the body posted to a webhook for a matching action
+type Payload struct {
+	WebhookID   int                    `json:"webhook_id"`
+	Event       string                 `json:"event"`
+	BlockHeight int64                  `json:"block_height"`
+	BlockHash   string                 `json:"block_hash"`
+	TxHash      string                 `json:"tx_hash"`
+	Sponsor     string                 `json:"sponsor"`
+	ActionType  int                    `json:"action_type"`
+	ActionName  string                 `json:"action_name"`
+	ActionIndex int                    `json:"action_index"`
+	Input       map[string]interface{} `json:"input"`
+	Output      map[string]interface{} `json:"output"`
+	Timestamp   string                 `json:"timestamp"`
+}
+
+// webhookFilter is the filter of an active webhook
+type webhookFilter struct {
+	id     int
+	filter Filter
+}
+
+// Match reports whether the action is selected by the filter
+func (f Filter) Match(action Action) bool {
+	if f.ActionType != nil && *f.ActionType != action.ActionType {
+		return false
+	}
+	if f.Address != "" {
+		participants := append(append([]string{action.Sponsor}, action.Actors...), action.Receivers...)
+		if !containsAddress(f.Address, participants) {
+			return false
+		}
+	}
+	if f.AssetAddress != "" && !containsAddress(f.AssetAddress, assetAddresses(action)) {
+		return false
+	}
+	if f.MinAmount != nil {
+		amount, ok := actionAmount(action)
+		if !ok || amount.Cmp(f.MinAmount) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeAddress returns an address the way it is compared by the filters, as action
+// inputs encode addresses with a 0x prefix while outputs and transactions do not
+func NormalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(address), "0x"))
+}
+
+// QueueDeliveries queues a delivery of every action of an indexed block to the active
+// webhooks it matches. It runs in the transaction writing the block, so the deliveries
+// are queued if and only if the block is committed.
+func QueueDeliveries(dbTx *sql.Tx, blockHeight uint64) (int, error) {
+	filters, err := activeFilters(dbTx)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching webhooks: %w", err)
+	}
+	if len(filters) == 0 {
+		return 0, nil
+	}
+
+	actions, err := blockActions(dbTx, blockHeight)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching actions of block %d: %w", blockHeight, err)
+	}
+
+	queued := 0
+	now := time.Now().UTC()
+	for _, action := range actions {
+		for _, webhook := range filters {
+			if !webhook.filter.Match(action) {
+				continue
+			}
+			payload, err := json.Marshal(newPayload(webhook.id, action))
+			if err != nil {
+				return queued, fmt.Errorf("error marshaling webhook payload: %w", err)
+			}
+			result, err := dbTx.Exec(`
+				INSERT INTO webhook_deliveries (webhook_id, block_height, tx_hash, action_index, payload, next_attempt_at, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $6)
+				ON CONFLICT (webhook_id, tx_hash, action_index) DO NOTHING`,
+				webhook.id, action.BlockHeight, action.TxHash, action.ActionIndex, string(payload), now)
+			if err != nil {
+				return queued, fmt.Errorf("error queuing delivery to webhook %d: %w", webhook.id, err)
+			}
+			// An action already queued for the webhook is not queued again
+			inserted, _ := result.RowsAffected()
+			queued += int(inserted)
+		}
+	}
+	return queued, nil
+}
+
+// CancelDeliveries removes the pending deliveries of the actions of the blocks at or above
+// fromHeight, which are rolled back by a reorg. Their partners are not notified of actions
+// that are no longer on the canonical chain, and an action included again in a new block
+// is queued with its new payload.
+func CancelDeliveries(dbTx *sql.Tx, fromHeight uint64) (int, error) {
+	result, err := dbTx.Exec(`DELETE FROM webhook_deliveries WHERE block_height >= $1 AND status = $2`,
+		fromHeight, models.DeliveryPending)
+	if err != nil {
+		return 0, err
+	}
+	cancelled, _ := result.RowsAffected()
+	return int(cancelled), nil
+}
+
+// activeFilters returns the filters of the active webhooks
+func activeFilters(dbTx *sql.Tx) ([]webhookFilter, error) {
+	rows, err := dbTx.Query(`
+		SELECT id, address, action_type, asset_address, min_amount
+		FROM webhooks
+		WHERE active
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var filters []webhookFilter
+	for rows.Next() {
+		var webhook webhookFilter
+		var minAmount sql.NullString
+		if err := rows.Scan(&webhook.id, &webhook.filter.Address, &webhook.filter.ActionType,
+			&webhook.filter.AssetAddress, &minAmount); err != nil {
+			return nil, err
+		}
+		if minAmount.Valid {
+			amount, ok := new(big.Int).SetString(minAmount.String, 10)
+			if !ok {
+				return nil, fmt.Errorf("invalid min_amount %q of webhook %d", minAmount.String, webhook.id)
+			}
+			webhook.filter.MinAmount = amount
+		}
+		filters = append(filters, webhook)
+	}
+	return filters, rows.Err()
+}
+
+// blockActions returns the successful actions of an indexed block in execution order
+func blockActions(dbTx *sql.Tx, blockHeight uint64) ([]Action, error) {
+	rows, err := dbTx.Query(`
+		SELECT b.block_height, b.block_hash, a.tx_hash, t.sponsor, t.actors, t.receivers,
+		       a.action_type, a.action_name, a.action_index, a.input, a.output, a.timestamp
+		FROM blocks b
+		JOIN transactions t ON t.block_hash = b.block_hash
+		JOIN actions a ON a.tx_hash = t.tx_hash
+		WHERE b.block_height = $1 AND t.success
+		ORDER BY t.id, a.action_index`, blockHeight)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []Action
+	for rows.Next() {
+		var action Action
+		var input, output []byte
+		if err := rows.Scan(&action.BlockHeight, &action.BlockHash, &action.TxHash, &action.Sponsor,
+			pq.Array(&action.Actors), pq.Array(&action.Receivers), &action.ActionType, &action.ActionName,
+			&action.ActionIndex, &input, &output, &action.Timestamp); err != nil {
+			return nil, err
+		}
+		if action.Input, err = decodeJSONMap(input); err != nil {
+			return nil, fmt.Errorf("error decoding input of tx %s: %w", action.TxHash, err)
+		}
+		if action.Output, err = decodeJSONMap(output); err != nil {
+			return nil, fmt.Errorf("error decoding output of tx %s: %w", action.TxHash, err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
+
+func newPayload(webhookID int, action Action) Payload {
+	return Payload{
+		WebhookID:   webhookID,
+		Event:       "action",
+		BlockHeight: action.BlockHeight,
+		BlockHash:   action.BlockHash,
+		TxHash:      action.TxHash,
+		Sponsor:     action.Sponsor,
+		ActionType:  action.ActionType,
+		ActionName:  action.ActionName,
+		ActionIndex: action.ActionIndex,
+		Input:       action.Input,
+		Output:      action.Output,
+		Timestamp:   action.Timestamp,
+	}
+}
+
+// assetAddresses returns the asset and dataset addresses in the input and output of an action
+func assetAddresses(action Action) []string {
+	var addresses []string
+	for _, fields := range []map[string]interface{}{action.Input, action.Output} {
+		for key, value := range fields {
+			if key != "dataset_address" && !strings.HasSuffix(key, "asset_address") {
+				continue
+			}
+			if address, ok := value.(string); ok && address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// actionAmount returns the amount of an action, if it has one
+func actionAmount(action Action) (*big.Int, bool) {
+	for _, fields := range []map[string]interface{}{action.Input, action.Output} {
+		for _, key := range amountFields {
+			if value, ok := fields[key].(json.Number); ok {
+				return new(big.Int).SetString(value.String(), 10)
+			}
+		}
+	}
+	return nil, false
+}
+
+// containsAddress reports whether address is one of candidates
+func containsAddress(address string, candidates []string) bool {
+	address = NormalizeAddress(address)
+	for _, candidate := range candidates {
+		if NormalizeAddress(candidate) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSONMap decodes a JSON object keeping numbers as json.Number, so uint64 amounts
+// above 2^53 are compared exactly
+func decodeJSONMap(data []byte) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
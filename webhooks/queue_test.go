@@ -0,0 +1,155 @@
+// Copyright (C) 2024, Nuklai. All rights reserved.
+// See the file LICENSE for licensing terms.
+
+package webhooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math/big"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	sponsor      = "00c4cb545f748a28770042f893784ce85b107389004d6a0e0d6d7518eeae1292d9"
+	receiver     = "006835bfa9c67557da9fe6c7ad69089e17c6cad3e18284e037c78aa307e3c0c840"
+	assetAddress = "00cf77495ce1bdbf11e5e45463fad5a862cb6cc0a20e00e658c4ac3355dcdc64bb"
+)
+
+func newMockTx(t *testing.T) (*sql.Tx, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	mock.ExpectBegin()
+	dbTx, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	return dbTx, mock
+}
+
+func transferAction(value string) Action {
+	return Action{
+		BlockHeight: 10,
+		BlockHash:   "block",
+		TxHash:      "tx1",
+		Sponsor:     sponsor,
+		Actors:      []string{sponsor},
+		Receivers:   []string{receiver},
+		ActionType:  0,
+		ActionName:  "Transfer",
+		Input: map[string]interface{}{
+			"to":            "0x" + receiver,
+			"asset_address": "0x" + assetAddress,
+			"value":         json.Number(value),
+		},
+		Output: map[string]interface{}{"actor": sponsor, "receiver": receiver},
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	transferType := 0
+	mintType := 5
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty filter", filter: Filter{}, want: true},
+		{name: "receiver", filter: Filter{Address: "0x" + receiver}, want: true},
+		{name: "other address", filter: Filter{Address: assetAddress}, want: false},
+		{name: "action type", filter: Filter{ActionType: &transferType}, want: true},
+		{name: "other action type", filter: Filter{ActionType: &mintType}, want: false},
+		{name: "asset address", filter: Filter{AssetAddress: assetAddress}, want: true},
+		{name: "other asset address", filter: Filter{AssetAddress: receiver}, want: false},
+		{name: "amount above minimum", filter: Filter{MinAmount: big.NewInt(1_000)}, want: true},
+		{name: "amount below minimum", filter: Filter{MinAmount: new(big.Int).Lsh(big.NewInt(1), 64)}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(transferAction("18446744073709551615")); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// An action without an amount never reaches a minimum
+	action := transferAction("0")
+	delete(action.Input, "value")
+	if (Filter{MinAmount: big.NewInt(0)}).Match(action) {
+		t.Error("Match() selected an action without an amount")
+	}
+}
+
+func TestQueueDeliveries(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+
+	mock.ExpectQuery(`SELECT id, address, action_type, asset_address, min_amount\s+FROM webhooks`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "action_type", "asset_address", "min_amount"}).
+			AddRow(1, receiver, nil, "", "100").
+			AddRow(2, sponsor, 5, "", nil))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM blocks b`)).
+		WithArgs(uint64(10)).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"block_height", "block_hash", "tx_hash", "sponsor", "actors", "receivers",
+			"action_type", "action_name", "action_index", "input", "output", "timestamp",
+		}).AddRow(10, "block", "tx1", sponsor, "{"+sponsor+"}", "{"+receiver+"}", 0, "Transfer", 0,
+			`{"to":"0x`+receiver+`","value":250}`, `{"receiver":"`+receiver+`"}`, "2024-01-01T00:00:00Z"))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO webhook_deliveries`)).
+		WithArgs(1, int64(10), "tx1", 0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	queued, err := QueueDeliveries(dbTx, 10)
+	if err != nil {
+		t.Fatalf("QueueDeliveries() error = %v", err)
+	}
+	if queued != 1 {
+		t.Fatalf("QueueDeliveries() queued %d deliveries, want 1", queued)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueDeliveriesWithoutWebhooks(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+
+	// The actions of the block are not read when no webhook is active
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM webhooks`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "address", "action_type", "asset_address", "min_amount"}))
+
+	if _, err := QueueDeliveries(dbTx, 10); err != nil {
+		t.Fatalf("QueueDeliveries() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelDeliveries(t *testing.T) {
+	dbTx, mock := newMockTx(t)
+
+	// Only the pending deliveries of the orphaned blocks are removed
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM webhook_deliveries WHERE block_height >= $1 AND status = $2`)).
+		WithArgs(uint64(10), "pending").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	cancelled, err := CancelDeliveries(dbTx, 10)
+	if err != nil {
+		t.Fatalf("CancelDeliveries() error = %v", err)
+	}
+	if cancelled != 3 {
+		t.Fatalf("CancelDeliveries() cancelled %d deliveries, want 3", cancelled)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}